@@ -0,0 +1,71 @@
+package main
+
+import "strings"
+
+// tripAccess is what a vehicle offers riders who need a ramp or a bike
+// rack. Flags are nil when the source doesn't say.
+type tripAccess struct {
+	Wheelchair *bool
+	Bikes      *bool
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+// gtfsFlag reads a GTFS accessibility column: 1 is yes, 2 is no, and 0 or
+// blank is unknown
+func gtfsFlag(v string) *bool {
+	switch v {
+	case "1":
+		return boolPtr(true)
+	case "2":
+		return boolPtr(false)
+	}
+	return nil
+}
+
+// vehicleAccess reads SIRI VehicleFeatureRef values. Producers only list
+// features a vehicle has, so absence leaves the flag unknown.
+func vehicleAccess(features []string) tripAccess {
+	var access tripAccess
+	for _, f := range features {
+		f = strings.ToLower(f)
+		switch {
+		case strings.Contains(f, "wheelchair"), strings.Contains(f, "lowfloor"), strings.Contains(f, "ramp"), strings.Contains(f, "lift"):
+			access.Wheelchair = boolPtr(true)
+		case strings.Contains(f, "bicycle"), strings.Contains(f, "bike"):
+			access.Bikes = boolPtr(true)
+		}
+	}
+	return access
+}
+
+// annotateAccessibility fills in flags the realtime feed left unknown from
+// the agency's GTFS trips and stops. It does nothing unless gtfs.accessibility
+// is on, since that may download the feed.
+func annotateAccessibility(agency string, result *DirectionArrivals) {
+	if !currentConfig().GTFS.Accessibility {
+		return
+	}
+	idx, err := agencyIndex(agency)
+	if err != nil {
+		debugf("No accessibility data for %s: %v", agency, err)
+		return
+	}
+
+	if stop := idx.findStop(result.StopID); stop != nil {
+		result.WheelchairBoarding = stop.Wheelchair
+	}
+	for i := range result.Arrivals {
+		a := &result.Arrivals[i]
+		trip, ok := idx.Trips[a.TripID]
+		if !ok {
+			continue
+		}
+		if a.Wheelchair == nil {
+			a.Wheelchair = trip.Wheelchair
+		}
+		if a.Bikes == nil {
+			a.Bikes = trip.Bikes
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnotateAccessibility(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	idx, err := parseGTFS("SF", gtfsZip(t, testGTFS))
+	if err != nil {
+		t.Fatalf("parseGTFS: %v", err)
+	}
+	gtfsIndexes.Lock()
+	gtfsIndexes.byAgency["SF"] = idx
+	gtfsIndexes.Unlock()
+	t.Cleanup(func() {
+		gtfsIndexes.Lock()
+		delete(gtfsIndexes.byAgency, "SF")
+		gtfsIndexes.Unlock()
+	})
+
+	cfg := *currentConfig()
+	cfg.GTFS.Accessibility = true
+	activeConfig.Store(&cfg)
+
+	// Realtime features win over GTFS; unknown stays unknown
+	rt := vehicleAccess([]string{"lowFloor"})
+	dir := DirectionArrivals{StopID: "16994", Arrivals: []Arrival{
+		{TripID: "t1", Wheelchair: boolPtr(false)},
+		{TripID: "t2", Wheelchair: rt.Wheelchair, Bikes: rt.Bikes},
+		{TripID: "t3"},
+	}}
+	annotateAccessibility("SF", &dir)
+
+	flag := func(b *bool) string {
+		if b == nil {
+			return "?"
+		}
+		return strconv.FormatBool(*b)
+	}
+	var got []string
+	for _, a := range dir.Arrivals {
+		got = append(got, flag(a.Wheelchair)+"/"+flag(a.Bikes))
+	}
+	if want := "false/false true/? ?/?"; strings.Join(got, " ") != want {
+		t.Errorf("arrival flags = %v, want %s", got, want)
+	}
+	if flag(dir.WheelchairBoarding) != "true" {
+		t.Errorf("stop wheelchair boarding = %s, want true", flag(dir.WheelchairBoarding))
+	}
+
+	// Flags survive into the served response
+	dir.Label, dir.Arrivals = "Ocean Beach", arrivalsAt(time.Now().Add(5*time.Minute))
+	dir.Arrivals[0].Wheelchair = boolPtr(true)
+	cache.mu.Lock()
+	cache.data = ArrivalsResponse{Stops: []StopArrivals{{Name: "Embarcadero", Directions: []DirectionArrivals{dir}}}}
+	cache.mu.Unlock()
+	served := buildArrivalsResponse(time.Now()).Stops[0].Directions[0]
+	if flag(served.Arrivals[0].Wheelchair) != "true" || flag(served.WheelchairBoarding) != "true" {
+		t.Errorf("served flags = %s/%s", flag(served.Arrivals[0].Wheelchair), flag(served.WheelchairBoarding))
+	}
+}
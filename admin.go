@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// directionRef identifies one configured direction by index
+type directionRef struct {
+	stop, dir int
+}
+
+// selectDirections finds configured directions matching the optional stop
+// name and direction label/stop ID filters
+func selectDirections(stopName, direction string) []directionRef {
+	var refs []directionRef
+	for i, stop := range currentConfig().Stops {
+		if stopName != "" && !strings.EqualFold(stop.Name, stopName) {
+			continue
+		}
+		for j, dir := range stop.Directions {
+			if direction != "" && !strings.EqualFold(dir.Label, direction) && dir.StopID != direction {
+				continue
+			}
+			refs = append(refs, directionRef{i, j})
+		}
+	}
+	return refs
+}
+
+// refreshDirections fetches the given directions and swaps them into the
+// cache. A full refresh is done instead if the cache is still empty. The
+// quota is checked under refreshMu, so two triggers can't both pass the
+// check and then spend the same requests: nothing is fetched unless the
+// quota covers the requests on top of headroom. It returns the requests
+// needed and, when they don't fit, how long until they would.
+func refreshDirections(ctx context.Context, refs []directionRef, headroom int) (int, time.Duration) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	config := currentConfig()
+
+	cache.mu.RLock()
+	empty := len(cache.data.Stops) != len(config.Stops)
+	cache.mu.RUnlock()
+
+	full := empty || len(refs) == totalDirections()
+	n := refRequests(refs)
+	if full {
+		n = totalRequests()
+	}
+	if wait := quota.waitFor(n+headroom, clock.Now()); wait > 0 {
+		return n, wait
+	}
+	if full {
+		refreshCacheLocked(ctx)
+		return n, 0
+	}
+
+	fetched := make(map[directionRef]DirectionArrivals, len(refs))
+	for i, ref := range refs {
+		if i > 0 && config.Provider == "511" {
+			sleepContext(ctx, upstreamDelay)
+		}
+		// A cancelled refresh keeps what it fetched so far
+		if ctx.Err() != nil {
+			break
+		}
+		stop := config.Stops[ref.stop]
+		fetched[ref] = fetchDirection(ctx, stop, stop.Directions[ref.dir])
+	}
+
+	// Copy on write so readers holding the previous snapshot are unaffected
+	now := clock.Now()
+	cache.update(func(data ArrivalsResponse) ArrivalsResponse {
+		return mergeDirections(data, fetched, now)
+	})
+	return n, 0
+}
+
+func totalDirections() int {
+	n := 0
+	for _, stop := range currentConfig().Stops {
+		n += len(stop.Directions)
+	}
+	return n
+}
+
+// upstreamRequests counts the requests fetching dirs makes: one per stop
+// ID, since a direction may merge several stops
+func upstreamRequests(dirs ...Direction) int {
+	n := 0
+	for _, dir := range dirs {
+		n += len(dir.allStopIDs())
+	}
+	return n
+}
+
+// totalRequests counts the requests a full refresh makes
+func totalRequests() int {
+	n := 0
+	for _, stop := range currentConfig().Stops {
+		n += upstreamRequests(stop.Directions...)
+	}
+	return n
+}
+
+// refRequests counts the requests fetching refs makes
+func refRequests(refs []directionRef) int {
+	stops := currentConfig().Stops
+	n := 0
+	for _, ref := range refs {
+		n += upstreamRequests(stops[ref.stop].Directions[ref.dir])
+	}
+	return n
+}
+
+// handleAdminRefresh triggers an immediate refresh, optionally scoped with
+// ?stop=<name> and/or ?direction=<label or stop ID>
+func handleAdminRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	refs := selectDirections(r.URL.Query().Get("stop"), r.URL.Query().Get("direction"))
+	if len(refs) == 0 {
+		http.Error(w, "no matching stop or direction", http.StatusNotFound)
+		return
+	}
+
+	if !leading() {
+		http.Error(w, "this instance follows the shared cache's leader, which does the fetching", http.StatusConflict)
+		return
+	}
+	// The refresh finishes even if the caller hangs up
+	n, wait := refreshDirections(outliveRequest(r), refs, 0)
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, fmt.Sprintf("upstream quota exhausted; %d requests needed", n), http.StatusTooManyRequests)
+		return
+	}
+	infof("Forced refresh of %d directions", len(refs))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"refreshed":       len(refs),
+		"quota_remaining": quota.remaining(clock.Now()),
+	})
+}
+
+// Admin view of the cache, including per-direction fetch bookkeeping
+type cacheDirectionView struct {
+	DirectionArrivals
+	FetchedAt  *time.Time `json:"fetched_at"`
+	FetchError string     `json:"fetch_error,omitempty"`
+}
+
+type cacheStopView struct {
+	Name       string               `json:"name"`
+	Line       string               `json:"line"`
+	Directions []cacheDirectionView `json:"directions"`
+}
+
+type cacheView struct {
+	LastFetched *time.Time      `json:"last_fetched"`
+	LastUpdated string          `json:"last_updated"`
+	Stops       []cacheStopView `json:"stops"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// handleAdminCache returns the raw cached snapshot on GET and clears it on DELETE
+func handleAdminCache(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cache.mu.RLock()
+		data := cache.data
+		lastFetched := cache.lastFetched
+		cache.mu.RUnlock()
+
+		view := cacheView{
+			LastFetched: optionalTime(lastFetched),
+			LastUpdated: data.LastUpdated,
+			Stops:       make([]cacheStopView, len(data.Stops)),
+		}
+		for i, stop := range data.Stops {
+			view.Stops[i] = cacheStopView{
+				Name:       stop.Name,
+				Line:       stop.Line,
+				Directions: make([]cacheDirectionView, len(stop.Directions)),
+			}
+			for j, dir := range stop.Directions {
+				view.Stops[i].Directions[j] = cacheDirectionView{
+					DirectionArrivals: dir,
+					FetchedAt:         optionalTime(dir.FetchedAt),
+					FetchError:        dir.FetchError,
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(view)
+
+	case http.MethodDelete:
+		cache.store(ArrivalsResponse{}, time.Time{})
+
+		infof("Cache cleared by admin request")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
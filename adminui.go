@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//go:embed templates/admin.html
+var adminTemplates embed.FS
+
+var adminPage = template.Must(template.New("admin.html").Funcs(template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+}).ParseFS(adminTemplates, "templates/admin.html"))
+
+// adminView is everything the admin page shows
+type adminView struct {
+	CSRF   string
+	Notice string
+	Error  string
+
+	Config     *Config
+	Health     []adminHealthRow
+	LastFetch  string
+	QuotaLeft  int
+	QuotaLimit int
+
+	Query    string
+	Agency   string
+	Results  []StopMatch
+	Searched bool
+}
+
+// adminHealthRow is one direction's most recent fetch
+type adminHealthRow struct {
+	Stop, Label, StopID string
+	FetchedAt           string
+	Arrivals            int
+	Error               string
+}
+
+// adminCSRFToken is sent with every admin UI form. Browsers resend basic
+// auth credentials to any site's form posts, so the token, derived from the
+// admin secrets, proves the form came from this page.
+func adminCSRFToken() string {
+	a := currentConfig().Admin
+	sum := sha256.Sum256([]byte("muni-tracker admin ui\x00" + a.Token + "\x00" + a.Username + "\x00" + a.Password))
+	return hex.EncodeToString(sum[:16])
+}
+
+// handleAdminUI serves the admin page at /admin (GET) and applies its
+// forms (POST). Edits go through the same validation and save path as
+// PUT /api/admin/config.
+func handleAdminUI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		renderAdminUI(w, r, "")
+	case http.MethodPost:
+		if !secureCompare(r.PostFormValue("csrf"), adminCSRFToken()) {
+			http.Error(w, "invalid form token; reload the page", http.StatusForbidden)
+			return
+		}
+		notice, err := applyAdminForm(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			renderAdminUI(w, r, err.Error())
+			return
+		}
+		http.Redirect(w, r, "/admin?notice="+url.QueryEscape(notice), http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func renderAdminUI(w http.ResponseWriter, r *http.Request, errMsg string) {
+	config := currentConfig()
+	q := r.URL.Query()
+	view := adminView{
+		CSRF:       adminCSRFToken(),
+		Notice:     q.Get("notice"),
+		Error:      errMsg,
+		Config:     config,
+		QuotaLeft:  quota.remaining(clock.Now()),
+		QuotaLimit: config.UpstreamHourlyLimit,
+		Query:      q.Get("q"),
+		Agency:     q.Get("agency"),
+	}
+	if view.Agency == "" {
+		view.Agency = "SF"
+	}
+	view.Agency, _ = lookupAgency(view.Agency)
+
+	cache.mu.RLock()
+	data, lastFetched := cache.data, cache.lastFetched
+	cache.mu.RUnlock()
+	if !lastFetched.IsZero() {
+		view.LastFetch = displayClock(lastFetched)
+	}
+	for _, stop := range data.Stops {
+		for _, dir := range stop.Directions {
+			row := adminHealthRow{
+				Stop:     stop.Name,
+				Label:    dir.Label,
+				StopID:   dir.StopID,
+				Arrivals: len(dir.Arrivals),
+				Error:    dir.FetchError,
+			}
+			if !dir.FetchedAt.IsZero() {
+				row.FetchedAt = displayClock(dir.FetchedAt)
+			}
+			view.Health = append(view.Health, row)
+		}
+	}
+
+	if view.Query != "" {
+		view.Searched = true
+		idx, err := agencyIndex(view.Agency)
+		if err != nil {
+			warnf("Stop index for %s unavailable: %v", view.Agency, err)
+			view.Error = "Stop search is unavailable for " + view.Agency
+		} else {
+			view.Results = autocompleteStops(idx, view.Query, defaultAutocompleteLimit)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := adminPage.Execute(w, view); err != nil {
+		errorf("Admin page: %v", err)
+	}
+}
+
+// applyAdminForm edits the config file per the submitted form and applies
+// it, returning a notice for the page
+func applyAdminForm(r *http.Request) (string, error) {
+	if err := configEditable(); err != nil {
+		return "", err
+	}
+	cfg, err := readConfigFile()
+	if err != nil {
+		errorf("Reading config file: %v", err)
+		return "", fmt.Errorf("reading the config file failed")
+	}
+
+	var notice string
+	switch r.PostFormValue("action") {
+	case "intervals":
+		for name, field := range map[string]*int{
+			"refresh_interval":       &cfg.RefreshInterval,
+			"cache_refresh_interval": &cfg.CacheRefreshInterval,
+			"max_arrivals":           &cfg.MaxArrivals,
+		} {
+			n, err := formInt(r, name)
+			if err != nil {
+				return "", err
+			}
+			*field = n
+		}
+		notice = "Intervals saved"
+
+	case "add_stop":
+		stop := Stop{
+			Name:   strings.TrimSpace(r.PostFormValue("name")),
+			Line:   strings.TrimSpace(r.PostFormValue("line")),
+			Agency: r.PostFormValue("agency"),
+			Directions: []Direction{{
+				Label:  strings.TrimSpace(r.PostFormValue("label")),
+				StopID: strings.TrimSpace(r.PostFormValue("stop_id")),
+			}},
+		}
+		if stop.Name == "" {
+			return "", fmt.Errorf("the stop needs a name")
+		}
+		cfg.Stops = append(cfg.Stops, stop)
+		notice = "Added " + stop.Name
+
+	case "remove_stop":
+		i, err := formInt(r, "index")
+		if err != nil || i >= len(cfg.Stops) {
+			return "", fmt.Errorf("no such stop")
+		}
+		notice = "Removed " + cfg.Stops[i].Name
+		cfg.Stops = append(cfg.Stops[:i], cfg.Stops[i+1:]...)
+
+	case "move":
+		i, err1 := formInt(r, "index")
+		j, err2 := formInt(r, "to")
+		switch r.PostFormValue("list") {
+		case "stops":
+			err = moveItem(cfg.Stops, i, j)
+		case "dashboards":
+			err = moveItem(cfg.Dashboards, i, j)
+		default:
+			err = fmt.Errorf("unknown list")
+		}
+		if err1 != nil || err2 != nil || err != nil {
+			return "", fmt.Errorf("nothing to move there")
+		}
+		notice = "Order saved"
+
+	default:
+		return "", fmt.Errorf("unknown action")
+	}
+
+	doc, err := configNode(cfg)
+	if err != nil {
+		return "", fmt.Errorf("invalid config: %w", err)
+	}
+	if err := finalizeConfig(cfg); err != nil {
+		return "", fmt.Errorf("invalid config: %w", err)
+	}
+	restart, err := commitConfig(cfg, doc, "admin UI")
+	if err != nil {
+		return "", fmt.Errorf("saving the config failed")
+	}
+	if restart {
+		notice += "; restart the server to apply every change"
+	}
+	return notice, nil
+}
+
+// formInt reads a non-negative integer form field, 0 when empty
+func formInt(r *http.Request, name string) (int, error) {
+	v := strings.TrimSpace(r.PostFormValue(name))
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number", strings.ReplaceAll(name, "_", " "))
+	}
+	return n, nil
+}
+
+// moveItem moves list[i] to position j, shifting the items between
+func moveItem[T any](list []T, i, j int) error {
+	if i < 0 || j < 0 || i >= len(list) || j >= len(list) {
+		return fmt.Errorf("index out of range")
+	}
+	item := list[i]
+	if i < j {
+		copy(list[i:j], list[i+1:j+1])
+	} else {
+		copy(list[j+1:i+1], list[j:i])
+	}
+	list[j] = item
+	return nil
+}
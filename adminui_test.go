@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminUI(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+
+	raw := `api_key: test
+admin:
+  username: admin
+  password: secret
+stops:
+  - name: Embarcadero
+    directions: [{label: Ocean Beach, stop_id: "16994"}]
+`
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), "config.yaml")
+	t.Cleanup(func() { configPath = oldPath })
+	if err := os.WriteFile(configPath, []byte(raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseConfig([]byte(raw))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	handler := requireAdmin(handleAdminUI)
+	do := func(method string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := do("GET", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Embarcadero") {
+		t.Fatalf("GET /admin: HTTP %d", rec.Code)
+	}
+
+	form := url.Values{"action": {"intervals"}, "refresh_interval": {"45"}, "cache_refresh_interval": {"600"}}
+	if rec := do("POST", form); rec.Code != http.StatusForbidden {
+		t.Errorf("missing CSRF token: HTTP %d", rec.Code)
+	}
+
+	form.Set("csrf", adminCSRFToken())
+	if rec := do("POST", form); rec.Code != http.StatusSeeOther {
+		t.Fatalf("save intervals: HTTP %d: %s", rec.Code, rec.Body)
+	}
+	if got := currentConfig(); got.RefreshInterval != 45 || got.CacheRefreshInterval != 600 {
+		t.Errorf("applied intervals = %d, %d", got.RefreshInterval, got.CacheRefreshInterval)
+	}
+	saved, _ := os.ReadFile(configPath)
+	if !strings.Contains(string(saved), "cache_refresh_interval: 600") {
+		t.Errorf("config file not updated:\n%s", saved)
+	}
+
+	form.Set("refresh_interval", "-1")
+	if rec := do("POST", form); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative interval: HTTP %d", rec.Code)
+	}
+
+	list := []string{"a", "b", "c", "d"}
+	moveItem(list, 3, 1)
+	moveItem(list, 0, 2)
+	if strings.Join(list, "") != "dbac" {
+		t.Errorf("moveItem = %v", list)
+	}
+}
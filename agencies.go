@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// agencyAliases maps friendly agency names, normalized by agencyKey, to
+// 511 operator codes
+var agencyAliases = map[string]string{
+	"muni":              "SF",
+	"sfmuni":            "SF",
+	"sfmta":             "SF",
+	"bart":              "BA",
+	"caltrain":          "CT",
+	"actransit":         "AC",
+	"vta":               "SC",
+	"samtrans":          "SM",
+	"goldengate":        "GG",
+	"goldengatetransit": "GG",
+	"sfbayferry":        "SB",
+	"bayferry":          "SB",
+	"countyconnection":  "CC",
+	"tridelta":          "3D",
+	"wheels":            "WH",
+	"lavta":             "WH",
+	"marintransit":      "MA",
+	"smart":             "SA",
+	"emerygoround":      "EM",
+	"ace":               "CE",
+	"capitolcorridor":   "AM",
+	"soltrans":          "ST",
+	"vine":              "VN",
+	"westcat":           "WC",
+	"unioncitytransit":  "UC",
+}
+
+// agencyKey lowercases name and drops spaces and punctuation, so "AC
+// Transit", "ac-transit" and "actransit" are the same
+func agencyKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// resolveAgency turns a configured agency into a 511 operator code. Known
+// codes are accepted in any case. Anything else is passed through
+// unchanged, with a warning suggesting the closest known name when there
+// is one.
+func resolveAgency(name string) string {
+	if code, ok := lookupAgency(name); ok || name == "" {
+		return code
+	}
+
+	msg := fmt.Sprintf("Config: unknown agency %q, using it as a 511 operator code", name)
+	if alias := closestAlias(agencyKey(name)); alias != "" {
+		msg += fmt.Sprintf("; did you mean %q (%s)?", alias, agencyAliases[alias])
+	}
+	warnf("%s", msg)
+	return name
+}
+
+// errUnknownAgency refuses stop data for an agency that is neither
+// configured nor a known 511 operator
+var errUnknownAgency = errors.New("unknown agency")
+
+// allowedAgency resolves an agency named in a request to the operator
+// code to look it up by: a known code or name, or a configured agency
+func allowedAgency(name string) (string, error) {
+	if code, ok := lookupAgency(name); ok {
+		return code, nil
+	}
+	for _, stop := range allStops(currentConfig()) {
+		if stop.Agency == name {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%w %q", errUnknownAgency, name)
+}
+
+// lookupAgency returns the operator code for a friendly name or a known
+// code in any case
+func lookupAgency(name string) (string, bool) {
+	if code, ok := agencyAliases[agencyKey(name)]; ok {
+		return code, true
+	}
+	for _, code := range agencyAliases {
+		if strings.EqualFold(code, name) {
+			return code, true
+		}
+	}
+	return name, false
+}
+
+// closestAlias returns the alias within two edits of key, if any
+func closestAlias(key string) string {
+	best, bestDist := "", 3
+	for alias := range agencyAliases {
+		d := editDistance(key, alias)
+		if d < bestDist || (d == bestDist && alias < best) {
+			best, bestDist = alias, d
+		}
+	}
+	return best
+}
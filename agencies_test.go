@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestResolveAgency(t *testing.T) {
+	for in, want := range map[string]string{
+		"":           "",
+		"muni":       "SF",
+		"BART":       "BA",
+		"AC Transit": "AC",
+		"ac-transit": "AC",
+		"sf":         "SF",
+		"ct":         "CT",
+		"PE":         "PE",
+	} {
+		if got := resolveAgency(in); got != want {
+			t.Errorf("resolveAgency(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	if got := closestAlias(agencyKey("Cal-tran")); got != "caltrain" {
+		t.Errorf("closestAlias(Cal-tran) = %q", got)
+	}
+	if got := closestAlias(agencyKey("bert")); got != "bart" {
+		t.Errorf("closestAlias(bert) = %q", got)
+	}
+}
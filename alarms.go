@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlarmConfig is a vehicle to catch most days, e.g. the 8:12-ish inbound
+// N on weekdays. The server watches predictions for it and sends a
+// notification when it's time to leave.
+type AlarmConfig struct {
+	Name      string `yaml:"name"`
+	Stop      string `yaml:"stop"`
+	Direction string `yaml:"direction"`
+	// Stop code of the direction, as for a trip leg
+	StopID string `yaml:"stop_id,omitempty"`
+	// Roughly when the vehicle comes (HH:MM)
+	Target string `yaml:"target"`
+	// Minutes either side of target a vehicle may arrive and still count
+	// as this catch (default 10)
+	Window int `yaml:"window,omitempty"`
+	// weekday, saturday, sunday, or day names like Mon-Fri (default: every
+	// day). Holidays count as sunday.
+	Days []string `yaml:"days,omitempty"`
+	// Minutes from door to stop
+	WalkMinutes int `yaml:"walk_minutes"`
+
+	target time.Duration
+}
+
+func validateAlarms(cfg *Config) error {
+	for i := range cfg.Alarms {
+		a := &cfg.Alarms[i]
+		if a.Name == "" {
+			return fmt.Errorf("alarms[%d] needs a name", i)
+		}
+		leg := a.leg()
+		if err := resolveLeg(cfg, &leg); err != nil {
+			return fmt.Errorf("alarm %q: %w", a.Name, err)
+		}
+		a.Stop, a.Direction, a.StopID = leg.Stop, leg.Direction, leg.StopID
+		t, err := time.Parse("15:04", a.Target)
+		if err != nil {
+			return fmt.Errorf("alarm %q: invalid target %q (want HH:MM)", a.Name, a.Target)
+		}
+		a.target = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		if a.Window < 0 || a.WalkMinutes < 0 {
+			return fmt.Errorf("alarm %q: window and walk_minutes cannot be negative", a.Name)
+		}
+		if a.Window == 0 {
+			a.Window = 10
+		}
+		if err := validateDays(a.Days); err != nil {
+			return fmt.Errorf("alarm %q: %w", a.Name, err)
+		}
+	}
+	if len(cfg.Alarms) > 0 && !cfg.Notifications.enabled() {
+		warnf("Alarms are configured but no notification channels; leave-by times are only shown at /api/alarms")
+	}
+	return nil
+}
+
+// leg is the direction the alarm watches, in a trip leg's terms
+func (a AlarmConfig) leg() TripLeg {
+	return TripLeg{Stop: a.Stop, Direction: a.Direction, StopID: a.StopID}
+}
+
+// runsOn reports whether the alarm is set for the local day containing t
+func (a AlarmConfig) runsOn(t time.Time) bool {
+	return onDays(a.Days, t)
+}
+
+// alarmLateness is how far past the leave-by time a vehicle still counts
+// as catchable, covering the gap between alarm checks
+const alarmLateness = time.Minute
+
+// allArrivals asks for every cached arrival, since a catch or rule match
+// may be further out than the board shows
+var allArrivals = arrivalsPage{limit: 1000}
+
+// alarmCheckInterval is how often leave-by times are recomputed
+const alarmCheckInterval = 15 * time.Second
+
+// planAlarm picks the predicted vehicle for today's catch: of those
+// arriving within the window that can still be reached, the one closest to
+// the target. The leave-by time is its arrival less the walk.
+func planAlarm(a AlarmConfig, resp ArrivalsResponse, now time.Time) (catch, leaveBy time.Time, ok bool) {
+	local := localTime(now)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	target := midnight.Add(a.target)
+	window := time.Duration(a.Window) * time.Minute
+	walk := time.Duration(a.WalkMinutes) * time.Minute
+
+	var best time.Duration
+	for _, t := range directionDepartures(resp, a.leg()) {
+		if t.Before(target.Add(-window)) || t.After(target.Add(window)) {
+			continue
+		}
+		if t.Add(-walk).Before(now.Add(-alarmLateness)) {
+			continue
+		}
+		off := t.Sub(target)
+		if off < 0 {
+			off = -off
+		}
+		if !ok || off < best {
+			catch, best, ok = t, off, true
+		}
+	}
+	return catch, catch.Add(-walk), ok
+}
+
+// alarmsFired records, per alarm name, the local date its notification
+// went out, so each alarm fires once a day
+var alarmsFired = struct {
+	sync.Mutex
+	day map[string]string
+}{day: make(map[string]string)}
+
+// checkAlarms sends a leave-now notification for each alarm whose leave-by
+// time has come. Leave-by times are recomputed from the latest predictions
+// on every check, so they follow the vehicle as it runs early or late.
+func checkAlarms(ctx context.Context, now time.Time) {
+	cfg := currentConfig()
+	alarms := cfg.Alarms
+	if len(alarms) == 0 {
+		return
+	}
+	resp := buildArrivalsPage(now, allArrivals)
+	today := localTime(now).Format("2006-01-02")
+
+	for _, a := range alarms {
+		if !a.runsOn(now) {
+			continue
+		}
+		alarmsFired.Lock()
+		fired := alarmsFired.day[a.Name] == today
+		alarmsFired.Unlock()
+		if fired {
+			continue
+		}
+
+		catch, leaveBy, ok := planAlarm(a, resp, now)
+		if !ok || now.Before(leaveBy) {
+			continue
+		}
+		minutes := int(catch.Sub(now).Minutes())
+		notify(ctx, Notification{
+			Kind:  "alarm",
+			Title: fmt.Sprintf("Leave now: %s", a.Name),
+			Message: fmt.Sprintf("%s at %s arrives %s (%d min walk)",
+				a.Direction, a.Stop, displayTime(catch), a.WalkMinutes),
+			Time:      localTime(now).Format(time.RFC3339),
+			Stop:      a.Stop,
+			Direction: a.Direction,
+			Line:      stopLine(cfg, a.Stop),
+			Minutes:   &minutes,
+		})
+		alarmsFired.Lock()
+		alarmsFired.day[a.Name] = today
+		alarmsFired.Unlock()
+	}
+}
+
+// stopLine returns the line of the configured stop called name
+func stopLine(cfg *Config, name string) string {
+	for _, stop := range cfg.Stops {
+		if strings.EqualFold(stop.Name, name) {
+			return stop.Line
+		}
+	}
+	return ""
+}
+
+// startAlarmChecker recomputes leave-by times in the background until ctx
+// ends. It follows config reloads, so alarms can be added without a
+// restart.
+func startAlarmChecker(ctx context.Context) {
+	go func() {
+		for {
+			checkAlarms(ctx, clock.Now())
+			if !sleepContext(ctx, alarmCheckInterval) {
+				return
+			}
+		}
+	}()
+}
+
+// AlarmStatus is an alarm's plan for today
+type AlarmStatus struct {
+	Name      string `json:"name"`
+	Stop      string `json:"stop"`
+	Direction string `json:"direction"`
+	Target    string `json:"target"`
+	// Whether the alarm is set for today
+	Today bool `json:"today"`
+	// The predicted vehicle being tracked, if any
+	Catch          string `json:"catch,omitempty"`
+	LeaveBy        string `json:"leave_by,omitempty"`
+	LeaveByDisplay string `json:"leave_by_display,omitempty"`
+	// Whether today's notification has gone out
+	Sent bool `json:"sent"`
+}
+
+func handleAlarms(w http.ResponseWriter, r *http.Request) {
+	now := clock.Now()
+	resp := buildArrivalsPage(now, allArrivals)
+	today := localTime(now).Format("2006-01-02")
+
+	out := make([]AlarmStatus, 0)
+	for _, a := range currentConfig().Alarms {
+		s := AlarmStatus{
+			Name:      a.Name,
+			Stop:      a.Stop,
+			Direction: a.Direction,
+			Target:    a.Target,
+			Today:     a.runsOn(now),
+		}
+		alarmsFired.Lock()
+		s.Sent = alarmsFired.day[a.Name] == today
+		alarmsFired.Unlock()
+		if s.Today {
+			if catch, leaveBy, ok := planAlarm(a, resp, now); ok {
+				s.Catch = localTime(catch).Format(time.RFC3339)
+				s.LeaveBy = localTime(leaveBy).Format(time.RFC3339)
+				s.LeaveByDisplay = displayTime(leaveBy)
+			}
+		}
+		out = append(out, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"alarms": out})
+}
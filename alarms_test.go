@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAlarms(t *testing.T) {
+	fc, ft := withTestEnv(t, time.Now(), "")
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+notifications:
+  webhooks:
+    - url: https://hooks.example.com/leave
+alarms:
+  - name: Work
+    stop: Embarcadero
+    direction: ocean beach
+    target: "08:12"
+    days: [weekday]
+    walk_minutes: 6
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: "16994"
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	// A Friday morning
+	at := func(hour, min int) time.Time {
+		return time.Date(2026, 1, 30, hour, min, 0, 0, cfg.location)
+	}
+	predict := func(times ...time.Time) {
+		cache.mu.Lock()
+		cache.data = ArrivalsResponse{Stops: []StopArrivals{{
+			Name:       "Embarcadero",
+			Directions: []DirectionArrivals{{Label: "Ocean Beach", StopID: "16994", Arrivals: arrivalsAt(times...)}},
+		}}}
+		cache.mu.Unlock()
+	}
+
+	fc.now = at(7, 50)
+	predict(at(8, 4), at(8, 13), at(8, 21))
+	catch, leaveBy, ok := planAlarm(cfg.Alarms[0], buildArrivalsPage(fc.now, allArrivals), fc.now)
+	if !ok || !catch.Equal(at(8, 13)) || !leaveBy.Equal(at(8, 7)) {
+		t.Fatalf("plan = %v leave %v (%v), want the 8:13 leaving 8:07", catch, leaveBy, ok)
+	}
+
+	checkAlarms(context.Background(), fc.now)
+	if ft.requests != 0 {
+		t.Fatalf("notified %d times before leave-by", ft.requests)
+	}
+
+	// The vehicle runs two minutes late, so leave-by moves to 8:09
+	predict(at(8, 4), at(8, 15), at(8, 21))
+	fc.now = at(8, 8)
+	checkAlarms(context.Background(), fc.now)
+	if ft.requests != 0 {
+		t.Fatal("notified before the drifted leave-by")
+	}
+	fc.now = at(8, 9)
+	checkAlarms(context.Background(), fc.now)
+	fc.now = at(8, 10)
+	checkAlarms(context.Background(), fc.now)
+	if ft.requests != 1 {
+		t.Errorf("notified %d times, want once", ft.requests)
+	}
+
+	rec := httptest.NewRecorder()
+	handleAlarms(rec, httptest.NewRequest("GET", "/api/alarms", nil))
+	var got struct{ Alarms []AlarmStatus }
+	json.NewDecoder(rec.Body).Decode(&got)
+	if len(got.Alarms) != 1 || !got.Alarms[0].Sent || !got.Alarms[0].Today {
+		t.Errorf("/api/alarms = %+v", got.Alarms)
+	}
+
+	// Weekday alarms skip Saturday
+	if cfg.Alarms[0].runsOn(at(8, 0).AddDate(0, 0, 1)) {
+		t.Error("weekday alarm runs on Saturday")
+	}
+
+	for _, bad := range []string{
+		`{name: x, stop: Embarcadero, direction: Ocean Beach, target: "8am"}`,
+		`{name: x, stop: Embarcadero, direction: Castro, target: "08:00"}`,
+		`{name: x, stop: Embarcadero, direction: Ocean Beach, target: "08:00", days: [someday]}`,
+	} {
+		if _, err := parseConfig([]byte("api_key: test\nalarms: [" + bad + "]\nstops: [{name: Embarcadero, line: N, directions: [{label: Ocean Beach, stop_id: \"1\"}]}]\n")); err == nil {
+			t.Errorf("alarm %s should be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// AppriseConfig hands notifications to an Apprise API server
+// (github.com/caronc/apprise-api), which delivers them to any service
+// Apprise supports: Matrix, Gotify, Pushover, Signal bridges, and more
+type AppriseConfig struct {
+	// Base URL of the Apprise API server
+	URL string `yaml:"url,omitempty"`
+	// Configuration key saved on the server, sent to /notify/{key}
+	Key string `yaml:"key,omitempty"`
+	// Only notify the key's services carrying this tag
+	Tag string `yaml:"tag,omitempty"`
+	// Apprise service URLs, sent with each notification when there's no
+	// saved configuration key
+	URLs     []string        `yaml:"urls,omitempty"`
+	Template MessageTemplate `yaml:"template,omitempty"`
+}
+
+func (a AppriseConfig) enabled() bool {
+	return a.URL != ""
+}
+
+func validateAppriseConfig(a *AppriseConfig) error {
+	if !a.enabled() {
+		return nil
+	}
+	u, err := url.Parse(a.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("notifications.apprise: %q is not an http(s) URL", a.URL)
+	}
+	if (a.Key == "") == (len(a.URLs) == 0) {
+		return fmt.Errorf("notifications.apprise needs either key or urls")
+	}
+	if a.Tag != "" && a.Key == "" {
+		return fmt.Errorf("notifications.apprise.tag needs a key")
+	}
+	return nil
+}
+
+type appriseNotifier struct {
+	cfg AppriseConfig
+}
+
+func (a appriseNotifier) String() string {
+	return "Apprise " + a.cfg.URL
+}
+
+// appriseRequest is the body of Apprise API's /notify endpoints
+type appriseRequest struct {
+	URLs  string `json:"urls,omitempty"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Type  string `json:"type"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+// appriseEndpoint is /notify/{key} for a saved configuration, or the
+// stateless /notify
+func appriseEndpoint(cfg AppriseConfig) string {
+	endpoint := strings.TrimRight(cfg.URL, "/") + "/notify"
+	if cfg.Key != "" {
+		endpoint += "/" + url.PathEscape(cfg.Key)
+	}
+	return endpoint
+}
+
+func (a appriseNotifier) send(ctx context.Context, n Notification) error {
+	req := appriseRequest{
+		Title: n.Title,
+		Body:  n.Message,
+		Type:  "info",
+		Tag:   a.cfg.Tag,
+	}
+	if a.cfg.Key == "" {
+		req.URLs = strings.Join(a.cfg.URLs, ",")
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := postJSON(ctx, appriseEndpoint(a.cfg), body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
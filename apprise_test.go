@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAppriseNotifications(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	rt := &recordTransport{}
+	upstreamTransport = rt
+
+	for _, tc := range []struct {
+		config, wantURL, wantBody string
+	}{
+		{
+			"{url: \"http://apprise:8000/\", key: muni commute, tag: trains}",
+			"http://apprise:8000/notify/muni%20commute",
+			`{"title":"Leave now: Work","body":"N in 6 min","type":"info","tag":"trains"}`,
+		},
+		{
+			"{url: \"http://apprise:8000\", urls: [\"gotify://host/token\", \"matrix://user:pw@host/!room\"]}",
+			"http://apprise:8000/notify",
+			`{"urls":"gotify://host/token,matrix://user:pw@host/!room","title":"Leave now: Work","body":"N in 6 min","type":"info"}`,
+		},
+	} {
+		cfg, err := parseConfig([]byte("api_key: test\nnotifications: {apprise: " + tc.config + "}\n" + testStop))
+		if err != nil {
+			t.Fatalf("parseConfig(%s): %v", tc.config, err)
+		}
+		activeConfig.Store(cfg)
+		rt.urls, rt.bodies = nil, nil
+		if !notify(context.Background(), Notification{Kind: "alarm", Title: "Leave now: Work", Message: "N in 6 min"}) {
+			t.Fatalf("%s: not delivered", tc.config)
+		}
+		if rt.urls[0] != tc.wantURL || rt.bodies[0] != tc.wantBody {
+			t.Errorf("%s: posted %s %s", tc.config, rt.urls[0], rt.bodies[0])
+		}
+	}
+
+	if got := redactConfig(currentConfig()).Notifications.Apprise.URLs; got[0] != redacted || got[1] != redacted {
+		t.Errorf("Apprise URLs not redacted: %v", got)
+	}
+	for _, bad := range []string{"{url: \"http://apprise:8000\"}", "{url: \"http://apprise:8000\", key: k, urls: [\"x://\"]}", "{url: apprise, key: k}"} {
+		if _, err := parseConfig([]byte("api_key: test\nnotifications: {apprise: " + bad + "}\n" + testStop)); err == nil {
+			t.Errorf("apprise %s should be rejected", bad)
+		}
+	}
+}
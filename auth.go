@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Admin authentication. Either a bearer token, basic auth credentials,
+// or both may be configured. Admin routes are disabled when neither is set.
+type AdminConfig struct {
+	Token    string `yaml:"token,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Files holding the token or password instead
+	TokenFile    string `yaml:"token_file,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+}
+
+func (a AdminConfig) enabled() bool {
+	return a.Token != "" || a.Username != ""
+}
+
+func validateAdminConfig(a AdminConfig) error {
+	if (a.Username == "") != (a.Password == "") {
+		return fmt.Errorf("admin.username and admin.password must be set together")
+	}
+	return nil
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// adminAuthorized checks the request against the configured credentials
+func adminAuthorized(r *http.Request) bool {
+	a := currentConfig().Admin
+
+	if a.Token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if secureCompare(token, a.Token) {
+				return true
+			}
+		}
+	}
+
+	if a.Username != "" {
+		if user, pass, ok := r.BasicAuth(); ok {
+			// Evaluate both comparisons to keep timing independent of which one fails
+			userOK := secureCompare(user, a.Username)
+			passOK := secureCompare(pass, a.Password)
+			if userOK && passOK {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// requireAdmin wraps mutating and diagnostic handlers with admin auth
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := currentConfig().Admin
+		if !admin.enabled() {
+			http.Error(w, "admin endpoints are disabled; configure admin credentials", http.StatusForbidden)
+			return
+		}
+
+		if !adminAuthorized(r) {
+			if admin.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="muni-tracker admin"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="muni-tracker admin"`)
+			}
+			warnf("Unauthorized admin request: %s %s from %s", r.Method, loggedPath(r), r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// adminRoutes holds the patterns registered through handleAdmin
+var adminRoutes = map[string]bool{}
+
+// handleAdmin registers an admin-only route for the given methods
+func handleAdmin(pattern string, handler http.HandlerFunc, methods ...string) {
+	adminRoutes[pattern] = true
+	handleRoute(pattern, requireAdmin(handler), methods...)
+}
+
+// isAdminRoute reports whether path is served by an admin-only route,
+// matching patterns the way http.ServeMux does
+func isAdminRoute(path string) bool {
+	for pattern := range adminRoutes {
+		if path == pattern || strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) {
+			return true
+		}
+	}
+	return false
+}
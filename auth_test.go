@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+
+	for _, raw := range []string{"admin: {username: admin}", "admin: {password: secret}"} {
+		if _, err := parseConfig([]byte("api_key: test\n" + raw + "\n" + testStop)); err == nil || !strings.Contains(err.Error(), "set together") {
+			t.Errorf("%s: err = %v", raw, err)
+		}
+	}
+
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	basic := func(user, pass string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, pass) }
+	}
+	const (
+		tokenOnly = "admin: {token: tok-123}"
+		basicOnly = "admin: {username: admin, password: secret}"
+		both      = "admin: {token: tok-123, username: admin, password: secret}"
+	)
+	tests := []struct {
+		name      string
+		admin     string
+		auth      func(*http.Request)
+		code      int
+		challenge string
+	}{
+		{"disabled", "", bearer("tok-123"), http.StatusForbidden, ""},
+		{"token", tokenOnly, bearer("tok-123"), http.StatusOK, ""},
+		{"wrong token", tokenOnly, bearer("tok-124"), http.StatusUnauthorized, "Bearer"},
+		{"token prefix", tokenOnly, bearer("tok-12"), http.StatusUnauthorized, "Bearer"},
+		{"lowercase scheme", tokenOnly, func(r *http.Request) { r.Header.Set("Authorization", "bearer tok-123") }, http.StatusUnauthorized, "Bearer"},
+		{"no credentials", tokenOnly, func(*http.Request) {}, http.StatusUnauthorized, "Bearer"},
+		{"basic against a token", tokenOnly, basic("admin", "tok-123"), http.StatusUnauthorized, "Bearer"},
+		{"basic", basicOnly, basic("admin", "secret"), http.StatusOK, ""},
+		{"wrong password", basicOnly, basic("admin", "secre"), http.StatusUnauthorized, "Basic"},
+		{"wrong username", basicOnly, basic("Admin", "secret"), http.StatusUnauthorized, "Basic"},
+		{"bearer against basic", basicOnly, bearer("secret"), http.StatusUnauthorized, "Basic"},
+		{"either: token", both, bearer("tok-123"), http.StatusOK, ""},
+		{"either: basic", both, basic("admin", "secret"), http.StatusOK, ""},
+		{"either: neither", both, basic("admin", "tok-123"), http.StatusUnauthorized, "Basic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseConfig([]byte("api_key: test\n" + tt.admin + "\n" + testStop))
+			if err != nil {
+				t.Fatalf("parseConfig: %v", err)
+			}
+			activeConfig.Store(cfg)
+			req := httptest.NewRequest("GET", "/api/admin/status", nil)
+			tt.auth(req)
+			rec := httptest.NewRecorder()
+			requireAdmin(func(w http.ResponseWriter, r *http.Request) {})(rec, req)
+			if rec.Code != tt.code {
+				t.Errorf("status = %d, want %d", rec.Code, tt.code)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); !strings.HasPrefix(got, tt.challenge) || (tt.challenge == "") != (got == "") {
+				t.Errorf("WWW-Authenticate = %q, want %s", got, tt.challenge)
+			}
+		})
+	}
+}
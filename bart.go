@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const bartAdvisoryURL = "https://api.bart.gov/api/bsa.aspx"
+
+// bartPublicKey is BART's published key for low-volume use
+const bartPublicKey = "MW9S-E7SL-26DU-VV8V"
+
+// BARTConfig controls the elevator/escalator advisory feed. It is used for
+// stops that set bart_station.
+type BARTConfig struct {
+	APIKey     string `yaml:"api_key,omitempty"`
+	APIKeyFile string `yaml:"api_key_file,omitempty"`
+	// Minutes between feed fetches (default 5)
+	RefreshInterval int `yaml:"refresh_interval,omitempty"`
+}
+
+func validateBARTConfig(b *BARTConfig) {
+	if b.RefreshInterval <= 0 {
+		b.RefreshInterval = 5
+	}
+}
+
+// bartStationNames lists each BART station code with the names notices
+// use for it, full name first
+var bartStationNames = map[string][]string{
+	"12TH": {"12th St. Oakland City Center", "12th St. Oakland", "12th St"},
+	"16TH": {"16th St. Mission", "16th St"},
+	"19TH": {"19th St. Oakland", "19th St"},
+	"24TH": {"24th St. Mission", "24th St"},
+	"ANTC": {"Antioch"},
+	"ASHB": {"Ashby"},
+	"BALB": {"Balboa Park"},
+	"BAYF": {"Bay Fair"},
+	"BERY": {"Berryessa/North San Jose", "Berryessa"},
+	"CAST": {"Castro Valley"},
+	"CIVC": {"Civic Center/UN Plaza", "Civic Center"},
+	"COLM": {"Colma"},
+	"COLS": {"Coliseum"},
+	"CONC": {"Concord"},
+	"DALY": {"Daly City"},
+	"DBRK": {"Downtown Berkeley"},
+	"DELN": {"El Cerrito del Norte"},
+	"DUBL": {"Dublin/Pleasanton"},
+	"EMBR": {"Embarcadero"},
+	"FRMT": {"Fremont"},
+	"FTVL": {"Fruitvale"},
+	"GLEN": {"Glen Park"},
+	"HAYW": {"Hayward"},
+	"LAFY": {"Lafayette"},
+	"LAKE": {"Lake Merritt"},
+	"MCAR": {"MacArthur"},
+	"MLBR": {"Millbrae"},
+	"MLPT": {"Milpitas"},
+	"MONT": {"Montgomery St", "Montgomery"},
+	"NBRK": {"North Berkeley"},
+	"NCON": {"North Concord/Martinez", "North Concord"},
+	"OAKL": {"Oakland International Airport", "Oakland Airport"},
+	"ORIN": {"Orinda"},
+	"PCTR": {"Pittsburg Center"},
+	"PHIL": {"Pleasant Hill/Contra Costa Centre", "Pleasant Hill"},
+	"PITT": {"Pittsburg/Bay Point", "Bay Point"},
+	"PLZA": {"El Cerrito Plaza"},
+	"POWL": {"Powell St", "Powell"},
+	"RICH": {"Richmond"},
+	"ROCK": {"Rockridge"},
+	"SANL": {"San Leandro"},
+	"SBRN": {"San Bruno"},
+	"SFIA": {"San Francisco International Airport", "SFO"},
+	"SHAY": {"South Hayward"},
+	"SSAN": {"South San Francisco"},
+	"UCTY": {"Union City"},
+	"WARM": {"Warm Springs/South Fremont", "Warm Springs"},
+	"WCRK": {"Walnut Creek"},
+	"WDUB": {"West Dublin/Pleasanton", "West Dublin"},
+	"WOAK": {"West Oakland"},
+}
+
+// bartStationCode resolves a configured bart_station, a code or any of a
+// station's names in any case, to its code
+func bartStationCode(station string) (string, bool) {
+	if _, ok := bartStationNames[strings.ToUpper(station)]; ok {
+		return strings.ToUpper(station), true
+	}
+	for code, names := range bartStationNames {
+		for _, name := range names {
+			if strings.EqualFold(name, station) {
+				return code, true
+			}
+		}
+	}
+	return "", false
+}
+
+type bartStationName struct{ code, name string }
+
+// bartNamesLongestFirst holds every lowercased station name, longest first
+var bartNamesLongestFirst = func() []bartStationName {
+	var names []bartStationName
+	for code, list := range bartStationNames {
+		for _, name := range list {
+			names = append(names, bartStationName{code, strings.ToLower(name)})
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i].name) != len(names[j].name) {
+			return len(names[i].name) > len(names[j].name)
+		}
+		return names[i].name < names[j].name
+	})
+	return names
+}()
+
+// stationsMentioned returns the codes of the stations text names. Longer
+// names are matched first and only as whole words, so "North Berkeley"
+// doesn't also count as Downtown Berkeley, nor "South Hayward" as Hayward.
+func stationsMentioned(text string) map[string]bool {
+	isWord := func(b byte) bool {
+		return b >= 'a' && b <= 'z' || b >= '0' && b <= '9'
+	}
+	lower := []byte(strings.ToLower(text))
+	found := make(map[string]bool)
+	for _, n := range bartNamesLongestFirst {
+		for from := 0; ; {
+			i := bytes.Index(lower[from:], []byte(n.name))
+			if i < 0 {
+				break
+			}
+			start, end := from+i, from+i+len(n.name)
+			from = end
+			if start > 0 && isWord(lower[start-1]) || end < len(lower) && isWord(lower[end]) {
+				continue
+			}
+			found[n.code] = true
+			// Blank the match so shorter names inside it don't match again
+			for k := start; k < end; k++ {
+				lower[k] = ' '
+			}
+		}
+	}
+	return found
+}
+
+// bartStations returns the BART station codes configured on stops
+func bartStations(cfg *Config) []string {
+	var names []string
+	for _, stop := range allStops(cfg) {
+		if stop.BARTStation != "" {
+			names = append(names, stop.BARTStation)
+		}
+	}
+	return names
+}
+
+// bartAdvisories holds the out-of-service notices per configured station
+// code
+var bartAdvisories struct {
+	sync.RWMutex
+	byStation map[string][]string
+}
+
+// stationAdvisories returns the current advisories for a BART station
+func stationAdvisories(station string) []string {
+	if station == "" {
+		return nil
+	}
+	bartAdvisories.RLock()
+	defer bartAdvisories.RUnlock()
+	return bartAdvisories.byStation[station]
+}
+
+// stopAdvisories returns the advisories for cached stop i of stops,
+// checking the name in case the cache lags a config reload
+func stopAdvisories(stops []Stop, i int, name string) []string {
+	if i >= len(stops) || stops[i].Name != name {
+		return nil
+	}
+	return stationAdvisories(stops[i].BARTStation)
+}
+
+// bartText reads a BART field that is either a string or a CDATA wrapper
+func bartText(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var cdata struct {
+		Text string `json:"#cdata-section"`
+	}
+	json.Unmarshal(raw, &cdata)
+	return cdata.Text
+}
+
+// fetchElevatorStatus returns the elevator and escalator notices that
+// mention each of the given station codes
+func fetchElevatorStatus(ctx context.Context, b BARTConfig, stations []string) (map[string][]string, error) {
+	key := b.APIKey
+	if key == "" {
+		key = bartPublicKey
+	}
+	q := url.Values{}
+	q.Set("cmd", "elev")
+	q.Set("key", key)
+	q.Set("json", "y")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, bartAdvisoryURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := upstreamClient().Do(req)
+	if err != nil {
+		// Keep the key out of logs
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("BART advisories returned HTTP %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Root struct {
+			BSA []struct {
+				Description json.RawMessage `json:"description"`
+			} `json:"bsa"`
+		} `json:"root"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to parse BART advisories: %w", err)
+	}
+
+	// Notices are free text listing every affected station, so split them
+	// into sentences and keep the ones naming a configured station
+	byStation := make(map[string][]string, len(stations))
+	for _, bsa := range body.Root.BSA {
+		for _, sentence := range splitSentences(bartText(bsa.Description)) {
+			mentioned := stationsMentioned(sentence)
+			for _, station := range stations {
+				if mentioned[station] {
+					byStation[station] = append(byStation[station], sentence)
+				}
+			}
+		}
+	}
+	return byStation, nil
+}
+
+func splitSentences(text string) []string {
+	var out []string
+	for _, part := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ';' }) {
+		for _, s := range strings.SplitAfter(part, ". ") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// startBARTRefresher polls the advisory feed while any stop names a BART
+// station, following config reloads like the weather refresher
+func startBARTRefresher(ctx context.Context) {
+	startPeriodicRefresher(ctx, func(cfg *Config) time.Duration {
+		if len(bartStations(cfg)) == 0 {
+			return 0
+		}
+		return time.Duration(cfg.BART.RefreshInterval) * time.Minute
+	}, refreshBARTAdvisories)
+}
+
+// refreshBARTAdvisories fetches the configured stations' notices
+func refreshBARTAdvisories(ctx context.Context) {
+	cfg := currentConfig()
+	stations := bartStations(cfg)
+	if len(stations) == 0 {
+		return
+	}
+	byStation, err := fetchElevatorStatus(ctx, cfg.BART, stations)
+	if err != nil {
+		warnf("Error fetching BART elevator status: %v", err)
+		return
+	}
+	bartAdvisories.Lock()
+	bartAdvisories.byStation = byStation
+	bartAdvisories.Unlock()
+	debugf("Fetched BART elevator status: %d stations affected", len(byStation))
+}
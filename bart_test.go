@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchElevatorStatus(t *testing.T) {
+	withTestEnv(t, time.Now(), `{"root":{"bsa":[{"station":"BART","type":"ELEVATOR","description":{"#cdata-section":"There are 2 elevators out of service at this time: Embarcadero Station street elevator. 19th St. Oakland platform elevator."}}]}}`)
+
+	got, err := fetchElevatorStatus(context.Background(), BARTConfig{}, []string{"EMBR", "POWL"})
+	if err != nil {
+		t.Fatalf("fetchElevatorStatus: %v", err)
+	}
+	if len(got["EMBR"]) != 1 || got["EMBR"][0] != "There are 2 elevators out of service at this time: Embarcadero Station street elevator." {
+		t.Errorf("Embarcadero advisories = %q", got["EMBR"])
+	}
+	if len(got["POWL"]) != 0 {
+		t.Errorf("Powell advisories = %q, want none", got["POWL"])
+	}
+
+	// Whole station names, longest first
+	for text, want := range map[string]string{
+		"North Berkeley street elevator":             "NBRK",
+		"South Hayward and Hayward platform":         "HAYW SHAY",
+		"Pleasant Hill/Contra Costa Centre elevator": "PHIL",
+		"West Oakland, 12th St. Oakland City Center": "12TH WOAK",
+		"Richmondshire elevator":                     "",
+	} {
+		var codes []string
+		for code := range stationsMentioned(text) {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		if got := strings.Join(codes, " "); got != want {
+			t.Errorf("stations in %q = %q, want %q", text, got, want)
+		}
+	}
+
+	// Stops may name a station by code or name
+	cfg, err := parseConfig([]byte("api_key: test\nstops: [{name: A, bart_station: Embarcadero, directions: [{label: B, stop_id: \"1\"}]}, {name: C, bart_station: powl, directions: [{label: D, stop_id: \"2\"}]}]"))
+	if err != nil || cfg.Stops[0].BARTStation != "EMBR" || cfg.Stops[1].BARTStation != "POWL" {
+		t.Errorf("bart_station codes: %v", err)
+	}
+	if _, err := parseConfig([]byte("api_key: test\nstops: [{name: A, bart_station: Embarkadero, directions: [{label: B, stop_id: \"1\"}]}]")); err == nil {
+		t.Error("an unknown bart_station was accepted")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultGBFSStatusURL is Bay Wheels' GBFS station_status feed
+const defaultGBFSStatusURL = "https://gbfs.lyft.com/gbfs/2.3/bay/en/station_status.json"
+
+// BikeshareConfig lists GBFS stations to show alongside arrivals
+type BikeshareConfig struct {
+	StatusURL string `yaml:"status_url,omitempty"`
+	// Minutes between feed fetches (default 2)
+	RefreshInterval int                `yaml:"refresh_interval,omitempty"`
+	Stations        []BikeshareStation `yaml:"stations,omitempty"`
+}
+
+type BikeshareStation struct {
+	Name      string `yaml:"name"`
+	StationID string `yaml:"station_id"`
+}
+
+func (b BikeshareConfig) enabled() bool {
+	return len(b.Stations) > 0
+}
+
+func validateBikeshareConfig(b *BikeshareConfig) error {
+	if !b.enabled() {
+		return nil
+	}
+	for _, s := range b.Stations {
+		if s.StationID == "" {
+			return fmt.Errorf("bikeshare station %q needs a station_id", s.Name)
+		}
+	}
+	if b.StatusURL == "" {
+		b.StatusURL = defaultGBFSStatusURL
+	}
+	if b.RefreshInterval <= 0 {
+		b.RefreshInterval = 2
+	}
+	return nil
+}
+
+// StationStatus is the availability at one configured station
+type StationStatus struct {
+	Name            string `json:"name"`
+	StationID       string `json:"station_id"`
+	BikesAvailable  int    `json:"bikes_available"`
+	EbikesAvailable int    `json:"ebikes_available"`
+	DocksAvailable  int    `json:"docks_available"`
+	Renting         bool   `json:"renting"`
+	LastReported    string `json:"last_reported,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+var bikeshareCache struct {
+	sync.RWMutex
+	data []StationStatus
+}
+
+// currentBikeshare returns the last fetched station statuses, or nil if
+// bikeshare is disabled or has not been fetched yet
+func currentBikeshare() []StationStatus {
+	if !currentConfig().Bikeshare.enabled() {
+		return nil
+	}
+	bikeshareCache.RLock()
+	defer bikeshareCache.RUnlock()
+	return bikeshareCache.data
+}
+
+// gbfsStationStatus is the part of a GBFS station_status record we use.
+// Renting is a bool in GBFS 2.x and 0/1 in 1.x. num_ebikes_available is a
+// Lyft extension that Bay Wheels publishes.
+type gbfsStationStatus struct {
+	StationID         string          `json:"station_id"`
+	NumBikesAvailable int             `json:"num_bikes_available"`
+	NumEbikes         *int            `json:"num_ebikes_available"`
+	NumDocksAvailable int             `json:"num_docks_available"`
+	IsRenting         json.RawMessage `json:"is_renting"`
+	LastReported      int64           `json:"last_reported"`
+}
+
+func fetchBikeshare(ctx context.Context, b BikeshareConfig) ([]StationStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.StatusURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := upstreamClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GBFS feed returned HTTP %d", resp.StatusCode)
+	}
+
+	var feed struct {
+		Data struct {
+			Stations []gbfsStationStatus `json:"stations"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("failed to parse GBFS feed: %w", err)
+	}
+
+	byID := make(map[string]gbfsStationStatus, len(feed.Data.Stations))
+	for _, s := range feed.Data.Stations {
+		byID[s.StationID] = s
+	}
+
+	out := make([]StationStatus, len(b.Stations))
+	for i, station := range b.Stations {
+		out[i] = StationStatus{Name: station.Name, StationID: station.StationID}
+		s, ok := byID[station.StationID]
+		if !ok {
+			out[i].Error = tr("Station not in feed")
+			continue
+		}
+		out[i].BikesAvailable = s.NumBikesAvailable
+		if s.NumEbikes != nil {
+			out[i].EbikesAvailable = *s.NumEbikes
+		}
+		out[i].DocksAvailable = s.NumDocksAvailable
+		out[i].Renting = string(s.IsRenting) == "true" || string(s.IsRenting) == "1"
+		if s.LastReported > 0 {
+			out[i].LastReported = time.Unix(s.LastReported, 0).UTC().Format(time.RFC3339)
+		}
+	}
+	return out, nil
+}
+
+// startBikeshareRefresher polls the GBFS feed on its own interval, following
+// config reloads like the weather refresher
+func startBikeshareRefresher(ctx context.Context) {
+	startPeriodicRefresher(ctx, func(cfg *Config) time.Duration {
+		if !cfg.Bikeshare.enabled() {
+			return 0
+		}
+		return time.Duration(cfg.Bikeshare.RefreshInterval) * time.Minute
+	}, refreshBikeshare)
+}
+
+// refreshBikeshare fetches the configured stations' status into the cache
+func refreshBikeshare(ctx context.Context) {
+	b := currentConfig().Bikeshare
+	if !b.enabled() {
+		return
+	}
+	stations, err := fetchBikeshare(ctx, b)
+	if err != nil {
+		warnf("Error fetching bikeshare status: %v", err)
+		return
+	}
+	bikeshareCache.Lock()
+	bikeshareCache.data = stations
+	bikeshareCache.Unlock()
+	debugf("Fetched bikeshare status for %d stations", len(stations))
+}
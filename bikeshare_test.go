@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFetchBikeshare(t *testing.T) {
+	withTestEnv(t, time.Now(), `{"data":{"stations":[
+		{"station_id":"a","num_bikes_available":4,"num_ebikes_available":2,"num_docks_available":11,"is_renting":true,"last_reported":1769803200},
+		{"station_id":"b","num_bikes_available":0,"num_docks_available":20,"is_renting":0}
+	]}}`)
+
+	b := BikeshareConfig{Stations: []BikeshareStation{{Name: "A", StationID: "a"}, {Name: "B", StationID: "b"}, {Name: "C", StationID: "c"}}}
+	if err := validateBikeshareConfig(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fetchBikeshare(context.Background(), b)
+	if err != nil {
+		t.Fatalf("fetchBikeshare: %v", err)
+	}
+	want := []StationStatus{
+		{Name: "A", StationID: "a", BikesAvailable: 4, EbikesAvailable: 2, DocksAvailable: 11, Renting: true, LastReported: "2026-01-30T20:00:00Z"},
+		{Name: "B", StationID: "b", DocksAvailable: 20},
+		{Name: "C", StationID: "c", Error: "Station not in feed"},
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("station %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
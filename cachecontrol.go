@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// arrivalsMaxAge is how long an arrivals response may be reused once
+// served. Minutes are whole numbers, so in half a minute a count is off
+// by one at most.
+const arrivalsMaxAge = 30 * time.Second
+
+// configMaxAge is how long /api/config may be reused; it only changes
+// when the config does
+const configMaxAge = time.Hour
+
+// datasetMaxAge is how long stop, line, agency and shape data may be
+// reused. It comes from feeds refreshed daily at most.
+const datasetMaxAge = 24 * time.Hour
+
+// setCacheControl allows caching a response for maxAge. Responses that
+// depend on a client key or a profile are only cached by the browser.
+func setCacheControl(w http.ResponseWriter, r *http.Request, maxAge time.Duration) {
+	scope := "public"
+	if len(currentConfig().ClientKeys) > 0 || r.URL.Query().Get("profile") != "" {
+		scope = "private"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds())))
+}
+
+// setLastModified sets Last-Modified and reports whether the request's
+// If-Modified-Since makes a body unnecessary, in which case 304 has been
+// written
+func setLastModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	if modified.IsZero() {
+		return false
+	}
+	modified = modified.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// setConfigCaching sets caching headers for /api/config, reporting
+// whether 304 has been written. A profile's stops change whenever it is
+// saved, so those responses are revalidated every time.
+func setConfigCaching(w http.ResponseWriter, r *http.Request, cfg *Config) bool {
+	if r.URL.Query().Get("profile") != "" {
+		w.Header().Set("Cache-Control", "private, no-cache")
+		return false
+	}
+	setCacheControl(w, r, configMaxAge)
+	return setLastModified(w, r, cfg.loadedAt)
+}
+
+// setArrivalsCaching sets caching headers for an arrivals response.
+// Caches count Age, the data age, against max-age, so it is added on to
+// keep the response usable for arrivalsMaxAge after it is served.
+// Last-Modified is the newest fetch, for information only: minutes
+// change without a fetch, so conditional requests always get a body.
+func setArrivalsCaching(w http.ResponseWriter, r *http.Request, resp ArrivalsResponse, now time.Time) {
+	age, _ := dataAge(resp, now)
+	setCacheControl(w, r, age.Truncate(time.Second)+arrivalsMaxAge)
+
+	var newest time.Time
+	for _, stop := range resp.Stops {
+		for _, dir := range stop.Directions {
+			if dir.UpdatedAt.After(newest) {
+				newest = dir.UpdatedAt
+			}
+		}
+	}
+	if !newest.IsZero() {
+		w.Header().Set("Last-Modified", newest.UTC().Format(http.TimeFormat))
+	}
+}
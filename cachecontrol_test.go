@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheControl(t *testing.T) {
+	start := time.Date(2026, 3, 4, 20, 0, 0, 0, time.UTC)
+	fc, _ := withTestEnv(t, start, `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`)
+	refreshCache()
+	fc.now = start.Add(90 * time.Second)
+
+	get := func(handler http.HandlerFunc, path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// max-age counts from the fetch, like Age
+	rec := get(handleArrivals, "/api/arrivals")
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=120" || rec.Header().Get("Age") != "90" {
+		t.Errorf("arrivals Cache-Control = %q, Age = %q", got, rec.Header().Get("Age"))
+	}
+	if got := rec.Header().Get("Last-Modified"); got != "Wed, 04 Mar 2026 20:00:00 GMT" {
+		t.Errorf("arrivals Last-Modified = %q", got)
+	}
+	if rec := get(handleArrivals, "/api/arrivals", "If-Modified-Since", "Wed, 04 Mar 2026 20:00:00 GMT"); rec.Code != http.StatusOK {
+		t.Errorf("conditional arrivals = %d", rec.Code)
+	}
+	if got := get(handleNext, "/api/next").Header().Get("Cache-Control"); got != "public, max-age=120" {
+		t.Errorf("next Cache-Control = %q", got)
+	}
+
+	rec = get(handleConfig, "/api/config")
+	modified := rec.Header().Get("Last-Modified")
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=3600" || modified == "" {
+		t.Errorf("config Cache-Control = %q, Last-Modified = %q", got, modified)
+	}
+	if rec := get(handleConfig, "/api/config", "If-Modified-Since", modified); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("conditional config = %d", rec.Code)
+	}
+
+	// Keyed responses stay out of shared caches
+	cfg := *currentConfig()
+	cfg.ClientKeys = []ClientKey{{Name: "kitchen", Key: "secret-key"}}
+	activeConfig.Store(&cfg)
+	if got := get(handleArrivals, "/api/arrivals").Header().Get("Cache-Control"); got != "private, max-age=120" {
+		t.Errorf("keyed arrivals Cache-Control = %q", got)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // the runtime image has no zoneinfo
+)
+
+// defaultTimezone is where the supported agencies run
+const defaultTimezone = "America/Los_Angeles"
+
+// localTime converts t to the configured timezone
+func localTime(t time.Time) time.Time {
+	if config := currentConfig(); config != nil && config.location != nil {
+		return t.In(config.location)
+	}
+	return t
+}
+
+// Display formats for time_format. Anything else is used as a Go time
+// layout.
+const (
+	timeFormat12h = "12h"
+	timeFormat24h = "24h"
+)
+
+// displayLayouts returns the configured layouts for clock times with and
+// without seconds
+func displayLayouts() (withSeconds, short string) {
+	format := ""
+	if config := currentConfig(); config != nil {
+		format = config.TimeFormat
+	}
+	switch format {
+	case "", timeFormat12h:
+		return "3:04:05 PM", "3:04 PM"
+	case timeFormat24h:
+		return "15:04:05", "15:04"
+	}
+	return format, format
+}
+
+// displayClock formats t for display with seconds, in the configured
+// timezone and format
+func displayClock(t time.Time) string {
+	layout, _ := displayLayouts()
+	return localTime(t).Format(layout)
+}
+
+// displayTime formats t for display to the minute
+func displayTime(t time.Time) string {
+	_, layout := displayLayouts()
+	return localTime(t).Format(layout)
+}
+
+// Service day types. Holidays run on the Sunday schedule.
+const (
+	serviceWeekday  = "weekday"
+	serviceSaturday = "saturday"
+	serviceSunday   = "sunday"
+)
+
+// ServiceCalendarConfig lists days that run a reduced schedule
+type ServiceCalendarConfig struct {
+	// Dates (YYYY-MM-DD) that run the Sunday/holiday schedule
+	Holidays []string `yaml:"holidays,omitempty"`
+}
+
+func parseHolidays(dates []string) (map[string]bool, error) {
+	holidays := make(map[string]bool, len(dates))
+	for _, d := range dates {
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return nil, fmt.Errorf("invalid service_calendar.holidays date %q (want YYYY-MM-DD)", d)
+		}
+		holidays[d] = true
+	}
+	return holidays, nil
+}
+
+// serviceDayType classifies the service day containing t
+func serviceDayType(t time.Time) string {
+	t = localTime(t)
+	if currentConfig().holidays[t.Format("2006-01-02")] {
+		return serviceSunday
+	}
+	switch t.Weekday() {
+	case time.Saturday:
+		return serviceSaturday
+	case time.Sunday:
+		return serviceSunday
+	}
+	return serviceWeekday
+}
+
+// dayNames are the day names accepted in days lists
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// dayRange parses a day name or a range of them such as Mon-Fri. Ranges
+// may wrap past Sunday.
+func dayRange(d string) (from, to time.Weekday, ok bool) {
+	first, last, isRange := strings.Cut(strings.ToLower(d), "-")
+	if from, ok = dayNames[first]; !ok {
+		return 0, 0, false
+	}
+	if !isRange {
+		return from, from, true
+	}
+	to, ok = dayNames[last]
+	return from, to, ok
+}
+
+// validateDays checks a days list, whose entries are service day types
+// (weekday, saturday, sunday), day names, or ranges such as Mon-Fri
+func validateDays(days []string) error {
+	for _, d := range days {
+		switch strings.ToLower(d) {
+		case serviceWeekday, serviceSaturday, serviceSunday:
+			continue
+		}
+		if _, _, ok := dayRange(d); !ok {
+			return fmt.Errorf("unknown day %q (want weekday, saturday, sunday, or day names like Mon-Fri)", d)
+		}
+	}
+	return nil
+}
+
+// onDays reports whether the local day containing t is in days. Service
+// day types follow the holiday calendar; day names don't. An empty list
+// means every day.
+func onDays(days []string, t time.Time) bool {
+	if len(days) == 0 {
+		return true
+	}
+	dayType, weekday := serviceDayType(t), localTime(t).Weekday()
+	for _, d := range days {
+		if strings.EqualFold(d, dayType) {
+			return true
+		}
+		if from, to, ok := dayRange(d); ok && (weekday-from+7)%7 <= (to-from+7)%7 {
+			return true
+		}
+	}
+	return false
+}
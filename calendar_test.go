@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDisplayTimeFormat(t *testing.T) {
+	now := time.Date(2026, 1, 30, 20, 0, 5, 0, time.UTC) // 12:00:05 PM Pacific
+	withTestEnv(t, now, "")
+	cache.mu.Lock()
+	cache.data = ArrivalsResponse{Stops: []StopArrivals{{
+		Name:       "Embarcadero",
+		Directions: []DirectionArrivals{{Label: "Ocean Beach", StopID: "16994", Arrivals: arrivalsAt(now.Add(75 * time.Minute))}},
+	}}}
+	cache.mu.Unlock()
+
+	resp := buildArrivalsResponse(now)
+	if _, err := time.Parse(time.RFC3339, resp.LastUpdated); err != nil {
+		t.Errorf("last_updated %q is not RFC3339", resp.LastUpdated)
+	}
+	if resp.LastUpdatedDisplay != "12:00:05 PM" || resp.Stops[0].Directions[0].Arrivals[0].DisplayTime != "1:15 PM" {
+		t.Errorf("12h display = %q, %q", resp.LastUpdatedDisplay, resp.Stops[0].Directions[0].Arrivals[0].DisplayTime)
+	}
+
+	cfg := *currentConfig()
+	for format, want := range map[string]string{"24h": "13:15", "15h04": "13h15"} {
+		cfg.TimeFormat = format
+		activeConfig.Store(&cfg)
+		if got := buildArrivalsResponse(now).Stops[0].Directions[0].Arrivals[0].DisplayTime; got != want {
+			t.Errorf("%s display time = %q, want %q", format, got, want)
+		}
+	}
+}
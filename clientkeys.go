@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client API keys, one per device or kiosk. When any are configured,
+// requests to /api/* must present a key via the X-API-Key header or
+// the `key` query parameter.
+type ClientKey struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+func validateClientKeys(keys []ClientKey) error {
+	seen := make(map[string]bool)
+	for i, k := range keys {
+		if k.Name == "" || k.Key == "" {
+			return fmt.Errorf("client_keys[%d]: name and key are required", i)
+		}
+		if seen[k.Key] {
+			return fmt.Errorf("client_keys[%d]: duplicate key for %q", i, k.Name)
+		}
+		seen[k.Key] = true
+	}
+	return nil
+}
+
+// Per-key request counters
+type ClientStats struct {
+	Name     string    `json:"name"`
+	Requests int64     `json:"requests"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type clientCounters struct {
+	mu    sync.Mutex
+	stats map[string]*ClientStats
+}
+
+var clients = &clientCounters{stats: make(map[string]*ClientStats)}
+
+func (c *clientCounters) record(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.stats[name]
+	if !ok {
+		s = &ClientStats{Name: name}
+		c.stats[name] = s
+	}
+	s.Requests++
+	s.LastSeen = clock.Now()
+}
+
+func (c *clientCounters) snapshot() []ClientStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]ClientStats, 0, len(c.stats))
+	for _, s := range c.stats {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// clientKeyName returns the name of the client presenting a valid key
+func clientKeyName(r *http.Request) (string, bool) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = r.URL.Query().Get("key")
+	}
+	if key == "" {
+		return "", false
+	}
+	for _, k := range currentConfig().ClientKeys {
+		if secureCompare(key, k.Key) {
+			return k.Name, true
+		}
+	}
+	return "", false
+}
+
+// requireClientKey enforces client keys on /api/* when any are configured.
+// Admin routes, including /api/debug/*, are protected by admin auth instead.
+func requireClientKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := apiPath(r.URL.Path)
+		if len(currentConfig().ClientKeys) == 0 ||
+			!strings.HasPrefix(path, "/api/") ||
+			strings.HasPrefix(path, "/api/admin/") || isAdminRoute(path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name, ok := clientKeyName(r)
+		if !ok {
+			http.Error(w, "missing or invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		clients.record(name)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleAdminClients(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clients.snapshot())
+}
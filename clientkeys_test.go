@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequireClientKey(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+	oldRoutes := adminRoutes
+	adminRoutes = map[string]bool{"/api/debug/raw/": true}
+	t.Cleanup(func() { adminRoutes = oldRoutes })
+
+	for raw, want := range map[string]string{
+		"client_keys: [{name: kiosk}]":                              "name and key are required",
+		"client_keys: [{key: kiosk-key}]":                           "name and key are required",
+		"client_keys: [{name: a, key: same}, {name: b, key: same}]": "duplicate key",
+	} {
+		if _, err := parseConfig([]byte("api_key: test\n" + raw + "\n" + testStop)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", raw, err, want)
+		}
+	}
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+client_keys: [{name: kiosk, key: kiosk-key}, {name: phone, key: phone-key}]
+stops:
+  - name: Embarcadero
+    directions: [{label: Ocean Beach, stop_id: "16994"}]
+dashboards:
+  - path: office
+    stops:
+      - name: 16th & Mission
+        directions: [{label: Marina, stop_id: "13300"}]
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+	clients.mu.Lock()
+	clear(clients.stats)
+	clients.mu.Unlock()
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+		code   int
+		client string
+	}{
+		{"header", "/api/arrivals", "kiosk-key", http.StatusOK, "kiosk"},
+		{"query", "/api/arrivals?key=phone-key", "", http.StatusOK, "phone"},
+		{"header wins over query", "/api/arrivals?key=phone-key", "kiosk-key", http.StatusOK, "kiosk"},
+		{"missing", "/api/arrivals", "", http.StatusUnauthorized, ""},
+		{"wrong", "/api/arrivals", "kiosk-ke", http.StatusUnauthorized, ""},
+		{"bad header, good query", "/api/arrivals?key=phone-key", "nope", http.StatusUnauthorized, ""},
+		{"name instead of key", "/api/arrivals", "kiosk", http.StatusUnauthorized, ""},
+		{"dashboard api", "/office/api/arrivals", "", http.StatusUnauthorized, ""},
+		{"dashboard api with key", "/office/api/arrivals", "phone-key", http.StatusOK, "phone"},
+		{"admin api", "/api/admin/status", "", http.StatusOK, ""},
+		{"admin debug route", "/api/debug/raw/16994", "", http.StatusOK, ""},
+		{"other debug route", "/api/debug/other", "", http.StatusUnauthorized, ""},
+		{"page", "/", "", http.StatusOK, ""},
+		{"static", "/static/app.js", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("X-API-Key", tt.header)
+			}
+			var seen string
+			rec := httptest.NewRecorder()
+			requireClientKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen, _ = clientKeyName(r)
+			})).ServeHTTP(rec, req)
+			if rec.Code != tt.code || seen != tt.client {
+				t.Errorf("status = %d for client %q, want %d for %q", rec.Code, seen, tt.code, tt.client)
+			}
+		})
+	}
+
+	// Only requests that presented a key are counted
+	var counts []string
+	for _, s := range clients.snapshot() {
+		counts = append(counts, fmt.Sprintf("%s=%d", s.Name, s.Requests))
+		if !s.LastSeen.Equal(fc.Now()) {
+			t.Errorf("%s last seen %v, want %v", s.Name, s.LastSeen, fc.Now())
+		}
+	}
+	if got := strings.Join(counts, " "); got != "kiosk=2 phone=2" {
+		t.Errorf("client counters = %s", got)
+	}
+}
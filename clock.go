@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Clock is the time source for fetching, caching, and quality detection.
+// Tests substitute a fixed clock so peak-hour checks, minute recalculation,
+// and refresh pacing are deterministic.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+var clock Clock = systemClock{}
+
+// upstreamTransport carries all upstream API requests. Tests replace it
+// with a RoundTripper that serves canned responses.
+var upstreamTransport http.RoundTripper = &http.Transport{
+	MaxIdleConns:        10,
+	MaxIdleConnsPerHost: 5,
+	IdleConnTimeout:     30 * time.Second,
+}
+
+// upstreamClient returns the HTTP client used for upstream requests.
+// Requests made with a traced context carry its traceparent, and GETs are
+// retried per the retry policy. The timeout covers the retries.
+func upstreamClient() *http.Client {
+	return &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: tracingTransport{retryTransport{upstreamTransport}},
+	}
+}
+
+// upstreamDelay paces consecutive upstream requests during a refresh
+const upstreamDelay = 1500 * time.Millisecond
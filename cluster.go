@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// SharedCacheConfig lets several instances serve one board while only one
+// of them, the leader, fetches from upstream. The leader writes its cache
+// to a file on storage every instance mounts, such as NFS, and the others
+// serve what it wrote. The leader holds a lease in a file beside it; when
+// the lease runs out, another instance takes over.
+type SharedCacheConfig struct {
+	// The shared cache file. The lease is kept at this path plus ".lease".
+	Path string `yaml:"path,omitempty"`
+	// How long a leader's lease lasts without renewal, in seconds
+	// (default 30). It is renewed every third of that.
+	LeaseSeconds int `yaml:"lease_seconds,omitempty"`
+	// Names this instance in the lease and /api/status (default
+	// hostname:pid)
+	InstanceID string `yaml:"instance_id,omitempty"`
+}
+
+func (s SharedCacheConfig) enabled() bool {
+	return s.Path != ""
+}
+
+func (s SharedCacheConfig) lease() time.Duration {
+	return time.Duration(s.LeaseSeconds) * time.Second
+}
+
+func validateSharedCacheConfig(s *SharedCacheConfig) error {
+	if !s.enabled() {
+		return nil
+	}
+	if s.LeaseSeconds < 0 {
+		return fmt.Errorf("shared_cache.lease_seconds cannot be negative")
+	}
+	if s.LeaseSeconds == 0 {
+		s.LeaseSeconds = 30
+	}
+	if s.LeaseSeconds < 3*int(clusterTick/time.Second) {
+		return fmt.Errorf("shared_cache.lease_seconds must be at least %d", 3*int(clusterTick/time.Second))
+	}
+	if s.InstanceID == "" {
+		host, _ := os.Hostname()
+		s.InstanceID = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+	return nil
+}
+
+// clusterTick is how often instances check the lease, and how soon
+// followers see what the leader fetched
+const clusterTick = 2 * time.Second
+
+// leaseFile is who leads, until when
+type leaseFile struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// clusterState is this instance's part in a shared cache
+type clusterState struct {
+	mu      sync.Mutex
+	leading bool
+	// Holder of the lease when last read
+	leader  string
+	expires time.Time
+	// The refresh last published, when leading
+	published time.Time
+	// The shared file as last read, when following
+	seenModTime time.Time
+	seenSize    int64
+	lastSync    time.Time
+}
+
+var cluster = &clusterState{}
+
+// leading reports whether this instance fetches from upstream: always,
+// unless it shares a cache and another instance holds the lease
+func leading() bool {
+	if !currentConfig().SharedCache.enabled() {
+		return true
+	}
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	return cluster.leading
+}
+
+// startCluster joins the shared cache, if one is configured. The first
+// round runs before returning, so an instance that follows serves the
+// leader's cache from the start instead of fetching its own.
+func startCluster(ctx context.Context) {
+	sc := currentConfig().SharedCache
+	if !sc.enabled() {
+		return
+	}
+	infof("Shared cache at %s as %s", sc.Path, sc.InstanceID)
+	cluster.step(sc, clock.Now())
+	go func() {
+		for sleepContext(ctx, clusterTick) {
+			cluster.step(currentConfig().SharedCache, clock.Now())
+		}
+	}()
+}
+
+// step renews or contests the lease, then publishes the cache when
+// leading or takes on the leader's when not
+func (c *clusterState) step(sc SharedCacheConfig, now time.Time) {
+	if !sc.enabled() {
+		return
+	}
+	if !c.isLeading() {
+		c.follow(sc, now)
+	}
+	c.elect(sc, now)
+	if c.isLeading() {
+		c.publish(sc)
+	}
+}
+
+func (c *clusterState) isLeading() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leading
+}
+
+// elect claims the lease when it is free, expired, or already ours and
+// due for renewal. Two instances claiming at once both write; the last
+// write wins, and the other sees so when it reads the lease back.
+func (c *clusterState) elect(sc SharedCacheConfig, now time.Time) {
+	path := sc.Path + ".lease"
+	lease, err := readLease(path)
+	if err != nil {
+		warnf("Shared cache: %v", err)
+		// Without the lease, lead only as long as the last claim lasts
+		c.mu.Lock()
+		expired := c.leading && !now.Before(c.expires)
+		c.mu.Unlock()
+		if expired {
+			c.setLeader(sc, "", now)
+		}
+		return
+	}
+
+	mine := lease.Holder == sc.InstanceID
+	if mine && now.Before(lease.Expires.Add(-sc.lease()*2/3)) {
+		c.setLeader(sc, lease.Holder, lease.Expires)
+		return
+	}
+	if !mine && lease.Holder != "" && now.Before(lease.Expires) {
+		c.setLeader(sc, lease.Holder, lease.Expires)
+		return
+	}
+
+	claim := leaseFile{Holder: sc.InstanceID, Expires: now.Add(sc.lease())}
+	data, err := json.Marshal(claim)
+	if err == nil {
+		err = writeFileAtomic(path, data)
+	}
+	if err == nil {
+		lease, err = readLease(path)
+	}
+	if err != nil {
+		warnf("Shared cache: claiming the lease: %v", err)
+		return
+	}
+	c.setLeader(sc, lease.Holder, lease.Expires)
+}
+
+// setLeader records who leads, logging when this instance's part changes
+func (c *clusterState) setLeader(sc SharedCacheConfig, holder string, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	was, wasLeader := c.leading, c.leader
+	c.leading = holder == sc.InstanceID
+	c.leader, c.expires = holder, expires
+	switch {
+	case c.leading && !was:
+		infof("Shared cache: leading; this instance fetches for all of them")
+		// Publish straight away, so followers know the new leader's state
+		c.published = time.Time{}
+	case !c.leading && holder != "" && (was || holder != wasLeader):
+		infof("Shared cache: following %s", holder)
+	case !c.leading && holder == "" && was:
+		warnf("Shared cache: lost the lease; no longer fetching")
+	}
+}
+
+// publish writes the cache for followers after each refresh
+func (c *clusterState) publish(sc SharedCacheConfig) {
+	refresher.mu.Lock()
+	done := refresher.lastComplete
+	refresher.mu.Unlock()
+
+	c.mu.Lock()
+	due := !done.Equal(c.published)
+	c.mu.Unlock()
+	if !due {
+		return
+	}
+	if err := saveSnapshot(sc.Path); err != nil {
+		errorf("Shared cache: writing %s: %v", sc.Path, err)
+		return
+	}
+	c.mu.Lock()
+	c.published = done
+	c.mu.Unlock()
+}
+
+// follow takes on the cache the leader last wrote, when it has changed
+func (c *clusterState) follow(sc SharedCacheConfig, now time.Time) {
+	fi, err := os.Stat(sc.Path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		warnf("Shared cache: %v", err)
+		return
+	}
+	c.mu.Lock()
+	seen := fi.ModTime().Equal(c.seenModTime) && fi.Size() == c.seenSize
+	c.seenModTime, c.seenSize = fi.ModTime(), fi.Size()
+	c.mu.Unlock()
+	if seen {
+		return
+	}
+
+	snap, err := readSnapshot(sc.Path)
+	if err != nil {
+		warnf("Shared cache: %v", err)
+		return
+	}
+	if !applySnapshot(snap) {
+		warnf("Shared cache: %s holds other stops than this instance's config; not serving it", sc.Path)
+		return
+	}
+	c.mu.Lock()
+	c.lastSync = now
+	c.mu.Unlock()
+}
+
+// readLease reads the lease file; a missing one is a free lease
+func readLease(path string) (leaseFile, error) {
+	var lease leaseFile
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return lease, nil
+	}
+	if err != nil {
+		return lease, err
+	}
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return lease, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return lease, nil
+}
+
+// ClusterStatus is this instance's part in a shared cache, for /api/status
+type ClusterStatus struct {
+	Instance string `json:"instance"`
+	Leading  bool   `json:"leading"`
+	// Holder of the lease, if anyone holds it
+	Leader       string     `json:"leader,omitempty"`
+	LeaseExpires *time.Time `json:"lease_expires,omitempty"`
+	// When a follower last took on the leader's cache
+	LastSync *time.Time `json:"last_sync,omitempty"`
+}
+
+// clusterStatus describes the shared cache, or is nil without one
+func clusterStatus() *ClusterStatus {
+	sc := currentConfig().SharedCache
+	if !sc.enabled() {
+		return nil
+	}
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	return &ClusterStatus{
+		Instance:     sc.InstanceID,
+		Leading:      cluster.leading,
+		Leader:       cluster.leader,
+		LeaseExpires: optionalTime(cluster.expires),
+		LastSync:     optionalTime(cluster.lastSync),
+	}
+}
+
+// leaveCluster gives up the lease on shutdown, after a last publish, so
+// another instance takes over at once rather than when the lease runs out
+func leaveCluster() {
+	sc := currentConfig().SharedCache
+	if !sc.enabled() || !cluster.isLeading() {
+		return
+	}
+	cluster.publish(sc)
+	path := sc.Path + ".lease"
+	if lease, err := readLease(path); err == nil && lease.Holder == sc.InstanceID {
+		if err := os.Remove(path); err != nil {
+			warnf("Shared cache: giving up the lease: %v", err)
+		}
+	}
+}
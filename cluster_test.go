@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"muni-tracker/pkg/go511"
+)
+
+func TestSharedCache(t *testing.T) {
+	fc, ft := withTestEnv(t, time.Date(2026, 3, 17, 8, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-03-17T08:12:00Z"}}}
+	]}}}`)
+	dir := t.TempDir()
+	a := SharedCacheConfig{Path: filepath.Join(dir, "cache.json"), LeaseSeconds: 30, InstanceID: "a"}
+	b := a
+	b.InstanceID = "b"
+	cfg := *currentConfig()
+	cfg.SharedCache = a
+	activeConfig.Store(&cfg)
+	old := cluster
+	defer func() { cluster = old }()
+	cluster = &clusterState{}
+	leader := cluster
+
+	// The first instance up takes the lease and publishes what it fetches
+	leader.step(a, fc.now)
+	if !leading() {
+		t.Fatal("first instance isn't leading")
+	}
+	refreshCache()
+	leader.step(a, fc.now)
+	if lease, _ := readLease(a.Path + ".lease"); lease.Holder != "a" {
+		t.Errorf("lease holder = %q, want a", lease.Holder)
+	}
+	_, revision := cache.revisions()
+	want := buildArrivalsPage(fc.now, allArrivals)
+
+	// Another instance serves the leader's cache, revisions and all, and
+	// fetches nothing itself
+	cache.mu.Lock()
+	cache.data, cache.revision, cache.changed = ArrivalsResponse{}, 0, nil
+	cache.mu.Unlock()
+	follower := &clusterState{}
+	cluster = follower
+	follower.step(b, fc.now)
+	if follower.isLeading() {
+		t.Fatal("second instance took the lease from a live leader")
+	}
+	if _, got := cache.revisions(); got != revision {
+		t.Errorf("follower revision = %d, want %d", got, revision)
+	}
+	if got := buildArrivalsPage(fc.now, allArrivals); !reflectEqualJSON(t, got, want) {
+		t.Errorf("follower arrivals = %+v, want %+v", got, want)
+	}
+	before := ft.requests
+	req := httptest.NewRequest("POST", "/api/admin/refresh", nil)
+	rec := httptest.NewRecorder()
+	handleAdminRefresh(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("refresh on a follower = %d, want 409", rec.Code)
+	}
+	// Nor does a config change, such as a remote config adding a stop
+	moved := cfg
+	moved.SharedCache = b
+	moved.Stops = append(append([]Stop{}, cfg.Stops...), Stop{Name: "Church", Directions: []Direction{{Label: "Inbound", StopID: "14448"}}})
+	applyConfig(&moved)
+	sched.tick(context.Background())
+	time.Sleep(10 * time.Millisecond) // a stray refresh would run in the background
+	if ft.requests != before {
+		t.Errorf("follower made %d upstream requests", ft.requests-before)
+	}
+	activeConfig.Store(&cfg)
+	if status := clusterStatus(); status == nil || status.Leading || status.Leader != "a" {
+		t.Errorf("follower status = %+v", status)
+	}
+
+	// When the leader stops renewing, the follower takes over, and the old
+	// leader follows once it's back
+	fc.Sleep(31 * time.Second)
+	follower.step(b, fc.now)
+	if !follower.isLeading() {
+		t.Fatal("follower didn't take over an expired lease")
+	}
+	leader.step(a, fc.now)
+	if leader.isLeading() {
+		t.Error("old leader still leading after losing the lease")
+	}
+
+	// A leader that shuts down frees the lease
+	cfg.SharedCache = b
+	activeConfig.Store(&cfg)
+	leaveCluster()
+	if lease, _ := readLease(a.Path + ".lease"); lease.Holder != "" {
+		t.Errorf("lease holder after shutdown = %q, want none", lease.Holder)
+	}
+}
+
+func TestSharedCacheFeeds(t *testing.T) {
+	fc, ft := withTestEnv(t, time.Date(2026, 3, 17, 8, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`)
+	dir := t.TempDir()
+	a := SharedCacheConfig{Path: filepath.Join(dir, "cache.json"), LeaseSeconds: 30, InstanceID: "a"}
+	b := a
+	b.InstanceID = "b"
+	cfg := *currentConfig()
+	cfg.SharedCache = a
+	cfg.GTFS.Dir = t.TempDir()
+	activeConfig.Store(&cfg)
+	old := cluster
+	defer func() { cluster = old }()
+	defer func() {
+		weatherCache.data, bikeshareCache.data, bartAdvisories.byStation = nil, nil, nil
+		clear(dashboardCache.byPath)
+		clear(datasets.entries)
+	}()
+	leader := &clusterState{}
+	cluster = leader
+
+	// The leader publishes its side feeds along with the board
+	weather := &Weather{Temperature: 14, TemperatureUnit: "°C", Description: "Fog"}
+	bikes := []StationStatus{{Name: "Market & 4th", StationID: "s1", BikesAvailable: 3}}
+	advisories := map[string][]string{"EMBR": {"Elevator out of service"}}
+	lines := []LineInfo{{ID: "N", Name: "Judah", Monitored: true}}
+	weatherCache.data, bikeshareCache.data, bartAdvisories.byStation = weather, bikes, advisories
+	board := ArrivalsResponse{Stops: []StopArrivals{{Name: "Church", Directions: []DirectionArrivals{{Label: "Inbound"}}}}}
+	fetched := fc.now
+	board.Stops[0].Directions[0].FetchedAt = fetched
+	dashboardCache.byPath["/kitchen"] = board
+	datasets.entries["lines:SF"] = datasetEntry{value: lines, fetchedAt: fc.now}
+	leader.step(a, fc.now)
+	refreshCache()
+	leader.step(a, fc.now)
+
+	weatherCache.data, bikeshareCache.data, bartAdvisories.byStation = nil, nil, nil
+	clear(dashboardCache.byPath)
+	clear(datasets.entries)
+	follower := &clusterState{}
+	cluster = follower
+	follower.step(b, fc.now)
+	if follower.isLeading() {
+		t.Fatal("second instance took the lease from a live leader")
+	}
+	if !reflect.DeepEqual(weatherCache.data, weather) || !reflect.DeepEqual(bikeshareCache.data, bikes) || !reflect.DeepEqual(bartAdvisories.byStation, advisories) {
+		t.Errorf("follower feeds = %+v %+v %+v", weatherCache.data, bikeshareCache.data, bartAdvisories.byStation)
+	}
+	if got := dashboardCache.byPath["/kitchen"]; len(got.Stops) != 1 || !got.Stops[0].Directions[0].FetchedAt.Equal(fetched) {
+		t.Errorf("follower dashboard = %+v", got)
+	}
+
+	// Shared datasets are served, even once stale; missing ones and GTFS
+	// feeds aren't fetched
+	before := ft.requests
+	fc.Sleep(2 * linesTTL)
+	got, err := cachedDataset("lines:SF", linesTTL, func(*go511.Client) ([]LineInfo, error) {
+		t.Error("follower fetched a dataset")
+		return nil, nil
+	})
+	if err != nil || !reflect.DeepEqual(got, lines) {
+		t.Errorf("follower lines = %+v, %v", got, err)
+	}
+	if _, err := agencyList(); err == nil {
+		t.Error("follower served agencies the leader never shared")
+	}
+	if _, err := gtfsFeed("SF", time.Hour); err == nil {
+		t.Error("follower served a GTFS feed it doesn't have")
+	}
+	if ft.requests != before {
+		t.Errorf("follower made %d upstream requests", ft.requests-before)
+	}
+}
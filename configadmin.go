@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secrets in exported configs. Sending it back unchanged
+// on import keeps the current secret.
+const redacted = "REDACTED"
+
+// maxConfigSize bounds config documents accepted over the admin API
+const maxConfigSize = 1 << 20
+
+// redactConfig returns a copy of cfg with secrets replaced
+func redactConfig(cfg *Config) Config {
+	out := *cfg
+	if out.APIKey != "" {
+		out.APIKey = redacted
+	}
+	if out.Admin.Token != "" {
+		out.Admin.Token = redacted
+	}
+	if out.Admin.Password != "" {
+		out.Admin.Password = redacted
+	}
+	if out.BART.APIKey != "" {
+		out.BART.APIKey = redacted
+	}
+	if out.Transitland.APIKey != "" {
+		out.Transitland.APIKey = redacted
+	}
+	if out.RemoteConfig.Token != "" {
+		out.RemoteConfig.Token = redacted
+	}
+	if out.Notifications.Email.Password != "" {
+		out.Notifications.Email.Password = redacted
+	}
+	out.Notifications.Webhooks = make([]WebhookConfig, len(cfg.Notifications.Webhooks))
+	for i, hook := range cfg.Notifications.Webhooks {
+		hook.URL = redacted
+		out.Notifications.Webhooks[i] = hook
+	}
+	out.Notifications.Discord = make([]DiscordConfig, len(cfg.Notifications.Discord))
+	for i, d := range cfg.Notifications.Discord {
+		d.WebhookURL = redacted
+		out.Notifications.Discord[i] = d
+	}
+	if out.Notifications.Apprise.Key != "" {
+		out.Notifications.Apprise.Key = redacted
+	}
+	out.Notifications.Apprise.URLs = make([]string, len(cfg.Notifications.Apprise.URLs))
+	for i := range cfg.Notifications.Apprise.URLs {
+		out.Notifications.Apprise.URLs[i] = redacted
+	}
+	if out.ErrorReporting.SentryDSN != "" {
+		out.ErrorReporting.SentryDSN = redacted
+	}
+	if out.ErrorReporting.WebhookURL != "" {
+		out.ErrorReporting.WebhookURL = redacted
+	}
+	out.ClientKeys = make([]ClientKey, len(cfg.ClientKeys))
+	for i, k := range cfg.ClientKeys {
+		out.ClientKeys[i] = ClientKey{Name: k.Name, Key: redacted}
+	}
+	return out
+}
+
+// restoreSecrets fills redacted placeholders in an imported config from the
+// running one, so an exported config can be re-imported as-is
+func restoreSecrets(cfg, current *Config) error {
+	if cfg.APIKey == redacted {
+		cfg.APIKey = current.APIKey
+	}
+	if cfg.Admin.Token == redacted {
+		cfg.Admin.Token = current.Admin.Token
+	}
+	if cfg.Admin.Password == redacted {
+		cfg.Admin.Password = current.Admin.Password
+	}
+	if cfg.BART.APIKey == redacted {
+		cfg.BART.APIKey = current.BART.APIKey
+	}
+	if cfg.Transitland.APIKey == redacted {
+		cfg.Transitland.APIKey = current.Transitland.APIKey
+	}
+	if cfg.RemoteConfig.Token == redacted {
+		cfg.RemoteConfig.Token = current.RemoteConfig.Token
+	}
+	if cfg.Notifications.Email.Password == redacted {
+		cfg.Notifications.Email.Password = current.Notifications.Email.Password
+	}
+	if cfg.ErrorReporting.SentryDSN == redacted {
+		cfg.ErrorReporting.SentryDSN = current.ErrorReporting.SentryDSN
+	}
+	if cfg.ErrorReporting.WebhookURL == redacted {
+		cfg.ErrorReporting.WebhookURL = current.ErrorReporting.WebhookURL
+	}
+	for i, hook := range cfg.Notifications.Webhooks {
+		if hook.URL != redacted {
+			continue
+		}
+		if i >= len(current.Notifications.Webhooks) {
+			return fmt.Errorf("notifications.webhooks[%d]: no existing URL to keep", i)
+		}
+		cfg.Notifications.Webhooks[i].URL = current.Notifications.Webhooks[i].URL
+	}
+	for i, d := range cfg.Notifications.Discord {
+		if d.WebhookURL != redacted {
+			continue
+		}
+		if i >= len(current.Notifications.Discord) {
+			return fmt.Errorf("notifications.discord[%d]: no existing webhook to keep", i)
+		}
+		cfg.Notifications.Discord[i].WebhookURL = current.Notifications.Discord[i].WebhookURL
+	}
+	if cfg.Notifications.Apprise.Key == redacted {
+		cfg.Notifications.Apprise.Key = current.Notifications.Apprise.Key
+	}
+	for i, u := range cfg.Notifications.Apprise.URLs {
+		if u != redacted {
+			continue
+		}
+		if i >= len(current.Notifications.Apprise.URLs) {
+			return fmt.Errorf("notifications.apprise.urls[%d]: no existing URL to keep", i)
+		}
+		cfg.Notifications.Apprise.URLs[i] = current.Notifications.Apprise.URLs[i]
+	}
+	for i, k := range cfg.ClientKeys {
+		if k.Key != redacted {
+			continue
+		}
+		found := false
+		for _, ck := range current.ClientKeys {
+			if ck.Name == k.Name {
+				cfg.ClientKeys[i].Key = ck.Key
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("client_keys[%d]: no existing key for %q to keep", i, k.Name)
+		}
+	}
+	return nil
+}
+
+// marshalConfig encodes a config with the same indentation as config.example.yaml
+func marshalConfig(cfg *Config) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(withoutFileSecrets(cfg)); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// configNode encodes a config as written, before finalizeConfig fills in
+// defaults, leaving out what isn't set: zero means the default for every
+// setting, so a zero value is the same as none.
+func configNode(cfg *Config) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := doc.Encode(withoutFileSecrets(cfg)); err != nil {
+		return nil, err
+	}
+	pruneZero(&doc)
+	return &doc, nil
+}
+
+// pruneZero drops mapping entries whose value is zero or empty
+func pruneZero(n *yaml.Node) {
+	for _, c := range n.Content {
+		pruneZero(c)
+	}
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	kept := n.Content[:0]
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if !zeroNode(n.Content[i+1]) {
+			kept = append(kept, n.Content[i], n.Content[i+1])
+		}
+	}
+	n.Content = kept
+}
+
+func zeroNode(n *yaml.Node) bool {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		switch n.Tag {
+		case "!!int", "!!float":
+			return n.Value == "0"
+		case "!!bool":
+			return n.Value == "false"
+		case "!!null":
+			return true
+		}
+		return n.Value == ""
+	case yaml.MappingNode, yaml.SequenceNode:
+		return len(n.Content) == 0
+	}
+	return false
+}
+
+// mergeNode makes dst say what src does while keeping dst's comments,
+// key order, and quoting wherever the two agree
+func mergeNode(dst, src *yaml.Node) {
+	switch {
+	case dst.Kind == yaml.MappingNode && src.Kind == yaml.MappingNode:
+		values := make(map[string]*yaml.Node)
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			values[src.Content[i].Value] = src.Content[i+1]
+		}
+		kept := dst.Content[:0]
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			k, v := dst.Content[i], dst.Content[i+1]
+			if sv, ok := values[k.Value]; ok {
+				mergeNode(v, sv)
+				kept = append(kept, k, v)
+				delete(values, k.Value)
+			}
+		}
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			if _, added := values[src.Content[i].Value]; added {
+				kept = append(kept, src.Content[i], src.Content[i+1])
+			}
+		}
+		dst.Content = kept
+
+	case dst.Kind == yaml.SequenceNode && src.Kind == yaml.SequenceNode && len(dst.Content) == len(src.Content):
+		for i := range dst.Content {
+			mergeNode(dst.Content[i], src.Content[i])
+		}
+
+	case dst.Kind == yaml.ScalarNode && src.Kind == yaml.ScalarNode && dst.Value == src.Value && dst.ShortTag() == src.ShortTag():
+		// Unchanged
+
+	default:
+		head, line, foot := dst.HeadComment, dst.LineComment, dst.FootComment
+		*dst = *src
+		dst.HeadComment, dst.LineComment, dst.FootComment = head, line, foot
+	}
+}
+
+// writeConfigFile atomically replaces the config file on disk with doc,
+// from configNode. The file is edited rather than rewritten, so comments
+// and settings left at their defaults stay as they were, and the previous
+// file is kept as <path>.bak.
+func writeConfigFile(doc *yaml.Node) error {
+	original, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if migrated, _, err := migrateConfig(original); err == nil {
+			var current yaml.Node
+			if yaml.Unmarshal(migrated, &current) == nil && len(current.Content) == 1 {
+				mergeNode(current.Content[0], doc)
+				doc = &current
+			}
+		}
+		if err := writeFileAtomic(configPath+".bak", original); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return writeFileAtomic(configPath, buf.Bytes())
+}
+
+// writeFileAtomic replaces path with data via a temp file and rename, so
+// readers never see a partial file. The file is only readable by its owner.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// errConfigIncludes refuses edits that would fold include files into
+// config.yaml
+var errConfigIncludes = fmt.Errorf("config is split across include files; edit them directly")
+
+// configEditable reports why the running config can't be saved back to
+// config.yaml, if it can't
+func configEditable() error {
+	cfg := currentConfig()
+	if len(cfg.Include) > 0 {
+		return errConfigIncludes
+	}
+	if cfg.RemoteConfig.enabled() {
+		return errRemoteConfig
+	}
+	return nil
+}
+
+// commitConfig saves a validated config to disk and applies it, reporting
+// whether a restart is needed. doc is the config as written, from
+// configNode before cfg was finalized.
+func commitConfig(cfg *Config, doc *yaml.Node, source string) (bool, error) {
+	if err := writeConfigFile(doc); err != nil {
+		errorf("Failed to write config file: %v", err)
+		return false, err
+	}
+
+	applyOverrides(cfg)
+	restart := applyConfig(cfg)
+	infof("Config replaced via %s (%d stops)", source, len(cfg.Stops))
+	return restart, nil
+}
+
+// readConfigFile loads the config file as written, without defaults or
+// command-line overrides, as the starting point for an edit
+func readConfigFile() (*Config, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if data, _, err = migrateConfig(data); err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyConfig swaps in a new config and adjusts running components. It
+// reports whether any changed settings only take effect after a restart.
+func applyConfig(cfg *Config) bool {
+	old := currentConfig()
+	activeConfig.Store(cfg)
+
+	if cacheRefreshInterval(cfg) != cacheRefreshInterval(old) {
+		setRefreshInterval(cacheRefreshInterval(cfg))
+	}
+
+	// New stops no longer match the cache, so the scheduler's next tick
+	// refreshes everything, on the instance that fetches
+	if !reflect.DeepEqual(cfg.Stops, old.Stops) {
+		infof("Stops changed, cache will refresh")
+	}
+
+	return cfg.Port != old.Port ||
+		cfg.Listen != old.Listen ||
+		cfg.SocketMode != old.SocketMode ||
+		cfg.SocketGroup != old.SocketGroup ||
+		cfg.StaticDir != old.StaticDir ||
+		cfg.StaticCompress != old.StaticCompress ||
+		dashboardRoutesChanged(cfg, old) ||
+		!reflect.DeepEqual(cfg.TLS, old.TLS) ||
+		cfg.HTTP2 != old.HTTP2
+}
+
+// handleAdminConfig exports the effective config (GET) or replaces it (PUT).
+// Documents are YAML; JSON is accepted on PUT since it is valid YAML.
+func handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		out := redactConfig(currentConfig())
+		data, err := marshalConfig(&out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(data)
+
+	case http.MethodPut:
+		if err := configEditable(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigSize))
+		if err != nil {
+			http.Error(w, "failed to read body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		body, _, err = migrateConfig(body)
+		if err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		cfg := &Config{}
+		if err := yaml.Unmarshal(body, cfg); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(cfg.Include) > 0 {
+			http.Error(w, "invalid config: include can only be set in config.yaml itself", http.StatusBadRequest)
+			return
+		}
+		if err := checkExecUnchanged(cfg, currentConfig()); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := restoreSecrets(cfg, currentConfig()); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		doc, err := configNode(cfg)
+		if err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := finalizeConfig(cfg); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		restart, err := commitConfig(cfg, doc, "admin API")
+		if err != nil {
+			http.Error(w, "failed to save config", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"ok","restart_required":%t}`+"\n", restart)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteConfigFile(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+
+	raw := `# Kitchen board
+api_key: test
+refresh_interval: 30 # seconds
+stops:
+  # Closest to home
+  - name: Embarcadero
+    directions: [{label: Ocean Beach, stop_id: "16994"}]
+`
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), "config.yaml")
+	t.Cleanup(func() { configPath = oldPath })
+	if err := os.WriteFile(configPath, []byte(raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseConfig([]byte(raw))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	// An edit from the admin UI starts from the file as written
+	form := url.Values{"action": {"intervals"}, "refresh_interval": {"45"}}
+	req := httptest.NewRequest("POST", "/admin", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := applyAdminForm(req); err != nil {
+		t.Fatalf("applyAdminForm: %v", err)
+	}
+
+	saved, _ := os.ReadFile(configPath)
+	for _, want := range []string{"# Kitchen board", "refresh_interval: 45 # seconds", "# Closest to home", `stop_id: "16994"`, "api_key: test"} {
+		if !strings.Contains(string(saved), want) {
+			t.Errorf("saved config lacks %q:\n%s", want, saved)
+		}
+	}
+	// Defaults filled in when the config was loaded aren't written out
+	for _, unwanted := range []string{"port:", "upstream_hourly_limit:", "version:", "stages:"} {
+		if strings.Contains(string(saved), unwanted) {
+			t.Errorf("saved config has %q:\n%s", unwanted, saved)
+		}
+	}
+	if backup, _ := os.ReadFile(configPath + ".bak"); string(backup) != raw {
+		t.Errorf("backup = %q, want the original", backup)
+	}
+	if got := currentConfig().RefreshInterval; got != 45 {
+		t.Errorf("applied refresh_interval = %d", got)
+	}
+
+	// A document sent to the admin API replaces the file's settings, and
+	// leaves out the ones it doesn't set
+	doc := strings.Replace(raw, "refresh_interval: 30 # seconds\n", "", 1)
+	doc = strings.Replace(doc, "api_key: test", "api_key: REDACTED", 1)
+	rec := httptest.NewRecorder()
+	handleAdminConfig(rec, httptest.NewRequest("PUT", "/api/admin/config", strings.NewReader(doc)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT = %d: %s", rec.Code, rec.Body)
+	}
+	saved, _ = os.ReadFile(configPath)
+	if strings.Contains(string(saved), "refresh_interval") || !strings.Contains(string(saved), "# Closest to home") ||
+		!strings.Contains(string(saved), "api_key: test") {
+		t.Errorf("saved config after removing refresh_interval:\n%s", saved)
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+	cfg, err := parseConfig([]byte(`
+api_key: test
+notifications:
+  webhooks: [{url: "https://hooks.example.com/notify?token=s3cret"}]
+  apprise: {url: "http://apprise:8000", key: s3cret}
+error_reporting:
+  webhook_url: "https://hooks.example.com/errors?token=s3cret"
+` + testStop))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		secret func(*Config) *string
+	}{
+		{"notifications.webhooks[0].url", func(c *Config) *string { return &c.Notifications.Webhooks[0].URL }},
+		{"notifications.apprise.key", func(c *Config) *string { return &c.Notifications.Apprise.Key }},
+		{"error_reporting.webhook_url", func(c *Config) *string { return &c.ErrorReporting.WebhookURL }},
+	}
+	exported := redactConfig(cfg)
+	for _, tt := range tests {
+		if got := *tt.secret(&exported); got != redacted {
+			t.Errorf("%s exported as %q", tt.name, got)
+		}
+	}
+	if err := restoreSecrets(&exported, cfg); err != nil {
+		t.Fatalf("restoreSecrets: %v", err)
+	}
+	for _, tt := range tests {
+		if got, want := *tt.secret(&exported), *tt.secret(cfg); got != want {
+			t.Errorf("%s restored as %q, want %q", tt.name, got, want)
+		}
+	}
+
+	// A placeholder with nothing behind it is refused
+	extra := redactConfig(cfg)
+	extra.Notifications.Webhooks = append(extra.Notifications.Webhooks, WebhookConfig{URL: redacted})
+	if err := restoreSecrets(&extra, cfg); err == nil {
+		t.Error("restored a webhook that doesn't exist")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configMigrations[i] upgrades a raw config document from version i+1 to
+// i+2. When a change would break existing files, append the migration
+// that upgrades them; the schema version follows. Migrations work on the
+// decoded YAML so they can rename or reshape keys the Config struct no
+// longer has.
+var configMigrations []func(doc map[string]interface{}) error
+
+// configVersion is the config schema this build reads
+func configVersion() int {
+	return len(configMigrations) + 1
+}
+
+// migrateConfig upgrades a config document to configVersion(), returning
+// the upgraded document and the version it started at. Files without a
+// version are version 1. Up-to-date documents are returned unchanged.
+func migrateConfig(data []byte) ([]byte, int, error) {
+	doc := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	latest := configVersion()
+
+	version := 1
+	if v, ok := doc["version"]; ok {
+		n, isInt := v.(int)
+		if !isInt || n < 1 {
+			return nil, 0, fmt.Errorf("config version must be a positive integer")
+		}
+		version = n
+	}
+	if version > latest {
+		return nil, version, fmt.Errorf("config version %d is newer than this build supports (%d); upgrade muni-tracker", version, latest)
+	}
+	if version == latest {
+		return data, version, nil
+	}
+
+	for v := version; v < latest; v++ {
+		if err := configMigrations[v-1](doc); err != nil {
+			return nil, version, fmt.Errorf("migrating config from version %d: %w", v, err)
+		}
+	}
+	doc["version"] = latest
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, version, err
+	}
+	return out, version, nil
+}
+
+// rewriteMigratedConfig saves an upgraded config over the original, after
+// copying the original to <path>.v<version>.bak
+func rewriteMigratedConfig(original, migrated []byte, from int) error {
+	backup := fmt.Sprintf("%s.v%d.bak", configPath, from)
+	if err := os.WriteFile(backup, original, 0600); err != nil {
+		return fmt.Errorf("backing up config: %w", err)
+	}
+	if err := writeFileAtomic(configPath, migrated); err != nil {
+		return err
+	}
+	infof("Upgraded %s to config version %d; the original is in %s", configPath, configVersion(), backup)
+	return nil
+}
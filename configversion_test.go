@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	// A pretend version 2 that renamed refresh_interval
+	old := configMigrations
+	configMigrations = append(configMigrations, func(doc map[string]interface{}) error {
+		if v, ok := doc["refresh_interval"]; ok {
+			doc["frontend_refresh"] = v
+			delete(doc, "refresh_interval")
+		}
+		return nil
+	})
+	t.Cleanup(func() { configMigrations = old })
+
+	migrated, from, err := migrateConfig([]byte("api_key: test\nrefresh_interval: 20\n"))
+	if err != nil || from != 1 {
+		t.Fatalf("migrateConfig: from %d, %v", from, err)
+	}
+	if got := string(migrated); !strings.Contains(got, "frontend_refresh: 20") || !strings.Contains(got, "version: 2") || strings.Contains(got, "refresh_interval") {
+		t.Errorf("migrated:\n%s", got)
+	}
+
+	current := []byte("version: 2\n# keep me\napi_key: test\n")
+	if out, from, err := migrateConfig(current); err != nil || from != 2 || string(out) != string(current) {
+		t.Errorf("current version should pass through untouched: %q, %d, %v", out, from, err)
+	}
+
+	if _, _, err := migrateConfig([]byte("version: 3\n")); err == nil {
+		t.Error("newer version should be rejected")
+	}
+
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), "config.yaml")
+	t.Cleanup(func() { configPath = oldPath })
+	original := []byte("api_key: test\nrefresh_interval: 20\n")
+	if err := rewriteMigratedConfig(original, migrated, 1); err != nil {
+		t.Fatalf("rewriteMigratedConfig: %v", err)
+	}
+	if backup, _ := os.ReadFile(configPath + ".v1.bak"); string(backup) != string(original) {
+		t.Errorf("backup = %q", backup)
+	}
+	if saved, _ := os.ReadFile(configPath); string(saved) != string(migrated) {
+		t.Errorf("saved = %q", saved)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORS configuration for browser widgets served from other origins
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins,omitempty"`
+	MaxAge         int      `yaml:"max_age,omitempty"`
+}
+
+func (c CORSConfig) allowOrigin(origin string) (string, bool) {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(o, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// corsMiddleware adds CORS headers for allowed origins and answers
+// preflight requests before they reach auth or routing.
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := currentConfig().CORS
+		origin := r.Header.Get("Origin")
+		if len(c.AllowedOrigins) == 0 || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		allowed, ok := c.allowOrigin(origin)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+
+		// Preflight
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			methods := "GET, HEAD, POST, PUT, DELETE, OPTIONS"
+			if allow := allowedMethods(r); allow != nil {
+				methods = strings.Join(allow, ", ")
+			}
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key")
+			maxAge := c.MaxAge
+			if maxAge == 0 {
+				maxAge = 600
+			}
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(maxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 3, 5, 20, 0, 0, 0, time.UTC), "")
+	const widget = "https://widget.example.com"
+
+	tests := []struct {
+		name    string
+		origins []string
+		maxAge  int
+		method  string
+		origin  string
+		// Access-Control-Request-Method, making it a preflight
+		request string
+		allow   string
+		code    int
+		vary    bool
+	}{
+		{"not configured", nil, 0, "GET", widget, "", "", http.StatusOK, false},
+		{"same origin", []string{widget}, 0, "GET", "", "", "", http.StatusOK, false},
+		{"allowed", []string{widget}, 0, "GET", widget, "", widget, http.StatusOK, true},
+		{"allowed in another case", []string{widget}, 0, "GET", "HTTPS://Widget.example.com", "", "HTTPS://Widget.example.com", http.StatusOK, true},
+		{"other origin", []string{widget}, 0, "GET", "https://evil.example.com", "", "", http.StatusOK, true},
+		{"other scheme", []string{widget}, 0, "GET", "http://widget.example.com", "", "", http.StatusOK, true},
+		{"wildcard", []string{"*"}, 0, "GET", "https://any.example.com", "", "*", http.StatusOK, true},
+		{"preflight", []string{widget}, 0, "OPTIONS", widget, "PUT", widget, http.StatusNoContent, true},
+		{"preflight from another origin", []string{widget}, 0, "OPTIONS", "https://evil.example.com", "PUT", "", http.StatusOK, true},
+		{"plain OPTIONS", []string{widget}, 0, "OPTIONS", widget, "", widget, http.StatusOK, true},
+		{"preflight with max_age", []string{widget}, 60, "OPTIONS", widget, "GET", widget, http.StatusNoContent, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := *currentConfig()
+			cfg.CORS = CORSConfig{AllowedOrigins: tt.origins, MaxAge: tt.maxAge}
+			activeConfig.Store(&cfg)
+
+			req := httptest.NewRequest(tt.method, "/test-cors/unrouted", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.request != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.request)
+			}
+			rec := httptest.NewRecorder()
+			corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+
+			h := rec.Header()
+			if rec.Code != tt.code || h.Get("Access-Control-Allow-Origin") != tt.allow {
+				t.Errorf("status = %d, allowed origin %q; want %d, %q", rec.Code, h.Get("Access-Control-Allow-Origin"), tt.code, tt.allow)
+			}
+			if vary := slices.Contains(h.Values("Vary"), "Origin"); vary != tt.vary {
+				t.Errorf("Vary = %q", h.Values("Vary"))
+			}
+			if tt.code != http.StatusNoContent {
+				return
+			}
+			wantAge := "600"
+			if tt.maxAge != 0 {
+				wantAge = strconv.Itoa(tt.maxAge)
+			}
+			if h.Get("Access-Control-Max-Age") != wantAge || h.Get("Access-Control-Allow-Methods") == "" ||
+				!strings.Contains(h.Get("Access-Control-Allow-Headers"), "X-API-Key") {
+				t.Errorf("preflight headers = %v", h)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DashboardConfig is an independent board served under its own path
+// prefix, e.g. one for home and one for the office from a single server.
+// Everything not set here (API key, timezone, language...) is shared with
+// the top-level config.
+type DashboardConfig struct {
+	// Path prefix such as "/office"; the board is served at /office/
+	Path  string `yaml:"path"`
+	Stops []Stop `yaml:"stops"`
+	// Seconds between upstream fetches for this board (default 240)
+	CacheRefreshInterval int `yaml:"cache_refresh_interval,omitempty"`
+	// Seconds between frontend refreshes (default: the top-level value)
+	RefreshInterval int `yaml:"refresh_interval,omitempty"`
+	// Directory holding this board's web UI, for a different theme
+	StaticDir string `yaml:"static_dir,omitempty"`
+}
+
+func validateDashboards(cfg *Config) error {
+	seen := make(map[string]bool)
+	for i := range cfg.Dashboards {
+		d := &cfg.Dashboards[i]
+		d.Path = "/" + strings.Trim(d.Path, "/")
+		if d.Path == "/" || d.Path == "/api" || strings.HasPrefix(d.Path, "/api/") || d.Path == "/health" || d.Path == "/admin" {
+			return fmt.Errorf("dashboards[%d]: path %q is reserved", i, d.Path)
+		}
+		if seen[d.Path] {
+			return fmt.Errorf("dashboard path %q is used twice", d.Path)
+		}
+		seen[d.Path] = true
+
+		if len(d.Stops) == 0 {
+			return fmt.Errorf("dashboard %s needs at least one stop", d.Path)
+		}
+		if err := validateStops(d.Stops); err != nil {
+			return fmt.Errorf("dashboard %s: %w", d.Path, err)
+		}
+		if d.CacheRefreshInterval < 0 || d.RefreshInterval < 0 {
+			return fmt.Errorf("dashboard %s: refresh intervals cannot be negative", d.Path)
+		}
+		if d.RefreshInterval == 0 {
+			d.RefreshInterval = cfg.RefreshInterval
+		}
+	}
+	return nil
+}
+
+// allStops returns the top-level stops followed by every dashboard's
+func allStops(cfg *Config) []Stop {
+	stops := append([]Stop(nil), cfg.Stops...)
+	for _, d := range cfg.Dashboards {
+		stops = append(stops, d.Stops...)
+	}
+	return stops
+}
+
+// currentDashboard returns the live config for the dashboard at path. It
+// is looked up on each use so admin config edits apply to existing boards.
+func currentDashboard(path string) (DashboardConfig, bool) {
+	for _, d := range currentConfig().Dashboards {
+		if d.Path == path {
+			return d, true
+		}
+	}
+	return DashboardConfig{}, false
+}
+
+// dashboardRoutesChanged reports whether dashboards were added, removed or
+// given a new static_dir, which only takes effect after a restart
+func dashboardRoutesChanged(cfg, old *Config) bool {
+	if len(cfg.Dashboards) != len(old.Dashboards) {
+		return true
+	}
+	for i, d := range cfg.Dashboards {
+		if d.Path != old.Dashboards[i].Path || d.StaticDir != old.Dashboards[i].StaticDir {
+			return true
+		}
+	}
+	return false
+}
+
+// apiPath strips a dashboard prefix from a request path, so middleware
+// treats /office/api/arrivals like /api/arrivals
+func apiPath(path string) string {
+	for _, d := range currentConfig().Dashboards {
+		if strings.HasPrefix(path, d.Path+"/") {
+			return strings.TrimPrefix(path, d.Path)
+		}
+	}
+	return path
+}
+
+// dashboardCache holds the fetched arrivals for each dashboard path
+var dashboardCache = struct {
+	sync.RWMutex
+	byPath map[string]ArrivalsResponse
+}{byPath: make(map[string]ArrivalsResponse)}
+
+// refreshDashboard fetches every direction on a dashboard. It shares
+// refreshMu with the main cache so boards never fetch in parallel. A
+// batch that would overrun the hourly quota isn't started; refreshDashboard
+// returns how long until it fits.
+func refreshDashboard(ctx context.Context, d DashboardConfig) time.Duration {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	// Nothing else fetches while refreshMu is held, so the quota checked
+	// here is still there when the batch runs
+	requests := 0
+	for _, stop := range d.Stops {
+		requests += upstreamRequests(stop.Directions...)
+	}
+	if wait := quota.waitFor(requests, clock.Now()); wait > 0 {
+		warnf("Holding dashboard %s for %v to stay within upstream_hourly_limit", d.Path, wait.Round(time.Second))
+		return wait
+	}
+
+	ctx = newTraceContext(ctx)
+	infof("Refreshing dashboard %s... trace=%s", d.Path, traceID(ctx))
+	config := currentConfig()
+
+	dashboardCache.Lock()
+	prev := dashboardCache.byPath[d.Path]
+	dashboardCache.Unlock()
+
+	response := ArrivalsResponse{
+		Stops:       make([]StopArrivals, len(d.Stops)),
+		LastUpdated: localTime(clock.Now()).Format(time.RFC3339),
+	}
+	for i, stop := range d.Stops {
+		response.Stops[i] = StopArrivals{
+			Name:       stop.Name,
+			Line:       stop.Line,
+			Directions: make([]DirectionArrivals, len(stop.Directions)),
+		}
+		for j, dir := range stop.Directions {
+			if ctx.Err() != nil {
+				return 0
+			}
+			response.Stops[i].Directions[j] = fetchDirection(ctx, stop, dir)
+			last, ok := previousDirection(prev, i, j, dir.StopID)
+			keepUpdatedAt(&response.Stops[i].Directions[j], last, ok)
+			if config.Provider == "511" {
+				sleepContext(ctx, upstreamDelay)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return 0
+	}
+
+	dashboardCache.Lock()
+	dashboardCache.byPath[d.Path] = response
+	dashboardCache.Unlock()
+	return 0
+}
+
+// startDashboardRefresher keeps one dashboard's cache fresh on its own
+// interval until ctx ends. A dashboard removed from the config stops
+// being fetched.
+func startDashboardRefresher(ctx context.Context, path string) {
+	go func() {
+		for {
+			d, ok := currentDashboard(path)
+			if !ok {
+				if !sleepContext(ctx, time.Minute) {
+					return
+				}
+				continue
+			}
+			// Another instance fetches for this one, and shares its
+			// dashboards through the shared cache file
+			if !leading() {
+				if !sleepContext(ctx, clusterTick) {
+					return
+				}
+				continue
+			}
+			wait := refreshDashboard(ctx, d)
+
+			interval := time.Duration(d.CacheRefreshInterval) * time.Second
+			if interval == 0 {
+				interval = 4 * time.Minute
+			}
+			if wait > 0 {
+				interval = min(wait, interval)
+			}
+			dashboardCache.Lock()
+			if data, ok := dashboardCache.byPath[path]; ok {
+				data.NextRefresh = clock.Now().Add(interval)
+				dashboardCache.byPath[path] = data
+			}
+			dashboardCache.Unlock()
+			if !sleepContext(ctx, interval) {
+				return
+			}
+		}
+	}()
+}
+
+// buildDashboardArrivals is the arrivals view for the dashboard at path
+func buildDashboardArrivals(path string, now time.Time, page arrivalsPage) ArrivalsResponse {
+	dashboardCache.RLock()
+	data := dashboardCache.byPath[path]
+	dashboardCache.RUnlock()
+
+	d, _ := currentDashboard(path)
+	resp := buildArrivalsView(data, d.Stops, now, page)
+	resp.NextRefresh = data.NextRefresh
+	return resp
+}
+
+// dashboardHandler serves a dashboard's API and web UI under its path. The
+// frontend uses relative URLs, so the same assets work at any prefix.
+func dashboardHandler(path string) http.Handler {
+	build := func(now time.Time, page arrivalsPage) ArrivalsResponse {
+		return buildDashboardArrivals(path, now, page)
+	}
+
+	d, _ := currentDashboard(path)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/arrivals", func(w http.ResponseWriter, r *http.Request) {
+		serveArrivals(w, r, build)
+	})
+	mux.HandleFunc("/api/next", func(w http.ResponseWriter, r *http.Request) {
+		serveNext(w, r, build)
+	})
+	mux.HandleFunc("/api/profiles/", handleProfile)
+	mux.HandleFunc("/api/devices/", handleDevice)
+	mux.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
+		d, ok := currentDashboard(path)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		stops, err := configStops(r, d.Stops)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		config := currentConfig()
+		if setConfigCaching(w, r, config) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ConfigResponse{
+			Stops:           stops,
+			RefreshInterval: d.RefreshInterval,
+			Timezone:        config.Timezone,
+			TimeFormat:      config.TimeFormat,
+		})
+	})
+	mux.Handle("/", staticHandler(staticDirFS(d.StaticDir), currentConfig().StaticCompress))
+
+	return http.StripPrefix(path, mux)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDashboards(t *testing.T) {
+	_, ft := withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"22","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:04:00Z"}}}
+	]}}}`)
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+refresh_interval: 20
+stops:
+  - name: Embarcadero
+    directions: [{label: Ocean Beach, stop_id: "16994"}]
+dashboards:
+  - path: office/
+    stops:
+      - name: 16th & Mission
+        line: "22"
+        directions: [{label: Marina, stop_id: "13300"}]
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+	t.Cleanup(func() {
+		dashboardCache.Lock()
+		delete(dashboardCache.byPath, "/office")
+		dashboardCache.Unlock()
+	})
+
+	d, ok := currentDashboard("/office")
+	if !ok || d.RefreshInterval != 20 {
+		t.Fatalf("dashboard = %+v, %v", d, ok)
+	}
+	if wait := refreshDashboard(context.Background(), d); wait != 0 || ft.requests != 1 {
+		t.Errorf("upstream requests = %d, want 1 (wait %v)", ft.requests, wait)
+	}
+
+	// A batch that would overrun the quota waits, keeping the last data
+	limited := *cfg
+	quota.remaining(clock.Now())
+	limited.UpstreamHourlyLimit = len(quota.calls)
+	activeConfig.Store(&limited)
+	if wait := refreshDashboard(context.Background(), d); wait <= 0 || ft.requests != 1 {
+		t.Errorf("over quota: wait %v, %d requests", wait, ft.requests)
+	}
+	activeConfig.Store(cfg)
+
+	// The prefix is stripped and only the dashboard's stops are served
+	h := dashboardHandler("/office")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/office/api/arrivals", nil))
+	var resp ArrivalsResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Stops) != 1 || resp.Stops[0].Name != "16th & Mission" || len(resp.Stops[0].Directions[0].Arrivals) != 1 {
+		t.Errorf("dashboard arrivals = %+v", resp)
+	}
+
+	if got := apiPath("/office/api/next"); got != "/api/next" {
+		t.Errorf("apiPath = %q", got)
+	}
+
+	for _, path := range []string{"/", "api/x", "/health"} {
+		bad := *cfg
+		bad.Dashboards = []DashboardConfig{{Path: path, Stops: cfg.Stops}}
+		if err := validateDashboards(&bad); err == nil {
+			t.Errorf("path %q should be rejected", path)
+		}
+	}
+}
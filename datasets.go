@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"muni-tracker/pkg/go511"
+)
+
+// datasets caches 511 reference data (lines, operators) that changes
+// rarely, so browsing it doesn't eat into the arrivals quota
+var datasets = struct {
+	sync.Mutex
+	entries map[string]datasetEntry
+}{entries: make(map[string]datasetEntry)}
+
+type datasetEntry struct {
+	value     interface{}
+	fetchedAt time.Time
+}
+
+var (
+	// datasetLoads makes concurrent requests for a dataset share one fetch
+	datasetLoads flightGroup[interface{}]
+	// datasetFailures holds off refetching datasets that failed
+	datasetFailures failureBackoff
+)
+
+// cachedDataset returns the cached value for key, refetching it once it is
+// older than ttl. A stale value is served if the refetch fails, is backing
+// off from an earlier failure, or would overrun the hourly quota. A shared
+// cache's followers don't fetch, and serve what the leader last shared.
+func cachedDataset[T any](key string, ttl time.Duration, fetch func(*go511.Client) (T, error)) (T, error) {
+	datasets.Lock()
+	entry, ok := datasets.entries[key]
+	datasets.Unlock()
+	var cached T
+	if ok {
+		cached, ok = datasetValue[T](entry.value)
+	}
+	if ok && clock.Now().Sub(entry.fetchedAt) < ttl {
+		return cached, nil
+	}
+
+	value, err := datasetLoads.do(key, func() (interface{}, error) {
+		config := currentConfig()
+		if config.Provider != "511" {
+			return nil, fmt.Errorf("%s is only available from 511", key)
+		}
+		if !leading() {
+			return nil, fmt.Errorf("%s hasn't been shared by the cluster leader yet", key)
+		}
+		if err := datasetFailures.check(key, clock.Now()); err != nil {
+			return nil, err
+		}
+		if wait := quota.take(clock.Now()); wait > 0 {
+			return nil, fmt.Errorf("upstream quota exhausted for %v", wait.Round(time.Second))
+		}
+		client := go511.NewClient(config.APIKey)
+		client.HTTPClient = upstreamClient()
+
+		value, err := fetch(client)
+		if err != nil {
+			datasetFailures.fail(key, clock.Now(), err)
+			return nil, err
+		}
+		datasetFailures.succeed(key)
+		datasets.Lock()
+		datasets.entries[key] = datasetEntry{value: value, fetchedAt: clock.Now()}
+		datasets.Unlock()
+		return value, nil
+	})
+	if err != nil {
+		var zero T
+		if ok {
+			if currentConfig().Provider == "511" && leading() {
+				warnf("Failed to refresh %s, serving cached copy: %v", key, err)
+			}
+			return cached, nil
+		}
+		return zero, err
+	}
+	return value.(T), nil
+}
+
+// datasetValue returns a cached value as a T. Values taken from the
+// leader's snapshot arrive as JSON and are decoded here, on first use.
+func datasetValue[T any](v interface{}) (T, bool) {
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		t, ok := v.(T)
+		return t, ok
+	}
+	var t T
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return t, false
+	}
+	return t, true
+}
+
+// LineInfo is a line as served by /api/lines
+type LineInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Code      string `json:"code"`
+	Mode      string `json:"mode"`
+	Monitored bool   `json:"monitored"`
+}
+
+// linesTTL is how long an agency's lines are cached
+const linesTTL = 24 * time.Hour
+
+func handleLines(w http.ResponseWriter, r *http.Request) {
+	agency := r.URL.Query().Get("agency")
+	if agency == "" {
+		agency = "SF"
+	}
+	// Only configured and known agencies, so requests can't spend the
+	// quota on made-up ones
+	agency, err := allowedAgency(agency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	lines, err := cachedDataset("lines:"+agency, linesTTL, func(c *go511.Client) ([]LineInfo, error) {
+		// Other requests may wait on this load, so it isn't tied to r
+		raw, err := c.Lines(serverCtx, agency)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]LineInfo, len(raw))
+		for i, l := range raw {
+			out[i] = LineInfo{ID: l.ID, Name: l.Name, Code: l.PublicCode, Mode: l.TransportMode, Monitored: l.Monitored}
+		}
+		return out, nil
+	})
+	if err != nil {
+		warnf("Lines for %s unavailable: %v", agency, err)
+		http.Error(w, "line data unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	setCacheControl(w, r, datasetMaxAge)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agency": agency,
+		"lines":  lines,
+	})
+}
+
+// AgencyInfo is an operator as served by /api/agencies
+type AgencyInfo struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	ShortName string `json:"short_name,omitempty"`
+	Mode      string `json:"mode,omitempty"`
+	Website   string `json:"website,omitempty"`
+	Monitored bool   `json:"monitored"`
+}
+
+// agenciesTTL is how long the operators list is cached. Agencies are
+// added a few times a year at most.
+const agenciesTTL = 7 * 24 * time.Hour
+
+// agencyList returns the 511 operators, cached for agenciesTTL
+func agencyList() ([]AgencyInfo, error) {
+	return cachedDataset("agencies", agenciesTTL, func(c *go511.Client) ([]AgencyInfo, error) {
+		raw, err := c.Operators(serverCtx)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]AgencyInfo, len(raw))
+		for i, op := range raw {
+			out[i] = AgencyInfo{Code: op.ID, Name: op.Name, ShortName: op.ShortName, Mode: op.PrimaryMode, Website: op.WebSite, Monitored: op.Monitored}
+		}
+		return out, nil
+	})
+}
+
+func handleAgencies(w http.ResponseWriter, r *http.Request) {
+	agencies, err := agencyList()
+	if err != nil {
+		warnf("Agencies unavailable: %v", err)
+		http.Error(w, "agency data unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	setCacheControl(w, r, datasetMaxAge)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agencies": agencies,
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDatasetDownloads(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+	rt := &recordTransport{status: http.StatusInternalServerError}
+	upstreamTransport = rt
+	t.Cleanup(func() {
+		datasetFailures = failureBackoff{}
+		datasets.Lock()
+		delete(datasets.entries, "lines:SF")
+		datasets.Unlock()
+	})
+	lines := func(agency string) int {
+		rec := httptest.NewRecorder()
+		handleLines(rec, httptest.NewRequest("GET", "/api/lines?agency="+agency, nil))
+		return rec.Code
+	}
+
+	if code := lines("nope"); code != http.StatusNotFound || len(rt.urls) != 0 {
+		t.Errorf("unknown agency: status %d, %d requests", code, len(rt.urls))
+	}
+	if code := lines("SF"); code != http.StatusServiceUnavailable || len(rt.urls) == 0 {
+		t.Fatalf("failed fetch: status %d, %d requests", code, len(rt.urls))
+	}
+	// The failure is remembered, even for the alias
+	tried := len(rt.urls)
+	if code := lines("muni"); code != http.StatusServiceUnavailable || len(rt.urls) != tried {
+		t.Errorf("retry within the backoff: status %d, %d requests", code, len(rt.urls)-tried)
+	}
+
+	// Once the budget is spent, a stale copy is served without fetching
+	datasets.Lock()
+	datasets.entries["lines:SF"] = datasetEntry{value: []LineInfo{{ID: "N"}}, fetchedAt: fc.now.Add(-2 * linesTTL)}
+	datasets.Unlock()
+	fc.now = fc.now.Add(maxFailureBackoff)
+	cfg := *currentConfig()
+	cfg.UpstreamHourlyLimit = 1
+	activeConfig.Store(&cfg)
+	quota.record(fc.now)
+	t.Cleanup(func() {
+		quota.mu.Lock()
+		quota.calls = nil
+		quota.mu.Unlock()
+	})
+	if code := lines("SF"); code != http.StatusOK || len(rt.urls) != tried {
+		t.Errorf("over quota: status %d, %d requests", code, len(rt.urls)-tried)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// DeltaDirection is a changed direction and the stop it belongs to
+type DeltaDirection struct {
+	Stop string `json:"stop"`
+	Line string `json:"line"`
+	DirectionArrivals
+}
+
+// DeltaResponse lists the directions that changed after a revision
+type DeltaResponse struct {
+	Revision uint64 `json:"revision"`
+	// Every direction is listed, and clients should drop what they hold:
+	// since was missing, from another run, or older than a config change
+	Full        bool             `json:"full"`
+	Directions  []DeltaDirection `json:"directions"`
+	LastUpdated string           `json:"last_updated"`
+}
+
+// revisions returns the current revision and the one the layout last
+// changed at
+func (c *ArrivalsCache) revisions() (revision, layout uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.init()
+	return c.revision, c.layoutRevision
+}
+
+// handleDelta serves /api/arrivals/delta?since=, the directions whose
+// arrivals changed after the given revision. Unchanged directions are
+// left out, so clients count their minutes down from arrival_time.
+func handleDelta(w http.ResponseWriter, r *http.Request) {
+	page, err := parseArrivalsPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var since uint64
+	if v := r.URL.Query().Get("since"); v != "" {
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "since must be a revision from an earlier response", http.StatusBadRequest)
+			return
+		}
+	}
+	favorites, err := requestFavorites(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Read the revision before the data, so a change in between is sent
+	// again rather than missed
+	revision, layout := cache.revisions()
+	now := clock.Now()
+	arrivals := buildArrivalsPage(now, page)
+	if favorites != nil {
+		arrivals = filterFavorites(arrivals, favorites)
+	}
+
+	resp := DeltaResponse{
+		Revision:    revision,
+		Full:        since == 0 || since < layout || since > revision,
+		Directions:  []DeltaDirection{},
+		LastUpdated: arrivals.LastUpdated,
+	}
+	for _, stop := range arrivals.Stops {
+		for _, dir := range stop.Directions {
+			if resp.Full || dir.Revision > since {
+				resp.Directions = append(resp.Directions, DeltaDirection{Stop: stop.Name, Line: stop.Line, DirectionArrivals: dir})
+			}
+		}
+	}
+
+	setDataAge(w, arrivals, now)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
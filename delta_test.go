@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeltaUpdates(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 3, 9, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-03-09T20:05:00Z"}}}
+	]}}}`)
+	cfg, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: "16994"
+      - label: Downtown
+        stop_id: "16995"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeConfig.Store(cfg)
+	refreshCache()
+
+	delta := func(query string) DeltaResponse {
+		rec := httptest.NewRecorder()
+		handleDelta(rec, httptest.NewRequest("GET", "/api/arrivals/delta"+query, nil))
+		var resp DeltaResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("delta%s: %d %v %s", query, rec.Code, err, rec.Body)
+		}
+		return resp
+	}
+
+	full := delta("")
+	if !full.Full || len(full.Directions) != 2 || full.Directions[0].Stop != "Embarcadero" || full.Directions[1].Label != "Downtown" {
+		t.Fatalf("full = %+v", full)
+	}
+	since := "?since=" + strconv.FormatUint(full.Revision, 10)
+	if resp := delta(since); resp.Full || len(resp.Directions) != 0 || resp.Revision != full.Revision {
+		t.Errorf("unchanged = %+v", resp)
+	}
+
+	// Only the direction whose arrivals moved is sent
+	cache.mu.RLock()
+	data := cache.data
+	cache.mu.RUnlock()
+	moved := data
+	moved.Stops = []StopArrivals{data.Stops[0]}
+	moved.Stops[0].Directions = append([]DirectionArrivals(nil), data.Stops[0].Directions...)
+	moved.Stops[0].Directions[1].Arrivals = arrivalsAt(time.Date(2026, 3, 9, 20, 9, 0, 0, time.UTC))
+	cache.store(moved, clock.Now())
+
+	resp := delta(since)
+	if resp.Full || resp.Revision <= full.Revision || len(resp.Directions) != 1 || resp.Directions[0].StopID != "16995" || len(resp.Directions[0].Arrivals) != 1 {
+		t.Errorf("changed = %+v", resp)
+	}
+	if resp := delta("?since=" + strconv.FormatUint(resp.Revision, 10)); len(resp.Directions) != 0 {
+		t.Errorf("after change = %+v", resp)
+	}
+
+	// A revision from before the stops changed, or from another run,
+	// gets everything
+	shrunk := moved
+	shrunk.Stops = []StopArrivals{moved.Stops[0]}
+	shrunk.Stops[0].Directions = moved.Stops[0].Directions[:1]
+	cache.store(shrunk, clock.Now())
+	for _, q := range []string{since, "?since=99999999999"} {
+		if resp := delta(q); !resp.Full || len(resp.Directions) != 1 {
+			t.Errorf("%s = %+v", q, resp)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handleDelta(rec, httptest.NewRequest("GET", "/api/arrivals/delta?since=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("since=-1 = %d", rec.Code)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// defaultDestinationNames maps 511's destination strings to the names
+// riders know. Keys are matched case-insensitively; config entries in
+// destination_names are added on top and win.
+var defaultDestinationNames = map[string]string{
+	"SAN FRANCISCO CALTRAIN DEPOT VIA DOWNTOWN": "Caltrain",
+	"SAN FRANCISCO CALTRAIN DEPOT":              "Caltrain",
+	"SAN FRANCISCO CALTRAIN STATION":            "Caltrain",
+	"CALTRAIN DEPOT":                            "Caltrain",
+	"CALTRAIN/BALL PARK":                        "Caltrain/Ball Park",
+	"4TH ST & KING ST":                          "Caltrain",
+	"SAN FRANCISCO":                             "San Francisco",
+	"SAN JOSE DIRIDON STATION":                  "San Jose Diridon",
+	"EMBARCADERO STATION":                       "Embarcadero",
+	"FISHERMANS WHARF":                          "Fisherman's Wharf",
+	"OCEAN BEACH VIA DOWNTOWN":                  "Ocean Beach",
+}
+
+// destinationKey folds case and whitespace so near-identical strings match
+func destinationKey(name string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(name), " "))
+}
+
+// buildDestinationNames merges the configured names over the defaults
+func buildDestinationNames(custom map[string]string) map[string]string {
+	names := make(map[string]string, len(defaultDestinationNames)+len(custom))
+	for k, v := range defaultDestinationNames {
+		names[destinationKey(k)] = v
+	}
+	for k, v := range custom {
+		names[destinationKey(k)] = v
+	}
+	return names
+}
+
+// normalizeDestination returns the display name for a destination. Unknown
+// all-caps names are title-cased rather than shown shouting.
+func normalizeDestination(name string) string {
+	if config := currentConfig(); config != nil {
+		if display, ok := config.destinations[destinationKey(name)]; ok {
+			return display
+		}
+	}
+	if name != strings.ToUpper(name) {
+		return name
+	}
+	return titleCase(name)
+}
+
+// titleCase capitalizes the first letter of each word: "4TH ST" -> "4th St"
+func titleCase(s string) string {
+	out := []rune(strings.ToLower(s))
+	start := true
+	for i, r := range out {
+		if start && unicode.IsLetter(r) {
+			out[i] = unicode.ToUpper(r)
+		}
+		start = unicode.IsSpace(r) || r == '/' || r == '-' || r == '('
+	}
+	return string(out)
+}
+
+// isShortTurn reports whether an arrival's destination is one of the
+// direction's short-turn terminals. Names match case-insensitively on
+// substrings, so "Embarcadero" also catches "Embarcadero Station".
+func isShortTurn(dir Direction, destination string) bool {
+	dest := strings.ToLower(normalizeDestination(destination))
+	for _, st := range dir.ShortTurns {
+		if st = strings.ToLower(strings.TrimSpace(st)); st != "" && strings.Contains(dest, st) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeDestination(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	cfg := *currentConfig()
+	cfg.destinations = buildDestinationNames(map[string]string{"van ness station outbound": "Van Ness"})
+	activeConfig.Store(&cfg)
+
+	tests := map[string]string{
+		"SAN FRANCISCO CALTRAIN DEPOT VIA DOWNTOWN": "Caltrain",
+		"San Francisco  Caltrain Depot":             "Caltrain",
+		"VAN NESS STATION OUTBOUND":                 "Van Ness",
+		"FISHERMAN'S WHARF":                         "Fisherman's Wharf",
+		"4TH ST & KING ST/BALL PARK":                "4th St & King St/Ball Park",
+		"WEST PORTAL/SLOAT":                         "West Portal/Sloat",
+		"Ocean Beach":                               "Ocean Beach",
+	}
+	for in, want := range tests {
+		if got := normalizeDestination(in); got != want {
+			t.Errorf("normalizeDestination(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestShortTurns(t *testing.T) {
+	now := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	withTestEnv(t, now, "")
+
+	arrivals := arrivalsAt(now.Add(2*time.Minute), now.Add(6*time.Minute), now.Add(10*time.Minute))
+	arrivals[0].Destination = "EMBARCADERO STATION"
+	arrivals[1].Destination = "Ocean Beach"
+	arrivals[2].Destination = "Embarcadero"
+	cache.mu.Lock()
+	cache.data = ArrivalsResponse{Stops: []StopArrivals{{
+		Name:       "Church & Duboce",
+		Directions: []DirectionArrivals{{Label: "Inbound", StopID: "16994", Arrivals: arrivals}},
+	}}}
+	cache.mu.Unlock()
+
+	cfg := *currentConfig()
+	cfg.Stops = []Stop{{Name: "Church & Duboce", Directions: []Direction{{Label: "Inbound", StopID: "16994", ShortTurns: []string{"embarcadero"}}}}}
+	activeConfig.Store(&cfg)
+
+	got := buildArrivalsResponse(now).Stops[0].Directions[0].Arrivals
+	if len(got) != 3 || !got[0].ShortTurn || got[1].ShortTurn || !got[2].ShortTurn {
+		t.Errorf("flagged arrivals = %+v", got)
+	}
+
+	cfg.Stops[0].Directions[0].HideShortTurns = true
+	got = buildArrivalsResponse(now).Stops[0].Directions[0].Arrivals
+	if len(got) != 1 || got[0].Destination != "Ocean Beach" {
+		t.Errorf("with short turns hidden got %+v", got)
+	}
+}
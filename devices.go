@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DevicesConfig enables the device registry, where displays register
+// themselves and pick up the view settings stored for them
+type DevicesConfig struct {
+	// JSON file the registry is stored in; the registry is off when empty
+	File string `yaml:"file,omitempty"`
+	// Most devices the server will hold (default 50)
+	MaxDevices int `yaml:"max_devices,omitempty"`
+}
+
+func (d DevicesConfig) enabled() bool {
+	return d.File != ""
+}
+
+func validateDevicesConfig(d *DevicesConfig) error {
+	if d.MaxDevices < 0 {
+		return fmt.Errorf("devices.max_devices cannot be negative")
+	}
+	if d.MaxDevices == 0 {
+		d.MaxDevices = 50
+	}
+	return nil
+}
+
+// maxDeviceSize bounds device documents accepted over the API
+const maxDeviceSize = 4 << 10
+
+// deviceName is what displays may register as, e.g. "kitchen-kiosk"
+var deviceName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// deviceThemes are the themes the web UI ships
+var deviceThemes = map[string]bool{"": true, "default": true, "dark": true}
+
+// Device is a registered display and the view settings it should use
+type Device struct {
+	Name string `json:"name"`
+	// Screen size the device last reported, e.g. "800x480"
+	Screen string `json:"screen,omitempty"`
+	// Dashboard path to show, "" for the main board
+	Dashboard string `json:"dashboard,omitempty"`
+	Theme     string `json:"theme,omitempty"`
+	// "minutes" or "time"
+	DisplayMode  string    `json:"display_mode,omitempty"`
+	RegisteredAt time.Time `json:"registered_at"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// validateDevice checks a device's settings against the config
+func validateDevice(d *Device, cfg *Config) error {
+	if len(d.Screen) > 32 {
+		return fmt.Errorf("screen is too long")
+	}
+	if d.Dashboard != "" {
+		d.Dashboard = "/" + strings.Trim(d.Dashboard, "/")
+		found := false
+		for _, db := range cfg.Dashboards {
+			found = found || db.Path == d.Dashboard
+		}
+		if !found {
+			return fmt.Errorf("unknown dashboard %q", d.Dashboard)
+		}
+	}
+	if !deviceThemes[d.Theme] {
+		return fmt.Errorf("unknown theme %q", d.Theme)
+	}
+	switch d.DisplayMode {
+	case "", "minutes", "time":
+	default:
+		return fmt.Errorf("display_mode must be minutes or time")
+	}
+	return nil
+}
+
+// devices maps device names to their registrations
+var devices = &jsonStore[Device]{}
+
+// deviceFlushInterval is how often last contact times are written to the
+// registry. Displays check in on every page load, and saving each one
+// would rewrite the file all day.
+const deviceFlushInterval = 5 * time.Minute
+
+// deviceSightings holds last contact times not yet written to the registry
+var deviceSightings = struct {
+	sync.Mutex
+	seen map[string]time.Time
+}{seen: make(map[string]time.Time)}
+
+// sawDevice notes contact from a registered device
+func sawDevice(name string, at time.Time) {
+	deviceSightings.Lock()
+	defer deviceSightings.Unlock()
+	if at.After(deviceSightings.seen[name]) {
+		deviceSightings.seen[name] = at
+	}
+}
+
+// lastSeen returns d with its latest contact, written out yet or not
+func lastSeen(d Device) Device {
+	deviceSightings.Lock()
+	defer deviceSightings.Unlock()
+	if at, ok := deviceSightings.seen[d.Name]; ok && at.After(d.LastSeen) {
+		d.LastSeen = at
+	}
+	return d
+}
+
+// flushDeviceSightings writes pending contact times to the registry in
+// one save. Times that couldn't be saved are kept for the next try.
+func flushDeviceSightings() {
+	deviceSightings.Lock()
+	seen := deviceSightings.seen
+	deviceSightings.seen = make(map[string]time.Time)
+	deviceSightings.Unlock()
+
+	cfg := currentConfig()
+	if len(seen) == 0 || !cfg.Devices.enabled() {
+		return
+	}
+	err := devices.edit(cfg.Devices.File, func(items map[string]Device) bool {
+		changed := false
+		for name, at := range seen {
+			if d, ok := items[name]; ok && at.After(d.LastSeen) {
+				d.LastSeen = at
+				items[name] = d
+				changed = true
+			}
+		}
+		return changed
+	})
+	if err != nil {
+		warnf("Devices: saving last contact times: %v", err)
+		for name, at := range seen {
+			sawDevice(name, at)
+		}
+	}
+}
+
+// startDeviceFlusher writes contact times out every deviceFlushInterval
+// until ctx ends; the server flushes once more as it stops
+func startDeviceFlusher(ctx context.Context) {
+	go func() {
+		for sleepContext(ctx, deviceFlushInterval) {
+			flushDeviceSightings()
+		}
+	}()
+}
+
+// readDevice decodes a device from a request body and validates it
+func readDevice(w http.ResponseWriter, r *http.Request, cfg *Config) (Device, error) {
+	var d Device
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDeviceSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&d); err != nil {
+		return d, fmt.Errorf("invalid device: %w", err)
+	}
+	return d, validateDevice(&d, cfg)
+}
+
+// deviceFromPath returns the device name in /api/.../devices/{name}
+func deviceFromPath(w http.ResponseWriter, r *http.Request, prefix string) (string, bool) {
+	name := strings.TrimPrefix(apiPath(r.URL.Path), prefix)
+	if !deviceName.MatchString(name) {
+		http.Error(w, "device name must be 1-64 letters, digits, '-' or '_'", http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+// writeDeviceError reports a failed registry update
+func writeDeviceError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errStoreFull) {
+		http.Error(w, "device limit reached", http.StatusInsufficientStorage)
+		return
+	}
+	errorf("Devices: %v", err)
+	http.Error(w, "saving device failed", http.StatusInternalServerError)
+}
+
+// handleDevice lets a display fetch (GET) its settings or register (POST)
+// at /api/devices/{name}. Registering a known device only records its
+// screen size and last contact; the stored settings win, so a re-imaged
+// kiosk comes back exactly as it was. Registering takes a client key or
+// admin credentials, so anyone who can reach the board can't fill the
+// registry.
+func handleDevice(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	if !cfg.Devices.enabled() {
+		http.Error(w, "device registry is not enabled", http.StatusNotFound)
+		return
+	}
+	name, ok := deviceFromPath(w, r, "/api/devices/")
+	if !ok {
+		return
+	}
+
+	var d Device
+	switch r.Method {
+	case http.MethodGet:
+		var err error
+		d, ok, err = devices.get(cfg.Devices.File, name)
+		if err != nil {
+			errorf("Devices: %v", err)
+			http.Error(w, "device registry unavailable", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "device not registered", http.StatusNotFound)
+			return
+		}
+
+	case http.MethodPost:
+		if _, ok := clientKeyName(r); !ok && !adminAuthorized(r) {
+			http.Error(w, "registering a device takes a client key or admin credentials", http.StatusUnauthorized)
+			return
+		}
+		reported, err := readDevice(w, r, cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := clock.Now().UTC()
+
+		// A check-in that changes nothing but the contact time isn't
+		// written out until the next flush
+		d, ok, err = devices.get(cfg.Devices.File, name)
+		if err == nil && ok && (reported.Screen == "" || reported.Screen == d.Screen) {
+			sawDevice(name, now)
+			break
+		}
+		d, err = devices.update(cfg.Devices.File, name, cfg.Devices.MaxDevices, func(old Device, exists bool) (Device, error) {
+			if exists {
+				old.LastSeen = now
+				if reported.Screen != "" {
+					old.Screen = reported.Screen
+				}
+				return old, nil
+			}
+			infof("Device %s registered", name)
+			reported.Name = name
+			reported.RegisteredAt, reported.LastSeen = now, now
+			return reported, nil
+		})
+		if err != nil {
+			writeDeviceError(w, err)
+			return
+		}
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lastSeen(d))
+}
+
+// handleAdminDevices lists every registered device
+func handleAdminDevices(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	if !cfg.Devices.enabled() {
+		http.Error(w, "device registry is not enabled", http.StatusNotFound)
+		return
+	}
+	list, err := devices.list(cfg.Devices.File)
+	if err != nil {
+		errorf("Devices: %v", err)
+		http.Error(w, "device registry unavailable", http.StatusInternalServerError)
+		return
+	}
+	for i, d := range list {
+		list[i] = lastSeen(d)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleAdminDevice changes a device's settings (PUT) or forgets it
+// (DELETE) at /api/admin/devices/{name}
+func handleAdminDevice(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	if !cfg.Devices.enabled() {
+		http.Error(w, "device registry is not enabled", http.StatusNotFound)
+		return
+	}
+	name, ok := deviceFromPath(w, r, "/api/admin/devices/")
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		settings, err := readDevice(w, r, cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := clock.Now().UTC()
+		d, err := devices.update(cfg.Devices.File, name, cfg.Devices.MaxDevices, func(old Device, exists bool) (Device, error) {
+			if !exists {
+				old.RegisteredAt = now
+			}
+			old.Name = name
+			old.Dashboard = settings.Dashboard
+			old.Theme = settings.Theme
+			old.DisplayMode = settings.DisplayMode
+			return old, nil
+		})
+		if err != nil {
+			writeDeviceError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lastSeen(d))
+
+	case http.MethodDelete:
+		ok, err := devices.delete(cfg.Devices.File, name)
+		if err != nil {
+			errorf("Devices: %v", err)
+			http.Error(w, "deleting device failed", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "device not registered", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
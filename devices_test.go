@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeviceRegistry(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+	t.Cleanup(func() { clear(deviceSightings.seen) })
+
+	file := filepath.Join(t.TempDir(), "devices.json")
+	cfg, err := parseConfig([]byte(`
+api_key: test
+client_keys: [{name: kitchen, key: kitchen-key}]
+devices:
+  file: ` + file + `
+dashboards:
+  - path: /office
+    stops:
+      - name: Montgomery
+        directions: [{label: Richmond, stop_id: "MONT"}]
+stops:
+  - name: Embarcadero
+    directions: [{label: Ocean Beach, stop_id: "16994"}]
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	do := func(handler http.HandlerFunc, method, path, body string) Device {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", "kitchen-key")
+		handler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: HTTP %d: %s", method, path, rec.Code, rec.Body)
+		}
+		var d Device
+		json.NewDecoder(rec.Body).Decode(&d)
+		return d
+	}
+
+	// Registering takes a client key or admin credentials
+	rec := httptest.NewRecorder()
+	handleDevice(rec, httptest.NewRequest("POST", "/api/devices/kitchen", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("register without a key: HTTP %d", rec.Code)
+	}
+
+	d := do(handleDevice, "POST", "/api/devices/kitchen", `{"screen":"800x480","theme":"dark"}`)
+	if d.Name != "kitchen" || d.Theme != "dark" || d.Screen != "800x480" {
+		t.Errorf("registered = %+v", d)
+	}
+
+	// Check-ins are served at once but written out in batches
+	saved := func() Device {
+		var stored map[string]Device
+		data, _ := os.ReadFile(file)
+		json.Unmarshal(data, &stored)
+		return stored["kitchen"]
+	}
+	fc.Sleep(time.Minute)
+	if d = do(handleDevice, "POST", "/api/devices/kitchen", `{"screen":"800x480"}`); !d.LastSeen.Equal(fc.now.UTC()) {
+		t.Errorf("last seen = %v, want %v", d.LastSeen, fc.now)
+	}
+	if got := saved().LastSeen; !got.Before(d.LastSeen) {
+		t.Errorf("check-in written out at once: %v", got)
+	}
+	flushDeviceSightings()
+	if got := saved().LastSeen; !got.Equal(d.LastSeen) {
+		t.Errorf("flushed last seen = %v, want %v", got, d.LastSeen)
+	}
+
+	d = do(handleAdminDevice, "PUT", "/api/admin/devices/kitchen", `{"dashboard":"office/","display_mode":"time"}`)
+	if d.Dashboard != "/office" || d.Theme != "" || d.Screen != "800x480" {
+		t.Errorf("after admin edit = %+v", d)
+	}
+
+	// A re-imaged kiosk registers with defaults and gets its settings back
+	fc.Sleep(time.Hour)
+	devices = &jsonStore[Device]{}
+	d = do(handleDevice, "POST", "/api/devices/kitchen", `{"screen":"1024x600"}`)
+	if d.Dashboard != "/office" || d.DisplayMode != "time" || d.Screen != "1024x600" || !d.LastSeen.After(d.RegisteredAt) {
+		t.Errorf("re-registered = %+v", d)
+	}
+
+	rec = httptest.NewRecorder()
+	handleAdminDevice(rec, httptest.NewRequest("PUT", "/api/admin/devices/kitchen", strings.NewReader(`{"dashboard":"/garage"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown dashboard: HTTP %d", rec.Code)
+	}
+}
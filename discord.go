@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DiscordConfig posts notifications to a Discord channel webhook
+type DiscordConfig struct {
+	// From the channel's Integrations > Webhooks settings
+	WebhookURL string `yaml:"webhook_url"`
+	// Overrides the webhook's display name
+	Username string          `yaml:"username,omitempty"`
+	Template MessageTemplate `yaml:"template,omitempty"`
+}
+
+func validateDiscordConfig(hooks []DiscordConfig) error {
+	for i, d := range hooks {
+		u, err := url.Parse(d.WebhookURL)
+		if err != nil || u.Scheme != "https" || !strings.Contains(u.Path, "/api/webhooks/") {
+			return fmt.Errorf("notifications.discord[%d]: webhook_url is not a Discord webhook URL", i)
+		}
+	}
+	return nil
+}
+
+// Badge colors from the web UI, so embeds match the board
+const (
+	colorTLine    = 0xFF6700
+	colorNLine    = 0xB8FF12
+	colorCaltrain = 0xFF00AA
+	colorDefault  = 0x00D4FF
+)
+
+// lineColor picks a line's badge color the way the web UI does
+func lineColor(line string) int {
+	l := strings.ToLower(line)
+	switch {
+	case strings.Contains(l, "caltrain"):
+		return colorCaltrain
+	case strings.Contains(l, "t ") || strings.HasPrefix(l, "t"):
+		return colorTLine
+	case strings.Contains(l, "n ") || strings.HasPrefix(l, "n"):
+		return colorNLine
+	}
+	return colorDefault
+}
+
+type discordNotifier struct {
+	cfg DiscordConfig
+}
+
+func (d discordNotifier) String() string {
+	return "Discord webhook"
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color"`
+	Timestamp   string         `json:"timestamp,omitempty"`
+	Fields      []discordField `json:"fields,omitempty"`
+}
+
+type discordMessage struct {
+	Username string         `json:"username,omitempty"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+// discordPayload renders n as an embed with the line's color and the
+// arrival details as fields
+func discordPayload(cfg DiscordConfig, n Notification) discordMessage {
+	embed := discordEmbed{
+		Title:       n.Title,
+		Description: n.Message,
+		Color:       lineColor(n.Line),
+		Timestamp:   n.Time,
+	}
+	add := func(name, value string) {
+		if value != "" {
+			embed.Fields = append(embed.Fields, discordField{Name: name, Value: value, Inline: true})
+		}
+	}
+	add("Line", n.Line)
+	add("Stop", n.Stop)
+	add("Direction", n.Direction)
+	add("Destination", n.Destination)
+	if n.Minutes != nil {
+		add("Minutes", strconv.Itoa(*n.Minutes))
+	}
+	return discordMessage{Username: cfg.Username, Embeds: []discordEmbed{embed}}
+}
+
+func (d discordNotifier) send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(discordPayload(d.cfg, n))
+	if err != nil {
+		return err
+	}
+	resp, err := postJSON(ctx, d.cfg.WebhookURL, body)
+	if err != nil {
+		// The URL holds the webhook's token, so keep it out of the log
+		return fmt.Errorf("posting to Discord failed")
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
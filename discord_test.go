@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDiscordNotifications(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	rt := &recordTransport{}
+	upstreamTransport = rt
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+notifications:
+  discord:
+    - webhook_url: https://discord.com/api/webhooks/123/token
+      username: Muni
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: "16994"
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	minutes := 9
+	notify(context.Background(), Notification{Kind: "threshold", Title: "Embarcadero Ocean Beach: 9 min", Message: "N Judah to Ocean Beach",
+		Time: "2026-01-30T08:00:00-08:00", Stop: "Embarcadero", Line: "N Judah", Destination: "Ocean Beach", Minutes: &minutes})
+	if len(rt.bodies) != 1 {
+		t.Fatalf("posted %d times", len(rt.bodies))
+	}
+	var msg discordMessage
+	if err := json.Unmarshal([]byte(rt.bodies[0]), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Username != "Muni" || len(msg.Embeds) != 1 {
+		t.Fatalf("message = %+v", msg)
+	}
+	embed := msg.Embeds[0]
+	if embed.Color != colorNLine || embed.Title != "Embarcadero Ocean Beach: 9 min" || len(embed.Fields) != 4 ||
+		embed.Fields[3] != (discordField{Name: "Minutes", Value: "9", Inline: true}) {
+		t.Errorf("embed = %+v", embed)
+	}
+
+	// The webhook token stays out of exports and survives a re-import
+	exported := redactConfig(cfg)
+	if exported.Notifications.Discord[0].WebhookURL != redacted {
+		t.Error("Discord webhook URL not redacted")
+	}
+	if err := restoreSecrets(&exported, cfg); err != nil || exported.Notifications.Discord[0].WebhookURL != cfg.Notifications.Discord[0].WebhookURL {
+		t.Errorf("restore = %v, %q", err, exported.Notifications.Discord[0].WebhookURL)
+	}
+
+	if _, err := parseConfig([]byte("api_key: test\nnotifications: {discord: [{webhook_url: \"https://example.com/hook\"}]}\n" + testStop)); err == nil {
+		t.Error("non-Discord webhook_url should be rejected")
+	}
+}
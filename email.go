@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EmailConfig sends notifications and an optional daily digest by SMTP
+type EmailConfig struct {
+	Host string `yaml:"host,omitempty"`
+	// Default 587, or 465 with security: tls
+	Port int `yaml:"port,omitempty"`
+	// starttls (default), tls for implicit TLS, or none
+	Security     string   `yaml:"security,omitempty"`
+	Username     string   `yaml:"username,omitempty"`
+	Password     string   `yaml:"password,omitempty"`
+	PasswordFile string   `yaml:"password_file,omitempty"`
+	From         string   `yaml:"from,omitempty"`
+	To           []string `yaml:"to,omitempty"`
+	// Local time (HH:MM) to mail a summary of the day's notifications
+	// and alarms (default: no digest)
+	Digest   string          `yaml:"digest,omitempty"`
+	Template MessageTemplate `yaml:"template,omitempty"`
+}
+
+func (e EmailConfig) enabled() bool {
+	return e.Host != ""
+}
+
+// SMTP security modes
+const (
+	smtpStartTLS = "starttls"
+	smtpTLS      = "tls"
+	smtpPlain    = "none"
+)
+
+func validateEmailConfig(e *EmailConfig) error {
+	if !e.enabled() {
+		return nil
+	}
+	switch e.Security {
+	case "":
+		e.Security = smtpStartTLS
+	case smtpStartTLS, smtpTLS, smtpPlain:
+	default:
+		return fmt.Errorf("notifications.email.security must be starttls, tls, or none")
+	}
+	if e.Port == 0 {
+		e.Port = 587
+		if e.Security == smtpTLS {
+			e.Port = 465
+		}
+	}
+	if e.From == "" || len(e.To) == 0 {
+		return fmt.Errorf("notifications.email needs from and to addresses")
+	}
+	if e.Digest != "" {
+		if _, err := time.Parse("15:04", e.Digest); err != nil {
+			return fmt.Errorf("invalid notifications.email.digest %q (want HH:MM)", e.Digest)
+		}
+	}
+	return nil
+}
+
+type emailNotifier struct {
+	cfg EmailConfig
+}
+
+func (e emailNotifier) String() string {
+	return "email " + e.cfg.Host
+}
+
+// send doesn't watch ctx: net/smtp has no way to cancel a conversation
+func (e emailNotifier) send(_ context.Context, n Notification) error {
+	return sendEmail(e.cfg, buildEmail(e.cfg, n.Title, n.Message))
+}
+
+// buildEmail formats a plain-text message with the headers mail servers
+// and spam filters expect
+func buildEmail(e EmailConfig, subject, body string) []byte {
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := e.Host
+	if _, d, ok := strings.Cut(e.From, "@"); ok {
+		domain = strings.Trim(d, "> ")
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", clock.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+	return msg.Bytes()
+}
+
+// sendEmail delivers a message; tests replace it
+var sendEmail = smtpSend
+
+func smtpSend(e EmailConfig, msg []byte) error {
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	tlsConfig := &tls.Config{ServerName: e.Host}
+
+	var conn net.Conn
+	var err error
+	if e.Security == smtpTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	c, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if e.Security == smtpStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if e.Username != "" {
+		// PlainAuth refuses to send the password unencrypted, except to
+		// localhost
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// digestState is the local date the last digest went out
+var digestState struct {
+	sync.Mutex
+	sent string
+}
+
+// checkDigest mails the daily digest once its time has come
+func checkDigest(now time.Time) {
+	e := currentConfig().Notifications.Email
+	if !e.enabled() || e.Digest == "" {
+		return
+	}
+	local := localTime(now)
+	today := local.Format("2006-01-02")
+	at, _ := time.Parse("15:04", e.Digest)
+	due := time.Date(local.Year(), local.Month(), local.Day(), at.Hour(), at.Minute(), 0, 0, local.Location())
+
+	digestState.Lock()
+	defer digestState.Unlock()
+	if local.Before(due) || digestState.sent == today {
+		return
+	}
+	// Only the first check after the digest time sends, so a restart late
+	// in the day doesn't mail a second one
+	if digestState.sent == "" && local.Sub(due) > 2*digestCheckInterval {
+		digestState.sent = today
+		return
+	}
+	digestState.sent = today
+
+	subject := "Muni digest for " + local.Format("Mon Jan 2")
+	if err := sendEmail(e, buildEmail(e, subject, digestBody(now))); err != nil {
+		warnf("Email digest failed: %v", err)
+		return
+	}
+	infof("Sent email digest to %s", strings.Join(e.To, ", "))
+}
+
+// digestBody lists the last day's notifications and today's alarms
+func digestBody(now time.Time) string {
+	var b strings.Builder
+	b.WriteString("Notifications in the last 24 hours:\n")
+	sent := sentSince(now.Add(-24 * time.Hour))
+	if len(sent) == 0 {
+		b.WriteString("  none\n")
+	}
+	for _, n := range sent {
+		t, _ := time.Parse(time.RFC3339, n.Time)
+		fmt.Fprintf(&b, "  %s  %s: %s\n", displayTime(t), n.Title, n.Message)
+	}
+
+	var alarms []string
+	for _, a := range currentConfig().Alarms {
+		if a.runsOn(now) {
+			alarms = append(alarms, fmt.Sprintf("  %s: %s %s around %s, %d min walk", a.Name, a.Stop, a.Direction, a.Target, a.WalkMinutes))
+		}
+	}
+	if len(alarms) > 0 {
+		b.WriteString("\nAlarms today:\n")
+		b.WriteString(strings.Join(alarms, "\n"))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// digestCheckInterval is how often the digest time is checked
+const digestCheckInterval = 30 * time.Second
+
+// startEmailDigest sends the daily digest in the background until ctx
+// ends. It follows config reloads, so a digest can be set up without a
+// restart.
+func startEmailDigest(ctx context.Context) {
+	go func() {
+		for {
+			checkDigest(clock.Now())
+			if !sleepContext(ctx, digestCheckInterval) {
+				return
+			}
+		}
+	}()
+}
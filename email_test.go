@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailNotifications(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Now(), "")
+
+	var sent []string
+	oldSend := sendEmail
+	sendEmail = func(e EmailConfig, msg []byte) error {
+		sent = append(sent, string(msg))
+		return nil
+	}
+	digestState.sent = ""
+	t.Cleanup(func() {
+		sendEmail = oldSend
+		digestState.sent = ""
+	})
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+notifications:
+  email:
+    host: smtp.example.com
+    username: tracker
+    password: secret
+    from: tracker@example.com
+    to: [me@example.com]
+    digest: "07:00"
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: "16994"
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+	if e := cfg.Notifications.Email; e.Port != 587 || e.Security != smtpStartTLS {
+		t.Errorf("defaults = port %d security %q", e.Port, e.Security)
+	}
+
+	fc.now = time.Date(2026, 1, 29, 18, 0, 0, 0, cfg.location)
+	if !notify(context.Background(), Notification{Kind: "test", Title: "Leave now: Café", Message: "N in 6 min", Time: fc.now.Format(time.RFC3339)}) {
+		t.Fatal("email notification not delivered")
+	}
+	if len(sent) != 1 || !strings.Contains(sent[0], "To: me@example.com\r\n") ||
+		!strings.Contains(sent[0], "Subject: =?utf-8?q?Leave_now:_Caf=C3=A9?=\r\n") || !strings.HasSuffix(sent[0], "\r\n\r\nN in 6 min\r\n") {
+		t.Fatalf("message = %q", sent)
+	}
+
+	// The digest goes out once, at its time the next morning
+	fc.now = time.Date(2026, 1, 30, 6, 59, 45, 0, cfg.location)
+	checkDigest(fc.now)
+	fc.Sleep(digestCheckInterval)
+	checkDigest(fc.now)
+	fc.Sleep(digestCheckInterval)
+	checkDigest(fc.now)
+	if len(sent) != 2 {
+		t.Fatalf("sent %d emails, want the notification and one digest", len(sent))
+	}
+	if !strings.Contains(sent[1], "Muni digest for Fri Jan 30") || !strings.Contains(sent[1], "Leave now: Café: N in 6 min") {
+		t.Errorf("digest = %q", sent[1])
+	}
+
+	if redactConfig(cfg).Notifications.Email.Password != redacted {
+		t.Error("SMTP password not redacted")
+	}
+	for _, bad := range []string{"{host: smtp.example.com, to: [a@b.c]}", "{host: h, from: a@b.c, to: [a@b.c], security: ssl}", "{host: h, from: a@b.c, to: [a@b.c], digest: 7am}"} {
+		if _, err := parseConfig([]byte("api_key: test\nnotifications: {email: " + bad + "}\n" + testStop)); err == nil {
+			t.Errorf("email %s should be rejected", bad)
+		}
+	}
+}
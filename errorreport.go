@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrorReportingConfig sends panics, repeated parse failures and fatal
+// config errors somewhere a human looks, for headless kiosk deployments
+type ErrorReportingConfig struct {
+	// Sentry DSN, e.g. https://<key>@o0.ingest.sentry.io/<project>. The
+	// SENTRY_DSN environment variable is used when this is empty, which
+	// also covers config files that fail to load.
+	SentryDSN string `yaml:"sentry_dsn,omitempty"`
+	// Receives each report as a JSON POST
+	WebhookURL string `yaml:"webhook_url,omitempty"`
+	// Sentry environment tag (default production)
+	Environment string `yaml:"environment,omitempty"`
+}
+
+func validateErrorReportingConfig(e *ErrorReportingConfig) error {
+	if e.SentryDSN != "" {
+		if _, _, err := parseSentryDSN(e.SentryDSN); err != nil {
+			return fmt.Errorf("error_reporting.sentry_dsn: %w", err)
+		}
+	}
+	if e.WebhookURL != "" {
+		u, err := url.Parse(e.WebhookURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("error_reporting.webhook_url: %q is not an http(s) URL", e.WebhookURL)
+		}
+	}
+	if e.Environment == "" {
+		e.Environment = "production"
+	}
+	return nil
+}
+
+// parseSentryDSN returns the envelope endpoint and public key of a DSN
+func parseSentryDSN(dsn string) (endpoint, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User == nil {
+		return "", "", errors.New("not a Sentry DSN")
+	}
+	// The project ID is the last path segment, after any path prefix of a
+	// self-hosted server
+	path := strings.Trim(u.Path, "/")
+	prefix, project := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		prefix, project = "/"+path[:i], path[i+1:]
+	}
+	if project == "" || u.User.Username() == "" {
+		return "", "", errors.New("not a Sentry DSN")
+	}
+	return fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project), u.User.Username(), nil
+}
+
+// ErrorReport is one problem, as sent to the webhook
+type ErrorReport struct {
+	// panic, parse_failure or config
+	Kind    string            `json:"kind"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Time    string            `json:"time"`
+	Context map[string]string `json:"context,omitempty"`
+	Stack   string            `json:"stack,omitempty"`
+}
+
+// errorReportInterval limits repeats of one message, so a panic on every
+// refresh doesn't flood the inbox
+const errorReportInterval = 10 * time.Minute
+
+var errorReports = struct {
+	sync.Mutex
+	sent    map[string]time.Time
+	pending sync.WaitGroup
+}{sent: make(map[string]time.Time)}
+
+// reportError sends r in the background, unless the same message was
+// reported recently
+func reportError(r ErrorReport) {
+	now := clock.Now()
+	errorReports.Lock()
+	if last, ok := errorReports.sent[r.Kind+r.Message]; ok && now.Sub(last) < errorReportInterval {
+		errorReports.Unlock()
+		return
+	}
+	errorReports.sent[r.Kind+r.Message] = now
+	errorReports.pending.Add(1)
+	errorReports.Unlock()
+
+	go func() {
+		defer errorReports.pending.Done()
+		sendErrorReport(r)
+	}()
+}
+
+// reportFatal sends r and waits, for errors the process is about to exit
+// on
+func reportFatal(r ErrorReport) {
+	r.Level = "fatal"
+	reportError(r)
+	errorReports.pending.Wait()
+}
+
+// errorReporting returns the reporting settings in effect, from the
+// environment alone when no config has loaded
+func errorReporting() ErrorReportingConfig {
+	var e ErrorReportingConfig
+	if cfg := currentConfig(); cfg != nil {
+		e = cfg.ErrorReporting
+	}
+	if e.SentryDSN == "" {
+		e.SentryDSN = os.Getenv("SENTRY_DSN")
+	}
+	if e.Environment == "" {
+		e.Environment = "production"
+	}
+	return e
+}
+
+func sendErrorReport(r ErrorReport) {
+	e := errorReporting()
+	if r.Level == "" {
+		r.Level = "error"
+	}
+	r.Time = clock.Now().UTC().Format(time.RFC3339)
+
+	// Not serverCtx: a crash during shutdown should still be reported
+	ctx := context.Background()
+	if e.SentryDSN != "" {
+		if err := sendSentry(ctx, e, r); err != nil {
+			warnf("Error report to Sentry failed: %v", err)
+		}
+	}
+	if e.WebhookURL != "" {
+		body, _ := json.Marshal(r)
+		resp, err := postJSON(ctx, e.WebhookURL, body)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				err = fmt.Errorf("HTTP %d", resp.StatusCode)
+			}
+		}
+		if err != nil {
+			warnf("Error report to webhook failed: %v", err)
+		}
+	}
+}
+
+// sentryEvent is the subset of Sentry's event payload the reports use
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Platform    string            `json:"platform"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger"`
+	Environment string            `json:"environment"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Message     map[string]string `json:"message"`
+	Tags        map[string]string `json:"tags"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+// sendSentry posts r to Sentry's envelope endpoint
+func sendSentry(ctx context.Context, e ErrorReportingConfig, r ErrorReport) error {
+	endpoint, key, err := parseSentryDSN(e.SentryDSN)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	host, _ := os.Hostname()
+	event := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   r.Time,
+		Platform:    "go",
+		Level:       r.Level,
+		Logger:      "muni-tracker",
+		Environment: e.Environment,
+		ServerName:  host,
+		Message:     map[string]string{"formatted": r.Message},
+		Tags:        map[string]string{"kind": r.Kind},
+		Extra:       r.Context,
+	}
+	if r.Stack != "" {
+		if event.Extra == nil {
+			event.Extra = make(map[string]string)
+		}
+		event.Extra["stack"] = r.Stack
+	}
+
+	var body bytes.Buffer
+	header, _ := json.Marshal(map[string]string{"event_id": event.EventID, "sent_at": r.Time})
+	payload, _ := json.Marshal(event)
+	fmt.Fprintf(&body, "%s\n{\"type\":\"event\"}\n%s\n", header, payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=muni-tracker/1.0, sentry_key="+key)
+	resp, err := upstreamClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// reportPanic reports a recovered panic with its stack
+func reportPanic(v interface{}, context map[string]string) {
+	reportError(ErrorReport{
+		Kind:    "panic",
+		Message: fmt.Sprintf("panic: %v", v),
+		Context: context,
+		Stack:   string(debug.Stack()),
+	})
+}
+
+// reportPanics recovers panics in HTTP handlers, reporting them and
+// answering 500 instead of dropping the connection
+func reportPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			errorf("Panic serving %s %s: %v", r.Method, loggedPath(r), v)
+			reportPanic(v, map[string]string{"method": r.Method, "path": loggedPath(r)})
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseFailureReportAfter is how many responses in a row from one stop
+// must fail to parse before it is reported
+const parseFailureReportAfter = 3
+
+var parseFailures = struct {
+	sync.Mutex
+	count map[string]int
+}{count: make(map[string]int)}
+
+// noteParseResult counts consecutive parse failures per stop, reporting
+// once when they reach parseFailureReportAfter
+func noteParseResult(agency, stopID string, err error) {
+	key := agency + "\x00" + stopID
+	parseFailures.Lock()
+	if err == nil {
+		delete(parseFailures.count, key)
+		parseFailures.Unlock()
+		return
+	}
+	parseFailures.count[key]++
+	n := parseFailures.count[key]
+	parseFailures.Unlock()
+
+	if n == parseFailureReportAfter {
+		reportError(ErrorReport{
+			Kind:    "parse_failure",
+			Message: fmt.Sprintf("%d StopMonitoring responses in a row failed to parse", n),
+			Context: map[string]string{"agency": agency, "stop_id": stopID, "error": err.Error()},
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorReporting(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	rt := &recordTransport{status: http.StatusOK}
+	upstreamTransport = rt
+
+	for dsn, want := range map[string]string{
+		"https://abc@o1.ingest.sentry.io/42":  "https://o1.ingest.sentry.io/api/42/envelope/",
+		"http://abc@sentry.lan:9000/sentry/7": "http://sentry.lan:9000/sentry/api/7/envelope/",
+		"https://o1.ingest.sentry.io/42":      "",
+		"https://abc@o1.ingest.sentry.io/":    "",
+		"ftp://abc@o1.ingest.sentry.io/42":    "",
+	} {
+		if got, _, _ := parseSentryDSN(dsn); got != want {
+			t.Errorf("parseSentryDSN(%q) = %q, want %q", dsn, got, want)
+		}
+	}
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+error_reporting:
+  sentry_dsn: https://abc@o1.ingest.sentry.io/42
+  webhook_url: https://hooks.example.com/errors
+  environment: kiosk
+` + testStop))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+	if redactConfig(cfg).ErrorReporting.SentryDSN != redacted {
+		t.Error("Sentry DSN not redacted")
+	}
+
+	// Empty bodies don't parse; the third in a row is reported once
+	for i := 0; i < 4; i++ {
+		fetchStopArrivals(context.Background(), "SF", "80001")
+		errorReports.pending.Wait()
+	}
+	var sentry, hook []int
+	for i, u := range rt.urls {
+		switch u {
+		case "https://o1.ingest.sentry.io/api/42/envelope/":
+			sentry = append(sentry, i)
+		case "https://hooks.example.com/errors":
+			hook = append(hook, i)
+		}
+	}
+	if len(sentry) != 1 || len(hook) != 1 {
+		t.Fatalf("reports: sentry %v, webhook %v of %v", sentry, hook, rt.urls)
+	}
+	if auth := rt.headers[sentry[0]].Get("X-Sentry-Auth"); !strings.Contains(auth, "sentry_key=abc") {
+		t.Errorf("X-Sentry-Auth = %q", auth)
+	}
+	lines := strings.Split(strings.TrimSpace(rt.bodies[sentry[0]]), "\n")
+	var event sentryEvent
+	if len(lines) != 3 || json.Unmarshal([]byte(lines[2]), &event) != nil {
+		t.Fatalf("envelope = %q", rt.bodies[sentry[0]])
+	}
+	if event.Tags["kind"] != "parse_failure" || event.Environment != "kiosk" || event.Extra["stop_id"] != "80001" || len(event.EventID) != 32 {
+		t.Errorf("event = %+v", event)
+	}
+	var report ErrorReport
+	json.Unmarshal([]byte(rt.bodies[hook[0]]), &report)
+	if report.Kind != "parse_failure" || report.Level != "error" || report.Context["agency"] != "SF" {
+		t.Errorf("webhook report = %+v", report)
+	}
+
+	// Handler panics answer 500 and are reported with a stack
+	rt.urls, rt.bodies = nil, nil
+	rec := httptest.NewRecorder()
+	reportPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})).ServeHTTP(rec, httptest.NewRequest("GET", "/api/next", nil))
+	errorReports.pending.Wait()
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("panic status = %d", rec.Code)
+	}
+	json.Unmarshal([]byte(rt.bodies[len(rt.bodies)-1]), &report)
+	if report.Kind != "panic" || report.Message != "panic: boom" || !strings.Contains(report.Stack, "goroutine") || report.Context["path"] != "/api/next" {
+		t.Errorf("panic report = %+v", report)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events the cache publishes as directions are stored
+const (
+	// A direction's arrivals changed
+	EventDirectionUpdated = "direction_updated"
+	// A fetch of a direction failed outright
+	EventFetchFailed = "fetch_failed"
+	// A direction's data quality warning appeared, changed, or cleared
+	EventQualityChanged = "quality_changed"
+)
+
+var eventKinds = []string{EventDirectionUpdated, EventFetchFailed, EventQualityChanged}
+
+// eventDirectionFetched goes to handlers only, for every direction fetched
+// without error, changed or not: its minutes count down even when its
+// arrivals stay the same
+const eventDirectionFetched = "direction_fetched"
+
+// Event is one change to the main board's cache
+type Event struct {
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+	// Cache revision the change was stored at
+	Revision uint64 `json:"revision"`
+	Stop     string `json:"stop"`
+	Line     string `json:"line"`
+	// The direction as /api/arrivals would serve it now
+	Direction DirectionArrivals `json:"direction"`
+	// Where the direction is in the configured stops
+	ref directionRef
+}
+
+// eventBufferSize is how many events a subscriber may fall behind by
+// before further events are dropped for it
+const eventBufferSize = 64
+
+// eventSubscriber receives the kinds of event it asked for, or all of
+// them when kinds is empty
+type eventSubscriber struct {
+	ch    chan Event
+	kinds map[string]bool
+}
+
+// eventBus fans cache events out to subscribers, so features that react
+// to new data don't each have to poll the cache for it. Publishing never
+// blocks: a subscriber that falls behind misses events. Features inside
+// the server that mustn't miss any register a handler instead.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[*eventSubscriber]bool
+	// Run for every event, see handle
+	handlers []func(Event)
+	// Quality level and warning last published per direction
+	quality map[string]string
+}
+
+var events = &eventBus{subs: make(map[*eventSubscriber]bool), quality: make(map[string]string)}
+
+// eventsDropped counts events a slow subscriber missed, by kind
+var eventsDropped = newCounterVec("kind")
+
+// subscribe returns a channel of events of the given kinds, all when none
+// are given, and a function that ends the subscription
+func (b *eventBus) subscribe(kinds ...string) (<-chan Event, func()) {
+	ch, cancel, _ := b.subscribeUpTo(0, kinds...)
+	return ch, cancel
+}
+
+// errTooManySubscribers is returned once a subscription limit is reached
+var errTooManySubscribers = fmt.Errorf("too many event streams open")
+
+// subscribeUpTo is subscribe, refused when limit subscriptions are already
+// open. A limit of 0 means none.
+func (b *eventBus) subscribeUpTo(limit int, kinds ...string) (<-chan Event, func(), error) {
+	sub := &eventSubscriber{ch: make(chan Event, eventBufferSize)}
+	if len(kinds) > 0 {
+		sub.kinds = make(map[string]bool, len(kinds))
+		for _, k := range kinds {
+			sub.kinds[k] = true
+		}
+	}
+	b.mu.Lock()
+	if limit > 0 && len(b.subs) >= limit {
+		b.mu.Unlock()
+		return nil, nil, errTooManySubscribers
+	}
+	b.subs[sub] = true
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, sub)
+			b.mu.Unlock()
+		})
+	}, nil
+}
+
+// handle runs fn for every event, the internal direction_fetched included,
+// on the goroutine that stored the change. Unlike a subscriber, a handler
+// sees every event in order, and holds up the store until it returns.
+// Handlers are registered from init.
+func (b *eventBus) handle(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, fn)
+}
+
+// subscribers reports how many subscriptions are open
+func (b *eventBus) subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+func (b *eventBus) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if sub.kinds != nil && !sub.kinds[e.Kind] {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			eventsDropped.inc(e.Kind)
+		}
+	}
+}
+
+// cacheStored publishes what changed between two cache snapshots. from is
+// the revision prev was stored at; directions stamped with a later one
+// changed. Only directions fetched since prev are judged for quality, so
+// the clock running on alone doesn't raise events.
+func (b *eventBus) cacheStored(prev, data ArrivalsResponse, from uint64) {
+	// Nobody is listening: skip building the view, and forget the quality
+	// baseline, which would be stale by the time someone is
+	b.mu.Lock()
+	idle := len(b.subs) == 0 && len(b.handlers) == 0
+	if idle {
+		clear(b.quality)
+	}
+	b.mu.Unlock()
+	if idle {
+		return
+	}
+
+	cfg := currentConfig()
+	if len(data.Stops) == 0 || layoutChanged(data, configLayout(cfg)) {
+		return // mid config change; the next store catches up
+	}
+	now := clock.Now()
+	view := buildArrivalsView(data, cfg.Stops, now, allArrivals)
+
+	var out []Event
+	seen := make(map[string]bool)
+	for i, stop := range data.Stops {
+		for j, dir := range stop.Directions {
+			served := view.Stops[i].Directions[j]
+			e := Event{Time: now, Revision: dir.Revision, Stop: stop.Name, Line: stop.Line, Direction: served, ref: directionRef{i, j}}
+			last, ok := previousDirection(prev, i, j, dir.StopID)
+			fetched := !ok || !dir.FetchedAt.Equal(last.FetchedAt)
+			if dir.Error == "" && fetched {
+				e.Kind = eventDirectionFetched
+				out = append(out, e)
+			}
+
+			switch {
+			case dir.Error != "" && fetched:
+				e.Kind = EventFetchFailed
+				out = append(out, e)
+			case dir.Error == "" && dir.Revision > from:
+				e.Kind = EventDirectionUpdated
+				out = append(out, e)
+			}
+
+			key := fmt.Sprintf("%d\x00%d\x00%s", i, j, dir.StopID)
+			seen[key] = true
+			if dir.Error != "" || !fetched {
+				continue
+			}
+			level := served.QualityLevel + "\x00" + served.QualityWarning
+			b.mu.Lock()
+			was, known := b.quality[key]
+			b.quality[key] = level
+			b.mu.Unlock()
+			if known && was != level {
+				e.Kind = EventQualityChanged
+				out = append(out, e)
+			}
+		}
+	}
+
+	b.mu.Lock()
+	for key := range b.quality {
+		if !seen[key] {
+			delete(b.quality, key)
+		}
+	}
+	handlers := b.handlers
+	b.mu.Unlock()
+
+	for _, e := range out {
+		if e.Kind != eventDirectionFetched {
+			b.publish(e)
+		}
+		for _, handle := range handlers {
+			handle(e)
+		}
+	}
+}
+
+// sseHeartbeat keeps idle event streams from being closed by proxies
+const sseHeartbeat = 25 * time.Second
+
+// maxEventStreams bounds the open /api/events streams, each of which holds
+// a connection and a buffer of events
+const maxEventStreams = 100
+
+// handleEvents streams cache events as server-sent events. ?kinds= limits
+// the stream to a comma-separated list of event kinds.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	var kinds []string
+	if v := r.URL.Query().Get("kinds"); v != "" {
+		for _, k := range strings.Split(v, ",") {
+			if !slices.Contains(eventKinds, k) {
+				http.Error(w, fmt.Sprintf("unknown event kind %q; available: %s", k, strings.Join(eventKinds, ", ")), http.StatusBadRequest)
+				return
+			}
+			kinds = append(kinds, k)
+		}
+	}
+
+	ch, cancel, err := events.subscribeUpTo(maxEventStreams, kinds...)
+	if err != nil {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				errorf("Encoding %s event: %v", e.Kind, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\nid: %d\ndata: %s\n\n", e.Kind, e.Revision, data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
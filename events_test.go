@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	visits := func(times ...string) string {
+		var parts []string
+		for _, at := range times {
+			parts = append(parts, `{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"`+at+`"}}}`)
+		}
+		return `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[` + strings.Join(parts, ",") + `]}}}`
+	}
+	_, ft := withTestEnv(t, time.Date(2026, 3, 15, 20, 0, 0, 0, time.UTC), visits("2026-03-15T20:10:00Z", "2026-03-15T20:20:00Z"))
+
+	all, cancel := events.subscribe()
+	defer cancel()
+	failures, cancelFailures := events.subscribe(EventFetchFailed)
+	defer cancelFailures()
+
+	next := func(ch <-chan Event) []string {
+		var kinds []string
+		for {
+			select {
+			case e := <-ch:
+				kinds = append(kinds, e.Kind)
+			default:
+				return kinds
+			}
+		}
+	}
+
+	refreshCache()
+	if got := next(all); !slices.Equal(got, []string{EventDirectionUpdated}) {
+		t.Errorf("events after first refresh = %v", got)
+	}
+
+	// The same arrivals fetched again aren't news
+	refreshCache()
+	if got := next(all); len(got) != 0 {
+		t.Errorf("events after an unchanged refresh = %v", got)
+	}
+
+	// A large gap changes the arrivals and the quality warning
+	ft.body = visits("2026-03-15T20:10:00Z", "2026-03-15T21:10:00Z")
+	refreshCache()
+	if got := next(all); !slices.Equal(got, []string{EventDirectionUpdated, EventQualityChanged}) {
+		t.Errorf("events after a gap appeared = %v", got)
+	}
+
+	// A failed fetch only reaches subscribers that asked for failures...
+	notFound := make([]int, 2*eventBufferSize)
+	for i := range notFound {
+		notFound[i] = http.StatusNotFound
+	}
+	upstreamTransport = &sequenceTransport{statuses: notFound}
+	refreshCache()
+	if got := next(all); !slices.Equal(got, []string{EventFetchFailed}) {
+		t.Errorf("events after a failed fetch = %v", got)
+	}
+	if got := next(failures); !slices.Equal(got, []string{EventFetchFailed}) {
+		t.Errorf("failure events = %v", got)
+	}
+
+	// ...and a subscriber that stops reading loses events rather than
+	// holding up the cache
+	for i := 0; i < eventBufferSize+5; i++ {
+		refreshCache()
+	}
+	if got := len(next(failures)); got != eventBufferSize {
+		t.Errorf("buffered events = %d, want %d", got, eventBufferSize)
+	}
+	cancel()
+	cancelFailures()
+
+	// /api/events streams them as server-sent events
+	srv := httptest.NewServer(http.HandlerFunc(handleEvents))
+	defer srv.Close()
+	if resp, err := http.Get(srv.URL + "?kinds=arrived"); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown kind = %v, %v", resp, err)
+	}
+	// Streams past the limit are turned away
+	var open []func()
+	for i := 0; i < maxEventStreams; i++ {
+		_, cancel := events.subscribe()
+		open = append(open, cancel)
+	}
+	if resp, err := http.Get(srv.URL); err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("stream past the limit = %v, %v", resp, err)
+	}
+	for _, cancel := range open {
+		cancel()
+	}
+	resp, err := http.Get(srv.URL + "?kinds=fetch_failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	refreshCache()
+	lines := bufio.NewScanner(resp.Body)
+	var got []string
+	for len(got) < 3 && lines.Scan() {
+		got = append(got, lines.Text())
+	}
+	if len(got) < 3 || got[0] != "event: fetch_failed" || !strings.HasPrefix(got[2], "data: ") {
+		t.Fatalf("stream = %q", got)
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(got[2], "data: ")), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Stop != "Embarcadero" || e.Direction.StopID != "16994" || e.Direction.Error == "" {
+		t.Errorf("event = %+v", e)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ExecProviderConfig runs an external program as the provider, so a city
+// can be supported in any language without rebuilding the server
+type ExecProviderConfig struct {
+	// Program and arguments, e.g. ["python3", "/opt/tracker/portland.py"]
+	Command []string `yaml:"command,omitempty"`
+	// Seconds a fetch may take (default 10)
+	Timeout int `yaml:"timeout,omitempty"`
+}
+
+// maxExecOutput bounds what an exec provider may print for one fetch
+const maxExecOutput = 1 << 20
+
+// execRequest is written to the program's stdin, one per fetch
+type execRequest struct {
+	Agency string    `json:"agency"`
+	StopID string    `json:"stop_id"`
+	Time   time.Time `json:"time"`
+}
+
+// execArrival is one arrival in the program's answer
+type execArrival struct {
+	// RFC3339
+	ArrivalTime  string `json:"arrival_time"`
+	Destination  string `json:"destination"`
+	Line         string `json:"line,omitempty"`
+	Realtime     bool   `json:"realtime,omitempty"`
+	Wheelchair   *bool  `json:"wheelchair_accessible,omitempty"`
+	Bikes        *bool  `json:"bikes_allowed,omitempty"`
+	TripID       string `json:"trip_id,omitempty"`
+	DirectionRef string `json:"direction_ref,omitempty"`
+}
+
+// execResponse is what the program prints on stdout
+type execResponse struct {
+	Arrivals []execArrival `json:"arrivals"`
+	// Set instead of arrivals when the fetch failed
+	Error string `json:"error,omitempty"`
+}
+
+func validateExecProvider(config *Config) error {
+	e := &config.Exec
+	if len(e.Command) == 0 || e.Command[0] == "" {
+		return fmt.Errorf("exec.command is required for the exec provider")
+	}
+	if e.Timeout < 0 {
+		return fmt.Errorf("exec.timeout cannot be negative")
+	}
+	if e.Timeout == 0 {
+		e.Timeout = 10
+	}
+	return nil
+}
+
+// errExecConfig refuses exec settings from the admin API and remote
+// config. They name a program the server runs, so, like include, they can
+// only be set in config.yaml itself.
+var errExecConfig = fmt.Errorf("provider: exec and exec.command can only be set in config.yaml itself")
+
+// checkExecUnchanged rejects a config from outside config.yaml that
+// switches to the exec provider or changes its program. Switching away
+// from exec is fine.
+func checkExecUnchanged(cfg, old *Config) error {
+	if !slices.Equal(cfg.Exec.Command, old.Exec.Command) || (cfg.Provider == "exec" && old.Provider != "exec") {
+		return errExecConfig
+	}
+	return nil
+}
+
+// limitedBuffer keeps the first max bytes written to it and fails after
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, errors.New("output too large")
+	}
+	return b.Buffer.Write(p)
+}
+
+// execStopArrivals runs the configured program once for a stop. It gets
+// an execRequest as JSON on stdin and answers with an execResponse on
+// stdout; a non-zero exit is a failed fetch, reported with its stderr.
+func execStopArrivals(ctx context.Context, agency, stopID string) ([]Arrival, error) {
+	cfg := currentConfig().Exec
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
+	defer cancel()
+
+	req, err := json.Marshal(execRequest{Agency: agency, StopID: stopID, Time: clock.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+	stdout := &limitedBuffer{max: maxExecOutput}
+	stderr := &limitedBuffer{max: 4 << 10}
+	cmd := exec.CommandContext(ctx, cfg.Command[0], cfg.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("exec provider timed out after %ds", cfg.Timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("exec provider: %v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("exec provider: %v", err)
+	}
+
+	var resp execResponse
+	dec := json.NewDecoder(io.LimitReader(&stdout.Buffer, maxExecOutput))
+	if err := dec.Decode(&resp); err != nil {
+		return nil, fmt.Errorf("exec provider: invalid response: %v", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("exec provider: %s", resp.Error)
+	}
+
+	arrivals := make([]Arrival, 0, len(resp.Arrivals))
+	for _, a := range resp.Arrivals {
+		t, err := time.Parse(time.RFC3339, a.ArrivalTime)
+		if err != nil {
+			return nil, fmt.Errorf("exec provider: arrival_time %q is not RFC3339", a.ArrivalTime)
+		}
+		arrivals = append(arrivals, Arrival{
+			ArrivalTime:  t.Format(time.RFC3339),
+			Destination:  a.Destination,
+			LineType:     a.Line,
+			Realtime:     a.Realtime,
+			Wheelchair:   a.Wheelchair,
+			Bikes:        a.Bikes,
+			TripID:       a.TripID,
+			DirectionRef: a.DirectionRef,
+		})
+	}
+	return arrivals, nil
+}
+
+func init() {
+	registerProvider("exec", ProviderSpec{
+		Provider: ProviderFunc(execStopArrivals),
+		Validate: validateExecProvider,
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// parseFields reads ?fields=minutes,destination into a set, checking each
+// name against the JSON fields of v's type. It returns nil when the
+// request doesn't select fields.
+func parseFields(r *http.Request, v interface{}) (map[string]bool, error) {
+	raw := r.URL.Query().Get("fields")
+	if raw == "" {
+		return nil, nil
+	}
+	known := make(map[string]bool)
+	jsonFieldNames(reflect.TypeOf(v), known, make(map[reflect.Type]bool))
+
+	fields := make(map[string]bool)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields[name] = true
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("fields is empty")
+	}
+	return fields, nil
+}
+
+// jsonFieldNames collects the JSON names of every field reachable from t
+func jsonFieldNames(t reflect.Type, into map[string]bool, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			jsonFieldNames(f.Type, into, seen)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		into[name] = true
+		jsonFieldNames(f.Type, into, seen)
+	}
+}
+
+// selectFields trims a decoded JSON document to the selected fields.
+// Objects and arrays leading to a selected field are kept around it, so
+// fields=minutes still gives stops[].directions[].arrivals[].minutes.
+// Array elements left with nothing stay as {} to keep their positions,
+// and an array left with nothing at all is dropped.
+func selectFields(v interface{}, fields map[string]bool) (interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for k, val := range v {
+			if fields[k] {
+				out[k] = val
+			} else if kept, ok := selectFields(val, fields); ok {
+				out[k] = kept
+			}
+		}
+		return out, len(out) > 0
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		found := false
+		for i, elem := range v {
+			kept, ok := selectFields(elem, fields)
+			if !ok {
+				kept = map[string]interface{}{}
+			}
+			out[i] = kept
+			found = found || ok
+		}
+		return out, found
+	}
+	return nil, false
+}
+
+// fieldsJSON encodes v with only the selected fields
+func fieldsJSON(v interface{}, fields map[string]bool) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	kept, _ := selectFields(doc, fields)
+	if kept == nil {
+		kept = map[string]interface{}{}
+	}
+	return kept, nil
+}
+
+// selectColumns returns the indexes of the CSV columns named in fields,
+// or of every column when fields is nil
+func selectColumns(fields map[string]bool) []int {
+	var cols []int
+	for i, name := range csvColumns {
+		if fields == nil || fields[name] {
+			cols = append(cols, i)
+		}
+	}
+	return cols
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFieldSelection(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 3, 6, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-03-06T20:05:00Z"}}}
+	]}}}`)
+	refreshCache()
+
+	get := func(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	rec := get(handleArrivals, "/api/arrivals?fields=minutes,destination")
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("arrivals: %v %s", err, rec.Body)
+	}
+	if _, ok := doc["last_updated"]; ok || len(doc) != 1 {
+		t.Errorf("top level = %v", doc)
+	}
+	dir := doc["stops"].([]interface{})[0].(map[string]interface{})["directions"].([]interface{})[0].(map[string]interface{})
+	arrival := dir["arrivals"].([]interface{})[0].(map[string]interface{})
+	if len(dir) != 1 || len(arrival) != 2 || arrival["destination"] != "Ocean Beach" || arrival["minutes"] == nil {
+		t.Errorf("direction = %v", dir)
+	}
+
+	rec = get(handleNext, "/api/next?fields=minutes,line")
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"departures":[{"line":"N Judah","minutes":4}]}` {
+		t.Errorf("next = %s", body)
+	}
+
+	rec = get(handleNext, "/api/next?format=csv&fields=destination,minutes")
+	if rows, err := csv.NewReader(rec.Body).ReadAll(); err != nil || len(rows) != 2 || strings.Join(rows[0], ",") != "destination,minutes" || rows[1][1] != "4" {
+		t.Errorf("csv = %v %v", rows, err)
+	}
+
+	for _, path := range []string{"/api/arrivals?fields=minutes,colour", "/api/arrivals?fields=,", "/api/arrivals?format=csv&fields=quality_level"} {
+		if rec := get(handleArrivals, path); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"muni-tracker/pkg/go511"
+)
+
+// Record/replay of raw 511 responses for offline development. With
+// `record: true` every successful StopMonitoring body is saved to
+// fixtures_dir; `provider: replay` serves those files back with arrival
+// times shifted so they appear as upcoming as when they were recorded.
+
+func fixturePath(agency, stopID string) string {
+	return filepath.Join(currentConfig().FixturesDir, fmt.Sprintf("%s_%s.json", agency, stopID))
+}
+
+// recordFixture saves the latest raw response for a stop
+func recordFixture(agency, stopID string, body []byte) error {
+	if err := os.MkdirAll(currentConfig().FixturesDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(fixturePath(agency, stopID), body, 0644)
+}
+
+// replayStopArrivals loads a recorded response and shifts its timestamps
+// so the recording time maps to now
+func replayStopArrivals(agency, stopID string) ([]Arrival, error) {
+	path := fixturePath(agency, stopID)
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no fixture for stop %s: %w", stopID, err)
+	}
+
+	apiResp, err := go511.ParseStopMonitoring(body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer the response's own timestamp; fall back to the file time
+	recordedAt, err := time.Parse(time.RFC3339, apiResp.ServiceDelivery.ResponseTimestamp)
+	if err != nil {
+		fi, statErr := os.Stat(path)
+		if statErr != nil {
+			return nil, statErr
+		}
+		recordedAt = fi.ModTime()
+	}
+
+	shift := clock.Now().Sub(recordedAt)
+	arrivals := arrivalsFromResponse(apiResp)
+	for i, a := range arrivals {
+		t, err := time.Parse(time.RFC3339, a.ArrivalTime)
+		if err != nil {
+			continue
+		}
+		arrivals[i].ArrivalTime = t.Add(shift).Format(time.RFC3339)
+	}
+
+	return arrivals, nil
+}
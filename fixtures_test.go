@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReplayFixture(t *testing.T) {
+	now := time.Date(2026, 3, 12, 20, 0, 0, 0, time.UTC)
+	withTestEnv(t, now, "")
+	cfg := *currentConfig()
+	cfg.FixturesDir = t.TempDir()
+	activeConfig.Store(&cfg)
+
+	visit := `{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2025-06-01T08:05:30Z"}}}`
+	arrivalAt := func(stopID string) time.Time {
+		t.Helper()
+		arrivals, err := replayStopArrivals("SF", stopID)
+		if err != nil {
+			t.Fatalf("replayStopArrivals(%s): %v", stopID, err)
+		}
+		if len(arrivals) != 1 {
+			t.Fatalf("replayed %d arrivals", len(arrivals))
+		}
+		at, err := time.Parse(time.RFC3339, arrivals[0].ArrivalTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+
+	// Arrivals keep their lead over the response's own timestamp
+	recorded := `{"ServiceDelivery":{"ResponseTimestamp":"2025-06-01T08:00:00Z","StopMonitoringDelivery":{"MonitoredStopVisit":[` + visit + `]}}}`
+	if err := recordFixture("SF", "16994", []byte(recorded)); err != nil {
+		t.Fatalf("recordFixture: %v", err)
+	}
+	if got, want := arrivalAt("16994"), now.Add(5*time.Minute+30*time.Second); !got.Equal(want) {
+		t.Errorf("replayed arrival = %v, want %v", got, want)
+	}
+
+	// Without a timestamp, the file's modification time is the recording time
+	untimed := `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[` + visit + `]}}}`
+	if err := recordFixture("SF", "15731", []byte(untimed)); err != nil {
+		t.Fatalf("recordFixture: %v", err)
+	}
+	mtime := time.Date(2025, 6, 1, 8, 4, 0, 0, time.UTC)
+	if err := os.Chtimes(fixturePath("SF", "15731"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := arrivalAt("15731"), now.Add(90*time.Second); !got.Equal(want) {
+		t.Errorf("replayed arrival by file time = %v, want %v", got, want)
+	}
+
+	if _, err := replayStopArrivals("SF", "99999"); err == nil {
+		t.Error("replayed a stop with no fixture")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// flightGroup runs one load per key at a time. Callers asking for a key
+// that is already loading wait for that load and share its result, so a
+// burst of requests for the same feed downloads it once.
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flight[T]
+}
+
+type flight[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// do runs load for key, or waits for the run already in progress
+func (g *flightGroup[T]) do(key string, load func() (T, error)) (T, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight[T])
+	}
+	if f, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-f.done
+		return f.value, f.err
+	}
+	f := &flight[T]{done: make(chan struct{})}
+	g.calls[key] = f
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+	f.value, f.err = load()
+	return f.value, f.err
+}
+
+// Bounds of the wait before retrying a failed download
+const (
+	minFailureBackoff = time.Minute
+	maxFailureBackoff = time.Hour
+)
+
+// failureBackoff remembers failed downloads by key, so a feed that is
+// missing or broken upstream isn't retried on every request. The wait
+// doubles with each failure in a row.
+type failureBackoff struct {
+	mu     sync.Mutex
+	failed map[string]failedLoad
+}
+
+type failedLoad struct {
+	err   error
+	wait  time.Duration
+	until time.Time
+}
+
+// check returns the last error for key while its backoff lasts
+func (b *failureBackoff) check(key string, now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f, ok := b.failed[key]; ok && now.Before(f.until) {
+		return f.err
+	}
+	return nil
+}
+
+// fail records a failed load of key
+func (b *failureBackoff) fail(key string, now time.Time, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failed == nil {
+		b.failed = make(map[string]failedLoad)
+	}
+	wait := minFailureBackoff
+	if f, ok := b.failed[key]; ok {
+		wait = min(2*f.wait, maxFailureBackoff)
+	}
+	b.failed[key] = failedLoad{err: err, wait: wait, until: now.Add(wait)}
+}
+
+// succeed forgets key's failures
+func (b *failureBackoff) succeed(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failed, key)
+}
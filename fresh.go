@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// freshMinAge is how recently a direction must have been fetched for
+// ?fresh=1 to serve it from the cache anyway, so a crowd of riders
+// checking at once costs one upstream request rather than one each
+const freshMinAge = 30 * time.Second
+
+// freshMu serializes on-demand fetches, so the second of two requests
+// sees the first one's result as recent
+var freshMu sync.Mutex
+
+// parseFresh reads the optional ?fresh= flag
+func parseFresh(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("fresh")
+	if v == "" {
+		return false, nil
+	}
+	fresh, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("fresh must be 1 or 0")
+	}
+	return fresh, nil
+}
+
+// freshRefs lists the directions a ?fresh=1 request wants refetched:
+// those matching ?stop= and ?direction=, narrowed to the request's
+// favorites when it names a profile
+func freshRefs(r *http.Request) ([]directionRef, error) {
+	q := r.URL.Query()
+	refs := selectDirections(q.Get("stop"), q.Get("direction"))
+	favorites, err := requestFavorites(r)
+	if err != nil || favorites == nil {
+		return refs, err
+	}
+	cfg := currentConfig()
+	var kept []directionRef
+	for _, ref := range refs {
+		if favorites[cfg.Stops[ref.stop].Directions[ref.dir].StopID] {
+			kept = append(kept, ref)
+		}
+	}
+	return kept, nil
+}
+
+// staleRefs drops the directions fetched within freshMinAge
+func staleRefs(refs []directionRef, now time.Time) []directionRef {
+	cache.mu.RLock()
+	data := cache.data
+	cache.mu.RUnlock()
+
+	cfg := currentConfig()
+	var stale []directionRef
+	for _, ref := range refs {
+		cached, ok := previousDirection(data, ref.stop, ref.dir, cfg.Stops[ref.stop].Directions[ref.dir].StopID)
+		if !ok || now.Sub(cached.FetchedAt) >= freshMinAge {
+			stale = append(stale, ref)
+		}
+	}
+	return stale
+}
+
+// freshen fetches the directions a ?fresh=1 request asks for before it is
+// answered from the cache. The fetch only goes ahead when the hourly
+// quota would still cover a full scheduled refresh afterwards; otherwise
+// the cached data is served as usual, as it is on an instance following
+// a shared cache's leader. X-Fresh tells the client which happened:
+// fetched, recent, quota, or follower. It reports false after writing an
+// error response.
+func freshen(w http.ResponseWriter, r *http.Request) bool {
+	fresh, err := parseFresh(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if !fresh {
+		return true
+	}
+	refs, err := freshRefs(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return false
+	}
+	if len(refs) == 0 {
+		http.Error(w, "no matching stop or direction", http.StatusNotFound)
+		return false
+	}
+
+	freshMu.Lock()
+	defer freshMu.Unlock()
+
+	now := clock.Now()
+	refs = staleRefs(refs, now)
+	switch {
+	case len(refs) == 0:
+		w.Header().Set("X-Fresh", "recent")
+	case !leading():
+		w.Header().Set("X-Fresh", "follower")
+	default:
+		// The fetch finishes even if the caller hangs up, so the next
+		// request benefits from it
+		if _, wait := refreshDirections(outliveRequest(r), refs, totalRequests()); wait > 0 {
+			infof("On-demand fetch of %d directions refused: quota reserved for scheduled refreshes", len(refs))
+			w.Header().Set("X-Fresh", "quota")
+			break
+		}
+		infof("On-demand fetch of %d directions", len(refs))
+		w.Header().Set("X-Fresh", "fetched")
+	}
+	return true
+}
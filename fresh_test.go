@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFreshFetch(t *testing.T) {
+	fc, ft := withTestEnv(t, time.Date(2026, 3, 10, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-03-10T20:05:00Z"}}}
+	]}}}`)
+	refreshCache()
+
+	get := func(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	fc.Sleep(10 * time.Second)
+	before := ft.requests
+	if rec := get(handleArrivals, "/api/arrivals?fresh=1"); rec.Code != http.StatusOK || rec.Header().Get("X-Fresh") != "recent" || ft.requests != before {
+		t.Errorf("recent fetch = %d %q, %d requests", rec.Code, rec.Header().Get("X-Fresh"), ft.requests-before)
+	}
+
+	fc.Sleep(time.Minute)
+	rec := get(handleNext, "/api/next?fresh=1&direction=16994")
+	if age, _ := strconv.Atoi(rec.Header().Get("X-Data-Age")); rec.Header().Get("X-Fresh") != "fetched" || ft.requests != before+1 || age > 5 {
+		t.Errorf("fresh fetch = %q, %d requests, age %q", rec.Header().Get("X-Fresh"), ft.requests-before, rec.Header().Get("X-Data-Age"))
+	}
+
+	// The fetch must leave enough quota for a scheduled refresh
+	fc.Sleep(time.Minute)
+	cfg := *currentConfig()
+	quota.remaining(clock.Now())
+	cfg.UpstreamHourlyLimit = len(quota.calls) + 1
+	activeConfig.Store(&cfg)
+	if rec := get(handleArrivals, "/api/arrivals?fresh=1"); rec.Code != http.StatusOK || rec.Header().Get("X-Fresh") != "quota" || ft.requests != before+1 {
+		t.Errorf("over quota = %d %q", rec.Code, rec.Header().Get("X-Fresh"))
+	}
+
+	for path, want := range map[string]int{
+		"/api/arrivals?fresh=maybe":               http.StatusBadRequest,
+		"/api/arrivals?fresh=1&direction=nowhere": http.StatusNotFound,
+	} {
+		if rec := get(handleArrivals, path); rec.Code != want {
+			t.Errorf("%s = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+// cancelTransport cancels a context once it has answered a request
+type cancelTransport struct {
+	*fakeTransport
+	cancel context.CancelFunc
+}
+
+func (t cancelTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	defer t.cancel()
+	return t.fakeTransport.RoundTrip(r)
+}
+
+func TestRefreshDirectionsCancelled(t *testing.T) {
+	fc, ft := withTestEnv(t, time.Date(2026, 3, 10, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`)
+	cfg, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: Embarcadero
+    directions: [{label: Inbound, stop_id: "16994"}, {label: Outbound, stop_id: "15731"}, {label: Castro, stop_id: "13300"}]
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+	refreshCache()
+
+	// A client that goes away mid-refresh neither waits out the delay
+	// between requests nor makes the rest of them
+	ctx, cancel := context.WithCancel(context.Background())
+	upstreamTransport = cancelTransport{ft, cancel}
+	before, start := ft.requests, fc.now
+	refreshDirections(ctx, []directionRef{{0, 0}, {0, 1}}, 0)
+	if ft.requests != before+1 || fc.now.Sub(start) >= upstreamDelay {
+		t.Errorf("cancelled refresh made %d requests and waited %v", ft.requests-before, fc.now.Sub(start))
+	}
+}
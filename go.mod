@@ -2,4 +2,10 @@ module muni-tracker
 
 go 1.21
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	golang.org/x/crypto v0.21.0
+	golang.org/x/net v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/text v0.14.0 // indirect
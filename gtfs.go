@@ -0,0 +1,452 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"muni-tracker/pkg/go511"
+)
+
+// GTFSConfig controls the locally cached GTFS feeds used for stop lookups
+type GTFSConfig struct {
+	// Directory holding downloaded feeds as <agency>.zip (default "gtfs")
+	Dir string `yaml:"dir,omitempty"`
+	// Days before a cached feed is downloaded again (default 7)
+	MaxAgeDays int `yaml:"max_age_days,omitempty"`
+	// Flag wheelchair and bike access on arrivals from the feed. This loads
+	// the feed for every configured agency.
+	Accessibility bool `yaml:"accessibility,omitempty"`
+	// Skip fetching and report no service on days the feed's calendar
+	// runs nothing at a stop. This loads the feed for every configured
+	// agency.
+	Calendar bool `yaml:"calendar,omitempty"`
+}
+
+func validateGTFSConfig(g *GTFSConfig) {
+	if g.Dir == "" {
+		g.Dir = "gtfs"
+	}
+	if g.MaxAgeDays <= 0 {
+		g.MaxAgeDays = 7
+	}
+}
+
+// gtfsStop is one stop from an agency's feed
+type gtfsStop struct {
+	ID    string
+	Code  string
+	Name  string
+	Lat   float64
+	Lon   float64
+	Lines []string
+	// Trip headsigns seen at the stop, as direction hints
+	Headsigns []string
+	// Wheelchair boarding, nil when the feed doesn't say
+	Wheelchair *bool
+	// service_ids of the trips calling at the stop
+	Services []string
+}
+
+// gtfsIndex is the parsed subset of a feed that lookups need
+type gtfsIndex struct {
+	Agency string
+	Stops  []gtfsStop
+	Shapes map[string][]RouteShape
+	// Accessibility by trip_id, only for trips the feed flags
+	Trips map[string]tripAccess
+	// Service calendars by service_id; nil when the feed has none
+	Services map[string]*gtfsService
+	LoadedAt time.Time
+}
+
+var gtfsIndexes = struct {
+	sync.Mutex
+	byAgency map[string]*gtfsIndex
+}{byAgency: make(map[string]*gtfsIndex)}
+
+var (
+	// gtfsLoads makes concurrent lookups for an agency share one load,
+	// while other agencies' indexes stay readable
+	gtfsLoads flightGroup[*gtfsIndex]
+	// gtfsFailures holds off retrying feeds that failed to load
+	gtfsFailures failureBackoff
+)
+
+// agencyIndex returns the stop index for an agency, loading or refreshing
+// the feed when needed. Only configured and known agencies are loaded, so
+// a request can't have the server download whatever feed it names.
+func agencyIndex(agency string) (*gtfsIndex, error) {
+	agency, err := allowedAgency(agency)
+	if err != nil {
+		return nil, err
+	}
+	maxAge := time.Duration(currentConfig().GTFS.MaxAgeDays) * 24 * time.Hour
+
+	gtfsIndexes.Lock()
+	idx, ok := gtfsIndexes.byAgency[agency]
+	gtfsIndexes.Unlock()
+	if ok && clock.Now().Sub(idx.LoadedAt) < maxAge {
+		return idx, nil
+	}
+
+	return gtfsLoads.do(agency, func() (*gtfsIndex, error) {
+		if err := gtfsFailures.check(agency, clock.Now()); err != nil {
+			return nil, err
+		}
+		idx, err := loadGTFS(agency, maxAge)
+		if err != nil {
+			gtfsFailures.fail(agency, clock.Now(), err)
+			return nil, err
+		}
+		gtfsFailures.succeed(agency)
+
+		gtfsIndexes.Lock()
+		gtfsIndexes.byAgency[agency] = idx
+		gtfsIndexes.Unlock()
+		infof("Loaded GTFS for %s: %d stops", agency, len(idx.Stops))
+		return idx, nil
+	})
+}
+
+// loadGTFS reads and indexes an agency's feed
+func loadGTFS(agency string, maxAge time.Duration) (*gtfsIndex, error) {
+	data, err := gtfsFeed(agency, maxAge)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := parseGTFS(agency, data)
+	if err != nil {
+		return nil, fmt.Errorf("GTFS feed for %s: %w", agency, err)
+	}
+	return idx, nil
+}
+
+// findStop returns the stop with the given code, or nil
+func (idx *gtfsIndex) findStop(code string) *gtfsStop {
+	for i := range idx.Stops {
+		if idx.Stops[i].Code == code {
+			return &idx.Stops[i]
+		}
+	}
+	return nil
+}
+
+// findStopID returns the stop with the given GTFS stop_id, or nil. Some
+// agencies' feeds leave stop_code empty and 511 takes the stop_id instead.
+func (idx *gtfsIndex) findStopID(id string) *gtfsStop {
+	for i := range idx.Stops {
+		if idx.Stops[i].ID == id {
+			return &idx.Stops[i]
+		}
+	}
+	return nil
+}
+
+// gtfsFeed returns the zipped feed from the cache directory, downloading
+// it from 511 when missing or stale. Offline providers only use the cache.
+func gtfsFeed(agency string, maxAge time.Duration) ([]byte, error) {
+	config := currentConfig()
+	path := filepath.Join(config.GTFS.Dir, filepath.Base(agency)+".zip")
+
+	// A shared cache's followers use the feeds the leader downloads, so
+	// gtfs.dir belongs on the shared storage too
+	download := config.Provider == "511" && leading()
+	info, statErr := os.Stat(path)
+	if statErr == nil && (clock.Now().Sub(info.ModTime()) < maxAge || !download) {
+		return os.ReadFile(path)
+	}
+	if !download {
+		return nil, fmt.Errorf("no cached GTFS feed at %s", path)
+	}
+
+	if wait := quota.take(clock.Now()); wait > 0 {
+		err := fmt.Errorf("upstream quota exhausted for %v", wait.Round(time.Second))
+		if statErr == nil {
+			warnf("Not refreshing GTFS for %s, using cached copy: %v", agency, err)
+			return os.ReadFile(path)
+		}
+		return nil, err
+	}
+	client := go511.NewClient(config.APIKey)
+	client.HTTPClient = upstreamClient()
+	client.HTTPClient.Timeout = 2 * time.Minute // feeds are large
+
+	data, err := client.Datafeed(serverCtx, agency)
+	if err != nil {
+		// Fall back to a stale copy rather than failing outright
+		if statErr == nil {
+			warnf("Failed to refresh GTFS for %s, using cached copy: %v", agency, err)
+			return os.ReadFile(path)
+		}
+		return nil, err
+	}
+
+	if err := os.MkdirAll(config.GTFS.Dir, 0755); err != nil {
+		warnf("Failed to cache GTFS for %s: %v", agency, err)
+	} else if err := os.WriteFile(path, data, 0644); err != nil {
+		warnf("Failed to cache GTFS for %s: %v", agency, err)
+	}
+	return data, nil
+}
+
+// parseGTFS reads stops, the lines serving each stop, and line shapes from
+// a feed
+func parseGTFS(agency string, data []byte) (*gtfsIndex, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	// route_id -> display name
+	routes := make(map[string]string)
+	err = readGTFSFile(zr, "routes.txt", func(row map[string]string) {
+		name := row["route_short_name"]
+		if name == "" {
+			name = row["route_long_name"]
+		}
+		routes[row["route_id"]] = name
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	trips := make(map[string]gtfsTrip)
+	err = readGTFSFile(zr, "trips.txt", func(row map[string]string) {
+		trips[row["trip_id"]] = gtfsTrip{
+			route:     row["route_id"],
+			service:   row["service_id"],
+			headsign:  row["trip_headsign"],
+			shape:     row["shape_id"],
+			direction: row["direction_id"],
+			access: tripAccess{
+				Wheelchair: gtfsFlag(row["wheelchair_accessible"]),
+				Bikes:      gtfsFlag(row["bikes_allowed"]),
+			},
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// stop_id -> sets of line names, headsigns and service_ids
+	stopLines := make(map[string]map[string]bool)
+	stopHeadsigns := make(map[string]map[string]bool)
+	stopServices := make(map[string]map[string]bool)
+	err = readGTFSFile(zr, "stop_times.txt", func(row map[string]string) {
+		trip := trips[row["trip_id"]]
+		line, ok := routes[trip.route]
+		if !ok {
+			return
+		}
+		addToSet(stopLines, row["stop_id"], line)
+		if trip.service != "" {
+			addToSet(stopServices, row["stop_id"], trip.service)
+		}
+		if trip.headsign != "" {
+			addToSet(stopHeadsigns, row["stop_id"], trip.headsign)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &gtfsIndex{Agency: agency, Trips: make(map[string]tripAccess), LoadedAt: clock.Now()}
+	for id, trip := range trips {
+		if trip.access != (tripAccess{}) {
+			idx.Trips[id] = trip.access
+		}
+	}
+
+	err = readGTFSFile(zr, "stops.txt", func(row map[string]string) {
+		lat, err1 := strconv.ParseFloat(row["stop_lat"], 64)
+		lon, err2 := strconv.ParseFloat(row["stop_lon"], 64)
+		if err1 != nil || err2 != nil {
+			return
+		}
+		stop := gtfsStop{
+			ID:         row["stop_id"],
+			Code:       row["stop_code"],
+			Name:       row["stop_name"],
+			Lat:        lat,
+			Lon:        lon,
+			Wheelchair: gtfsFlag(row["wheelchair_boarding"]),
+		}
+		if stop.Code == "" {
+			stop.Code = stop.ID
+		}
+		stop.Lines = sortedSet(stopLines[stop.ID])
+		stop.Headsigns = sortedSet(stopHeadsigns[stop.ID])
+		stop.Services = sortedSet(stopServices[stop.ID])
+		idx.Stops = append(idx.Stops, stop)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// shapes.txt is optional in GTFS
+	if idx.Shapes, err = parseShapes(zr, routes, trips); err != nil {
+		return nil, err
+	}
+	if idx.Services, err = parseServices(zr); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+type gtfsTrip struct {
+	route, service, headsign, shape, direction string
+	access                                     tripAccess
+}
+
+// RouteShape is the path one direction of a line follows
+type RouteShape struct {
+	Direction string `json:"direction"`
+	Headsign  string `json:"headsign,omitempty"`
+	// [lon, lat] pairs in travel order
+	Points [][2]float64 `json:"points"`
+}
+
+// parseShapes picks the most used shape for each line and direction and
+// loads its points. Lines are keyed by upper-cased name.
+func parseShapes(zr *zip.Reader, routes map[string]string, trips map[string]gtfsTrip) (map[string][]RouteShape, error) {
+	type key struct{ line, direction string }
+	counts := make(map[key]map[string]int)
+	headsigns := make(map[string]string)
+	for _, trip := range trips {
+		line, ok := routes[trip.route]
+		if !ok || trip.shape == "" {
+			continue
+		}
+		k := key{strings.ToUpper(line), trip.direction}
+		if counts[k] == nil {
+			counts[k] = make(map[string]int)
+		}
+		counts[k][trip.shape]++
+		headsigns[trip.shape] = trip.headsign
+	}
+
+	chosen := make(map[string]key)
+	for k, shapes := range counts {
+		best, bestN := "", 0
+		for shape, n := range shapes {
+			if n > bestN || (n == bestN && shape < best) {
+				best, bestN = shape, n
+			}
+		}
+		chosen[best] = k
+	}
+
+	type point struct {
+		seq      int
+		lon, lat float64
+	}
+	points := make(map[string][]point)
+	err := readGTFSFile(zr, "shapes.txt", func(row map[string]string) {
+		id := row["shape_id"]
+		if _, ok := chosen[id]; !ok {
+			return
+		}
+		seq, err1 := strconv.Atoi(row["shape_pt_sequence"])
+		lat, err2 := strconv.ParseFloat(row["shape_pt_lat"], 64)
+		lon, err3 := strconv.ParseFloat(row["shape_pt_lon"], 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return
+		}
+		points[id] = append(points[id], point{seq, lon, lat})
+	})
+	if err != nil && !errors.Is(err, errMissingGTFSFile) {
+		return nil, err
+	}
+
+	out := make(map[string][]RouteShape)
+	for id, k := range chosen {
+		pts := points[id]
+		if len(pts) == 0 {
+			continue
+		}
+		sort.Slice(pts, func(i, j int) bool { return pts[i].seq < pts[j].seq })
+		shape := RouteShape{Direction: k.direction, Headsign: headsigns[id], Points: make([][2]float64, len(pts))}
+		for i, p := range pts {
+			shape.Points[i] = [2]float64{p.lon, p.lat}
+		}
+		out[k.line] = append(out[k.line], shape)
+	}
+	for _, shapes := range out {
+		sort.Slice(shapes, func(i, j int) bool { return shapes[i].Direction < shapes[j].Direction })
+	}
+	return out, nil
+}
+
+func addToSet(sets map[string]map[string]bool, key, value string) {
+	set := sets[key]
+	if set == nil {
+		set = make(map[string]bool)
+		sets[key] = set
+	}
+	set[value] = true
+}
+
+func sortedSet(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for v := range set {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+var errMissingGTFSFile = errors.New("missing GTFS file")
+
+// readGTFSFile streams a CSV file from the feed, calling fn per row with
+// fields keyed by column name
+func readGTFSFile(zr *zip.Reader, name string, fn func(row map[string]string)) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMissingGTFSFile, name)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	cols := make([]string, len(header))
+	for i, h := range header {
+		cols[i] = strings.TrimSpace(string(go511.StripBOM([]byte(h))))
+	}
+
+	row := make(map[string]string, len(cols))
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for i, col := range cols {
+			if i < len(rec) {
+				row[col] = strings.TrimSpace(rec[i])
+			} else {
+				row[col] = ""
+			}
+		}
+		fn(row)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGTFSDownloads(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+	rt := &recordTransport{status: http.StatusNotFound}
+	upstreamTransport = rt
+	cfg := *currentConfig()
+	cfg.GTFS.Dir = t.TempDir()
+	cfg.UpstreamHourlyLimit = 2
+	activeConfig.Store(&cfg)
+	t.Cleanup(func() {
+		gtfsFailures = failureBackoff{}
+		quota.mu.Lock()
+		quota.calls = nil
+		quota.mu.Unlock()
+	})
+	quota.mu.Lock()
+	quota.calls = nil
+	quota.mu.Unlock()
+
+	// Agencies nobody configured and 511 doesn't run aren't fetched
+	if _, err := agencyIndex("../../etc"); !errors.Is(err, errUnknownAgency) || len(rt.urls) != 0 {
+		t.Errorf("unknown agency: err = %v, %d requests", err, len(rt.urls))
+	}
+	rec := httptest.NewRecorder()
+	handleNearbyStops(rec, httptest.NewRequest("GET", "/api/stops/nearby?lat=37.8&lon=-122.4&agency=nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("nearby for an unknown agency: status %d", rec.Code)
+	}
+
+	// A failed download is remembered until its backoff runs out
+	if _, err := agencyIndex("muni"); err == nil || len(rt.urls) != 1 {
+		t.Fatalf("first load: err = %v, %d requests", err, len(rt.urls))
+	}
+	if _, err := agencyIndex("SF"); err == nil || len(rt.urls) != 1 {
+		t.Errorf("retry within the backoff: err = %v, %d requests", err, len(rt.urls))
+	}
+	fc.now = fc.now.Add(minFailureBackoff)
+	if _, err := agencyIndex("SF"); err == nil || len(rt.urls) != 2 {
+		t.Errorf("retry after the backoff: err = %v, %d requests", err, len(rt.urls))
+	}
+
+	// With the hourly budget spent, nothing is downloaded
+	fc.now = fc.now.Add(2 * minFailureBackoff)
+	if _, err := agencyIndex("SF"); err == nil || !strings.Contains(err.Error(), "quota") || len(rt.urls) != 2 {
+		t.Errorf("over quota: err = %v, %d requests", err, len(rt.urls))
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"time"
+)
+
+// gtfsService is one service_id's calendar: the weekdays it runs between
+// two dates, and the dates added or removed on top of that
+type gtfsService struct {
+	days       [7]bool // by time.Weekday
+	start, end string  // YYYYMMDD, inclusive; empty when only dates apply
+	added      map[string]bool
+	removed    map[string]bool
+}
+
+// activeOn reports whether the service runs on the given YYYYMMDD date
+func (s *gtfsService) activeOn(date string, weekday time.Weekday) bool {
+	if s.removed[date] {
+		return false
+	}
+	if s.added[date] {
+		return true
+	}
+	return s.start != "" && s.start <= date && date <= s.end && s.days[weekday]
+}
+
+// parseServices reads calendar.txt and calendar_dates.txt. Both are
+// optional in GTFS; a feed with neither gives nil.
+func parseServices(zr *zip.Reader) (map[string]*gtfsService, error) {
+	services := make(map[string]*gtfsService)
+	service := func(id string) *gtfsService {
+		s := services[id]
+		if s == nil {
+			s = &gtfsService{added: make(map[string]bool), removed: make(map[string]bool)}
+			services[id] = s
+		}
+		return s
+	}
+
+	columns := [7]string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+	err := readGTFSFile(zr, "calendar.txt", func(row map[string]string) {
+		s := service(row["service_id"])
+		for day, col := range columns {
+			s.days[day] = row[col] == "1"
+		}
+		s.start, s.end = row["start_date"], row["end_date"]
+	})
+	if err != nil && !errors.Is(err, errMissingGTFSFile) {
+		return nil, err
+	}
+
+	err = readGTFSFile(zr, "calendar_dates.txt", func(row map[string]string) {
+		s := service(row["service_id"])
+		switch row["exception_type"] {
+		case "1":
+			s.added[row["date"]] = true
+		case "2":
+			s.removed[row["date"]] = true
+		}
+	})
+	if err != nil && !errors.Is(err, errMissingGTFSFile) {
+		return nil, err
+	}
+
+	if len(services) == 0 {
+		return nil, nil
+	}
+	return services, nil
+}
+
+// runsOn reports whether any trip serving the stops runs on day's date.
+// known is false when the feed can't say: it has no calendar, or none of
+// the stops are in it.
+func (idx *gtfsIndex) runsOn(stopIDs []string, day time.Time) (runs, known bool) {
+	if idx.Services == nil {
+		return false, false
+	}
+	date := day.Format("20060102")
+	for _, id := range stopIDs {
+		stop := idx.findStop(id)
+		if stop == nil {
+			stop = idx.findStopID(id)
+		}
+		if stop == nil {
+			continue
+		}
+		known = true
+		for _, service := range stop.Services {
+			if s := idx.Services[service]; s != nil && s.activeOn(date, day.Weekday()) {
+				return true, true
+			}
+		}
+	}
+	return false, known
+}
+
+// loadedIndex returns an agency's index if it is already loaded, without
+// downloading anything
+func loadedIndex(agency string) *gtfsIndex {
+	agency, err := allowedAgency(agency)
+	if err != nil {
+		return nil
+	}
+	gtfsIndexes.Lock()
+	defer gtfsIndexes.Unlock()
+	return gtfsIndexes.byAgency[agency]
+}
+
+// serviceRunsOn reports whether the direction has service on the service
+// day starting at midnight of day. It's true unless gtfs.calendar is on
+// and the agency's loaded feed shows nothing running at the stop.
+func serviceRunsOn(agency string, dir Direction, day time.Time) bool {
+	if !currentConfig().GTFS.Calendar {
+		return true
+	}
+	if agency == "" {
+		agency = "SF"
+	}
+	idx := loadedIndex(agency)
+	if idx == nil {
+		return true
+	}
+	runs, known := idx.runsOn(dir.allStopIDs(), day)
+	return runs || !known
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestServiceCalendar(t *testing.T) {
+	loc, _ := time.LoadLocation("America/Los_Angeles")
+	withTestEnv(t, time.Date(2026, 1, 29, 20, 0, 0, 0, time.UTC), "")
+
+	// Weekdays only, but not on Jan 19 and also on Saturday Jan 31
+	feed := maps.Clone(testGTFS)
+	feed["calendar.txt"] = "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+		"1,1,1,1,1,1,0,0,20260101,20261231\n"
+	feed["calendar_dates.txt"] = "service_id,date,exception_type\n1,20260119,2\n1,20260131,1\n"
+	idx, err := parseGTFS("SF", gtfsZip(t, feed))
+	if err != nil {
+		t.Fatalf("parseGTFS: %v", err)
+	}
+	gtfsIndexes.Lock()
+	gtfsIndexes.byAgency["SF"] = idx
+	gtfsIndexes.Unlock()
+	t.Cleanup(func() {
+		gtfsIndexes.Lock()
+		delete(gtfsIndexes.byAgency, "SF")
+		gtfsIndexes.Unlock()
+	})
+
+	cfg := *currentConfig()
+	cfg.GTFS.Calendar = true
+	activeConfig.Store(&cfg)
+
+	dir := Direction{StopID: "16994", FirstDeparture: ServiceTimes{Weekday: "05:12", Saturday: "06:05"}}
+	tests := []struct {
+		name    string
+		stopID  string
+		now     time.Time
+		ended   bool
+		resumes time.Time
+	}{
+		{"weekday", "16994", time.Date(2026, 1, 29, 12, 0, 0, 0, loc), false, time.Time{}},
+		{"sunday", "16994", time.Date(2026, 2, 1, 12, 0, 0, 0, loc), true, time.Date(2026, 2, 2, 5, 12, 0, 0, loc)},
+		{"saturday night runs late", "16994", time.Date(2026, 2, 1, 1, 0, 0, 0, loc), false, time.Time{}},
+		{"added saturday", "16994", time.Date(2026, 1, 31, 12, 0, 0, 0, loc), false, time.Time{}},
+		{"removed monday", "16994", time.Date(2026, 1, 19, 12, 0, 0, 0, loc), true, time.Date(2026, 1, 20, 5, 12, 0, 0, loc)},
+		{"stop not in the feed", "99999", time.Date(2026, 2, 1, 12, 0, 0, 0, loc), false, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := dir
+			d.StopID = tt.stopID
+			ended, resumes := serviceStatus("SF", d, tt.now)
+			if ended != tt.ended || !resumes.Equal(tt.resumes) {
+				t.Errorf("serviceStatus() = %v, %v; want %v, %v", ended, resumes, tt.ended, tt.resumes)
+			}
+		})
+	}
+
+	// The scheduler doesn't fetch on a day without service
+	sunday := time.Date(2026, 2, 1, 12, 0, 0, 0, loc)
+	if n := len(sched.plan(sunday)); n != 0 {
+		t.Errorf("batches on a day without service = %d", n)
+	}
+	if due := sched.directionDue(directionRef{0, 0}, "16994"); !due.After(sunday) {
+		t.Errorf("next fetch on a day without service = %v", due)
+	}
+	monday := time.Date(2026, 2, 2, 12, 0, 0, 0, loc)
+	if n := len(sched.plan(monday)); n != 1 {
+		t.Errorf("batches on a day with service = %d", n)
+	}
+
+	// Without gtfs.calendar the feed's calendar is ignored
+	cfg.GTFS.Calendar = false
+	if ended, _ := serviceStatus("SF", dir, sunday); ended {
+		t.Error("service ended with gtfs.calendar off")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment a replacement process is started with. Its listeners come
+// first from fd 3, then the ready and done pipes.
+const (
+	// Comma-separated names of the listeners handed over
+	handoffListenersEnv = "MUNI_HANDOFF_LISTENERS"
+	// Where the old process saves its snapshot once it has stopped
+	handoffSnapshotEnv = "MUNI_HANDOFF_SNAPSHOT"
+)
+
+// handoffReadyTimeout is how long a replacement gets to load its config
+// and take the listeners before the upgrade is abandoned
+const handoffReadyTimeout = 30 * time.Second
+
+// handoffState tracks both sides of an upgrade: what this process was
+// handed when it replaced another, and the process replacing it
+type handoffState struct {
+	mu sync.Mutex
+	// The binary this process was started from, resolved at start since
+	// an upgrade replaces the file
+	executable string
+	// Listeners serving now, in the order they're handed on
+	names     []string
+	listeners map[string]net.Listener
+
+	// From the process this one replaced
+	inherited map[string]net.Listener
+	ready     *os.File
+	done      *os.File
+	snapshot  string
+
+	// The process replacing this one
+	successor         *os.Process
+	successorDone     *os.File
+	successorSnapshot string
+}
+
+var handoff = &handoffState{listeners: make(map[string]net.Listener), inherited: make(map[string]net.Listener)}
+
+// inheritHandoff picks up what the process being replaced passed down,
+// if this process is a replacement
+func inheritHandoff() error {
+	handoff.executable, _ = os.Executable()
+
+	names, ok := os.LookupEnv(handoffListenersEnv)
+	if !ok {
+		return nil
+	}
+	handoff.snapshot = os.Getenv(handoffSnapshotEnv)
+	// Not for processes this one starts
+	os.Unsetenv(handoffListenersEnv)
+	os.Unsetenv(handoffSnapshotEnv)
+
+	fd := uintptr(3)
+	if names != "" {
+		for _, name := range strings.Split(names, ",") {
+			f := os.NewFile(fd, name)
+			fd++
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("inheriting listener %s: %w", name, err)
+			}
+			handoff.inherited[name] = ln
+		}
+	}
+	handoff.ready = os.NewFile(fd, "handoff-ready")
+	handoff.done = os.NewFile(fd+1, "handoff-done")
+	return nil
+}
+
+// listener returns the listener called name, handed over by the process
+// being replaced or else opened by open, and keeps it for the next upgrade
+func (h *handoffState) listener(name string, open func() (net.Listener, error)) (net.Listener, error) {
+	h.mu.Lock()
+	ln, ok := h.inherited[name]
+	delete(h.inherited, name)
+	h.mu.Unlock()
+
+	if ok {
+		// Clean up the socket file as if this process had made it
+		if ul, ok := ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(true)
+		}
+		infof("Took over %s listener on %s", name, ln.Addr())
+	} else {
+		var err error
+		if ln, err = open(); err != nil {
+			return nil, err
+		}
+	}
+
+	h.mu.Lock()
+	if _, dup := h.listeners[name]; !dup {
+		h.names = append(h.names, name)
+	}
+	h.listeners[name] = ln
+	h.mu.Unlock()
+	return ln, nil
+}
+
+// awaitPredecessor tells the process being replaced that this one is
+// ready, then waits for it to stop serving and save its snapshot. New
+// connections queue on the shared listeners meanwhile.
+func awaitPredecessor() error {
+	if handoff.ready == nil {
+		return nil
+	}
+	for name, ln := range handoff.inherited {
+		// Gone from the new config
+		ln.Close()
+		delete(handoff.inherited, name)
+	}
+	return signalReady(handoff.ready, handoff.done, 2*shutdownTimeout)
+}
+
+// signalReady writes the ready byte and waits up to timeout for done to
+// close
+func signalReady(ready, done *os.File, timeout time.Duration) error {
+	defer done.Close()
+	_, err := ready.Write([]byte{1})
+	ready.Close()
+	if err != nil {
+		return fmt.Errorf("the old process is gone: %w", err)
+	}
+	done.SetReadDeadline(time.Now().Add(timeout))
+	var buf [1]byte
+	for {
+		if _, err := done.Read(buf[:]); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return fmt.Errorf("the old process didn't stop within %v", timeout)
+			}
+			return nil
+		}
+	}
+}
+
+// waitReady reports whether the replacement wrote its ready byte within
+// timeout, rather than dying or running out of time
+func waitReady(ready *os.File, timeout time.Duration) bool {
+	ready.SetReadDeadline(time.Now().Add(timeout))
+	var buf [1]byte
+	n, _ := ready.Read(buf[:])
+	return n == 1
+}
+
+// startUpgrades replaces the server with a fresh start of its binary on
+// each upgrade signal. Once the replacement is ready, stop shuts this
+// process down as for SIGTERM, and the replacement takes over.
+func startUpgrades(stop context.CancelFunc) {
+	if len(upgradeSignals) == 0 {
+		return
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, upgradeSignals...)
+	go func() {
+		for range sigs {
+			pid, err := handoff.upgrade()
+			if err != nil {
+				errorf("Upgrade failed, still serving: %v", err)
+				continue
+			}
+			infof("Handing over to pid %d", pid)
+			signal.Stop(sigs)
+			stop()
+			return
+		}
+	}()
+}
+
+// upgrade starts the replacement and waits for it to be ready
+func (h *handoffState) upgrade() (int, error) {
+	if h.executable == "" {
+		return 0, fmt.Errorf("can't tell where this binary is")
+	}
+
+	h.mu.Lock()
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range h.names {
+		f, err := listenerFile(name, h.listeners[name])
+		if err != nil {
+			h.mu.Unlock()
+			return 0, err
+		}
+		files = append(files, f)
+	}
+	names := strings.Join(h.names, ",")
+	h.mu.Unlock()
+
+	snapshot := currentConfig().SnapshotFile
+	if snapshot == "" {
+		snapshot = filepath.Join(os.TempDir(), fmt.Sprintf("muni-tracker-handoff-%d.json", os.Getpid()))
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer readyR.Close()
+	doneR, doneW, err := os.Pipe()
+	if err != nil {
+		readyW.Close()
+		return 0, err
+	}
+	cmd := exec.Command(h.executable, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW, doneR)
+	cmd.Env = append(handoffEnviron(), handoffListenersEnv+"="+names, handoffSnapshotEnv+"="+snapshot)
+	err = cmd.Start()
+	// Only the replacement holds its ends now, so a crash reads as EOF
+	readyW.Close()
+	doneR.Close()
+	if err != nil {
+		doneW.Close()
+		return 0, err
+	}
+	go cmd.Wait()
+
+	if !waitReady(readyR, handoffReadyTimeout) {
+		cmd.Process.Kill()
+		doneW.Close()
+		return 0, fmt.Errorf("pid %d didn't get ready", cmd.Process.Pid)
+	}
+
+	h.mu.Lock()
+	for _, ln := range h.listeners {
+		// The socket file belongs to the replacement now
+		if ul, ok := ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	h.successor = cmd.Process
+	h.successorDone = doneW
+	h.successorSnapshot = snapshot
+	h.mu.Unlock()
+	return cmd.Process.Pid, nil
+}
+
+// handoffEnviron is this process's environment for its replacement. The
+// watchdog follows the main PID, which the replacement is about to be.
+func handoffEnviron() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// successorPID is the replacement's process ID, or zero outside an upgrade
+func successorPID() int {
+	handoff.mu.Lock()
+	defer handoff.mu.Unlock()
+	if handoff.successor == nil {
+		return 0
+	}
+	return handoff.successor.Pid
+}
+
+// snapshotToRestore is the snapshot to start from: the one the replaced
+// process saves, or the configured one
+func snapshotToRestore() string {
+	if handoff.snapshot != "" {
+		return handoff.snapshot
+	}
+	return currentConfig().SnapshotFile
+}
+
+// snapshotToSave is where to save the snapshot on shutdown: where the
+// replacement will look, or the configured file
+func snapshotToSave() string {
+	handoff.mu.Lock()
+	defer handoff.mu.Unlock()
+	if handoff.successorSnapshot != "" {
+		return handoff.successorSnapshot
+	}
+	return currentConfig().SnapshotFile
+}
+
+// handoffComplete lets the replacement go ahead once this process has
+// stopped and saved its snapshot
+func handoffComplete() {
+	handoff.mu.Lock()
+	defer handoff.mu.Unlock()
+	if handoff.successorDone != nil {
+		handoff.successorDone.Close()
+		handoff.successorDone = nil
+	}
+}
+
+// upgradeNotice is what systemd hears when this process stops: a new main
+// PID when it's being replaced, and that the service is stopping otherwise
+func upgradeNotice() string {
+	if pid := successorPID(); pid != 0 {
+		return "MAINPID=" + strconv.Itoa(pid)
+	}
+	return "STOPPING=1"
+}
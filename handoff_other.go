@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// upgradeSignals is empty: listeners can't be handed over here
+var upgradeSignals []os.Signal
+
+func listenerFile(name string, ln net.Listener) (*os.File, error) {
+	return nil, fmt.Errorf("the %s listener can't be handed over on this platform", name)
+}
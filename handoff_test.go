@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHandoff(t *testing.T) {
+	if len(upgradeSignals) == 0 {
+		t.Skip("listeners can't be handed over on this platform")
+	}
+
+	// A handed-over descriptor keeps accepting after the original closes,
+	// and passing it to exec leaves the original closable mid-Accept
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := listenerFile("main", ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Fd()
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		accepted <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	// Close blocks too when it can't interrupt the Accept
+	go ln.Close()
+	select {
+	case err := <-accepted:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Accept after Close = %v, want net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept still blocked after the listener closed")
+	}
+	inherited, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	conn, err := net.Dial("tcp", inherited.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing the handed-over listener: %v", err)
+	}
+	conn.Close()
+
+	// A replacement takes the inherited listener instead of opening one
+	h := &handoffState{listeners: make(map[string]net.Listener), inherited: map[string]net.Listener{"main": inherited}}
+	got, err := h.listener("main", func() (net.Listener, error) {
+		t.Error("opened a listener despite inheriting one")
+		return nil, errors.New("unexpected")
+	})
+	if err != nil || got != inherited {
+		t.Errorf("listener = %v, %v; want the inherited one", got, err)
+	}
+	if !slices.Equal(h.names, []string{"main"}) {
+		t.Errorf("names = %v, want [main]", h.names)
+	}
+
+	// The replacement says it's ready, then waits for the old process
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doneR, doneW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waited := make(chan error, 1)
+	go func() { waited <- signalReady(readyW, doneR, time.Second) }()
+	if !waitReady(readyR, time.Second) {
+		t.Fatal("waitReady = false after the replacement signalled")
+	}
+	select {
+	case err := <-waited:
+		t.Fatalf("signalReady returned %v before the old process finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	doneW.Close()
+	if err := <-waited; err != nil {
+		t.Errorf("signalReady = %v", err)
+	}
+	readyR.Close()
+
+	// A replacement that dies first isn't waited on
+	readyR, readyW, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readyW.Close()
+	if waitReady(readyR, time.Second) {
+		t.Error("waitReady = true for a replacement that died")
+	}
+	readyR.Close()
+
+	// A connection made just before shutdown is still answered
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	served := make(chan error, 1)
+	go func() { served <- serveUntilDone(ctx, srv, ln, srv.Serve) }()
+	conn, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	time.Sleep(50 * time.Millisecond)
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("request sent during shutdown: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serveUntilDone = %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// upgradeSignals start a zero-downtime upgrade
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// listenerFile duplicates a listener's descriptor for a replacement
+// process. Listener.File isn't used: exec puts its descriptor into
+// blocking mode, and with it the listener, which then can't be closed
+// while an Accept is waiting.
+func listenerFile(name string, ln net.Listener) (*os.File, error) {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("the %s listener can't be handed over", name)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var dup int
+	var dupErr error
+	err = rc.Control(func(fd uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if dup, dupErr = syscall.Dup(int(fd)); dupErr == nil {
+			syscall.CloseOnExec(dup)
+		}
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("handing over the %s listener: %w", name, err)
+	}
+	return os.NewFile(uintptr(dup), name), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// headways remembers a smoothed headway per direction so a summary is
+// still available when only one arrival is currently predicted
+var headways = struct {
+	sync.Mutex
+	minutes map[headwayKey]float64
+}{minutes: make(map[headwayKey]float64)}
+
+// headwaySmoothing is the weight given to each new observation
+const headwaySmoothing = 0.3
+
+// headwayKey names a direction by its stop code and its index within the
+// stop. The label can't be used: it may be filled in from the arrivals.
+type headwayKey struct {
+	stopID string
+	dir    int
+}
+
+// pruneHeadways drops history for directions no longer configured.
+// headways must be locked.
+func pruneHeadways(stops []Stop) {
+	configured := make(map[headwayKey]bool)
+	for _, stop := range stops {
+		for j, dir := range stop.Directions {
+			configured[headwayKey{dir.StopID, j}] = true
+		}
+	}
+	for key := range headways.minutes {
+		if !configured[key] {
+			delete(headways.minutes, key)
+		}
+	}
+}
+
+// medianGap returns the median gap in minutes between consecutive
+// arrivals, or 0 with fewer than two arrivals
+func medianGap(arrivals []Arrival) float64 {
+	times := make([]time.Time, 0, len(arrivals))
+	for _, a := range arrivals {
+		if t, err := time.Parse(time.RFC3339, a.ArrivalTime); err == nil {
+			times = append(times, t)
+		}
+	}
+	if len(times) < 2 {
+		return 0
+	}
+
+	gaps := make([]float64, 0, len(times)-1)
+	for i := 1; i < len(times); i++ {
+		// Sub-minute gaps are the same vehicle reported twice
+		if gap := times[i].Sub(times[i-1]).Minutes(); gap >= 1 {
+			gaps = append(gaps, gap)
+		}
+	}
+	if len(gaps) == 0 {
+		return 0
+	}
+	sort.Float64s(gaps)
+
+	mid := len(gaps) / 2
+	if len(gaps)%2 == 0 {
+		return (gaps[mid-1] + gaps[mid]) / 2
+	}
+	return gaps[mid]
+}
+
+func init() {
+	events.handle(observeHeadway)
+}
+
+// observeHeadway folds a fresh fetch into the direction's history
+func observeHeadway(e Event) {
+	if e.Kind != eventDirectionFetched {
+		return
+	}
+	// Vehicles kept on the board after leaving aren't part of the cadence
+	var upcoming []Arrival
+	for _, a := range e.Direction.Arrivals {
+		if a.Status != statusDeparted {
+			upcoming = append(upcoming, a)
+		}
+	}
+	gap := medianGap(upcoming)
+	if gap == 0 {
+		return
+	}
+
+	key := headwayKey{e.Direction.StopID, e.ref.dir}
+	headways.Lock()
+	defer headways.Unlock()
+	if prev, ok := headways.minutes[key]; ok {
+		gap = prev + headwaySmoothing*(gap-prev)
+	}
+	headways.minutes[key] = gap
+	pruneHeadways(currentConfig().Stops)
+}
+
+// approxHeadway summarizes how often vehicles come, in whole minutes.
+// Upcoming arrivals win; history fills in when there are too few.
+func approxHeadway(stopID string, dir int, arrivals []Arrival) int {
+	gap := medianGap(arrivals)
+	if gap == 0 {
+		headways.Lock()
+		gap = headways.minutes[headwayKey{stopID, dir}]
+		headways.Unlock()
+	}
+	return int(math.Round(gap))
+}
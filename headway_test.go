@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeadwayHistory(t *testing.T) {
+	now := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	withTestEnv(t, now, "")
+	cfg, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: A
+    directions: [{stop_id: "1"}, {label: X, stop_id: "2"}]
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	fetched := func(ref directionRef, stopID, label string, gap time.Duration) {
+		observeHeadway(Event{Kind: eventDirectionFetched, ref: ref, Direction: DirectionArrivals{
+			StopID:   stopID,
+			Label:    label,
+			Arrivals: arrivalsAt(now.Add(time.Minute), now.Add(time.Minute+gap)),
+		}})
+	}
+
+	// A derived label changes as arrivals come and go; the history stays
+	fetched(directionRef{0, 0}, "1", "1", 10*time.Minute)
+	fetched(directionRef{0, 0}, "1", "Ocean Beach", 10*time.Minute)
+	if h := approxHeadway("1", 0, nil); h != 10 {
+		t.Errorf("headway after the label changed = %d, want 10", h)
+	}
+	if h := approxHeadway("1", 1, nil); h != 0 {
+		t.Errorf("headway of another direction with the stop code = %d, want 0", h)
+	}
+
+	// Directions dropped from the config are forgotten
+	fetched(directionRef{0, 1}, "2", "X", 6*time.Minute)
+	next := *cfg
+	next.Stops = []Stop{{Name: "A", Directions: []Direction{{Label: "X", StopID: "2"}}}}
+	activeConfig.Store(&next)
+	fetched(directionRef{0, 0}, "2", "X", 6*time.Minute)
+	headways.Lock()
+	defer headways.Unlock()
+	if len(headways.minutes) != 1 || headways.minutes[headwayKey{"2", 0}] != 6 {
+		t.Errorf("history after the config changed = %v", headways.minutes)
+	}
+}
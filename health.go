@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// degradedAfterFailures is how many fetches in a row a direction must
+// fail before health reports degraded, so one dropped request doesn't
+const degradedAfterFailures = 3
+
+// DirectionHealth is one direction's recent fetch record
+type DirectionHealth struct {
+	Stop                string     `json:"stop"`
+	Direction           string     `json:"direction"`
+	StopID              string     `json:"stop_id"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastSuccess         *time.Time `json:"last_success"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// directionHealth is keyed by stop name and stop code, as directions are
+// configured
+var directionHealth = struct {
+	sync.Mutex
+	byKey map[string]*DirectionHealth
+}{byKey: make(map[string]*DirectionHealth)}
+
+func healthKey(stop Stop, dir Direction) string {
+	return stop.Name + "\x00" + dir.StopID
+}
+
+// recordFetch updates a direction's record after a fetch
+func recordFetch(stop Stop, dir Direction, result DirectionArrivals) {
+	directionHealth.Lock()
+	defer directionHealth.Unlock()
+
+	h := directionHealth.byKey[healthKey(stop, dir)]
+	if h == nil {
+		h = &DirectionHealth{}
+		directionHealth.byKey[healthKey(stop, dir)] = h
+	}
+	h.Stop, h.Direction, h.StopID = stop.Name, result.Label, dir.StopID
+	if result.Error != "" {
+		h.ConsecutiveFailures++
+		h.LastError = result.FetchError
+		return
+	}
+	at := result.FetchedAt
+	h.ConsecutiveFailures, h.LastError, h.LastSuccess = 0, "", &at
+}
+
+// healthReport lists every configured direction that has been fetched,
+// including dashboards'
+func healthReport(cfg *Config) []DirectionHealth {
+	directionHealth.Lock()
+	defer directionHealth.Unlock()
+
+	seen := make(map[string]bool)
+	out := []DirectionHealth{}
+	for _, stop := range allStops(cfg) {
+		for _, dir := range stop.Directions {
+			key := healthKey(stop, dir)
+			if h := directionHealth.byKey[key]; h != nil && !seen[key] {
+				seen[key] = true
+				out = append(out, *h)
+			}
+		}
+	}
+	return out
+}
+
+// HealthResponse is the /health body
+type HealthResponse struct {
+	// ok, or degraded while any direction has failed
+	// degradedAfterFailures fetches in a row or an agency is down
+	Status       string            `json:"status"`
+	AgenciesDown []string          `json:"agencies_down,omitempty"`
+	Directions   []DirectionHealth `json:"directions"`
+}
+
+func currentHealth() HealthResponse {
+	resp := HealthResponse{
+		Status:       "ok",
+		AgenciesDown: agenciesDown(),
+		Directions:   healthReport(currentConfig()),
+	}
+	if len(resp.AgenciesDown) > 0 {
+		resp.Status = "degraded"
+	}
+	for _, d := range resp.Directions {
+		if d.ConsecutiveFailures >= degradedAfterFailures {
+			resp.Status = "degraded"
+		}
+	}
+	return resp
+}
+
+// handleHealth reports "degraded", still with a 200 so container health
+// checks don't restart the server over an upstream outage
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentHealth())
+}
+
+// handleReadyz answers 503 while every direction's latest fetch failed,
+// when the server has nothing fresh to serve, so a load balancer can
+// route around it
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	health := currentHealth()
+	failing := 0
+	for _, d := range health.Directions {
+		if d.ConsecutiveFailures > 0 {
+			failing++
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if failing == len(health.Directions) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		health.Status = "unavailable"
+	}
+	json.NewEncoder(w).Encode(health)
+}
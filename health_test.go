@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	start := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	fc, ft := withTestEnv(t, start, `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`)
+	cfg, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: Health Street
+    line: N Judah
+    directions:
+      - {label: Inbound, stop_id: "70001"}
+      - {label: Outbound, stop_id: "70002"}
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	check := func(handler http.HandlerFunc, path string) (int, HealthResponse) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", path, nil))
+		var h HealthResponse
+		json.NewDecoder(rec.Body).Decode(&h)
+		return rec.Code, h
+	}
+
+	refreshCache()
+	if code, h := check(handleReadyz, "/readyz"); code != http.StatusOK || h.Status != "ok" || len(h.Directions) != 2 {
+		t.Fatalf("readyz = %d %+v", code, h)
+	}
+
+	// One direction failing stays ok until it has failed three times
+	stop := cfg.Stops[0]
+	fail := DirectionArrivals{Label: "Outbound", Error: "Unable to fetch", FetchError: "stop 70002: HTTP 500"}
+	for i := 1; i <= degradedAfterFailures; i++ {
+		if _, h := check(handleHealth, "/health"); h.Status != "ok" {
+			t.Fatalf("degraded after %d failures", i-1)
+		}
+		recordFetch(stop, stop.Directions[1], fail)
+	}
+	code, h := check(handleHealth, "/health")
+	if code != http.StatusOK || h.Status != "degraded" {
+		t.Fatalf("health = %d %+v", code, h)
+	}
+	out := h.Directions[1]
+	if out.StopID != "70002" || out.ConsecutiveFailures != 3 || out.LastError != "stop 70002: HTTP 500" || out.LastSuccess == nil {
+		t.Errorf("outbound = %+v", out)
+	}
+	if code, _ := check(handleReadyz, "/readyz"); code != http.StatusOK {
+		t.Errorf("readyz with one direction up = %d", code)
+	}
+
+	// Everything failing is unready
+	fc.now = start.Add(time.Minute)
+	ft.body = "down"
+	refreshCache()
+	if code, h := check(handleReadyz, "/readyz"); code != http.StatusServiceUnavailable || h.Status != "unavailable" {
+		t.Errorf("readyz = %d %+v", code, h)
+	}
+
+	// A success clears the count
+	ft.body = `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`
+	refreshCache()
+	if _, h := check(handleHealth, "/health"); h.Status != "ok" || h.Directions[1].ConsecutiveFailures != 0 {
+		t.Errorf("health after recovery = %+v", h)
+	}
+}
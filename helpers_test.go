@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a fixed time source; Sleep advances it instead of blocking
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time        { return c.now }
+func (c *fakeClock) Sleep(d time.Duration) { c.now = c.now.Add(d) }
+
+// fakeTransport answers every upstream request with a canned body
+type fakeTransport struct {
+	body     string
+	requests int
+}
+
+func (t *fakeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.requests++
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(t.body)),
+		Header:     make(http.Header),
+		Request:    r,
+	}, nil
+}
+
+// withTestEnv installs a fake clock, transport, and config for one test
+func withTestEnv(t *testing.T, now time.Time, body string) (*fakeClock, *fakeTransport) {
+	t.Helper()
+
+	fc := &fakeClock{now: now}
+	ft := &fakeTransport{body: body}
+
+	oldClock, oldTransport, oldConfig := clock, upstreamTransport, currentConfig()
+	clock, upstreamTransport = fc, ft
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: "16994"
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+	resetUpstreamState()
+
+	t.Cleanup(func() {
+		clock, upstreamTransport = oldClock, oldTransport
+		if oldConfig != nil {
+			activeConfig.Store(oldConfig)
+		}
+		cache.mu.Lock()
+		cache.data = ArrivalsResponse{}
+		cache.mu.Unlock()
+	})
+
+	return fc, ft
+}
+
+// resetUpstreamState forgets what earlier tests fetched: the requests
+// counted against the quota, the scheduler's queues, and headway history.
+// Each test's fake clock starts at its own time, so left over they would
+// throttle or skew the next test.
+func resetUpstreamState() {
+	quota.mu.Lock()
+	quota.calls = nil
+	quota.mu.Unlock()
+	sched.mu.Lock()
+	clear(sched.queues)
+	sched.mu.Unlock()
+	headways.Lock()
+	clear(headways.minutes)
+	headways.Unlock()
+}
+
+func arrivalsAt(times ...time.Time) []Arrival {
+	arrivals := make([]Arrival, len(times))
+	for i, at := range times {
+		arrivals[i] = Arrival{ArrivalTime: at.Format(time.RFC3339)}
+	}
+	return arrivals
+}
+
+// gtfsZip builds an in-memory GTFS feed from file contents
+func gtfsZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var testGTFS = map[string]string{
+	"routes.txt": "\xEF\xBB\xBFroute_id,route_short_name,route_long_name\nF,F,Market & Wharves\nN,N,Judah\n",
+	"trips.txt": "route_id,service_id,trip_id,trip_headsign,direction_id,shape_id,wheelchair_accessible,bikes_allowed\n" +
+		"F,1,t1,Fisherman's Wharf,0,f0,1,2\nN,1,t2,Ocean Beach,1,n1,1,0\nN,1,t3,Ocean Beach,1,n1,,\nN,1,t4,Ocean Beach,1,n1-short,,\n",
+	"shapes.txt": "shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence\n" +
+		"n1,37.79,-122.39,2\nn1,37.80,-122.40,1\nn1-short,37.0,-122.0,1\nf0,37.78,-122.40,1\n",
+	"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+		"t1,08:00:00,08:00:00,15731,1\nt1,08:10:00,08:10:00,16994,2\nt2,08:05:00,08:05:00,16994,1\n",
+	"stops.txt": "stop_id,stop_code,stop_name,stop_lat,stop_lon,wheelchair_boarding\n" +
+		"15731,15731,Market St & Powell St,37.78459,-122.40775,2\n" +
+		"16994,16994,Embarcadero Station,37.79291,-122.39676,1\n",
+}
+
+// routeTransport answers upstream requests by URL, 404 for anything else
+type routeTransport map[string]string
+
+func (rt routeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, ok := rt[r.URL.String()]
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+		Request:    r,
+	}, nil
+}
+
+// testStop is the smallest valid stops list, for config snippets
+const testStop = "stops: [{name: A, line: N, directions: [{label: B, stop_id: \"1\"}]}]\n"
+
+// recordTransport keeps each request's URL, headers and body and answers
+// with status, or 204
+type recordTransport struct {
+	urls    []string
+	headers []http.Header
+	bodies  []string
+	status  int
+}
+
+func (rt *recordTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body []byte
+	if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+	}
+	rt.urls = append(rt.urls, r.URL.String())
+	rt.headers = append(rt.headers, r.Header)
+	rt.bodies = append(rt.bodies, string(body))
+	status := rt.status
+	if status == 0 {
+		status = http.StatusNoContent
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Header:     make(http.Header),
+		Request:    r,
+	}, nil
+}
+
+// sequenceTransport answers with each status in turn, then 200 with body
+type sequenceTransport struct {
+	statuses []int
+	headers  []http.Header
+	body     string
+	requests int
+}
+
+func (t *sequenceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	status, header := http.StatusOK, make(http.Header)
+	if t.requests < len(t.statuses) {
+		status = t.statuses[t.requests]
+		if t.requests < len(t.headers) {
+			header = t.headers[t.requests]
+		}
+	}
+	t.requests++
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(t.body)),
+		Header:     header,
+		Request:    r,
+	}, nil
+}
+
+// reflectEqualJSON compares two values by their JSON
+func reflectEqualJSON(t *testing.T, a, b interface{}) bool {
+	t.Helper()
+	ja, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Equal(ja, jb)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// HTTP2Config tunes HTTP/2, which lets a dashboard multiplex its streams
+// and polls over one connection. It is on by default over TLS.
+type HTTP2Config struct {
+	// Serve HTTP/2 without TLS (h2c) to trusted_proxies and unix socket
+	// peers, for a proxy that terminates TLS and speaks h2c upstream
+	H2C bool `yaml:"h2c,omitempty"`
+	// Turn HTTP/2 off over TLS as well
+	Disable bool `yaml:"disable,omitempty"`
+	// Streams one connection may have open at once (default 250)
+	MaxConcurrentStreams uint32 `yaml:"max_concurrent_streams,omitempty"`
+}
+
+func validateHTTP2Config(config *Config) error {
+	h := &config.HTTP2
+	if h.H2C && h.Disable {
+		return fmt.Errorf("http2.h2c and http2.disable cannot both be set")
+	}
+	if h.H2C && config.TLS.enabled() {
+		return fmt.Errorf("http2.h2c is for plain HTTP listeners; HTTP/2 over TLS needs no setting")
+	}
+	if h.H2C && len(config.TrustedProxies) == 0 && !strings.HasPrefix(config.Listen, "unix:") {
+		return fmt.Errorf("http2.h2c needs trusted_proxies or a unix socket listener")
+	}
+	if h.MaxConcurrentStreams == 0 {
+		h.MaxConcurrentStreams = 250
+	}
+	return nil
+}
+
+func http2Server() *http2.Server {
+	return &http2.Server{MaxConcurrentStreams: currentConfig().HTTP2.MaxConcurrentStreams}
+}
+
+// configureHTTP2 sets up (or turns off) HTTP/2 on a TLS server
+func configureHTTP2(server *http.Server) error {
+	if currentConfig().HTTP2.Disable {
+		// A non-nil, empty map keeps net/http from adding h2
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return nil
+	}
+	return http2.ConfigureServer(server, http2Server())
+}
+
+// h2cHandler accepts h2c, by prior knowledge or Upgrade, from trusted
+// peers when http2.h2c is on. Everyone else gets HTTP/1.1. It must wrap
+// realIPMiddleware so it sees the direct peer.
+func h2cHandler(handler http.Handler) http.Handler {
+	if !currentConfig().HTTP2.H2C {
+		return handler
+	}
+	upgraded := h2c.NewHandler(handler, http2Server())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if trustedPeer(r) {
+			upgraded.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
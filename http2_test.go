@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestHTTP2(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 3, 3, 20, 0, 0, 0, time.UTC), "")
+	const stops = "stops: [{name: Embarcadero, directions: [{label: Ocean Beach, stop_id: \"16994\"}]}]\n"
+	for _, bad := range []string{
+		"http2: {h2c: true}\n",
+		"http2: {h2c: true, disable: true}\ntrusted_proxies: [127.0.0.1]\n",
+	} {
+		if _, err := parseConfig([]byte("api_key: test\n" + bad + stops)); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+
+	// h2c by prior knowledge, only from a trusted proxy
+	h2cClient := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+	for proxy, want := range map[string]bool{"127.0.0.1": true, "10.9.9.9": false} {
+		cfg, err := parseConfig([]byte("api_key: test\nhttp2: {h2c: true}\ntrusted_proxies: [" + proxy + "]\n" + stops))
+		if err != nil {
+			t.Fatalf("parseConfig: %v", err)
+		}
+		activeConfig.Store(cfg)
+		srv := httptest.NewServer(h2cHandler(ok))
+		resp, err := h2cClient.Get(srv.URL)
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "HTTP/2.0" {
+				t.Errorf("trusted %s: served over %s", proxy, body)
+			}
+		}
+		if (err == nil) != want {
+			t.Errorf("h2c from proxy %s: err = %v", proxy, err)
+		}
+		srv.Close()
+	}
+
+	// Over TLS, HTTP/2 is offered unless disabled
+	for _, disable := range []bool{false, true} {
+		cfg := *currentConfig()
+		cfg.HTTP2 = HTTP2Config{Disable: disable, MaxConcurrentStreams: 250}
+		activeConfig.Store(&cfg)
+		server := &http.Server{TLSConfig: &tls.Config{}}
+		if err := configureHTTP2(server); err != nil {
+			t.Fatal(err)
+		}
+		offered := false
+		for _, p := range server.TLSConfig.NextProtos {
+			offered = offered || p == "h2"
+		}
+		if offered == disable || (disable && len(server.TLSNextProto) != 0) {
+			t.Errorf("disable=%v: NextProtos %v, TLSNextProto %d", disable, server.TLSConfig.NextProtos, len(server.TLSNextProto))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import "fmt"
+
+// catalogs translate the server's English strings, keyed by the English
+// text. Format strings are looked up before formatting, so translations
+// may reorder arguments with %[n]s. Missing entries fall back to English.
+var catalogs = map[string]map[string]string{
+	"es": {
+		"No data from 511.org":                    "Sin datos de 511.org",
+		"Incomplete data - large gap in arrivals": "Datos incompletos: intervalo grande entre llegadas",
+		"Limited schedule data available":         "Datos de horario limitados",
+		"Unable to fetch":                         "No se pudo obtener",
+		"Station not in feed":                     "La estación no aparece en los datos",
+		"Service has ended":                       "El servicio ha terminado",
+		"Service resumes %s":                      "El servicio se reanuda a las %s",
+		"No arrivals":                             "Sin llegadas",
+		"Arrivals":                                "Llegadas",
+		"Next departures":                         "Próximas salidas",
+		"Loading...":                              "Cargando...",
+		"Due":                                     "Llega",
+		"Departing":                               "Saliendo",
+		"Departed":                                "Salió",
+		"No upcoming departures.":                 "No hay salidas próximas.",
+		"%s to %s from %s, %s.":                   "%s hacia %s desde %s, %s.",
+		"in %d minutes":                           "en %d minutos",
+		"in 1 minute":                             "en 1 minuto",
+		"due now":                                 "llega ahora",
+		"departing now":                           "sale ahora",
+		"Inbound":                                 "Entrante",
+		"Outbound":                                "Saliente",
+		"Northbound":                              "Dirección norte",
+		"Southbound":                              "Dirección sur",
+		"Eastbound":                               "Dirección este",
+		"Westbound":                               "Dirección oeste",
+		"%s to %s":                                "%s hacia %s",
+		"To %s":                                   "Hacia %s",
+		"Stop %s":                                 "Parada %s",
+	},
+	"zh": {
+		"No data from 511.org":                    "沒有來自 511.org 的資料",
+		"Incomplete data - large gap in arrivals": "資料不完整：班次間隔過大",
+		"Limited schedule data available":         "班次資料有限",
+		"Unable to fetch":                         "無法取得資料",
+		"Station not in feed":                     "資料中沒有此站",
+		"Service has ended":                       "服務已結束",
+		"Service resumes %s":                      "服務將於 %s 恢復",
+		"No arrivals":                             "沒有班次",
+		"Arrivals":                                "到站班次",
+		"Next departures":                         "下一班",
+		"Loading...":                              "載入中...",
+		"Due":                                     "到站",
+		"Departing":                               "開出中",
+		"Departed":                                "已開出",
+		"No upcoming departures.":                 "沒有即將出發的班次。",
+		"%s to %s from %s, %s.":                   "%[3]s 的 %[1]s 往 %[2]s，%[4]s。",
+		"in %d minutes":                           "%d 分鐘後",
+		"in 1 minute":                             "1 分鐘後",
+		"due now":                                 "即將到站",
+		"departing now":                           "正在開出",
+		"Inbound":                                 "入城",
+		"Outbound":                                "出城",
+		"Northbound":                              "北行",
+		"Southbound":                              "南行",
+		"Eastbound":                               "東行",
+		"Westbound":                               "西行",
+		"%s to %s":                                "%s 往 %s",
+		"To %s":                                   "往 %s",
+		"Stop %s":                                 "站點 %s",
+	},
+}
+
+// validateLanguage checks the language config against the catalogs
+func validateLanguage(lang string) error {
+	if _, ok := catalogs[lang]; ok || lang == "" || lang == "en" {
+		return nil
+	}
+	return fmt.Errorf("unsupported language %q", lang)
+}
+
+// tr translates s into the configured language
+func tr(s string) string {
+	if config := currentConfig(); config != nil {
+		if t, ok := catalogs[config.Language][s]; ok {
+			return t
+		}
+	}
+	return s
+}
+
+// trf translates a format string, then formats it
+func trf(format string, args ...interface{}) string {
+	return fmt.Sprintf(tr(format), args...)
+}
+
+// statusText is the display word for an arrival status, empty for
+// upcoming arrivals that show minutes instead
+func statusText(status string) string {
+	switch status {
+	case statusDue:
+		return tr("Due")
+	case statusDeparting:
+		return tr("Departing")
+	case statusDeparted:
+		return tr("Departed")
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCatalogsComplete(t *testing.T) {
+	for lang, catalog := range catalogs {
+		for other, ref := range catalogs {
+			for key := range ref {
+				if _, ok := catalog[key]; !ok {
+					t.Errorf("%s is missing %q (present in %s)", lang, key, other)
+				}
+			}
+		}
+		for key, text := range catalog {
+			if strings.Count(key, "%") != strings.Count(text, "%") {
+				t.Errorf("%s: %q has different verbs than %q", lang, text, key)
+			}
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	if got := trf("Service resumes %s", "5:12 AM"); got != "Service resumes 5:12 AM" {
+		t.Errorf("english = %q", got)
+	}
+
+	cfg := *currentConfig()
+	cfg.Language = "zh"
+	activeConfig.Store(&cfg)
+	if got := trf("%s to %s from %s, %s.", "N", "Ocean Beach", "Embarcadero", tr("due now")); got != "Embarcadero 的 N 往 Ocean Beach，即將到站。" {
+		t.Errorf("reordered chinese = %q", got)
+	}
+	if got := statusText(statusDeparted); got != "已開出" {
+		t.Errorf("status text = %q", got)
+	}
+
+	if err := validateLanguage("klingon"); err == nil {
+		t.Error("expected an error for an unknown language")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxIncludeDepth bounds fragments including further fragments
+const maxIncludeDepth = 5
+
+// expandIncludes merges the fragments named by the document's include
+// list into it, so stops can live in separate files. Entries are files,
+// globs, or directories (every *.yaml and *.yml inside, in name order),
+// relative to dir. Lists such as stops are appended, mappings are merged
+// key by key, and other values from later files win. Documents without
+// includes are returned unchanged.
+func expandIncludes(data []byte, dir string) ([]byte, error) {
+	doc := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if _, ok := doc["include"]; !ok {
+		return data, nil
+	}
+
+	includes, err := includeList(doc)
+	if err != nil {
+		return nil, err
+	}
+	if err := mergeIncludes(doc, dir, 1, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+	// Keep the list so admin edits know the config spans several files
+	doc["include"] = includes
+	return yaml.Marshal(doc)
+}
+
+// includeList reads the include key, a string or a list of strings
+func includeList(doc map[string]interface{}) ([]string, error) {
+	switch v := doc["include"].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		out := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("include[%d] must be a path", i)
+			}
+			out[i] = s
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("include must be a path or a list of paths")
+}
+
+// mergeIncludes merges doc's includes into doc, recursively
+func mergeIncludes(doc map[string]interface{}, dir string, depth int, seen map[string]bool) error {
+	includes, err := includeList(doc)
+	if err != nil {
+		return err
+	}
+	delete(doc, "include")
+	if len(includes) > 0 && depth > maxIncludeDepth {
+		return fmt.Errorf("includes nested more than %d deep", maxIncludeDepth)
+	}
+
+	for _, pattern := range includes {
+		files, err := includeFiles(pattern, dir)
+		if err != nil {
+			return err
+		}
+		for _, path := range files {
+			if seen[path] {
+				return fmt.Errorf("%s is included twice", path)
+			}
+			seen[path] = true
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("include: %w", err)
+			}
+			fragment := make(map[string]interface{})
+			if err := yaml.Unmarshal(data, &fragment); err != nil {
+				return fmt.Errorf("include %s: %w", path, err)
+			}
+			if err := mergeIncludes(fragment, filepath.Dir(path), depth+1, seen); err != nil {
+				return err
+			}
+			mergeConfigMaps(doc, fragment)
+		}
+	}
+	return nil
+}
+
+// includeFiles resolves one include entry to the files it names
+func includeFiles(pattern, dir string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+
+	if info, err := os.Stat(pattern); err == nil {
+		if !info.IsDir() {
+			return []string{pattern}, nil
+		}
+		var files []string
+		for _, ext := range []string{"*.yaml", "*.yml"} {
+			matches, _ := filepath.Glob(filepath.Join(pattern, ext))
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("include %q: %w", pattern, err)
+	}
+	// A literal path that doesn't exist is a mistake; an empty glob or
+	// conf.d directory is not
+	if len(matches) == 0 && !hasGlobMeta(pattern) {
+		return nil, fmt.Errorf("include: %s does not exist", pattern)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func hasGlobMeta(path string) bool {
+	for _, c := range path {
+		switch c {
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// mergeConfigMaps merges src into dst: lists append, mappings merge, and
+// other values from src replace dst's
+func mergeConfigMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		switch sv := v.(type) {
+		case []interface{}:
+			if dv, ok := dst[k].([]interface{}); ok {
+				dst[k] = append(dv, sv...)
+				continue
+			}
+		case map[string]interface{}:
+			if dv, ok := dst[k].(map[string]interface{}); ok {
+				mergeConfigMaps(dv, sv)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
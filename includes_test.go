@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandIncludes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("conf.d/20-office.yaml", `
+stops:
+  - name: Montgomery
+    directions: [{label: Richmond, stop_id: "MONT"}]
+`)
+	write("conf.d/10-home.yml", `
+stops:
+  - name: Church
+    directions: [{label: Inbound, stop_id: "14448"}]
+weather:
+  units: celsius
+`)
+	write("conf.d/notes.txt", "not yaml")
+	write("people/alex.yaml", "include: ../extra.yaml\n")
+	write("extra.yaml", `
+client_keys: [{name: alex, key: alex-secret}]
+`)
+
+	merged, err := expandIncludes([]byte(`
+api_key: test
+include: [conf.d, "people/*.yaml"]
+weather:
+  latitude: 37.7
+  longitude: -122.4
+stops:
+  - name: Embarcadero
+    directions: [{label: Ocean Beach, stop_id: "16994"}]
+`), dir)
+	if err != nil {
+		t.Fatalf("expandIncludes: %v", err)
+	}
+	cfg, err := parseConfig(merged)
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+
+	var names []string
+	for _, s := range cfg.Stops {
+		names = append(names, s.Name)
+	}
+	if got := strings.Join(names, ","); got != "Embarcadero,Church,Montgomery" {
+		t.Errorf("stops = %s", got)
+	}
+	if cfg.Weather.Latitude == nil || *cfg.Weather.Latitude != 37.7 || cfg.Weather.Units != "celsius" {
+		t.Errorf("weather = %+v", cfg.Weather)
+	}
+	if len(cfg.ClientKeys) != 1 || len(cfg.Include) != 2 {
+		t.Errorf("client keys = %v, include = %v", cfg.ClientKeys, cfg.Include)
+	}
+
+	if _, err := expandIncludes([]byte("include: missing.yaml\n"), dir); err == nil {
+		t.Error("a missing include file should be an error")
+	}
+	write("loop.yaml", "include: loop.yaml\n")
+	if _, err := expandIncludes([]byte("include: loop.yaml\n"), dir); err == nil {
+		t.Error("an include loop should be an error")
+	}
+}
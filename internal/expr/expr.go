@@ -0,0 +1,338 @@
+// Package expr compiles the conditions config rules are written in.
+//
+// A condition is a Go expression over named variables: comparisons,
+// arithmetic, && || !, and a few string functions, e.g.
+//
+//	destination == "Embarcadero" && !realtime && minutes > 30
+//	matches(line_type, "^owl")
+//
+// Conditions are type-checked when compiled, so evaluating one can't fail.
+package expr
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kind is the type of a variable or value
+type Kind int
+
+const (
+	String Kind = iota + 1
+	Number
+	Bool
+)
+
+func (k Kind) String() string {
+	switch k {
+	case String:
+		return "string"
+	case Number:
+		return "number"
+	case Bool:
+		return "bool"
+	}
+	return "invalid"
+}
+
+// funcs are the functions conditions may call: their argument kinds and
+// result
+var funcs = map[string]struct {
+	args   []Kind
+	result Kind
+}{
+	"contains":   {[]Kind{String, String}, Bool},
+	"startsWith": {[]Kind{String, String}, Bool},
+	"endsWith":   {[]Kind{String, String}, Bool},
+	"matches":    {[]Kind{String, String}, Bool},
+	"lower":      {[]Kind{String}, String},
+	"upper":      {[]Kind{String}, String},
+	"len":        {[]Kind{String}, Number},
+}
+
+// Condition is a compiled boolean expression
+type Condition struct {
+	src  string
+	root ast.Expr
+	vars map[string]Kind
+	// Patterns of matches() calls, compiled up front
+	patterns map[*ast.CallExpr]*regexp.Regexp
+}
+
+// Compile parses and type-checks src, which may use the given variables
+// and must be boolean
+func Compile(src string, vars map[string]Kind) (*Condition, error) {
+	root, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", src, err)
+	}
+	c := &Condition{src: src, root: root, vars: vars, patterns: make(map[*ast.CallExpr]*regexp.Regexp)}
+	kind, err := c.check(root)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", src, err)
+	}
+	if kind != Bool {
+		return nil, fmt.Errorf("%q is a %s, not a condition", src, kind)
+	}
+	return c, nil
+}
+
+func (c *Condition) String() string {
+	return c.src
+}
+
+// check works out the kind of e, rejecting what Eval can't evaluate
+func (c *Condition) check(e ast.Expr) (Kind, error) {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return c.check(e.X)
+
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT, token.FLOAT:
+			return Number, nil
+		case token.STRING:
+			return String, nil
+		}
+		return 0, fmt.Errorf("unsupported literal %s; strings take double quotes", e.Value)
+
+	case *ast.Ident:
+		if e.Name == "true" || e.Name == "false" {
+			return Bool, nil
+		}
+		if kind, ok := c.vars[e.Name]; ok {
+			return kind, nil
+		}
+		return 0, fmt.Errorf("unknown name %s; available: %s", e.Name, strings.Join(c.names(), ", "))
+
+	case *ast.UnaryExpr:
+		kind, err := c.check(e.X)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case e.Op == token.NOT && kind == Bool, e.Op == token.SUB && kind == Number:
+			return kind, nil
+		}
+		return 0, fmt.Errorf("can't apply %s to a %s", e.Op, kind)
+
+	case *ast.BinaryExpr:
+		x, err := c.check(e.X)
+		if err != nil {
+			return 0, err
+		}
+		y, err := c.check(e.Y)
+		if err != nil {
+			return 0, err
+		}
+		if x != y {
+			return 0, fmt.Errorf("can't compare or combine a %s with a %s", x, y)
+		}
+		switch e.Op {
+		case token.LAND, token.LOR:
+			if x == Bool {
+				return Bool, nil
+			}
+		case token.EQL, token.NEQ:
+			return Bool, nil
+		case token.LSS, token.LEQ, token.GTR, token.GEQ:
+			if x != Bool {
+				return Bool, nil
+			}
+		case token.ADD:
+			if x != Bool {
+				return x, nil
+			}
+		case token.SUB, token.MUL, token.QUO:
+			if x == Number {
+				return Number, nil
+			}
+		}
+		return 0, fmt.Errorf("can't apply %s to a %s", e.Op, x)
+
+	case *ast.CallExpr:
+		name, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			return 0, fmt.Errorf("unsupported call")
+		}
+		fn, ok := funcs[name.Name]
+		if !ok {
+			return 0, fmt.Errorf("unknown function %s", name.Name)
+		}
+		if len(e.Args) != len(fn.args) {
+			return 0, fmt.Errorf("%s takes %d arguments", name.Name, len(fn.args))
+		}
+		for i, arg := range e.Args {
+			kind, err := c.check(arg)
+			if err != nil {
+				return 0, err
+			}
+			if kind != fn.args[i] {
+				return 0, fmt.Errorf("argument %d of %s must be a %s", i+1, name.Name, fn.args[i])
+			}
+		}
+		if name.Name == "matches" {
+			lit, ok := e.Args[1].(*ast.BasicLit)
+			if !ok {
+				return 0, fmt.Errorf("the pattern given to matches must be a string literal")
+			}
+			pattern, _ := strconv.Unquote(lit.Value)
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return 0, err
+			}
+			c.patterns[e] = re
+		}
+		return fn.result, nil
+	}
+	return 0, fmt.Errorf("unsupported expression")
+}
+
+func (c *Condition) names() []string {
+	names := make([]string, 0, len(c.vars))
+	for name := range c.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Eval reports whether the condition holds for env, which maps variable
+// names to strings, bools, and ints or floats. Variables env leaves out
+// are zero.
+func (c *Condition) Eval(env map[string]any) bool {
+	return c.eval(c.root, env).(bool)
+}
+
+func (c *Condition) eval(e ast.Expr, env map[string]any) any {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return c.eval(e.X, env)
+
+	case *ast.BasicLit:
+		v := constant.MakeFromLiteral(e.Value, e.Kind, 0)
+		if e.Kind == token.STRING {
+			return constant.StringVal(v)
+		}
+		f, _ := constant.Float64Val(v)
+		return f
+
+	case *ast.Ident:
+		switch e.Name {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+		return value(c.vars[e.Name], env[e.Name])
+
+	case *ast.UnaryExpr:
+		x := c.eval(e.X, env)
+		if e.Op == token.NOT {
+			return !x.(bool)
+		}
+		return -x.(float64)
+
+	case *ast.BinaryExpr:
+		// Short-circuit, so guards like len(x) > 0 && ... read naturally
+		switch e.Op {
+		case token.LAND:
+			return c.eval(e.X, env).(bool) && c.eval(e.Y, env).(bool)
+		case token.LOR:
+			return c.eval(e.X, env).(bool) || c.eval(e.Y, env).(bool)
+		}
+		return binary(e.Op, c.eval(e.X, env), c.eval(e.Y, env))
+
+	case *ast.CallExpr:
+		args := make([]any, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = c.eval(arg, env)
+		}
+		switch e.Fun.(*ast.Ident).Name {
+		case "contains":
+			return strings.Contains(args[0].(string), args[1].(string))
+		case "startsWith":
+			return strings.HasPrefix(args[0].(string), args[1].(string))
+		case "endsWith":
+			return strings.HasSuffix(args[0].(string), args[1].(string))
+		case "matches":
+			return c.patterns[e].MatchString(args[0].(string))
+		case "lower":
+			return strings.ToLower(args[0].(string))
+		case "upper":
+			return strings.ToUpper(args[0].(string))
+		case "len":
+			return float64(len([]rune(args[0].(string))))
+		}
+	}
+	panic("expr: unchecked expression")
+}
+
+// value converts a variable from env to the representation eval works in
+func value(kind Kind, v any) any {
+	switch kind {
+	case String:
+		s, _ := v.(string)
+		return s
+	case Bool:
+		b, _ := v.(bool)
+		return b
+	}
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return float64(0)
+}
+
+func binary(op token.Token, x, y any) any {
+	switch op {
+	case token.EQL:
+		return x == y
+	case token.NEQ:
+		return x != y
+	}
+	if s, ok := x.(string); ok {
+		t := y.(string)
+		switch op {
+		case token.LSS:
+			return s < t
+		case token.LEQ:
+			return s <= t
+		case token.GTR:
+			return s > t
+		case token.GEQ:
+			return s >= t
+		}
+		return s + t
+	}
+	a, b := x.(float64), y.(float64)
+	switch op {
+	case token.LSS:
+		return a < b
+	case token.LEQ:
+		return a <= b
+	case token.GTR:
+		return a > b
+	case token.GEQ:
+		return a >= b
+	case token.ADD:
+		return a + b
+	case token.SUB:
+		return a - b
+	case token.MUL:
+		return a * b
+	}
+	return a / b
+}
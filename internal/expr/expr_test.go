@@ -0,0 +1,84 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+var testVars = map[string]Kind{
+	"destination": String,
+	"minutes":     Number,
+	"realtime":    Bool,
+}
+
+func TestEval(t *testing.T) {
+	env := map[string]any{"destination": "Embarcadero Station", "minutes": 12, "realtime": false}
+
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{`destination == "Embarcadero Station"`, true},
+		{`destination != "Embarcadero Station"`, false},
+		{`!realtime && minutes > 10`, true},
+		{`realtime || minutes >= 12.5`, false},
+		{`(minutes - 2) * 2 == 20`, true},
+		{`minutes / 4 < 3.5`, true},
+		{`-minutes < 0`, true},
+		{`contains(destination, "Embarcadero")`, true},
+		{`startsWith(lower(destination), "embarcadero")`, true},
+		{`endsWith(upper(destination), "STATION")`, true},
+		{`matches(destination, "^Emb.*Station$")`, true},
+		{`matches(destination, "^Caltrain")`, false},
+		{`len(destination) == 19`, true},
+		{`destination + "!" > "Embarcadero"`, true},
+		{`true && !false`, true},
+	}
+	for _, tt := range tests {
+		c, err := Compile(tt.src, testVars)
+		if err != nil {
+			t.Errorf("Compile(%s): %v", tt.src, err)
+			continue
+		}
+		if got := c.Eval(env); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEvalMissingVariables(t *testing.T) {
+	c, err := Compile(`destination == "" && minutes == 0 && !realtime`, testVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Eval(nil) {
+		t.Error("variables missing from env should be zero")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`minutes >`, "expected operand"},
+		{`minutes`, "not a condition"},
+		{`route == "N"`, "unknown name route"},
+		{`destination == 'N'`, "double quotes"},
+		{`destination == 3`, "string with a number"},
+		{`realtime < true`, "can't apply <"},
+		{`!minutes`, "can't apply !"},
+		{`shout(destination)`, "unknown function shout"},
+		{`contains(destination)`, "takes 2 arguments"},
+		{`contains(destination, 3)`, "must be a string"},
+		{`matches(destination, lower("x"))`, "string literal"},
+		{`matches(destination, "(")`, "missing closing )"},
+		{`destination[0] == "E"`, "unsupported expression"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.src, testVars)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Compile(%s) = %v, want an error mentioning %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Package quality judges whether a direction's predictions look complete.
+//
+// It knows nothing of the server's configuration: callers give it the
+// arrival times, the time in the agency's zone, and whether the service
+// day runs the weekday schedule.
+package quality
+
+import "time"
+
+// Levels a direction's data can be judged at
+const (
+	Good    = "good"
+	Warning = "warning"
+)
+
+// Warnings Detect gives, in English; callers translate them for display
+const (
+	NoData   = "No data from 511.org"
+	LargeGap = "Incomplete data - large gap in arrivals"
+	Limited  = "Limited schedule data available"
+)
+
+const (
+	// Longest believable wait between consecutive arrivals
+	maxGap = 40 * time.Minute
+	// Longest believable wait for the first arrival during service hours
+	maxFirst = 50 * time.Minute
+	// A single arrival sooner than this at rush hour is suspicious
+	peakSoon = 90 * time.Minute
+	// Service hours, by the agency's clock; weekend and holiday service
+	// starts later
+	firstHour        = 6
+	firstHourWeekend = 8
+	lastHour         = 22
+)
+
+// Detect returns a warning and level for a direction's sorted arrival
+// times. now must be in the agency's time zone, and weekday is true when
+// now's service day runs the weekday schedule, the only one with commute
+// peaks.
+func Detect(times []time.Time, now time.Time, weekday bool) (message, level string) {
+	if len(times) == 0 {
+		return NoData, Warning
+	}
+
+	for i := 1; i < len(times); i++ {
+		if times[i].Sub(times[i-1]) > maxGap {
+			return LargeGap, Warning
+		}
+	}
+
+	serviceStart := firstHour
+	if !weekday {
+		serviceStart = firstHourWeekend
+	}
+	first := times[0].Sub(now)
+	hour := now.Hour()
+
+	// A far-off first arrival during service hours means predictions are
+	// missing, not that nothing is coming
+	if hour >= serviceStart && hour < lastHour && first > maxFirst {
+		return Limited, Warning
+	}
+
+	// A lone prediction at rush hour is as suspicious
+	peak := weekday && ((hour >= 7 && hour <= 9) || (hour >= 16 && hour <= 19))
+	if peak && len(times) == 1 && first < peakSoon {
+		return Limited, Warning
+	}
+
+	return "", Good
+}
@@ -0,0 +1,47 @@
+package quality
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetect(t *testing.T) {
+	// A Tuesday, in whatever zone: Detect only reads the wall clock
+	peak := time.Date(2026, 2, 3, 8, 0, 0, 0, time.UTC)
+	night := time.Date(2026, 2, 3, 23, 0, 0, 0, time.UTC)
+	early := time.Date(2026, 2, 3, 7, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		weekday bool
+		after   []time.Duration
+		message string
+	}{
+		{"no data", peak, true, nil, NoData},
+		{"regular", peak, true, []time.Duration{3 * time.Minute, 11 * time.Minute}, ""},
+		{"large gap", peak, true, []time.Duration{3 * time.Minute, 50 * time.Minute}, LargeGap},
+		{"far first arrival", peak, true, []time.Duration{55 * time.Minute}, Limited},
+		{"far first arrival at night", night, true, []time.Duration{55 * time.Minute}, ""},
+		{"far first arrival before weekend service", early, false, []time.Duration{55 * time.Minute}, ""},
+		{"single arrival at peak", peak, true, []time.Duration{10 * time.Minute}, Limited},
+		{"single arrival on the weekend", peak, false, []time.Duration{10 * time.Minute}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			times := make([]time.Time, len(tt.after))
+			for i, d := range tt.after {
+				times[i] = tt.now.Add(d)
+			}
+			message, level := Detect(times, tt.now, tt.weekday)
+			want := Good
+			if tt.message != "" {
+				want = Warning
+			}
+			if message != tt.message || level != want {
+				t.Errorf("Detect = %q, %q; want %q, %q", message, level, tt.message, want)
+			}
+		})
+	}
+}
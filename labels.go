@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// directionRefNames spells out the SIRI DirectionRef codes 511 agencies use
+var directionRefNames = map[string]string{
+	"IB": "Inbound",
+	"OB": "Outbound",
+	"N":  "Northbound",
+	"S":  "Southbound",
+	"E":  "Eastbound",
+	"W":  "Westbound",
+}
+
+// derivedLabels remembers the last label derived per stop code, so a
+// direction keeps its name while no vehicles are predicted
+var derivedLabels = struct {
+	sync.Mutex
+	byStop map[string]string
+}{byStop: make(map[string]string)}
+
+// deriveDirectionLabel names a direction from its arrivals, e.g. "Inbound
+// to Caltrain", using the most common direction and destination
+func deriveDirectionLabel(arrivals []Arrival) string {
+	direction := mostCommon(arrivals, func(a Arrival) string {
+		if name, ok := directionRefNames[strings.ToUpper(a.DirectionRef)]; ok {
+			return tr(name)
+		}
+		return a.DirectionRef
+	})
+	destination := mostCommon(arrivals, func(a Arrival) string { return normalizeDestination(a.Destination) })
+
+	switch {
+	case direction != "" && destination != "":
+		return trf("%s to %s", direction, destination)
+	case destination != "":
+		return trf("To %s", destination)
+	}
+	return direction
+}
+
+// mostCommon returns the most frequent non-empty key, ties going to the
+// alphabetically first
+func mostCommon(arrivals []Arrival, key func(Arrival) string) string {
+	counts := make(map[string]int)
+	for _, a := range arrivals {
+		if k := key(a); k != "" {
+			counts[k]++
+		}
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0]
+}
+
+// directionLabel returns the configured label, or one derived from the
+// latest arrivals when the config leaves it out
+func directionLabel(dir Direction, arrivals []Arrival) string {
+	if dir.Label != "" {
+		return dir.Label
+	}
+
+	derivedLabels.Lock()
+	defer derivedLabels.Unlock()
+	if label := deriveDirectionLabel(arrivals); label != "" {
+		derivedLabels.byStop[dir.StopID] = label
+	}
+	return knownLabel(dir)
+}
+
+// knownLabel is the label last derived for a direction without one,
+// falling back to its stop code. Callers hold derivedLabels.
+func knownLabel(dir Direction) string {
+	if label := derivedLabels.byStop[dir.StopID]; label != "" {
+		return label
+	}
+	return trf("Stop %s", dir.StopID)
+}
+
+// labelledStops copies stops with derived labels filled in, for /api/config
+func labelledStops(stops []Stop) []Stop {
+	derivedLabels.Lock()
+	defer derivedLabels.Unlock()
+
+	out := make([]Stop, len(stops))
+	for i, stop := range stops {
+		out[i] = stop
+		out[i].Directions = make([]Direction, len(stop.Directions))
+		for j, dir := range stop.Directions {
+			if dir.Label == "" {
+				dir.Label = knownLabel(dir)
+			}
+			out[i].Directions[j] = dir
+		}
+	}
+	return out
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDirectionLabel(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	arrivals := []Arrival{
+		{DirectionRef: "IB", Destination: "CALTRAIN"},
+		{DirectionRef: "IB", Destination: "Caltrain"},
+		{DirectionRef: "IB", Destination: "Embarcadero"},
+	}
+
+	if got := directionLabel(Direction{Label: "Downtown", StopID: "1"}, arrivals); got != "Downtown" {
+		t.Errorf("configured label = %q", got)
+	}
+	dir := Direction{StopID: "17072"}
+	if got := directionLabel(dir, nil); got != "Stop 17072" {
+		t.Errorf("label before any data = %q", got)
+	}
+	if got := directionLabel(dir, arrivals); got != "Inbound to Caltrain" {
+		t.Errorf("derived label = %q", got)
+	}
+	// The last derived label sticks while nothing is predicted
+	if got := directionLabel(dir, nil); got != "Inbound to Caltrain" {
+		t.Errorf("label with no arrivals = %q", got)
+	}
+	if got := deriveDirectionLabel([]Arrival{{Destination: "Millbrae"}}); got != "To Millbrae" {
+		t.Errorf("label without DirectionRef = %q", got)
+	}
+}
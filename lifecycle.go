@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout is how long in-flight requests get to finish once the
+// server is asked to stop
+const shutdownTimeout = 10 * time.Second
+
+// serverCtx is cancelled when the server begins shutting down. Work that
+// isn't owned by one request, like shared dataset loads, derives from it.
+var serverCtx = context.Background()
+
+// detachedContext has the deadline and cancellation of one context and
+// the values of another
+type detachedContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+// outliveRequest is a context for work a request starts that must not be
+// cut short when the client goes away. It keeps the request's trace but
+// ends with the server.
+func outliveRequest(r *http.Request) context.Context {
+	return detachedContext{Context: serverCtx, values: r.Context()}
+}
+
+// sleepContext sleeps for d on the clock, returning early when ctx ends.
+// It reports whether ctx is still live.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if _, real := clock.(systemClock); !real {
+		// A fake clock can't be interrupted, so an ended ctx skips the sleep
+		if ctx.Err() != nil {
+			return false
+		}
+		clock.Sleep(d)
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// firstRequestGrace is how long connections accepted just before shutdown
+// get to send their first request. net/http drops a connection whose
+// first request arrives once Shutdown has begun, so shutdown waits for
+// them, and during an upgrade those clients would otherwise see errors.
+const firstRequestGrace = time.Second
+
+// serveUntilDone runs serve on ln until it fails or ctx ends. Then ln is
+// closed, and srv waits up to shutdownTimeout for open requests. During
+// an upgrade, systemd is pointed at the replacement instead of told the
+// service is stopping.
+func serveUntilDone(ctx context.Context, srv *http.Server, ln net.Listener, serve func(net.Listener) error) error {
+	srv.BaseContext = func(net.Listener) context.Context { return ctx }
+	var waiting connsWaiting
+	srv.ConnState = waiting.track
+
+	errc := make(chan error, 1)
+	go func() { errc <- serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	infof("Shutting down...")
+	sdNotify(upgradeNotice())
+	// Serve returns once the listener is closed
+	ln.Close()
+	if err := <-errc; !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	waiting.wait(firstRequestGrace)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// connsWaiting counts connections that haven't sent a request yet
+type connsWaiting struct {
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+func (c *connsWaiting) track(conn net.Conn, state http.ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state == http.StateNew {
+		if c.conns == nil {
+			c.conns = make(map[net.Conn]bool)
+		}
+		c.conns[conn] = true
+	} else {
+		delete(c.conns, conn)
+	}
+}
+
+// wait returns when every connection has sent a request or hung up, or
+// after timeout
+func (c *connsWaiting) wait(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		n := len(c.conns)
+		c.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
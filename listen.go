@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// listen opens the configured listener. The `listen` option accepts either
+// a TCP address (":8080", "127.0.0.1:8080") or "unix:/path/to/socket";
+// when unset the server listens on all interfaces at `port`.
+// A listener handed over by an upgrade is used as is.
+func listen() (net.Listener, error) {
+	return handoff.listener("main", func() (net.Listener, error) {
+		config := currentConfig()
+		if path, ok := strings.CutPrefix(config.Listen, "unix:"); ok {
+			return listenUnix(path)
+		}
+
+		addr := config.Listen
+		if addr == "" {
+			addr = fmt.Sprintf(":%d", config.Port)
+		}
+		return net.Listen("tcp", addr)
+	})
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	config := currentConfig()
+
+	// Remove a stale socket left behind by a previous run
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	var mode os.FileMode
+	if config.SocketMode != "" {
+		m, err := strconv.ParseUint(config.SocketMode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid socket_mode %q: %w", config.SocketMode, err)
+		}
+		mode = os.FileMode(m) & os.ModePerm
+	}
+	gid := -1
+	if config.SocketGroup != "" {
+		grp, err := user.LookupGroup(config.SocketGroup)
+		if err != nil {
+			return nil, fmt.Errorf("invalid socket_group: %w", err)
+		}
+		gid, _ = strconv.Atoi(grp.Gid)
+	}
+
+	// The socket is created with no more access than socket_mode gives,
+	// and only the owner's until it has its group, so it's never briefly
+	// open to more users than configured. The mask is process-wide, but
+	// this runs at startup before anything else creates files.
+	var ln net.Listener
+	var err error
+	if config.SocketMode != "" {
+		initial := mode
+		if gid >= 0 {
+			initial &= 0700
+		}
+		old := umask(int(os.ModePerm &^ initial))
+		ln, err = net.Listen("unix", path)
+		umask(old)
+	} else {
+		ln, err = net.Listen("unix", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if gid >= 0 {
+		if err := os.Chown(path, -1, gid); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set socket group: %w", err)
+		}
+	}
+	if config.SocketMode != "" {
+		if err := os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+		}
+	}
+
+	infof("Listening on unix socket %s", path)
+	return ln, nil
+}
+
+// listenURL describes the listener for startup logs
+func listenURL(ln net.Listener, scheme string) string {
+	if ln.Addr().Network() == "unix" {
+		return "unix:" + ln.Addr().String()
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return fmt.Sprintf("%s://localhost:%s", scheme, port)
+}
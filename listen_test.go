@@ -0,0 +1,79 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenUnix(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	grp, err := user.LookupGroupId(u.Gid)
+	if err != nil {
+		t.Skip(err)
+	}
+	// A permissive mask would leave the socket wider open than asked
+	old := umask(0)
+	t.Cleanup(func() { umask(old) })
+
+	tests := []struct {
+		name        string
+		mode, group string
+		want        os.FileMode
+		err         string
+	}{
+		{"mode", "0660", "", 0660, ""},
+		{"owner only", "0600", "", 0600, ""},
+		{"mode and group", "0660", grp.Name, 0660, ""},
+		{"invalid mode", "rw-rw----", "", 0, "invalid socket_mode"},
+		{"unknown group", "0660", "no-such-group-here", 0, "invalid socket_group"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := *currentConfig()
+			cfg.SocketMode, cfg.SocketGroup = tt.mode, tt.group
+			activeConfig.Store(&cfg)
+
+			path := filepath.Join(t.TempDir(), "board.sock")
+			ln, err := listenUnix(path)
+			if mask := umask(0); mask != 0 {
+				t.Errorf("umask left at %#o", mask)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want %q", err, tt.err)
+				}
+				if _, statErr := os.Stat(path); statErr == nil {
+					t.Error("socket created despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("listenUnix: %v", err)
+			}
+			defer ln.Close()
+
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fi.Mode().Perm(); got != tt.want {
+				t.Errorf("mode = %v, want %v", got, tt.want)
+			}
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok && tt.group != "" && strconv.Itoa(int(st.Gid)) != grp.Gid {
+				t.Errorf("gid = %d, want %s", st.Gid, grp.Gid)
+			}
+		})
+	}
+}
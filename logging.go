@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Log levels, from most to least verbose
+const (
+	levelDebug int32 = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(levelInfo)
+}
+
+func parseLogLevel(s string) (int32, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return levelDebug, nil
+	case "", "info":
+		return levelInfo, nil
+	case "warn", "warning":
+		return levelWarn, nil
+	case "error":
+		return levelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (use debug, info, warn, or error)", s)
+}
+
+func logf(level int32, prefix, format string, args ...interface{}) {
+	if level < logLevel.Load() {
+		return
+	}
+	// Depth 3 attributes the line to the caller of debugf/infof/...
+	log.Output(3, prefix+fmt.Sprintf(format, args...))
+}
+
+func debugf(format string, args ...interface{}) { logf(levelDebug, "DEBUG ", format, args...) }
+func infof(format string, args ...interface{})  { logf(levelInfo, "", format, args...) }
+func warnf(format string, args ...interface{})  { logf(levelWarn, "WARN ", format, args...) }
+func errorf(format string, args ...interface{}) { logf(levelError, "ERROR ", format, args...) }
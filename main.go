@@ -1,46 +1,151 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"gopkg.in/yaml.v3"
+	"muni-tracker/internal/quality"
+	"muni-tracker/pkg/go511"
 )
 
 // Config structures
 type Direction struct {
 	Label  string `yaml:"label" json:"label"`
 	StopID string `yaml:"stop_id" json:"stop_id"`
+	// More stop codes riders treat as the same place, e.g. both platforms
+	// of a station. Their arrivals are merged with stop_id's.
+	StopIDs []string `yaml:"stop_ids,omitempty" json:"stop_ids,omitempty"`
+	// Scheduled service hours, used to tell "service ended" from missing data
+	FirstDeparture ServiceTimes `yaml:"first_departure,omitempty" json:"-"`
+	LastDeparture  ServiceTimes `yaml:"last_departure,omitempty" json:"-"`
+	// Arrivals to show, overriding the global max_arrivals
+	MaxArrivals int `yaml:"max_arrivals,omitempty" json:"-"`
+	// Destinations of short-turn trips that end before the rider's stop.
+	// Matching arrivals are flagged, or dropped with hide_short_turns.
+	ShortTurns     []string `yaml:"short_turns,omitempty" json:"-"`
+	HideShortTurns bool     `yaml:"hide_short_turns,omitempty" json:"-"`
+	// Notify when the next vehicle is within a range of minutes away
+	NotifyWhen NotifyRule `yaml:"notify_when,omitempty" json:"-"`
 }
 
 type Stop struct {
-	Name       string      `yaml:"name" json:"name"`
-	Line       string      `yaml:"line" json:"line"`
-	Agency     string      `yaml:"agency" json:"agency"`
-	Directions []Direction `yaml:"directions" json:"directions"`
+	Name   string `yaml:"name" json:"name"`
+	Line   string `yaml:"line" json:"line"`
+	Agency string `yaml:"agency" json:"agency"`
+	// BART station name as BART's advisories spell it, for elevator notices
+	BARTStation string      `yaml:"bart_station,omitempty" json:"-"`
+	Directions  []Direction `yaml:"directions" json:"directions"`
 }
 
 type Config struct {
-	APIKey               string `yaml:"api_key"`
-	RefreshInterval      int    `yaml:"refresh_interval"`
-	CacheRefreshInterval int    `yaml:"cache_refresh_interval"`
-	Port                 int    `yaml:"port"`
-	Stops                []Stop `yaml:"stops"`
+	// Schema version, see configMigrations
+	Version int `yaml:"version"`
+	// Fragments merged into this file, see expandIncludes
+	Include              []string              `yaml:"include,omitempty"`
+	RemoteConfig         RemoteConfig          `yaml:"remote_config,omitempty"`
+	APIKey               string                `yaml:"api_key"`
+	APIKeyFile           string                `yaml:"api_key_file,omitempty"`
+	RefreshInterval      int                   `yaml:"refresh_interval"`
+	CacheRefreshInterval int                   `yaml:"cache_refresh_interval,omitempty"`
+	MaxArrivals          int                   `yaml:"max_arrivals,omitempty"`
+	MinutesRounding      string                `yaml:"minutes_rounding,omitempty"`
+	TimeFormat           string                `yaml:"time_format,omitempty"`
+	Language             string                `yaml:"language,omitempty"`
+	DepartedGrace        int                   `yaml:"departed_grace,omitempty"`
+	Port                 int                   `yaml:"port"`
+	Listen               string                `yaml:"listen,omitempty"`
+	SocketMode           string                `yaml:"socket_mode,omitempty"`
+	SocketGroup          string                `yaml:"socket_group,omitempty"`
+	TLS                  TLSConfig             `yaml:"tls,omitempty"`
+	HTTP2                HTTP2Config           `yaml:"http2,omitempty"`
+	Admin                AdminConfig           `yaml:"admin,omitempty"`
+	ClientKeys           []ClientKey           `yaml:"client_keys,omitempty"`
+	CORS                 CORSConfig            `yaml:"cors,omitempty"`
+	TrustedProxies       []string              `yaml:"trusted_proxies,omitempty"`
+	AccessLog            bool                  `yaml:"access_log,omitempty"`
+	SlowLog              SlowLogConfig         `yaml:"slow_log,omitempty"`
+	Metrics              bool                  `yaml:"metrics,omitempty"`
+	ErrorReporting       ErrorReportingConfig  `yaml:"error_reporting,omitempty"`
+	RateLimit            RateLimitConfig       `yaml:"rate_limit,omitempty"`
+	StaticDir            string                `yaml:"static_dir,omitempty"`
+	StaticCompress       bool                  `yaml:"static_compress,omitempty"`
+	UpstreamHourlyLimit  int                   `yaml:"upstream_hourly_limit,omitempty"`
+	Retry                RetryConfig           `yaml:"retry,omitempty"`
+	SnapshotFile         string                `yaml:"snapshot_file,omitempty"`
+	SharedCache          SharedCacheConfig     `yaml:"shared_cache,omitempty"`
+	Provider             string                `yaml:"provider,omitempty"`
+	FixturesDir          string                `yaml:"fixtures_dir,omitempty"`
+	Record               bool                  `yaml:"record,omitempty"`
+	Simulator            SimulatorConfig       `yaml:"simulator,omitempty"`
+	Exec                 ExecProviderConfig    `yaml:"exec,omitempty"`
+	Transitland          TransitlandConfig     `yaml:"transitland,omitempty"`
+	LogLevel             string                `yaml:"log_level,omitempty"`
+	SelfTest             bool                  `yaml:"selftest,omitempty"`
+	ServiceCalendar      ServiceCalendarConfig `yaml:"service_calendar,omitempty"`
+	Timezone             string                `yaml:"timezone,omitempty"`
+	DestinationNames     map[string]string     `yaml:"destination_names,omitempty"`
+	Pipeline             PipelineConfig        `yaml:"pipeline,omitempty"`
+	Weather              WeatherConfig         `yaml:"weather,omitempty"`
+	Bikeshare            BikeshareConfig       `yaml:"bikeshare,omitempty"`
+	BART                 BARTConfig            `yaml:"bart,omitempty"`
+	Trips                []TripConfig          `yaml:"trips,omitempty"`
+	GTFS                 GTFSConfig            `yaml:"gtfs,omitempty"`
+	Dashboards           []DashboardConfig     `yaml:"dashboards,omitempty"`
+	Profiles             ProfilesConfig        `yaml:"profiles,omitempty"`
+	Devices              DevicesConfig         `yaml:"devices,omitempty"`
+	Notifications        NotificationsConfig   `yaml:"notifications,omitempty"`
+	Alarms               []AlarmConfig         `yaml:"alarms,omitempty"`
+	Stops                []Stop                `yaml:"stops"`
+
+	proxies      []*net.IPNet
+	holidays     map[string]bool
+	location     *time.Location
+	destinations map[string]string
+	// When the config was loaded, for /api/config's Last-Modified
+	loadedAt time.Time
 }
 
 // API response structures
 type Arrival struct {
 	ArrivalTime string `json:"arrival_time"`
+	// ArrivalTime formatted per time_format, for clients that just print it
+	DisplayTime string `json:"display_time,omitempty"`
 	Minutes     int    `json:"minutes"`
+	Seconds     int    `json:"seconds"`
+	Status      string `json:"status,omitempty"`
+	// Status in the configured language, for display
+	StatusText  string `json:"status_text,omitempty"`
 	Destination string `json:"destination"`
 	LineType    string `json:"line_type,omitempty"`
+	// Realtime is false for predictions taken from the schedule
+	Realtime bool `json:"realtime"`
+	// ShortTurn marks a trip ending before the rider's destination
+	ShortTurn bool `json:"short_turn,omitempty"`
+	// Accessibility of the vehicle, omitted when unknown
+	Wheelchair *bool `json:"wheelchair_accessible,omitempty"`
+	Bikes      *bool `json:"bikes_allowed,omitempty"`
+
+	// GTFS trip_id, for looking up static trip data
+	TripID string `json:"-"`
+	// SIRI DirectionRef, for labelling directions the config leaves unnamed
+	DirectionRef string `json:"-"`
 }
 
 type DirectionArrivals struct {
@@ -50,142 +155,448 @@ type DirectionArrivals struct {
 	Error          string    `json:"error,omitempty"`
 	QualityWarning string    `json:"quality_warning,omitempty"`
 	QualityLevel   string    `json:"quality_level,omitempty"`
+	ServiceEnded   bool      `json:"service_ended,omitempty"`
+	ServiceResumes string    `json:"service_resumes,omitempty"`
+	// Typical minutes between vehicles, 0 if unknown
+	ApproxHeadwayMinutes int `json:"approx_headway_minutes,omitempty"`
+	// Upcoming arrivals in the cache, before limit and offset
+	Available int `json:"available"`
+	// Whether the stop itself has step-free boarding, omitted when unknown
+	WheelchairBoarding *bool `json:"wheelchair_boarding,omitempty"`
+
+	// Cache bookkeeping, exposed only through the admin cache endpoint
+	FetchedAt  time.Time `json:"-"`
+	FetchError string    `json:"-"`
+	// Last successful fetch, kept across failed ones
+	UpdatedAt time.Time `json:"-"`
+	// Cache revision at which the direction last changed
+	Revision uint64 `json:"-"`
+	// Warning a pipeline rule raised when the direction was fetched
+	RuleWarning string `json:"-"`
 }
 
 type StopArrivals struct {
 	Name       string              `json:"name"`
 	Line       string              `json:"line"`
 	Directions []DirectionArrivals `json:"directions"`
+	// Elevator and escalator outages at the stop's BART station
+	Advisories []string `json:"advisories,omitempty"`
 }
 
 type ArrivalsResponse struct {
-	Stops       []StopArrivals `json:"stops"`
-	LastUpdated string         `json:"last_updated"`
+	Stops []StopArrivals `json:"stops"`
+	// RFC3339, empty until the first refresh
+	LastUpdated        string          `json:"last_updated"`
+	LastUpdatedDisplay string          `json:"last_updated_display"`
+	Weather            *Weather        `json:"weather,omitempty"`
+	Bikeshare          []StationStatus `json:"bikeshare,omitempty"`
+
+	// When the board's refresher is next due, for ?meta=1
+	NextRefresh time.Time `json:"-"`
 }
 
 type ConfigResponse struct {
 	Stops           []Stop `json:"stops"`
 	RefreshInterval int    `json:"refresh_interval"`
+	Timezone        string `json:"timezone"`
+	TimeFormat      string `json:"time_format"`
 }
 
-// 511.org API response structures
-type MonitoredCall struct {
-	ExpectedArrivalTime   string `json:"ExpectedArrivalTime"`
-	ExpectedDepartureTime string `json:"ExpectedDepartureTime"`
-}
+// activeConfig holds the running configuration. It is replaced wholesale
+// when edited through the admin API, so readers take a snapshot with
+// currentConfig rather than holding on to fields across requests.
+var activeConfig atomic.Pointer[Config]
 
-type MonitoredVehicleJourney struct {
-	LineRef         string        `json:"LineRef"`
-	DestinationName string        `json:"DestinationName"`
-	MonitoredCall   MonitoredCall `json:"MonitoredCall"`
-}
+// configPath is the file the configuration was loaded from
+var configPath string
 
-type MonitoredStopVisit struct {
-	MonitoredVehicleJourney MonitoredVehicleJourney `json:"MonitoredVehicleJourney"`
+func currentConfig() *Config {
+	return activeConfig.Load()
 }
 
-type StopMonitoringDelivery struct {
-	MonitoredStopVisit []MonitoredStopVisit `json:"MonitoredStopVisit"`
+// Cache for arrivals data
+type ArrivalsCache struct {
+	mu          sync.RWMutex
+	data        ArrivalsResponse
+	lastFetched time.Time
+	// Counts changes to data, for long polls and delta updates
+	revision uint64
+	// Revision at which the stops or directions last changed
+	layoutRevision uint64
+	// Closed and replaced when revision moves on
+	changed chan struct{}
 }
 
-type ServiceDelivery struct {
-	StopMonitoringDelivery StopMonitoringDelivery `json:"StopMonitoringDelivery"`
-}
+var cache = &ArrivalsCache{}
 
-type APIResponse struct {
-	ServiceDelivery ServiceDelivery `json:"ServiceDelivery"`
+// Command-line overrides, applied on top of the YAML config
+type configOverrides struct {
+	ConfigPath      string
+	Port            int
+	RefreshInterval int
+	LogLevel        string
+	// Rewrite an outdated config file in the current schema
+	MigrateConfig bool
 }
 
-var config Config
+var overrides configOverrides
 
-// Shared HTTP client with connection pooling
-var httpClient = &http.Client{
-	Timeout: 15 * time.Second,
-	Transport: &http.Transport{
-		MaxIdleConns:        10,
-		MaxIdleConnsPerHost: 5,
-		IdleConnTimeout:     30 * time.Second,
-	},
+// validateOverrides rejects flag values no config could hold
+func validateOverrides(o configOverrides) error {
+	if o.Port < 0 || o.Port > 65535 {
+		return fmt.Errorf("-port %d is not a valid port", o.Port)
+	}
+	if o.RefreshInterval < 0 {
+		return fmt.Errorf("-refresh-interval cannot be negative")
+	}
+	_, err := parseLogLevel(o.LogLevel)
+	return err
 }
 
-// Cache for arrivals data
-type ArrivalsCache struct {
-	mu          sync.RWMutex
-	data        ArrivalsResponse
-	lastFetched time.Time
-}
+// applyOverrides applies command-line flags to a finalized config
+func applyOverrides(config *Config) {
+	if overrides.Port != 0 {
+		config.Port = overrides.Port
+	}
+	if overrides.RefreshInterval != 0 {
+		config.RefreshInterval = overrides.RefreshInterval
+	}
+	if overrides.LogLevel != "" {
+		config.LogLevel = overrides.LogLevel
+	}
 
-var cache = &ArrivalsCache{}
+	level, _ := parseLogLevel(config.LogLevel)
+	logLevel.Store(level)
+}
 
 func loadConfig() error {
-	configPath := "config.yaml"
+	configPath = "config.yaml"
 	if envPath := os.Getenv("CONFIG_PATH"); envPath != "" {
 		configPath = envPath
 	}
+	if overrides.ConfigPath != "" {
+		configPath = overrides.ConfigPath
+	}
 
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return fmt.Errorf("failed to parse config file: %w", err)
+	migrated, from, err := migrateConfig(data)
+	if err != nil {
+		return err
+	}
+	if from < configVersion() {
+		if overrides.MigrateConfig {
+			if err := rewriteMigratedConfig(data, migrated, from); err != nil {
+				return err
+			}
+		} else {
+			warnf("Config is version %d; upgraded in memory to %d. Run with -migrate-config to update the file", from, configVersion())
+		}
+	}
+
+	merged, err := expandIncludes(migrated, filepath.Dir(configPath))
+	if err != nil {
+		return err
+	}
+
+	cfg, err := parseConfig(merged)
+	if err != nil {
+		return err
+	}
+	if cfg.RemoteConfig.enabled() {
+		cfg = loadRemoteConfig(merged, cfg)
+	}
+
+	applyOverrides(cfg)
+	activeConfig.Store(cfg)
+	return nil
+}
+
+// parseConfig parses and validates a config document, applying defaults
+func parseConfig(data []byte) (*Config, error) {
+	data, _, err := migrateConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return config, finalizeConfig(config)
+}
+
+// validateStops checks each direction and fills in defaults
+func validateStops(stops []Stop) error {
+	for i := range stops {
+		stop := &stops[i]
+		stop.Agency = resolveAgency(stop.Agency)
+		if stop.BARTStation != "" {
+			code, ok := bartStationCode(stop.BARTStation)
+			if !ok {
+				return fmt.Errorf("stop %q: bart_station %q is not a BART station code or name", stop.Name, stop.BARTStation)
+			}
+			stop.BARTStation = code
+		}
+		for j := range stop.Directions {
+			dir := &stop.Directions[j]
+			if dir.StopID == "" && len(dir.StopIDs) > 0 {
+				dir.StopID, dir.StopIDs = dir.StopIDs[0], dir.StopIDs[1:]
+			}
+			if dir.StopID == "" {
+				return fmt.Errorf("stop %q direction %q needs a stop_id", stop.Name, dir.Label)
+			}
+			if dir.MaxArrivals < 0 {
+				return fmt.Errorf("stop %q direction %q: max_arrivals cannot be negative", stop.Name, dir.Label)
+			}
+			if err := dir.FirstDeparture.validate("first_departure"); err != nil {
+				return err
+			}
+			if err := dir.LastDeparture.validate("last_departure"); err != nil {
+				return err
+			}
+			if err := dir.NotifyWhen.validate(); err != nil {
+				return fmt.Errorf("stop %q direction %q: %w", stop.Name, dir.Label, err)
+			}
+		}
+	}
+	return nil
+}
+
+// finalizeConfig validates a config and fills in defaults
+func finalizeConfig(config *Config) error {
+	config.Version = configVersion()
+	config.loadedAt = clock.Now()
+	if err := resolveSecretFiles(config); err != nil {
+		return err
+	}
+
+	if err := validateProvider(config); err != nil {
+		return err
 	}
 
-	if config.APIKey == "" {
-		return fmt.Errorf("api_key is required in config")
+	if config.FixturesDir == "" {
+		config.FixturesDir = "fixtures"
 	}
 
 	if len(config.Stops) == 0 {
 		return fmt.Errorf("at least one stop must be configured")
 	}
 
+	if err := validateLanguage(config.Language); err != nil {
+		return err
+	}
+
+	if config.TimeFormat == "" {
+		config.TimeFormat = timeFormat12h
+	}
+
+	switch config.MinutesRounding {
+	case "":
+		config.MinutesRounding = roundFloor
+	case roundFloor, roundCeil, roundNearest:
+	default:
+		return fmt.Errorf("minutes_rounding must be %s, %s, or %s", roundFloor, roundCeil, roundNearest)
+	}
+
+	if config.DepartedGrace < 0 {
+		return fmt.Errorf("departed_grace cannot be negative")
+	}
+	if config.DepartedGrace == 0 {
+		config.DepartedGrace = int(departingWindow.Seconds())
+	}
+
+	if config.MaxArrivals < 0 {
+		return fmt.Errorf("max_arrivals cannot be negative")
+	}
+	if config.MaxArrivals == 0 {
+		config.MaxArrivals = defaultMaxArrivals
+	}
+
+	if err := validateStops(config.Stops); err != nil {
+		return err
+	}
+
 	if config.RefreshInterval == 0 {
 		config.RefreshInterval = 30
 	}
 
+	if err := validateDashboards(config); err != nil {
+		return err
+	}
+	if err := validateProfilesConfig(&config.Profiles); err != nil {
+		return err
+	}
+	if err := validateDevicesConfig(&config.Devices); err != nil {
+		return err
+	}
+	if err := validateRemoteConfig(&config.RemoteConfig); err != nil {
+		return err
+	}
+
+	if _, err := parseLogLevel(config.LogLevel); err != nil {
+		return err
+	}
+
+	if err := validateTLSConfig(&config.TLS); err != nil {
+		return err
+	}
+
+	if err := validateAdminConfig(config.Admin); err != nil {
+		return err
+	}
+
+	if err := validateClientKeys(config.ClientKeys); err != nil {
+		return err
+	}
+
+	proxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	config.proxies = proxies
+
+	holidays, err := parseHolidays(config.ServiceCalendar.Holidays)
+	if err != nil {
+		return err
+	}
+	config.holidays = holidays
+
+	if config.Timezone == "" {
+		config.Timezone = defaultTimezone
+	}
+	location, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q: %v", config.Timezone, err)
+	}
+	config.location = location
+
+	config.destinations = buildDestinationNames(config.DestinationNames)
+	if err := validatePipelineConfig(&config.Pipeline); err != nil {
+		return err
+	}
+
+	if err := validateWeatherConfig(&config.Weather); err != nil {
+		return err
+	}
+
+	if err := validateBikeshareConfig(&config.Bikeshare); err != nil {
+		return err
+	}
+
+	validateBARTConfig(&config.BART)
+
+	if err := validateTrips(config); err != nil {
+		return err
+	}
+
+	if err := validateNotificationsConfig(&config.Notifications); err != nil {
+		return err
+	}
+	if err := validateAlarms(config); err != nil {
+		return err
+	}
+	if err := validateErrorReportingConfig(&config.ErrorReporting); err != nil {
+		return err
+	}
+	if err := validateSlowLogConfig(&config.SlowLog); err != nil {
+		return err
+	}
+	if err := validateRetryConfig(&config.Retry); err != nil {
+		return err
+	}
+	if err := validateSharedCacheConfig(&config.SharedCache); err != nil {
+		return err
+	}
+	if err := validateHTTP2Config(config); err != nil {
+		return err
+	}
+
+	validateGTFSConfig(&config.GTFS)
+
+	if config.UpstreamHourlyLimit == 0 {
+		config.UpstreamHourlyLimit = 60
+	}
+
+	if err := validateRateLimitConfig(&config.RateLimit); err != nil {
+		return err
+	}
+
 	if config.Port == 0 {
 		config.Port = 8080
+		if config.TLS.enabled() {
+			config.Port = 443
+		}
 	}
 
 	return nil
 }
 
-func fetchStopArrivals(agency, stopID string) ([]Arrival, error) {
+// fetchTimeout bounds one stop's fetch, whatever the provider. A longer
+// exec.timeout is cut short by it.
+const fetchTimeout = 20 * time.Second
+
+// fetchArrivals fetches a stop's arrivals from the configured provider
+func fetchArrivals(ctx context.Context, agency, stopID string) ([]Arrival, error) {
 	if agency == "" {
 		agency = "SF"
 	}
-	url := fmt.Sprintf(
-		"https://api.511.org/transit/StopMonitoring?api_key=%s&agency=%s&stopCode=%s&format=json",
-		config.APIKey, agency, stopID,
-	)
-
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+	// Don't spend quota on a fetch nobody will use
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	return providers[currentConfig().Provider].Provider.Arrivals(ctx, agency, stopID)
+}
+
+func fetchStopArrivals(ctx context.Context, agency, stopID string) ([]Arrival, error) {
+	quota.record(clock.Now())
+	config := currentConfig()
+
+	client := go511.NewClient(config.APIKey)
+	client.HTTPClient = upstreamClient()
+
+	start := time.Now()
+	body, err := client.StopMonitoringRaw(ctx, agency, stopID)
+	took := time.Since(start)
+	debugf("StopMonitoring agency=%s stop=%s took %v trace=%s", agency, stopID, took.Round(time.Millisecond), traceID(ctx))
+	recordUpstreamFetch(agency, stopID, took, err)
+	noteSlowFetch(ctx, agency, stopID, took)
+	keepRawPayload(agency, stopID, body, err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body[:min(len(body), 100)]))
+	if config.Record {
+		if err := recordFixture(agency, stopID, body); err != nil {
+			warnf("Failed to record fixture for stop %s: %v", stopID, err)
+		}
 	}
 
-	// Strip UTF-8 BOM if present
-	body = bytes.TrimPrefix(body, []byte{0xEF, 0xBB, 0xBF})
-
-	var apiResp APIResponse
-	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	apiResp, err := go511.ParseStopMonitoring(body)
+	noteParseResult(agency, stopID, err)
+	if err != nil {
+		upstreamParseFailures.inc(agency)
+		return nil, err
 	}
 
+	return arrivalsFromResponse(apiResp), nil
+}
+
+// arrivalsFromResponse extracts arrivals from a StopMonitoring response
+func arrivalsFromResponse(apiResp *go511.StopMonitoringResponse) []Arrival {
 	arrivals := make([]Arrival, 0)
+	times := make([]time.Time, 0)
+
+	// The same vehicle can show up more than once, as separate arrival and
+	// departure records or repeated across deliveries. Keep its earliest time.
+	seen := make(map[string]int)
 
-	for _, visit := range apiResp.ServiceDelivery.StopMonitoringDelivery.MonitoredStopVisit {
+	for _, visit := range apiResp.Visits() {
 		// Use arrival time, or departure time if arrival is not available
 		timeStr := visit.MonitoredVehicleJourney.MonitoredCall.ExpectedArrivalTime
 		if timeStr == "" {
@@ -196,28 +607,89 @@ func fetchStopArrivals(agency, stopID string) ([]Arrival, error) {
 		}
 
 		// Validate the timestamp can be parsed
-		_, err := time.Parse(time.RFC3339, timeStr)
+		t, err := time.Parse(time.RFC3339, timeStr)
 		if err != nil {
 			continue
 		}
 
+		key, isTrip := vehicleKey(visit.MonitoredVehicleJourney)
+		if i, ok := seen[key]; ok && key != "" {
+			if isTrip || absDuration(t.Sub(times[i])) < sameVehicleWindow {
+				if t.Before(times[i]) {
+					arrivals[i].ArrivalTime = timeStr
+					times[i] = t
+				}
+				continue
+			}
+		}
+		seen[key] = len(arrivals)
+
+		access := vehicleAccess(visit.MonitoredVehicleJourney.VehicleFeatureRef)
 		arrivals = append(arrivals, Arrival{
-			ArrivalTime: timeStr,
-			Destination: visit.MonitoredVehicleJourney.DestinationName,
-			LineType:    visit.MonitoredVehicleJourney.LineRef,
+			ArrivalTime:  timeStr,
+			Destination:  visit.MonitoredVehicleJourney.DestinationName,
+			LineType:     visit.MonitoredVehicleJourney.LineRef,
+			Realtime:     visit.MonitoredVehicleJourney.Monitored,
+			Wheelchair:   access.Wheelchair,
+			Bikes:        access.Bikes,
+			TripID:       visit.MonitoredVehicleJourney.FramedVehicleJourneyRef.DatedVehicleJourneyRef,
+			DirectionRef: visit.MonitoredVehicleJourney.DirectionRef,
 		})
+		times = append(times, t)
+	}
+
+	return arrivals
+}
+
+// allStopIDs returns stop_id followed by any extra stop_ids
+func (d Direction) allStopIDs() []string {
+	ids := []string{d.StopID}
+	for _, id := range d.StopIDs {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
 	}
+	return ids
+}
 
-	return arrivals, nil
+// sortArrivals orders arrivals merged from several stops by time
+func sortArrivals(arrivals []Arrival) {
+	sort.SliceStable(arrivals, func(a, b int) bool {
+		ta, _ := time.Parse(time.RFC3339, arrivals[a].ArrivalTime)
+		tb, _ := time.Parse(time.RFC3339, arrivals[b].ArrivalTime)
+		return ta.Before(tb)
+	})
+}
+
+// sameVehicleWindow is how close two visits by one vehicle must be to count
+// as the same stop call. A vehicle on a loop can legitimately return later.
+const sameVehicleWindow = 5 * time.Minute
+
+// vehicleKey identifies the trip or vehicle behind a visit, or "" if the
+// feed gives neither. isTrip is true when the key names a single trip.
+func vehicleKey(j go511.MonitoredVehicleJourney) (key string, isTrip bool) {
+	if ref := j.FramedVehicleJourneyRef.DatedVehicleJourneyRef; ref != "" {
+		return "trip:" + j.LineRef + "/" + j.FramedVehicleJourneyRef.DataFrameRef + "/" + ref, true
+	}
+	if j.VehicleRef != "" {
+		return "vehicle:" + j.VehicleRef, false
+	}
+	return "", false
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
 }
 
 // detectQualityIssues analyzes arrivals and returns warning message and level
 func detectQualityIssues(arrivals []Arrival, now time.Time) (string, string) {
 	if len(arrivals) == 0 {
-		return "No data from 511.org", "warning"
+		return quality.Detect(nil, now, true)
 	}
 
-	// Parse arrival times
 	times := make([]time.Time, 0, len(arrivals))
 	for _, arr := range arrivals {
 		t, err := time.Parse(time.RFC3339, arr.ArrivalTime)
@@ -226,44 +698,121 @@ func detectQualityIssues(arrivals []Arrival, now time.Time) (string, string) {
 		}
 		times = append(times, t)
 	}
-
 	if len(times) == 0 {
-		return "", "good"
+		return "", quality.Good
 	}
 
-	// Check 1: Large gaps (>40 mins)
-	for i := 1; i < len(times); i++ {
-		gap := times[i].Sub(times[i-1]).Minutes()
-		if gap > 40 {
-			return "Incomplete data - large gap in arrivals", "warning"
-		}
+	// Service hours are judged on the transit agency's wall clock
+	return quality.Detect(times, localTime(now), serviceDayType(now) == serviceWeekday)
+}
+
+// refreshMu serializes full cache refreshes and admin triggers. Scheduled
+// batches share it, so they run side by side but never during those.
+var refreshMu sync.RWMutex
+
+// fetchDirection fetches one direction and builds its cache entry
+func fetchDirection(ctx context.Context, stop Stop, dir Direction) (result DirectionArrivals) {
+	result = DirectionArrivals{
+		Label:     directionLabel(dir, nil),
+		StopID:    dir.StopID,
+		Arrivals:  []Arrival{},
+		FetchedAt: clock.Now(),
 	}
+	// Deferred first so it runs last, seeing a recovered panic's error
+	defer func() { recordFetch(stop, dir, result) }()
+
+	// A bad upstream response must never take down the refresher
+	defer func() {
+		if r := recover(); r != nil {
+			errorf("Panic fetching %s (stop %s): %v", result.Label, dir.StopID, r)
+			reportPanic(r, map[string]string{"stop": stop.Name, "direction": result.Label, "stop_id": dir.StopID})
+			result.Arrivals = []Arrival{}
+			result.Error = "Unable to fetch"
+			result.FetchError = fmt.Sprintf("panic: %v", r)
+		}
+	}()
 
-	// Check 2: Far future first arrival during normal hours (lowered to 50 mins)
-	firstMinutes := times[0].Sub(now).Minutes()
-	hour := now.Hour()
-	isNormalHours := hour >= 6 && hour < 22
+	arrivals := make([]Arrival, 0)
+	var failed []string
+	for k, stopID := range dir.allStopIDs() {
+		if k > 0 && currentConfig().Provider == "511" {
+			sleepContext(ctx, upstreamDelay)
+		}
+		got, err := fetchArrivals(ctx, stop.Agency, stopID)
+		if err != nil {
+			warnf("Error fetching %s (stop %s): %v trace=%s", result.Label, stopID, err, traceID(ctx))
+			failed = append(failed, fmt.Sprintf("stop %s: %v", stopID, err))
+			continue
+		}
+		arrivals = append(arrivals, got...)
+	}
 
-	if isNormalHours && firstMinutes > 50 {
-		return "Limited schedule data available", "warning"
+	// A direction only fails when none of its stops answered
+	if len(failed) > 0 {
+		result.FetchError = strings.Join(failed, "; ")
+	}
+	if len(failed) == len(dir.allStopIDs()) {
+		result.Error = "Unable to fetch"
+	} else {
+		sortArrivals(arrivals)
+		batch := pipelineBatch{Stop: stop, Direction: dir, FetchedAt: result.FetchedAt, Arrivals: arrivals}
+		runPipeline(&batch)
+		arrivals = batch.Arrivals
+		result.Arrivals = arrivals
+		result.RuleWarning = batch.Warning
+		result.Label = directionLabel(dir, arrivals)
+		annotateAccessibility(stop.Agency, &result)
+		result.UpdatedAt = result.FetchedAt
+		infof("Fetched %s: %d arrivals", result.Label, len(arrivals))
 	}
 
-	// Check 3: Sparse data during peak hours
-	isPeakHours := (hour >= 7 && hour <= 9) || (hour >= 16 && hour <= 19)
-	if isPeakHours && len(times) == 1 && firstMinutes < 90 {
-		return "Limited schedule data available", "warning"
+	return result
+}
+
+// previousDirection returns the cached entry at stop i, direction j if it
+// is still for the same stop code
+func previousDirection(prev ArrivalsResponse, i, j int, stopID string) (DirectionArrivals, bool) {
+	if i >= len(prev.Stops) || j >= len(prev.Stops[i].Directions) || prev.Stops[i].Directions[j].StopID != stopID {
+		return DirectionArrivals{}, false
 	}
+	return prev.Stops[i].Directions[j], true
+}
 
-	return "", "good"
+// keepUpdatedAt carries a direction's last successful fetch time over a
+// failed fetch, so its data age keeps growing during an outage
+func keepUpdatedAt(result *DirectionArrivals, prev DirectionArrivals, ok bool) {
+	if ok && result.UpdatedAt.IsZero() {
+		result.UpdatedAt = prev.UpdatedAt
+	}
 }
 
-// refreshCache fetches all stops sequentially with delays to avoid rate limiting
+// refreshCache fetches all stops sequentially with delays to avoid rate
+// limiting. It stops early when the server shuts down.
 func refreshCache() {
-	log.Println("Refreshing arrivals cache...")
+	refreshCacheContext(newTraceContext(serverCtx))
+}
+
+// refreshCacheContext is refreshCache as part of the context's trace
+func refreshCacheContext(ctx context.Context) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	refreshCacheLocked(ctx)
+}
+
+// refreshCacheLocked is refreshCacheContext with refreshMu held
+func refreshCacheLocked(ctx context.Context) {
+	infof("Refreshing arrivals cache... trace=%s", traceID(ctx))
+	markRefreshProgress()
+
+	config := currentConfig()
+
+	cache.mu.RLock()
+	prev := cache.data
+	cache.mu.RUnlock()
 
 	response := ArrivalsResponse{
 		Stops:       make([]StopArrivals, len(config.Stops)),
-		LastUpdated: time.Now().Format("3:04:05 PM"),
+		LastUpdated: localTime(clock.Now()).Format(time.RFC3339),
 	}
 
 	for i, stop := range config.Stops {
@@ -274,103 +823,298 @@ func refreshCache() {
 		}
 
 		for j, dir := range stop.Directions {
-			response.Stops[i].Directions[j] = DirectionArrivals{
-				Label:    dir.Label,
-				StopID:   dir.StopID,
-				Arrivals: []Arrival{},
-			}
-
-			arrivals, err := fetchStopArrivals(stop.Agency, dir.StopID)
-			if err != nil {
-				response.Stops[i].Directions[j].Error = "Unable to fetch"
-				log.Printf("Error fetching %s (stop %s): %v", dir.Label, dir.StopID, err)
-			} else {
-				response.Stops[i].Directions[j].Arrivals = arrivals
-				log.Printf("Fetched %s: %d arrivals", dir.Label, len(arrivals))
+			if ctx.Err() != nil {
+				break
 			}
+			markRefreshProgress()
+			response.Stops[i].Directions[j] = fetchDirection(ctx, stop, dir)
+			last, ok := previousDirection(prev, i, j, dir.StopID)
+			keepUpdatedAt(&response.Stops[i].Directions[j], last, ok)
 
 			// Wait 1.5 seconds between API calls to avoid rate limiting
 			// 60 requests/hour = 1 per minute allowed, but we batch them
-			time.Sleep(1500 * time.Millisecond)
+			if config.Provider == "511" {
+				sleepContext(ctx, upstreamDelay)
+			}
 		}
 	}
 
-	// Update cache
-	cache.mu.Lock()
-	cache.data = response
-	cache.lastFetched = time.Now()
-	cache.mu.Unlock()
+	// A cancelled refresh would store errors for what it didn't fetch
+	if err := ctx.Err(); err != nil {
+		infof("Cache refresh abandoned: %v", err)
+		return
+	}
 
-	log.Println("Cache refresh complete")
-}
+	// Update cache
+	cache.store(response, clock.Now())
 
-// startCacheRefresher runs the cache refresh in the background
-func startCacheRefresher() {
-	// Initial fetch
-	refreshCache()
+	checkUpstream(ctx, response, config.Stops, clock.Now())
 
-	// Count total directions to calculate refresh interval
-	totalDirections := 0
-	for _, stop := range config.Stops {
-		totalDirections += len(stop.Directions)
-	}
+	markRefreshDone()
+	infof("Cache refresh complete")
+}
 
-	// Use configured interval or default to 240 seconds (4 minutes)
-	// With 60 req/hour limit: 60 / totalDirections = max refreshes per hour
-	// Example: 4 directions = 15 refreshes/hour = 4 minute intervals minimum
+// cacheRefreshInterval returns the configured interval or the default of
+// 240 seconds (4 minutes).
+// With 60 req/hour limit: 60 / totalDirections = max refreshes per hour
+// Example: 4 directions = 15 refreshes/hour = 4 minute intervals minimum
+func cacheRefreshInterval(config *Config) time.Duration {
 	refreshInterval := time.Duration(config.CacheRefreshInterval) * time.Second
 	if refreshInterval == 0 {
 		refreshInterval = 4 * time.Minute
 	}
+	return refreshInterval
+}
 
-	log.Printf("Cache will refresh every %v (%d directions)", refreshInterval, totalDirections)
+// setRefreshInterval records the interval used by the watchdog. The
+// scheduler reads the interval from the config as it plans.
+func setRefreshInterval(refreshInterval time.Duration) {
+	refresher.mu.Lock()
+	refresher.interval = refreshInterval
+	refresher.mu.Unlock()
+}
 
-	ticker := time.NewTicker(refreshInterval)
-	go func() {
-		for range ticker.C {
-			refreshCache()
-		}
-	}()
+// startCacheRefresher fetches everything once, unless a snapshot already
+// filled the cache, then leaves the cache to the scheduler until ctx ends
+func startCacheRefresher(ctx context.Context) {
+	if !cacheMatchesConfig() && leading() {
+		refreshCacheContext(newTraceContext(ctx))
+	}
+
+	refreshInterval := cacheRefreshInterval(currentConfig())
+	infof("Cache will refresh every %v (%d directions)", refreshInterval, totalDirections())
+	setRefreshInterval(refreshInterval)
+
+	go sched.loop(ctx)
 }
 
 func handleArrivals(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	if !freshen(w, r) {
+		return
+	}
+	serveArrivals(w, r, buildArrivalsPage)
+}
+
+// arrivalsSource builds the arrivals view for one dashboard
+type arrivalsSource func(now time.Time, page arrivalsPage) ArrivalsResponse
+
+func serveArrivals(w http.ResponseWriter, r *http.Request, build arrivalsSource) {
+	page, err := parseArrivalsPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	favorites, err := requestFavorites(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	now := clock.Now()
+	resp := build(now, page)
+	if favorites != nil {
+		resp = filterFavorites(resp, favorites)
+	}
+	meta, err := requestMeta(r, resp, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	setDataAge(w, resp, now)
+	setArrivalsCaching(w, r, resp, now)
+	writeNegotiated(w, r, resp, meta, func() arrivalsTable { return arrivalsTableFrom(resp) })
+}
+
+// dataAge is how long ago the stalest direction in resp was last fetched
+// successfully. Directions that never have been don't count.
+func dataAge(resp ArrivalsResponse, now time.Time) (time.Duration, bool) {
+	var oldest time.Time
+	for _, stop := range resp.Stops {
+		for _, dir := range stop.Directions {
+			if !dir.UpdatedAt.IsZero() && (oldest.IsZero() || dir.UpdatedAt.Before(oldest)) {
+				oldest = dir.UpdatedAt
+			}
+		}
+	}
+	if oldest.IsZero() {
+		return 0, false
+	}
+	return max(now.Sub(oldest), 0), true
+}
+
+// setDataAge sets Age to the data age, repeated as X-Data-Age for clients
+// behind caching proxies, which rewrite Age
+func setDataAge(w http.ResponseWriter, resp ArrivalsResponse, now time.Time) {
+	if age, ok := dataAge(resp, now); ok {
+		seconds := strconv.Itoa(int(age.Seconds()))
+		w.Header().Set("Age", seconds)
+		w.Header().Set("X-Data-Age", seconds)
+	}
+}
+
+// arrivalsPage selects a window of each direction's upcoming arrivals. A
+// zero limit means the configured max_arrivals.
+type arrivalsPage struct {
+	limit, offset int
+}
+
+// maxPageLimit caps the limit query parameter; no direction caches
+// anywhere near this many arrivals
+const maxPageLimit = 1000
+
+// parseArrivalsPage reads the optional limit and offset query parameters
+func parseArrivalsPage(r *http.Request) (arrivalsPage, error) {
+	var page arrivalsPage
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return page, fmt.Errorf("limit must be a positive integer")
+		}
+		page.limit = min(n, maxPageLimit)
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return page, fmt.Errorf("offset must be a non-negative integer")
+		}
+		page.offset = n
+	}
+	return page, nil
+}
+
+// departingWindow is how long after its time a vehicle counts as
+// "departing" rather than "departed". Refresh lag means it is often still
+// at the stop.
+const departingWindow = 30 * time.Second
+
+// departedGrace is how long past arrivals stay visible, from the
+// departed_grace config (seconds, default 30)
+func departedGrace() time.Duration {
+	if g := currentConfig().DepartedGrace; g > 0 {
+		return time.Duration(g) * time.Second
+	}
+	return departingWindow
+}
+
+// defaultMaxArrivals is how many arrivals each direction shows by default
+const defaultMaxArrivals = 3
+
+// maxArrivals returns the arrival limit for cached direction j of stop i
+func maxArrivals(stops []Stop, i, j int, stopID string) int {
+	if dir, ok := configuredDirection(stops, i, j, stopID); ok && dir.MaxArrivals > 0 {
+		return dir.MaxArrivals
+	}
+	if n := currentConfig().MaxArrivals; n > 0 {
+		return n
+	}
+	return defaultMaxArrivals
+}
+
+// Arrival statuses reported alongside the countdown
+const (
+	statusUpcoming  = "upcoming"
+	statusDue       = "due"
+	statusDeparting = "departing"
+	statusDeparted  = "departed"
+)
+
+// arrivalStatus classifies an arrival by its seconds until arrival
+func arrivalStatus(seconds int) string {
+	switch {
+	case seconds < -int(departingWindow.Seconds()):
+		return statusDeparted
+	case seconds < 0:
+		return statusDeparting
+	case seconds < 60:
+		return statusDue
+	default:
+		return statusUpcoming
+	}
+}
+
+// Ways to turn seconds until arrival into whole minutes
+const (
+	roundFloor   = "floor"
+	roundCeil    = "ceil"
+	roundNearest = "nearest"
+)
+
+// roundMinutes converts seconds until arrival to minutes. Floor shows a
+// vehicle 59 seconds out as 0 min; ceil and nearest show 1. Departed
+// vehicles are always 0.
+func roundMinutes(seconds int, mode string) int {
+	if seconds <= 0 {
+		return 0
+	}
+	switch mode {
+	case roundCeil:
+		return (seconds + 59) / 60
+	case roundNearest:
+		return (seconds + 30) / 60
+	default:
+		return seconds / 60
+	}
+}
 
+// buildArrivalsResponse builds the arrivals view from the cache, with
+// minutes recalculated against now
+func buildArrivalsResponse(now time.Time) ArrivalsResponse {
+	return buildArrivalsPage(now, arrivalsPage{})
+}
+
+// buildArrivalsPage is buildArrivalsResponse with a custom window of
+// arrivals per direction, bounded by what the cache holds
+func buildArrivalsPage(now time.Time, page arrivalsPage) ArrivalsResponse {
 	cache.mu.RLock()
 	cachedData := cache.data
 	cache.mu.RUnlock()
 
+	resp := buildArrivalsView(cachedData, currentConfig().Stops, now, page)
+	resp.NextRefresh = nextRefresh()
+	return resp
+}
+
+// buildArrivalsView recalculates cached arrivals against now. stops is the
+// configuration the cache was fetched for.
+func buildArrivalsView(cachedData ArrivalsResponse, stops []Stop, now time.Time, page arrivalsPage) ArrivalsResponse {
 	// If cache is empty, return empty response
 	if len(cachedData.Stops) == 0 {
-		response := ArrivalsResponse{
-			Stops:       make([]StopArrivals, 0),
-			LastUpdated: "Loading...",
+		return ArrivalsResponse{
+			Stops:              make([]StopArrivals, 0),
+			LastUpdatedDisplay: tr("Loading..."),
 		}
-		json.NewEncoder(w).Encode(response)
-		return
 	}
 
 	// Create a fresh response with recalculated minutes
 	response := ArrivalsResponse{
-		Stops:       make([]StopArrivals, len(cachedData.Stops)),
-		LastUpdated: time.Now().Format("3:04:05 PM"),
+		Stops:              make([]StopArrivals, len(cachedData.Stops)),
+		LastUpdated:        localTime(now).Format(time.RFC3339),
+		LastUpdatedDisplay: displayClock(now),
+		Weather:            currentWeather(),
+		Bikeshare:          currentBikeshare(),
 	}
 
-	now := time.Now()
+	rounding := currentConfig().MinutesRounding
+	grace := departedGrace()
 
 	for i, stop := range cachedData.Stops {
 		response.Stops[i] = StopArrivals{
 			Name:       stop.Name,
 			Line:       stop.Line,
 			Directions: make([]DirectionArrivals, len(stop.Directions)),
+			Advisories: stopAdvisories(stops, i, stop.Name),
 		}
 
 		for j, dir := range stop.Directions {
 			response.Stops[i].Directions[j] = DirectionArrivals{
-				Label:    dir.Label,
-				StopID:   dir.StopID,
-				Arrivals: make([]Arrival, 0),
-				Error:    dir.Error,
+				Label:              dir.Label,
+				StopID:             dir.StopID,
+				Arrivals:           make([]Arrival, 0),
+				Error:              tr(dir.Error),
+				WheelchairBoarding: dir.WheelchairBoarding,
+				UpdatedAt:          dir.UpdatedAt,
+				Revision:           dir.Revision,
 			}
 
 			// Skip if there was an error fetching this direction
@@ -378,99 +1122,293 @@ func handleArrivals(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
-			// Recalculate minutes for each arrival
+			cfgDir, configured := configuredDirection(stops, i, j, dir.StopID)
+
+			// Recalculate minutes for each arrival. Vehicles kept on the
+			// board by departed_grace are set aside, so they count towards
+			// neither the limit nor the cadence and quality checks.
 			validArrivals := make([]Arrival, 0)
+			var departed []Arrival
 			for _, arrival := range dir.Arrivals {
 				arrivalTime, err := time.Parse(time.RFC3339, arrival.ArrivalTime)
 				if err != nil {
 					continue
 				}
 
-				minutes := int(arrivalTime.Sub(now).Minutes())
-				if minutes < 0 {
-					continue // Skip arrivals in the past
+				seconds := int(arrivalTime.Sub(now).Seconds())
+				if seconds < -int(grace.Seconds()) {
+					continue // Skip arrivals that have already left
+				}
+
+				shortTurn := configured && isShortTurn(cfgDir, arrival.Destination)
+				if shortTurn && cfgDir.HideShortTurns {
+					continue
 				}
 
-				validArrivals = append(validArrivals, Arrival{
+				a := Arrival{
 					ArrivalTime: arrival.ArrivalTime,
-					Minutes:     minutes,
+					DisplayTime: displayTime(arrivalTime),
+					Minutes:     roundMinutes(seconds, rounding),
+					Seconds:     seconds,
+					Status:      arrivalStatus(seconds),
+					StatusText:  statusText(arrivalStatus(seconds)),
 					Destination: arrival.Destination,
 					LineType:    arrival.LineType,
-				})
-			}
-
-			// Remove duplicate arrivals (within 60 seconds of each other)
-			dedupedArrivals := make([]Arrival, 0)
-			for i, arrival := range validArrivals {
-				isDuplicate := false
-				if i > 0 {
-					prevTime, _ := time.Parse(time.RFC3339, validArrivals[i-1].ArrivalTime)
-					currTime, _ := time.Parse(time.RFC3339, arrival.ArrivalTime)
-					if currTime.Sub(prevTime).Seconds() < 60 {
-						isDuplicate = true
-					}
+					Realtime:    arrival.Realtime,
+					ShortTurn:   shortTurn,
+					Wheelchair:  arrival.Wheelchair,
+					Bikes:       arrival.Bikes,
 				}
-				if !isDuplicate {
-					dedupedArrivals = append(dedupedArrivals, arrival)
+				if a.Status == statusDeparted {
+					departed = append(departed, a)
+				} else {
+					validArrivals = append(validArrivals, a)
 				}
 			}
-			validArrivals = dedupedArrivals
 
-			// Limit to 3 upcoming arrivals
-			if len(validArrivals) > 3 {
-				validArrivals = validArrivals[:3]
+			// Summarize cadence from everything upcoming, before the limit
+			response.Stops[i].Directions[j].ApproxHeadwayMinutes = approxHeadway(dir.StopID, j, validArrivals)
+
+			// Quality is judged on the default window so a one-arrival
+			// display doesn't read as sparse service
+			warningMsg, qualityLevel := detectQualityIssues(validArrivals[:min(len(validArrivals), defaultMaxArrivals)], now)
+			if qualityLevel == quality.Good && dir.RuleWarning != "" {
+				warningMsg, qualityLevel = dir.RuleWarning, quality.Warning
 			}
 
-			// Detect quality issues
-			warningMsg, qualityLevel := detectQualityIssues(validArrivals, now)
+			// Limit to the requested or configured number of upcoming arrivals
+			response.Stops[i].Directions[j].Available = len(validArrivals)
+			limit := page.limit
+			if limit == 0 {
+				limit = maxArrivals(stops, i, j, dir.StopID)
+			}
+			first := min(page.offset, len(validArrivals))
+			validArrivals = validArrivals[first : first+min(limit, len(validArrivals)-first)]
+			// Departed vehicles lead the first page, keeping time order
+			if page.offset == 0 && len(departed) > 0 {
+				validArrivals = append(departed, validArrivals...)
+			}
 
 			response.Stops[i].Directions[j].Arrivals = validArrivals
-			response.Stops[i].Directions[j].QualityWarning = warningMsg
+			response.Stops[i].Directions[j].QualityWarning = tr(warningMsg)
 			response.Stops[i].Directions[j].QualityLevel = qualityLevel
+
+			// Outside scheduled hours with no arrivals to come, say when
+			// service resumes instead of warning. Vehicles still on their
+			// way after the last departure time are listed as usual.
+			if configured && response.Stops[i].Directions[j].Available == 0 {
+				if ended, resumes := serviceStatus(stops[i].Agency, cfgDir, now); ended {
+					out := &response.Stops[i].Directions[j]
+					out.ServiceEnded = true
+					out.QualityWarning, out.QualityLevel = "", quality.Good
+					if !resumes.IsZero() {
+						out.ServiceResumes = resumes.Format(time.RFC3339)
+					}
+				}
+			}
 		}
 	}
 
-	json.NewEncoder(w).Encode(response)
+	return response
 }
 
 func handleConfig(w http.ResponseWriter, r *http.Request) {
+	config := currentConfig()
+	stops, err := configStops(r, config.Stops)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if setConfigCaching(w, r, config) {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ConfigResponse{
-		Stops:           config.Stops,
+		Stops:           stops,
 		RefreshInterval: config.RefreshInterval,
+		Timezone:        config.Timezone,
+		TimeFormat:      config.TimeFormat,
 	})
 }
 
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"status":"ok"}`))
-}
-
 func main() {
+	once := flag.Bool("once", false, "fetch arrivals once, print them, and exit")
+	format := flag.String("format", "text", "output format for -once: text or json")
+	tui := flag.Bool("tui", false, "show a live arrivals board in the terminal instead of serving HTTP")
+	selftest := flag.Bool("selftest", false, "check the API key and every configured stop code, then exit")
+	flag.StringVar(&overrides.ConfigPath, "config", "", "path to the config file (overrides CONFIG_PATH)")
+	flag.IntVar(&overrides.Port, "port", 0, "port to listen on (overrides port)")
+	flag.IntVar(&overrides.RefreshInterval, "refresh-interval", 0, "frontend refresh interval in seconds (overrides refresh_interval)")
+	flag.StringVar(&overrides.LogLevel, "log-level", "", "debug, info, warn, or error (overrides log_level)")
+	flag.BoolVar(&overrides.MigrateConfig, "migrate-config", false, "rewrite an outdated config file in the current schema, keeping a backup")
+	flag.Parse()
+
+	if err := validateOverrides(overrides); err != nil {
+		log.Fatal(err)
+	}
+
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
 	if err := loadConfig(); err != nil {
+		reportFatal(ErrorReport{Kind: "config", Message: fmt.Sprintf("Configuration error: %v", err)})
 		log.Fatalf("Configuration error: %v", err)
 	}
 
-	log.Printf("Loaded config with %d stops", len(config.Stops))
+	// Interrupt or SIGTERM cancels ctx: background fetches stop, and the
+	// server finishes its open requests and exits
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serverCtx = ctx
+
+	if *selftest {
+		if currentConfig().Provider != "511" {
+			log.Fatalf("Self-test only applies to the 511 provider")
+		}
+		results := runSelfTest(ctx)
+		printSelfTest(os.Stdout, results)
+		if selfTestFailed(results) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *once {
+		// Logging is off for a clean stdout, so errors go to stderr directly
+		if err := runOnce(ctx, os.Stdout, *format); err != nil {
+			fmt.Fprintln(os.Stderr, "muni-tracker:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *tui {
+		runTUI(ctx, os.Stdout)
+		return
+	}
+
+	infof("Loaded config with %d stops", len(currentConfig().Stops))
+
+	if err := inheritHandoff(); err != nil {
+		log.Fatalf("Upgrade: %v", err)
+	}
+	ln, err := listen()
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	redirectLn := listenRedirect()
+	// When replacing a running server, wait for it to stop and save its
+	// snapshot; connections queue on the shared listeners meanwhile
+	if err := awaitPredecessor(); err != nil {
+		warnf("Upgrade: %v", err)
+	}
+
+	if path := snapshotToRestore(); path != "" {
+		if _, err := restoreSnapshot(path); err != nil {
+			warnf("Snapshot: %v; starting cold", err)
+		}
+	}
+
+	// Join the shared cache first: followers don't reach upstream, so
+	// only the leader runs the self-test
+	startCluster(ctx)
+	if cfg := currentConfig(); cfg.SelfTest && cfg.Provider == "511" && leading() {
+		logSelfTest(runSelfTest(ctx))
+	}
 
 	// Start background cache refresher
-	startCacheRefresher()
+	startCacheRefresher(ctx)
+	startWeatherRefresher(ctx)
+	startBikeshareRefresher(ctx)
+	startBARTRefresher(ctx)
+	startAlarmChecker(ctx)
+	startEmailDigest(ctx)
+	startDeviceFlusher(ctx)
+	go logStopCodeCheck(currentConfig())
+	if currentConfig().RemoteConfig.enabled() {
+		startRemoteConfigRefresher(ctx)
+	}
 
 	// API routes
-	http.HandleFunc("/api/arrivals", handleArrivals)
-	http.HandleFunc("/api/config", handleConfig)
-	http.HandleFunc("/health", handleHealth)
+	handleRoute("/api/arrivals", handleArrivals, "GET")
+	handleRoute("/api/config", handleConfig, "GET")
+	handleRoute("/api/weather", handleWeather, "GET")
+	handleRoute("/api/next", handleNext, "GET")
+	handleRoute("/api/arrivals/poll", handlePoll, "GET")
+	handleRoute("/api/arrivals/delta", handleDelta, "GET")
+	handleRoute("/api/events", handleEvents, "GET")
+	handleRoute("/api/trips", handleTrips, "GET")
+	handleRoute("/api/alarms", handleAlarms, "GET")
+	handleRoute("/api/status", handleStatus, "GET")
+	handleRoute("/api/push/key", handlePushKey, "GET")
+	handleRoute("/api/push/subscriptions", handlePushSubscriptions, "POST", "DELETE")
+	handleRoute("/api/rules/", handleUserRules, "GET", "POST", "PUT", "DELETE")
+	handleRoute("/api/stops/nearby", handleNearbyStops, "GET")
+	handleRoute("/api/stops/autocomplete", handleAutocompleteStops, "GET")
+	handleRoute("/api/lines", handleLines, "GET")
+	handleRoute("/api/agencies", handleAgencies, "GET")
+	handleRoute("/api/shapes/", handleShapes, "GET")
+	handleRoute("/api/profiles/", handleProfile, "GET", "PUT", "DELETE")
+	handleRoute("/api/devices/", handleDevice, "GET", "POST")
+	handleRoute("/health", handleHealth, "GET")
+	handleRoute("/readyz", handleReadyz, "GET")
+	handleRoute("/metrics", handleMetrics, "GET")
+
+	// Admin routes
+	handleAdmin("/api/admin/clients", handleAdminClients, "GET")
+	handleAdmin("/api/admin/refresh", handleAdminRefresh, "POST")
+	handleAdmin("/api/admin/cache", handleAdminCache, "GET", "DELETE")
+	handleAdmin("/api/admin/config", handleAdminConfig, "GET", "PUT")
+	handleAdmin("/api/admin/devices", handleAdminDevices, "GET")
+	handleAdmin("/api/admin/devices/", handleAdminDevice, "PUT", "DELETE")
+	handleAdmin("/api/debug/raw/", handleDebugRaw, "GET")
+	handleAdmin("/admin", handleAdminUI, "GET", "POST")
+
+	// Extra dashboards. Paths are fixed at startup; adding one needs a
+	// restart, while edits to an existing one apply live.
+	for _, d := range currentConfig().Dashboards {
+		http.Handle(d.Path+"/", dashboardHandler(d.Path))
+		startDashboardRefresher(ctx, d.Path)
+		infof("Serving dashboard %s/ (%d stops)", d.Path, len(d.Stops))
+	}
 
 	// Static files
-	fs := http.FileServer(http.Dir("static"))
-	http.Handle("/", fs)
-
-	addr := fmt.Sprintf(":%d", config.Port)
-	log.Printf("Server starting on http://localhost%s", addr)
-
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	handleRoute("/", staticHandler(staticFS(), currentConfig().StaticCompress).ServeHTTP, "GET")
+
+	var handler http.Handler = http.DefaultServeMux
+	handler = reportPanics(handler)
+	handler = requireClientKey(handler)
+	handler = rateLimitMiddleware(handler)
+	handler = methodMiddleware(handler)
+	handler = corsMiddleware(handler)
+	handler = requestMetricsMiddleware(handler)
+	handler = slowRequestMiddleware(handler)
+	handler = accessLogMiddleware(handler)
+	handler = traceMiddleware(handler)
+	handler = realIPMiddleware(handler)
+
+	sdNotify("READY=1")
+	startWatchdog()
+	startUpgrades(stop)
+
+	if currentConfig().TLS.enabled() {
+		err = serveTLS(ctx, ln, redirectLn, handler)
+	} else {
+		infof("Server starting on %s", listenURL(ln, "http"))
+		server := &http.Server{Handler: h2cHandler(handler)}
+		err = serveUntilDone(ctx, server, ln, server.Serve)
+	}
+	if err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
+
+	flushDeviceSightings()
+	if path := snapshotToSave(); path != "" {
+		if err := saveSnapshot(path); err != nil {
+			errorf("Saving snapshot: %v", err)
+		} else {
+			infof("Saved snapshot to %s", path)
+		}
+	}
+	leaveCluster()
+	handoffComplete()
 }
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"muni-tracker/pkg/go511"
+)
+
+func TestDetectQualityIssues(t *testing.T) {
+	loc := time.FixedZone("PST", -8*3600)
+	peak := time.Date(2026, 1, 30, 8, 0, 0, 0, loc)
+	night := time.Date(2026, 1, 30, 23, 0, 0, 0, loc)
+	sunday := time.Date(2026, 2, 1, 8, 0, 0, 0, loc)
+	holiday := time.Date(2026, 1, 19, 8, 0, 0, 0, loc)
+
+	withTestEnv(t, peak, "")
+	cfg := *currentConfig()
+	cfg.holidays = map[string]bool{"2026-01-19": true}
+	activeConfig.Store(&cfg)
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		arrivals []Arrival
+		level    string
+	}{
+		{"no data", peak, nil, "warning"},
+		{"regular", peak, arrivalsAt(peak.Add(3*time.Minute), peak.Add(11*time.Minute)), "good"},
+		{"large gap", peak, arrivalsAt(peak.Add(3*time.Minute), peak.Add(50*time.Minute)), "warning"},
+		{"far first arrival by day", peak, arrivalsAt(peak.Add(55 * time.Minute)), "warning"},
+		{"far first arrival at night", night, arrivalsAt(night.Add(55 * time.Minute)), "good"},
+		{"single arrival at peak", peak, arrivalsAt(peak.Add(10 * time.Minute)), "warning"},
+		{"single arrival on sunday morning", sunday, arrivalsAt(sunday.Add(10 * time.Minute)), "good"},
+		{"single arrival on a holiday", holiday, arrivalsAt(holiday.Add(10 * time.Minute)), "good"},
+		{"far first arrival on a holiday before service", holiday.Add(-time.Hour), arrivalsAt(holiday.Add(time.Hour)), "good"},
+		{"peak given in UTC", peak.UTC(), arrivalsAt(peak.Add(10 * time.Minute)), "warning"},
+		{"night given in UTC", night.UTC(), arrivalsAt(night.Add(55 * time.Minute)), "good"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, level := detectQualityIssues(tt.arrivals, tt.now)
+			if level != tt.level {
+				t.Errorf("level = %q, want %q", level, tt.level)
+			}
+		})
+	}
+}
+
+func TestRefreshAndRecalculateMinutes(t *testing.T) {
+	start := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	fc, ft := withTestEnv(t, start, `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:05:00Z"}}},
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:05:30Z"}}},
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:14:00Z"}}}
+	]}}}`)
+
+	refreshCache()
+	if ft.requests != 1 {
+		t.Fatalf("upstream requests = %d, want 1", ft.requests)
+	}
+
+	// Minutes are recalculated against the clock, not the fetch time
+	fc.now = start.Add(2 * time.Minute)
+	got := buildArrivalsResponse(clock.Now()).Stops[0].Directions[0].Arrivals
+
+	// The 20:05:30 arrival is a duplicate of 20:05:00
+	if len(got) != 2 {
+		t.Fatalf("got %d arrivals, want 2: %+v", len(got), got)
+	}
+	if got[0].Minutes != 3 || got[1].Minutes != 12 {
+		t.Errorf("minutes = %d, %d; want 3, 12", got[0].Minutes, got[1].Minutes)
+	}
+	if h := buildArrivalsResponse(clock.Now()).Stops[0].Directions[0].ApproxHeadwayMinutes; h != 9 {
+		t.Errorf("approx headway = %d, want 9", h)
+	}
+	if !got[0].Realtime || got[1].Realtime {
+		t.Errorf("realtime = %v, %v; want true, false", got[0].Realtime, got[1].Realtime)
+	}
+
+	// Under a minute away is due, just departed stays briefly as departing
+	fc.now = start.Add(4*time.Minute + 30*time.Second)
+	got = buildArrivalsResponse(clock.Now()).Stops[0].Directions[0].Arrivals
+	if got[0].Status != statusDue || got[0].Seconds != 30 || got[0].Minutes != 0 {
+		t.Errorf("due arrival = %+v", got[0])
+	}
+	fc.now = start.Add(5*time.Minute + 20*time.Second)
+	got = buildArrivalsResponse(clock.Now()).Stops[0].Directions[0].Arrivals
+	if got[0].Status != statusDeparting || got[0].Seconds != -20 || got[0].Minutes != 0 {
+		t.Errorf("departing arrival = %+v", got[0])
+	}
+
+	// Past arrivals drop off
+	fc.now = start.Add(7 * time.Minute)
+	got = buildArrivalsResponse(clock.Now()).Stops[0].Directions[0].Arrivals
+	if len(got) != 1 || got[0].Minutes != 7 {
+		t.Errorf("after first departure got %+v", got)
+	}
+
+	// With one arrival left the headway comes from history
+	if h := buildArrivalsResponse(clock.Now()).Stops[0].Directions[0].ApproxHeadwayMinutes; h != 9 {
+		t.Errorf("approx headway from history = %d, want 9", h)
+	}
+}
+
+func TestArrivalsFromResponseDedupesVehicles(t *testing.T) {
+	resp, err := go511.ParseStopMonitoring([]byte(`{"ServiceDelivery":{"StopMonitoringDelivery":[
+		{"MonitoredStopVisit":[
+			{"MonitoredVehicleJourney":{"LineRef":"KX","FramedVehicleJourneyRef":{"DataFrameRef":"2026-01-30","DatedVehicleJourneyRef":"101"},"MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:05:00Z"}}},
+			{"MonitoredVehicleJourney":{"LineRef":"KX","FramedVehicleJourneyRef":{"DataFrameRef":"2026-01-30","DatedVehicleJourneyRef":"101"},"MonitoredCall":{"ExpectedDepartureTime":"2026-01-30T20:06:00Z"}}},
+			{"MonitoredVehicleJourney":{"LineRef":"N","VehicleRef":"1502","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:10:00Z"}}}
+		]},
+		{"MonitoredStopVisit":[
+			{"MonitoredVehicleJourney":{"LineRef":"N","VehicleRef":"1502","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:10:30Z"}}},
+			{"MonitoredVehicleJourney":{"LineRef":"N","VehicleRef":"1502","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:50:00Z"}}},
+			{"MonitoredVehicleJourney":{"LineRef":"N","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:10:00Z"}}}
+		]}
+	]}}`))
+	if err != nil {
+		t.Fatalf("ParseStopMonitoring: %v", err)
+	}
+
+	got := arrivalsFromResponse(resp)
+	want := []string{"2026-01-30T20:05:00Z", "2026-01-30T20:10:00Z", "2026-01-30T20:50:00Z", "2026-01-30T20:10:00Z"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d arrivals, want %d: %+v", len(got), len(want), got)
+	}
+	for i, a := range got {
+		if a.ArrivalTime != want[i] {
+			t.Errorf("arrival %d = %s, want %s", i, a.ArrivalTime, want[i])
+		}
+	}
+}
+
+func TestMaxArrivals(t *testing.T) {
+	start := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	withTestEnv(t, start, "")
+
+	var times []time.Time
+	for i := 1; i <= 6; i++ {
+		times = append(times, start.Add(time.Duration(i*4)*time.Minute))
+	}
+	cache.mu.Lock()
+	cache.data = ArrivalsResponse{Stops: []StopArrivals{{
+		Name:       "Embarcadero",
+		Directions: []DirectionArrivals{{Label: "Ocean Beach", StopID: "16994", Arrivals: arrivalsAt(times...)}},
+	}}}
+	cache.mu.Unlock()
+
+	count := func() int {
+		return len(buildArrivalsResponse(start).Stops[0].Directions[0].Arrivals)
+	}
+	if n := count(); n != defaultMaxArrivals {
+		t.Errorf("default: got %d arrivals, want %d", n, defaultMaxArrivals)
+	}
+
+	cfg := *currentConfig()
+	cfg.MaxArrivals = 5
+	activeConfig.Store(&cfg)
+	if n := count(); n != 5 {
+		t.Errorf("global max 5: got %d arrivals", n)
+	}
+
+	// A direction override wins, and the cache keeps everything
+	cfg.Stops = []Stop{{Name: "Embarcadero", Directions: []Direction{{Label: "Ocean Beach", StopID: "16994", MaxArrivals: 1}}}}
+	activeConfig.Store(&cfg)
+	if n := count(); n != 1 {
+		t.Errorf("direction max 1: got %d arrivals", n)
+	}
+	if n := len(cache.data.Stops[0].Directions[0].Arrivals); n != 6 {
+		t.Errorf("cache holds %d arrivals, want 6", n)
+	}
+
+	// limit and offset override the config, bounded by the cache
+	tests := []struct {
+		query  string
+		status int
+		first  int
+		count  int
+	}{
+		{"", http.StatusOK, 4, 1},
+		{"?limit=4", http.StatusOK, 4, 4},
+		{"?limit=4&offset=4", http.StatusOK, 20, 2},
+		{"?limit=50", http.StatusOK, 4, 6},
+		{"?offset=9", http.StatusOK, 0, 0},
+		{"?limit=9223372036854775807&offset=1", http.StatusOK, 8, 5},
+		{"?limit=0", http.StatusBadRequest, 0, 0},
+		{"?offset=-1", http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleArrivals(rec, httptest.NewRequest("GET", "/api/arrivals"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%q: status %d, want %d", tt.query, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var resp ArrivalsResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		dir := resp.Stops[0].Directions[0]
+		if len(dir.Arrivals) != tt.count || dir.Available != 6 {
+			t.Errorf("%q: got %d of %d arrivals, want %d of 6", tt.query, len(dir.Arrivals), dir.Available, tt.count)
+		} else if tt.count > 0 && dir.Arrivals[0].Minutes != tt.first {
+			t.Errorf("%q: first arrival in %d min, want %d", tt.query, dir.Arrivals[0].Minutes, tt.first)
+		}
+	}
+}
+
+func TestFetchDirectionMergesStops(t *testing.T) {
+	_, ft := withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:09:00Z"}}},
+		{"MonitoredVehicleJourney":{"LineRef":"N","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:03:00Z"}}}
+	]}}}`)
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: Church & Duboce
+    line: N Judah
+    directions:
+      - label: Outbound
+        stop_ids: ["17072", "14448", "17072"]
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	dir := cfg.Stops[0].Directions[0]
+	if dir.StopID != "17072" || len(dir.allStopIDs()) != 2 {
+		t.Fatalf("stop IDs = %q + %v", dir.StopID, dir.StopIDs)
+	}
+
+	got := fetchDirection(context.Background(), cfg.Stops[0], dir)
+	if ft.requests != 2 {
+		t.Errorf("upstream requests = %d, want 2", ft.requests)
+	}
+	var times []string
+	for _, a := range got.Arrivals {
+		times = append(times, a.ArrivalTime[11:16])
+	}
+	// Both platforms report the same vehicles; the dedupe stage keeps one
+	if want := "20:03 20:09"; strings.Join(times, " ") != want {
+		t.Errorf("merged arrivals = %v, want %s", times, want)
+	}
+}
+
+func TestRoundMinutes(t *testing.T) {
+	tests := []struct {
+		seconds              int
+		floor, ceil, nearest int
+	}{
+		{-20, 0, 0, 0},
+		{0, 0, 0, 0},
+		{29, 0, 1, 0},
+		{59, 0, 1, 1},
+		{60, 1, 1, 1},
+		{90, 1, 2, 2},
+		{149, 2, 3, 2},
+	}
+	for _, tt := range tests {
+		got := []int{roundMinutes(tt.seconds, roundFloor), roundMinutes(tt.seconds, roundCeil), roundMinutes(tt.seconds, roundNearest)}
+		if got[0] != tt.floor || got[1] != tt.ceil || got[2] != tt.nearest {
+			t.Errorf("%ds: floor/ceil/nearest = %v, want %d/%d/%d", tt.seconds, got, tt.floor, tt.ceil, tt.nearest)
+		}
+	}
+
+	if _, err := parseConfig([]byte("api_key: x\nminutes_rounding: up\nstops: [{name: a, directions: [{label: b, stop_id: '1'}]}]\n")); err == nil {
+		t.Error("expected an error for an unknown rounding mode")
+	}
+}
+
+func TestDepartedGrace(t *testing.T) {
+	now := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	withTestEnv(t, now, "")
+	cache.mu.Lock()
+	cache.data = ArrivalsResponse{Stops: []StopArrivals{{
+		Name: "Embarcadero",
+		Directions: []DirectionArrivals{{Label: "Ocean Beach", StopID: "16994", Arrivals: arrivalsAt(
+			now.Add(-80*time.Second), now.Add(-20*time.Second), now.Add(5*time.Minute),
+		)}},
+	}}}
+	cache.mu.Unlock()
+
+	statuses := func() string {
+		var out []string
+		for _, a := range buildArrivalsResponse(now).Stops[0].Directions[0].Arrivals {
+			out = append(out, a.Status)
+		}
+		return strings.Join(out, " ")
+	}
+	if got := statuses(); got != "departing upcoming" {
+		t.Errorf("default grace: %s", got)
+	}
+
+	cfg := *currentConfig()
+	cfg.DepartedGrace = 90
+	activeConfig.Store(&cfg)
+	if got := statuses(); got != "departed departing upcoming" {
+		t.Errorf("90s grace: %s", got)
+	}
+
+	// Departed vehicles don't take up the limit or shorten the headway
+	cfg.MaxArrivals = 1
+	activeConfig.Store(&cfg)
+	cache.mu.Lock()
+	cache.data.Stops[0].Directions[0].Arrivals = arrivalsAt(now.Add(-80*time.Second), now.Add(2*time.Minute), now.Add(22*time.Minute))
+	cache.mu.Unlock()
+	if got := statuses(); got != "departed upcoming" {
+		t.Errorf("limit of 1: %s", got)
+	}
+	dir := buildArrivalsResponse(now).Stops[0].Directions[0]
+	if dir.Available != 2 || dir.ApproxHeadwayMinutes != 20 {
+		t.Errorf("available = %d, headway = %d; want 2, 20", dir.Available, dir.ApproxHeadwayMinutes)
+	}
+	if got := buildArrivalsPage(now, arrivalsPage{limit: 1, offset: 1}).Stops[0].Directions[0].Arrivals; len(got) != 1 || got[0].Status != statusUpcoming {
+		t.Errorf("second page = %+v", got)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	fc, ft := withTestEnv(t, time.Date(2026, 3, 13, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-03-13T20:30:00Z"}}}
+	]}}}`)
+	refreshCache()
+	_, revision := cache.revisions()
+	before := ft.requests
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Nothing is fetched for a cancelled context, and no quota is spent
+	if _, err := fetchArrivals(ctx, "SF", "16994"); !errors.Is(err, context.Canceled) {
+		t.Errorf("fetchArrivals err = %v, want context.Canceled", err)
+	}
+	refreshCacheContext(ctx)
+	if ft.requests != before {
+		t.Errorf("requests = %d after cancellation, want %d", ft.requests, before)
+	}
+	// An abandoned refresh keeps the cache as it was
+	if _, after := cache.revisions(); after != revision {
+		t.Errorf("revision = %d after an abandoned refresh, want %d", after, revision)
+	}
+
+	// The scheduler stops with its context
+	done := make(chan struct{})
+	go func() {
+		sched.loop(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler loop still running after cancellation")
+	}
+	if !sched.nextDue().IsZero() {
+		t.Error("scheduler still reports a next fetch after stopping")
+	}
+	fc.Sleep(time.Minute)
+	if ft.requests != before {
+		t.Errorf("requests = %d after the scheduler stopped, want %d", ft.requests, before)
+	}
+
+	// Work a request starts keeps its trace and outlives the client, but
+	// not the server
+	server, stop := context.WithCancel(context.Background())
+	old := serverCtx
+	serverCtx = server
+	defer func() { serverCtx = old }()
+
+	reqCtx, hangUp := context.WithCancel(withTrace(context.Background(), newTrace()))
+	req := httptest.NewRequest("GET", "/api/arrivals?fresh=1", nil).WithContext(reqCtx)
+	work := outliveRequest(req)
+	hangUp()
+	if work.Err() != nil {
+		t.Errorf("work cancelled with the request: %v", work.Err())
+	}
+	if traceID(work) != traceID(reqCtx) {
+		t.Errorf("trace = %q, want %q", traceID(work), traceID(reqCtx))
+	}
+	stop()
+	if !errors.Is(work.Err(), context.Canceled) {
+		t.Errorf("work err = %v after shutdown, want context.Canceled", work.Err())
+	}
+
+	// The server returns cleanly once its context ends
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	if err := serveUntilDone(ctx, srv, ln, srv.Serve); err != nil {
+		t.Errorf("serveUntilDone = %v", err)
+	}
+}
+
+func TestConfigOverrides(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+	oldOverrides, oldLevel, oldPath := overrides, logLevel.Load(), configPath
+	t.Cleanup(func() {
+		overrides, configPath = oldOverrides, oldPath
+		logLevel.Store(oldLevel)
+	})
+	configPath = filepath.Join(t.TempDir(), "config.yaml")
+
+	for _, tt := range []struct {
+		o   configOverrides
+		err string
+	}{
+		{configOverrides{}, ""},
+		{configOverrides{Port: 9090, RefreshInterval: 15, LogLevel: "debug"}, ""},
+		{configOverrides{Port: -1}, "not a valid port"},
+		{configOverrides{Port: 70000}, "not a valid port"},
+		{configOverrides{RefreshInterval: -5}, "cannot be negative"},
+		{configOverrides{LogLevel: "verbose"}, "unknown log level"},
+	} {
+		err := validateOverrides(tt.o)
+		if (err == nil) != (tt.err == "") || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("validateOverrides(%+v) = %v, want %q", tt.o, err, tt.err)
+		}
+	}
+
+	// Flags win over the file; settings without a flag are kept
+	local := []byte(`api_key: test
+port: 8080
+refresh_interval: 30
+log_level: warn
+remote_config: {url: "https://example.com/kiosk.yaml"}
+` + testStop)
+	cfg, err := parseConfig(local)
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	overrides = configOverrides{Port: 9090, LogLevel: "debug"}
+	applyOverrides(cfg)
+	if cfg.Port != 9090 || cfg.RefreshInterval != 30 || cfg.LogLevel != "debug" || logLevel.Load() != levelDebug {
+		t.Errorf("overridden config: port %d, refresh_interval %d, log_level %q", cfg.Port, cfg.RefreshInterval, cfg.LogLevel)
+	}
+
+	// and over a remote config applied later
+	activeConfig.Store(cfg)
+	remoteState.Lock()
+	remoteState.local = local
+	remoteState.Unlock()
+	upstreamTransport = routeTransport{"https://example.com/kiosk.yaml": "port: 7000\nrefresh_interval: 45\nlog_level: error\n"}
+	refreshRemoteConfig(context.Background())
+	if got := currentConfig(); got.Port != 9090 || got.RefreshInterval != 45 || got.LogLevel != "debug" {
+		t.Errorf("after a remote update: port %d, refresh_interval %d, log_level %q", got.Port, got.RefreshInterval, got.LogLevel)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// version is the release this binary was built from, set at build time
+// with -ldflags "-X main.version=v1.2.3"
+var version string
+
+// serverVersion is version, or else the module version or VCS revision
+// Go recorded in the binary
+func serverVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+			return s.Value[:12]
+		}
+	}
+	return "dev"
+}
+
+// ResponseMeta tells clients how fresh a response is and when it will
+// next change, so they can poll no faster than the data does
+type ResponseMeta struct {
+	// Seconds since the stalest direction was fetched successfully, null
+	// before the first fetch
+	DataAgeSeconds *int       `json:"data_age_seconds"`
+	DataUpdated    *time.Time `json:"data_updated"`
+	// When the background refresher is next due, null while unknown
+	NextRefresh    *time.Time `json:"next_refresh"`
+	QuotaLimit     int        `json:"quota_limit"`
+	QuotaRemaining int        `json:"quota_remaining"`
+	Version        string     `json:"version"`
+}
+
+// metaEnvelope wraps a response with its metadata for ?meta=1
+type metaEnvelope struct {
+	Data interface{}  `json:"data"`
+	Meta ResponseMeta `json:"meta"`
+}
+
+// nextRefresh is when the main board's refresher is next due
+func nextRefresh() time.Time {
+	if next := sched.nextDue(); !next.IsZero() {
+		return next
+	}
+	refresher.mu.Lock()
+	defer refresher.mu.Unlock()
+	if refresher.lastComplete.IsZero() || refresher.interval <= 0 {
+		return time.Time{}
+	}
+	return refresher.lastComplete.Add(refresher.interval)
+}
+
+// requestMeta returns the metadata for resp when the request asks for it
+// with ?meta=1, or nil when it doesn't
+func requestMeta(r *http.Request, resp ArrivalsResponse, now time.Time) (*ResponseMeta, error) {
+	raw := r.URL.Query().Get("meta")
+	if raw == "" {
+		return nil, nil
+	}
+	on, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("meta must be 1 or 0")
+	}
+	if !on {
+		return nil, nil
+	}
+
+	meta := &ResponseMeta{
+		NextRefresh:    optionalTime(resp.NextRefresh),
+		QuotaLimit:     currentConfig().UpstreamHourlyLimit,
+		QuotaRemaining: quota.remaining(now),
+		Version:        serverVersion(),
+	}
+	if age, ok := dataAge(resp, now); ok {
+		seconds := int(age.Seconds())
+		meta.DataAgeSeconds = &seconds
+		meta.DataUpdated = optionalTime(now.Add(-age))
+	}
+	return meta, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseMeta(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Date(2026, 3, 7, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-03-07T20:05:00Z"}}}
+	]}}}`)
+	refreshCache()
+	fc.Sleep(45 * time.Second)
+
+	get := func(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	var env struct {
+		Data ArrivalsResponse `json:"data"`
+		Meta ResponseMeta     `json:"meta"`
+	}
+	rec := get(handleArrivals, "/api/arrivals?meta=1")
+	if err := json.Unmarshal(rec.Body.Bytes(), &env); err != nil {
+		t.Fatalf("arrivals: %v %s", err, rec.Body)
+	}
+	if len(env.Data.Stops) != 1 || env.Meta.DataAgeSeconds == nil || *env.Meta.DataAgeSeconds < 45 || *env.Meta.DataAgeSeconds > 50 {
+		t.Errorf("envelope = %+v", env)
+	}
+	if env.Meta.Version == "" || env.Meta.QuotaLimit != currentConfig().UpstreamHourlyLimit || env.Meta.QuotaRemaining != quota.remaining(clock.Now()) {
+		t.Errorf("meta = %+v", env.Meta)
+	}
+
+	rec = get(handleNext, "/api/next?meta=1&fields=minutes")
+	if body := rec.Body.String(); !strings.HasPrefix(body, `{"data":{"departures":[{"minutes":4}]},"meta":{"data_age_seconds":`) {
+		t.Errorf("next = %s", body)
+	}
+
+	if rec := get(handleArrivals, "/api/arrivals?meta=0"); strings.Contains(rec.Body.String(), `"meta"`) {
+		t.Errorf("meta=0 = %s", rec.Body)
+	}
+	if rec := get(handleArrivals, "/api/arrivals?meta=please"); rec.Code != http.StatusBadRequest {
+		t.Errorf("meta=please = %d", rec.Code)
+	}
+}
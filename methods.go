@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// routeMethods lists the methods each route registered with handleRoute
+// answers. HEAD and OPTIONS are added by methodMiddleware.
+var routeMethods = make(map[string][]string)
+
+// handleRoute registers a handler for the given methods
+func handleRoute(pattern string, handler http.HandlerFunc, methods ...string) {
+	routeMethods[pattern] = methods
+	http.HandleFunc(pattern, handler)
+}
+
+// allowedMethods returns the methods a request's route answers, HEAD and
+// OPTIONS included, or nil for a route not registered with handleRoute.
+// Dashboard routes are looked up as their main-board counterparts.
+func allowedMethods(r *http.Request) []string {
+	lookup := r
+	if path := apiPath(r.URL.Path); path != r.URL.Path {
+		lookup = r.Clone(r.Context())
+		lookup.URL.Path = path
+	}
+	_, pattern := http.DefaultServeMux.Handler(lookup)
+	methods, ok := routeMethods[pattern]
+	if !ok {
+		return nil
+	}
+	allow := slices.Clone(methods)
+	if slices.Contains(allow, http.MethodGet) {
+		allow = append(allow, http.MethodHead)
+	}
+	return append(allow, http.MethodOptions)
+}
+
+// methodMiddleware answers OPTIONS with the route's methods, serves HEAD
+// from the GET handler, and turns away methods a route doesn't answer,
+// so clients that probe first get an accurate answer
+func methodMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allow := allowedMethods(r)
+		if allow == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodOptions:
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			w.WriteHeader(http.StatusNoContent)
+		case !slices.Contains(allow, r.Method):
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		case r.Method == http.MethodHead:
+			// The server drops the body for the original HEAD request
+			get := r.Clone(r.Context())
+			get.Method = http.MethodGet
+			next.ServeHTTP(w, get)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
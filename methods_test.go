@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMethods(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 3, 5, 20, 0, 0, 0, time.UTC), "")
+	cfg := *currentConfig()
+	cfg.CORS = CORSConfig{AllowedOrigins: []string{"https://widget.example.com"}}
+	activeConfig.Store(&cfg)
+
+	handleRoute("/test-methods/get", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		fmt.Fprint(w, "body")
+	}, "GET")
+	handleRoute("/test-methods/write", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}, "PUT", "DELETE")
+	srv := httptest.NewServer(corsMiddleware(methodMiddleware(http.DefaultServeMux)))
+	defer srv.Close()
+
+	do := func(method, path string, header ...string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do("HEAD", "/test-methods/get")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Method") != "GET" {
+		t.Errorf("HEAD = %d %v", resp.StatusCode, resp.Header)
+	}
+	resp = do("OPTIONS", "/test-methods/get")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("OPTIONS = %d, Allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	resp = do("POST", "/test-methods/get")
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("POST = %d, Allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	if resp := do("HEAD", "/test-methods/write"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("HEAD on a write-only route = %d", resp.StatusCode)
+	}
+
+	// Preflights list the route's own methods
+	resp = do("OPTIONS", "/test-methods/write", "Origin", "https://widget.example.com", "Access-Control-Request-Method", "PUT")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Methods") != "PUT, DELETE, OPTIONS" {
+		t.Errorf("preflight = %d, methods %q", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Methods"))
+	}
+}
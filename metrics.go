@@ -0,0 +1,362 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"muni-tracker/pkg/go511"
+)
+
+// processStart is reported as process_start_time_seconds
+var processStart = time.Now()
+
+// metricSample is one labelled value of a metric
+type metricSample struct {
+	// Appended to the family name, e.g. "_bucket"
+	suffix string
+	labels []string // name, value pairs
+	value  float64
+}
+
+// labelReplacer escapes label values per the Prometheus text format
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetric writes a metric family in the Prometheus text format
+func writeMetric(w io.Writer, name, kind, help string, samples ...metricSample) {
+	if len(samples) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, s := range samples {
+		w.Write([]byte(name + s.suffix))
+		if len(s.labels) > 0 {
+			w.Write([]byte("{"))
+			for i := 0; i+1 < len(s.labels); i += 2 {
+				if i > 0 {
+					w.Write([]byte(","))
+				}
+				fmt.Fprintf(w, `%s="%s"`, s.labels[i], labelReplacer.Replace(s.labels[i+1]))
+			}
+			w.Write([]byte("}"))
+		}
+		fmt.Fprintf(w, " %s\n", strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+// counterVec is a counter per combination of label values
+type counterVec struct {
+	mu     sync.Mutex
+	labels []string
+	values map[string]float64
+}
+
+func newCounterVec(labels ...string) *counterVec {
+	return &counterVec{labels: labels, values: make(map[string]float64)}
+}
+
+// labelKey joins label values into a map key
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs pairs label names with the values in key
+func labelPairs(names []string, key string) []string {
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, 0, 2*len(names))
+	for i, name := range names {
+		pairs = append(pairs, name, values[i])
+	}
+	return pairs
+}
+
+func (c *counterVec) add(v float64, values ...string) {
+	c.mu.Lock()
+	c.values[labelKey(values)] += v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.add(1, values...)
+}
+
+func (c *counterVec) samples() []metricSample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	samples := make([]metricSample, len(keys))
+	for i, k := range keys {
+		samples[i] = metricSample{labels: labelPairs(c.labels, k), value: c.values[k]}
+	}
+	return samples
+}
+
+// histogramVec is a histogram per combination of label values
+type histogramVec struct {
+	mu      sync.Mutex
+	labels  []string
+	buckets []float64
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := labelKey(values)
+	hist := h.values[key]
+	if hist == nil {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			hist.counts[i]++
+			break
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+// samples gives the cumulative _bucket series, then _sum and _count,
+// for each combination of labels
+func (h *histogramVec) samples() []metricSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var samples []metricSample
+	for _, k := range keys {
+		hist, pairs := h.values[k], labelPairs(h.labels, k)
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hist.counts[i]
+			samples = append(samples, metricSample{
+				suffix: "_bucket",
+				labels: append(append([]string{}, pairs...), "le", strconv.FormatFloat(le, 'g', -1, 64)),
+				value:  float64(cumulative),
+			})
+		}
+		samples = append(samples,
+			metricSample{suffix: "_bucket", labels: append(append([]string{}, pairs...), "le", "+Inf"), value: float64(hist.count)},
+			metricSample{suffix: "_sum", labels: pairs, value: hist.sum},
+			metricSample{suffix: "_count", labels: pairs, value: float64(hist.count)},
+		)
+	}
+	return samples
+}
+
+// Upstream fetch instrumentation, to tell a slow or failing 511 apart from
+// a flaky local network
+var (
+	upstreamLatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 15}
+	upstreamFetchSeconds   = newHistogramVec(upstreamLatencyBuckets, "agency", "stop_id")
+	// code is the HTTP status, or "error" when no response arrived
+	upstreamResponses     = newCounterVec("agency", "code")
+	upstreamParseFailures = newCounterVec("agency")
+	upstreamRateLimited   = newCounterVec("agency")
+)
+
+// recordUpstreamFetch records a StopMonitoring request's outcome
+func recordUpstreamFetch(agency, stopID string, took time.Duration, err error) {
+	upstreamFetchSeconds.observe(took.Seconds(), agency, stopID)
+	code := "200"
+	var httpErr *go511.HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		code = strconv.Itoa(httpErr.StatusCode)
+		if httpErr.StatusCode == http.StatusTooManyRequests {
+			upstreamRateLimited.inc(agency)
+		}
+	case err != nil:
+		code = "error"
+	}
+	upstreamResponses.inc(agency, code)
+}
+
+// Inbound request metrics. endpoint is the route pattern that served the
+// request, so tokens and file names in paths don't multiply series; client
+// is the client key's name, empty when keys aren't in use.
+var (
+	requestsTotal  = newCounterVec("endpoint", "method", "code", "client")
+	requestSeconds = newHistogramVec([]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}, "endpoint", "client")
+	responseBytes  = newHistogramVec([]float64{256, 1024, 4096, 16384, 65536, 262144, 1048576}, "endpoint", "client")
+)
+
+// routePattern names the handler that serves r
+func routePattern(r *http.Request) string {
+	if _, pattern := http.DefaultServeMux.Handler(r); pattern != "" {
+		return pattern
+	}
+	return "other"
+}
+
+// requestMetricsMiddleware records each request's count, latency and
+// response size when metrics are on
+func requestMetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !currentConfig().Metrics {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		endpoint := routePattern(r)
+		client, _ := clientKeyName(r)
+		requestsTotal.inc(endpoint, r.Method, strconv.Itoa(rec.status), client)
+		requestSeconds.observe(time.Since(start).Seconds(), endpoint, client)
+		responseBytes.observe(float64(rec.bytes), endpoint, client)
+	})
+}
+
+// cacheAgeSamples gives the age of each direction's cached data
+func cacheAgeSamples(resp ArrivalsResponse, now time.Time) []metricSample {
+	var samples []metricSample
+	for _, stop := range resp.Stops {
+		for _, dir := range stop.Directions {
+			if dir.UpdatedAt.IsZero() {
+				continue
+			}
+			samples = append(samples, metricSample{
+				labels: []string{"stop", stop.Name, "line", stop.Line, "direction", dir.Label, "stop_id", dir.StopID},
+				value:  now.Sub(dir.UpdatedAt).Seconds(),
+			})
+		}
+	}
+	return samples
+}
+
+// arrivalSamples gives each direction's upcoming arrivals as minutes
+// away, ranked from 1 for the next vehicle, up to the configured
+// max_arrivals
+func arrivalSamples(resp ArrivalsResponse) []metricSample {
+	var samples []metricSample
+	for _, stop := range resp.Stops {
+		for _, dir := range stop.Directions {
+			rank := 0
+			for _, a := range dir.Arrivals {
+				if a.Status == statusDeparted {
+					continue
+				}
+				rank++
+				samples = append(samples, metricSample{
+					labels: []string{
+						"stop", stop.Name,
+						"line", stop.Line,
+						"direction", dir.Label,
+						"stop_id", dir.StopID,
+						"rank", strconv.Itoa(rank),
+					},
+					value: float64(a.Minutes),
+				})
+			}
+		}
+	}
+	return samples
+}
+
+// handleMetrics serves /metrics for Prometheus when metrics: true
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !currentConfig().Metrics {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeMetric(w, "process_start_time_seconds", "gauge", "Start time of the process since unix epoch in seconds.",
+		metricSample{value: float64(processStart.Unix())})
+	writeMetric(w, "go_goroutines", "gauge", "Number of goroutines that currently exist.",
+		metricSample{value: float64(runtime.NumGoroutine())})
+	writeMetric(w, "go_memstats_heap_alloc_bytes", "gauge", "Number of heap bytes allocated and still in use.",
+		metricSample{value: float64(mem.HeapAlloc)})
+
+	cache.mu.RLock()
+	lastFetched, cached := cache.lastFetched, cache.data
+	cache.mu.RUnlock()
+	if !lastFetched.IsZero() {
+		writeMetric(w, "muni_cache_last_refresh_timestamp_seconds", "gauge", "When the arrivals cache was last refreshed.",
+			metricSample{value: float64(lastFetched.Unix())})
+	}
+
+	writeMetric(w, "muni_cache_age_seconds", "gauge", "Seconds since each direction was last fetched successfully.",
+		cacheAgeSamples(cached, clock.Now())...)
+
+	directions, retrying, nextFetch := queueSamples(sched.queueStatuses(clock.Now()), clock.Now())
+	writeMetric(w, "muni_scheduler_directions", "gauge", "Directions in each agency's refresh queue.", directions...)
+	writeMetric(w, "muni_scheduler_retrying_directions", "gauge", "Directions waiting to retry a failed fetch, by agency.", retrying...)
+	writeMetric(w, "muni_scheduler_next_fetch_seconds", "gauge", "Seconds until each agency's next scheduled fetch.", nextFetch...)
+
+	writeMetric(w, "muni_upstream_fetch_duration_seconds", "histogram", "Latency of upstream StopMonitoring requests.",
+		upstreamFetchSeconds.samples()...)
+	writeMetric(w, "muni_upstream_responses_total", "counter", "Upstream responses by HTTP status, or error when the request failed.",
+		upstreamResponses.samples()...)
+	writeMetric(w, "muni_upstream_parse_failures_total", "counter", "Upstream responses that could not be parsed.",
+		upstreamParseFailures.samples()...)
+	writeMetric(w, "muni_upstream_rate_limited_total", "counter", "Upstream requests refused with 429 Too Many Requests.",
+		upstreamRateLimited.samples()...)
+	writeMetric(w, "muni_upstream_retries_total", "counter", "Upstream requests retried, by host and the status or error that prompted it.",
+		upstreamRetries.samples()...)
+	writeMetric(w, "muni_event_subscribers", "gauge", "Open subscriptions to cache events, such as /api/events streams.",
+		metricSample{value: float64(events.subscribers())})
+	writeMetric(w, "muni_events_dropped_total", "counter", "Cache events a subscriber fell too far behind to receive, by kind.",
+		eventsDropped.samples()...)
+	if status := clusterStatus(); status != nil {
+		leader := 0.0
+		if status.Leading {
+			leader = 1
+		}
+		writeMetric(w, "muni_cluster_leader", "gauge", "1 while this instance holds the shared cache lease and fetches for the others.",
+			metricSample{value: leader})
+	}
+	if cfg := currentConfig(); cfg.Provider == "511" {
+		writeMetric(w, "muni_upstream_quota_remaining", "gauge", "Upstream requests left in the hourly budget.",
+			metricSample{value: float64(quota.remaining(clock.Now()))})
+	}
+
+	writeMetric(w, "muni_http_requests_total", "counter", "Requests served, by route, method, status and client key.",
+		requestsTotal.samples()...)
+	writeMetric(w, "muni_http_request_duration_seconds", "histogram", "Time to serve requests, by route and client key.",
+		requestSeconds.samples()...)
+	writeMetric(w, "muni_http_response_size_bytes", "histogram", "Response body sizes, by route and client key.",
+		responseBytes.samples()...)
+
+	writeMetric(w, "muni_next_arrival_minutes", "gauge", "Minutes until each upcoming arrival, by direction and rank.",
+		arrivalSamples(buildArrivalsPage(clock.Now(), arrivalsPage{}))...)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	start := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	withTestEnv(t, start, "")
+
+	scrape := func() (int, string) {
+		rec := httptest.NewRecorder()
+		handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Code, rec.Body.String()
+	}
+	if code, _ := scrape(); code != http.StatusNotFound {
+		t.Errorf("metrics off = %d", code)
+	}
+
+	cfg := *currentConfig()
+	cfg.Metrics = true
+	cfg.Stops[0].Name = `Church & "Duboce"`
+	activeConfig.Store(&cfg)
+	cache.mu.Lock()
+	cache.data = ArrivalsResponse{Stops: []StopArrivals{{
+		Name: cfg.Stops[0].Name,
+		Line: "N Judah",
+		Directions: []DirectionArrivals{{Label: "Ocean Beach", StopID: "16994",
+			Arrivals: arrivalsAt(start.Add(4*time.Minute+30*time.Second), start.Add(11*time.Minute+30*time.Second))}},
+	}}}
+	cache.lastFetched = start
+	cache.mu.Unlock()
+
+	code, body := scrape()
+	if code != http.StatusOK {
+		t.Fatalf("metrics = %d", code)
+	}
+	for _, want := range []string{
+		"# TYPE muni_next_arrival_minutes gauge\n",
+		`muni_next_arrival_minutes{stop="Church & \"Duboce\"",line="N Judah",direction="Ocean Beach",stop_id="16994",rank="1"} 4` + "\n",
+		`muni_next_arrival_minutes{stop="Church & \"Duboce\"",line="N Judah",direction="Ocean Beach",stop_id="16994",rank="2"} 11` + "\n",
+		"muni_cache_last_refresh_timestamp_seconds 1.7698032e+09\n",
+		"# TYPE go_goroutines gauge\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestUpstreamMetrics(t *testing.T) {
+	_, ft := withTestEnv(t, time.Now(), "not json")
+	cfg := *currentConfig()
+	cfg.Metrics = true
+	activeConfig.Store(&cfg)
+
+	count := func(c *counterVec, values ...string) float64 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.values[labelKey(values)]
+	}
+	ok, limited, parse := count(upstreamResponses, "SF", "200"), count(upstreamRateLimited, "SF"), count(upstreamParseFailures, "SF")
+
+	if _, err := fetchStopArrivals(context.Background(), "SF", "90001"); err == nil {
+		t.Fatal("unparseable body accepted")
+	}
+	upstreamTransport = &recordTransport{status: http.StatusTooManyRequests}
+	fetchStopArrivals(context.Background(), "SF", "90001")
+	upstreamTransport = ft
+
+	if got := count(upstreamResponses, "SF", "200") - ok; got != 1 {
+		t.Errorf("200 responses = %v", got)
+	}
+	if got := count(upstreamResponses, "SF", "429"); got < 1 {
+		t.Errorf("429 responses = %v", got)
+	}
+	if got := count(upstreamRateLimited, "SF") - limited; got != 1 {
+		t.Errorf("rate limited = %v", got)
+	}
+	if got := count(upstreamParseFailures, "SF") - parse; got != 1 {
+		t.Errorf("parse failures = %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE muni_upstream_fetch_duration_seconds histogram\n",
+		`muni_upstream_fetch_duration_seconds_bucket{agency="SF",stop_id="90001",le="+Inf"} 2` + "\n",
+		`muni_upstream_fetch_duration_seconds_count{agency="SF",stop_id="90001"} 2` + "\n",
+		`muni_upstream_rate_limited_total{agency="SF"} `,
+		"muni_upstream_quota_remaining ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	cfg := *currentConfig()
+	cfg.Metrics = true
+	cfg.ClientKeys = []ClientKey{{Name: "hallway-kiosk", Key: "k1"}}
+	activeConfig.Store(&cfg)
+
+	http.HandleFunc("/test-metrics/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	handler := requestMetricsMiddleware(requireClientKey(http.DefaultServeMux))
+	for _, path := range []string{"/test-metrics/a?key=k1", "/test-metrics/b?key=k1", "/test-metrics/c"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/next", nil))
+
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`muni_http_requests_total{endpoint="/test-metrics/",method="GET",code="200",client="hallway-kiosk"} 2` + "\n",
+		`muni_http_requests_total{endpoint="/test-metrics/",method="GET",code="200",client=""} 1` + "\n",
+		`muni_http_requests_total{endpoint="other",method="GET",code="401",client=""} 1` + "\n",
+		`muni_http_request_duration_seconds_count{endpoint="/test-metrics/",client="hallway-kiosk"} 2` + "\n",
+		`muni_http_response_size_bytes_bucket{endpoint="/test-metrics/",client="hallway-kiosk",le="256"} 2` + "\n",
+		`muni_http_response_size_bytes_sum{endpoint="/test-metrics/",client="hallway-kiosk"} 10` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestDataAge(t *testing.T) {
+	start := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	fc, ft := withTestEnv(t, start, `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:15:00Z"}}}
+	]}}}`)
+	cfg := *currentConfig()
+	cfg.Metrics = true
+	activeConfig.Store(&cfg)
+
+	refreshCache()
+	fc.now = start.Add(3 * time.Minute)
+	ft.body = "upstream down"
+	refreshCache()
+	fc.now = start.Add(4 * time.Minute)
+
+	rec := httptest.NewRecorder()
+	handleArrivals(rec, httptest.NewRequest("GET", "/api/arrivals", nil))
+	if age, dataAge := rec.Header().Get("Age"), rec.Header().Get("X-Data-Age"); age != "240" || dataAge != "240" {
+		t.Errorf("Age = %q, X-Data-Age = %q; want 240", age, dataAge)
+	}
+
+	rec = httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `muni_cache_age_seconds{stop="Embarcadero",line="N Judah",direction="Ocean Beach",stop_id="16994"} 240`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("metrics missing %q:\n%s", want, rec.Body.String())
+	}
+
+	// Fresh data resets the age
+	ft.body = `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`
+	refreshCache()
+	rec = httptest.NewRecorder()
+	handleArrivals(rec, httptest.NewRequest("GET", "/api/arrivals", nil))
+	if age := rec.Header().Get("Age"); age != "0" && age != "1" {
+		t.Errorf("Age after recovery = %q", age)
+	}
+}
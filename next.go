@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+)
+
+// NextDeparture is one arrival in the merged view, labelled with where it
+// leaves from
+type NextDeparture struct {
+	Stop      string `json:"stop"`
+	Line      string `json:"line"`
+	Direction string `json:"direction"`
+	Arrival
+}
+
+type NextResponse struct {
+	Departures []NextDeparture `json:"departures"`
+	// One sentence about the soonest departure, for voice assistants
+	Summary            string `json:"summary"`
+	LastUpdated        string `json:"last_updated"`
+	LastUpdatedDisplay string `json:"last_updated_display"`
+}
+
+// defaultNextLimit is how many departures /api/next returns by default
+const defaultNextLimit = 5
+
+// nextDepartures merges every direction's arrivals into one list, soonest
+// first, and returns the requested window of it
+func nextDepartures(resp ArrivalsResponse, page arrivalsPage) []NextDeparture {
+	out := make([]NextDeparture, 0)
+	for _, stop := range resp.Stops {
+		for _, dir := range stop.Directions {
+			for _, a := range dir.Arrivals {
+				if a.Status == statusDeparted {
+					continue
+				}
+				out = append(out, NextDeparture{Stop: stop.Name, Line: stop.Line, Direction: dir.Label, Arrival: a})
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Seconds < out[j].Seconds })
+
+	limit := page.limit
+	if limit == 0 {
+		limit = defaultNextLimit
+	}
+	first := min(page.offset, len(out))
+	return out[first : first+min(limit, len(out)-first)]
+}
+
+// nextSummary describes the soonest departure in plain words
+func nextSummary(departures []NextDeparture) string {
+	if len(departures) == 0 {
+		return tr("No upcoming departures.")
+	}
+	d := departures[0]
+	when := trf("in %d minutes", d.Minutes)
+	switch {
+	case d.Status == statusDeparting:
+		when = tr("departing now")
+	case d.Status == statusDue:
+		when = tr("due now")
+	case d.Minutes == 1:
+		when = tr("in 1 minute")
+	}
+	return trf("%s to %s from %s, %s.", d.Line, d.Direction, d.Stop, when)
+}
+
+func handleNext(w http.ResponseWriter, r *http.Request) {
+	if !freshen(w, r) {
+		return
+	}
+	serveNext(w, r, buildArrivalsPage)
+}
+
+func serveNext(w http.ResponseWriter, r *http.Request, build arrivalsSource) {
+	page, err := parseArrivalsPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	favorites, err := requestFavorites(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// Take everything cached so no direction's later arrivals are cut
+	// before the merge
+	now := clock.Now()
+	arrivals := build(now, arrivalsPage{limit: math.MaxInt})
+	if favorites != nil {
+		arrivals = filterFavorites(arrivals, favorites)
+	}
+	departures := nextDepartures(arrivals, page)
+	meta, err := requestMeta(r, arrivals, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	setDataAge(w, arrivals, now)
+	setArrivalsCaching(w, r, arrivals, now)
+	resp := NextResponse{
+		Departures:         departures,
+		Summary:            nextSummary(departures),
+		LastUpdated:        arrivals.LastUpdated,
+		LastUpdatedDisplay: arrivals.LastUpdatedDisplay,
+	}
+	writeNegotiated(w, r, resp, meta, func() arrivalsTable { return nextTableFrom(resp) })
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNextDepartures(t *testing.T) {
+	resp := ArrivalsResponse{Stops: []StopArrivals{
+		{Name: "Powell", Line: "F Market", Directions: []DirectionArrivals{
+			{Label: "Castro", Arrivals: []Arrival{{Minutes: 6, Seconds: 360}, {Minutes: 14, Seconds: 840}}},
+		}},
+		{Name: "Embarcadero", Line: "N Judah", Directions: []DirectionArrivals{
+			{Label: "Ocean Beach", Arrivals: []Arrival{{Minutes: 2, Seconds: 150}, {Minutes: 9, Seconds: 540}}},
+		}},
+	}}
+
+	got := nextDepartures(resp, arrivalsPage{limit: 3})
+	var order []string
+	for _, d := range got {
+		order = append(order, fmt.Sprintf("%s/%d", d.Direction, d.Minutes))
+	}
+	if want := "Ocean Beach/2 Castro/6 Ocean Beach/9"; strings.Join(order, " ") != want {
+		t.Errorf("order = %v, want %s", order, want)
+	}
+	if s := nextSummary(got); s != "N Judah to Ocean Beach from Embarcadero, in 2 minutes." {
+		t.Errorf("summary = %q", s)
+	}
+	if got := nextDepartures(resp, arrivalsPage{offset: 3}); len(got) != 1 || got[0].Minutes != 14 {
+		t.Errorf("offset 3 = %+v", got)
+	}
+	if got := nextDepartures(resp, arrivalsPage{limit: math.MaxInt, offset: 1}); len(got) != 3 {
+		t.Errorf("max limit from offset 1 = %+v", got)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// NotificationsConfig lists where alerts such as leave-now alarms are sent
+type NotificationsConfig struct {
+	Webhooks []WebhookConfig `yaml:"webhooks,omitempty"`
+	Email    EmailConfig     `yaml:"email,omitempty"`
+	Discord  []DiscordConfig `yaml:"discord,omitempty"`
+	Apprise  AppriseConfig   `yaml:"apprise,omitempty"`
+	WebPush  WebPushConfig   `yaml:"web_push,omitempty"`
+	// Rules household members manage for themselves over the API
+	UserRules UserRulesConfig `yaml:"user_rules,omitempty"`
+	// Alert on the channels above when an agency's feed stays down
+	OpsAlert OpsAlertConfig `yaml:"ops_alert,omitempty"`
+	// Message templates for every channel without its own
+	Template MessageTemplate `yaml:"template,omitempty"`
+}
+
+// WebhookConfig receives each notification as a JSON POST
+type WebhookConfig struct {
+	URL      string          `yaml:"url"`
+	Template MessageTemplate `yaml:"template,omitempty"`
+}
+
+func (n NotificationsConfig) enabled() bool {
+	return len(n.Webhooks) > 0 || n.Email.enabled() || len(n.Discord) > 0 || n.Apprise.enabled() || n.WebPush.enabled()
+}
+
+func validateNotificationsConfig(n *NotificationsConfig) error {
+	for i, hook := range n.Webhooks {
+		u, err := url.Parse(hook.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("notifications.webhooks[%d]: %q is not an http(s) URL", i, hook.URL)
+		}
+	}
+	if err := validateDiscordConfig(n.Discord); err != nil {
+		return err
+	}
+	if err := validateAppriseConfig(&n.Apprise); err != nil {
+		return err
+	}
+	if err := validateWebPushConfig(&n.WebPush); err != nil {
+		return err
+	}
+	if err := validateUserRulesConfig(n); err != nil {
+		return err
+	}
+	if err := validateOpsAlertConfig(&n.OpsAlert); err != nil {
+		return err
+	}
+	if err := compileTemplates(n); err != nil {
+		return err
+	}
+	return validateEmailConfig(&n.Email)
+}
+
+// Notification is one message for the configured channels
+type Notification struct {
+	// What raised it, e.g. "alarm"
+	Kind    string `json:"kind"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	Time    string `json:"time"`
+
+	// Details of the vehicle the notification is about, if any
+	Stop        string `json:"stop,omitempty"`
+	Direction   string `json:"direction,omitempty"`
+	Line        string `json:"line,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Minutes     *int   `json:"minutes,omitempty"`
+	// The direction's data quality when the notification was raised
+	QualityLevel   string `json:"quality_level,omitempty"`
+	QualityWarning string `json:"quality_warning,omitempty"`
+	// The arrival that triggered it, for message templates
+	Arrival Arrival `json:"-"`
+
+	// Notifications sharing a dedupe key are sent at most once per
+	// cool-down
+	key      string
+	cooldown time.Duration
+	// Profile token whose Web Push subscriptions alone receive it
+	user string
+}
+
+// notifier delivers notifications to one channel
+type notifier interface {
+	send(ctx context.Context, n Notification) error
+	String() string
+}
+
+// notifiers builds the channels configured in cfg, each with its message
+// templates
+func notifiers(cfg *Config) []notifier {
+	n := cfg.Notifications
+	var out []notifier
+	add := func(ch notifier, tmpl MessageTemplate) {
+		out = append(out, templatedNotifier{ch, tmpl.or(n.Template)})
+	}
+	for _, hook := range n.Webhooks {
+		add(webhookNotifier{hook.URL}, hook.Template)
+	}
+	for _, d := range n.Discord {
+		add(discordNotifier{d}, d.Template)
+	}
+	if n.Apprise.enabled() {
+		add(appriseNotifier{n.Apprise}, n.Apprise.Template)
+	}
+	if n.WebPush.enabled() {
+		add(webPushNotifier{cfg: n.WebPush}, n.WebPush.Template)
+	}
+	if n.Email.enabled() {
+		add(emailNotifier{n.Email}, n.Email.Template)
+	}
+	return out
+}
+
+// notify sends n to every configured channel, logging failures. It reports
+// whether any channel accepted it.
+func notify(ctx context.Context, n Notification) bool {
+	now := clock.Now()
+	if coolingDown(n.key, now) {
+		debugf("Notification %q suppressed: %q sent within its cool-down", n.Title, n.key)
+		return false
+	}
+
+	cfg := currentConfig()
+	channels := notifiers(cfg)
+	if n.user != "" {
+		wp := cfg.Notifications.WebPush
+		channels = []notifier{templatedNotifier{webPushNotifier{cfg: wp, user: n.user}, wp.Template.or(cfg.Notifications.Template)}}
+	}
+	if len(channels) == 0 {
+		warnf("Notification %q not sent: no notification channels configured", n.Title)
+		return false
+	}
+	delivered := false
+	for _, ch := range channels {
+		if err := ch.send(ctx, n); err != nil {
+			warnf("Notification %q to %s failed: %v", n.Title, ch, err)
+			continue
+		}
+		delivered = true
+	}
+	if delivered {
+		infof("Sent notification: %s", n.Title)
+		notificationLog.Lock()
+		notificationLog.sent = append(notificationLog.sent, n)
+		if len(notificationLog.sent) > notificationLogSize {
+			notificationLog.sent = notificationLog.sent[1:]
+		}
+		notificationLog.Unlock()
+
+		if n.key != "" {
+			cooldowns.Lock()
+			cooldowns.until[n.key] = now.Add(n.cooldown)
+			cooldowns.Unlock()
+		}
+	}
+	return delivered
+}
+
+// cooldowns holds, per dedupe key, when the next notification may go out
+var cooldowns = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{until: make(map[string]time.Time)}
+
+func coolingDown(key string, now time.Time) bool {
+	if key == "" {
+		return false
+	}
+	cooldowns.Lock()
+	defer cooldowns.Unlock()
+	return now.Before(cooldowns.until[key])
+}
+
+// notificationLogSize bounds the delivered notifications kept for digests
+const notificationLogSize = 200
+
+var notificationLog struct {
+	sync.Mutex
+	sent []Notification
+}
+
+// sentSince returns the notifications delivered since t, oldest first
+func sentSince(t time.Time) []Notification {
+	notificationLog.Lock()
+	defer notificationLog.Unlock()
+	var out []Notification
+	for _, n := range notificationLog.sent {
+		if at, err := time.Parse(time.RFC3339, n.Time); err == nil && !at.Before(t) {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+type webhookNotifier struct {
+	url string
+}
+
+func (w webhookNotifier) String() string {
+	u, err := url.Parse(w.url)
+	if err != nil {
+		return "webhook"
+	}
+	return "webhook " + u.Host
+}
+
+// postJSON posts a JSON body upstream as part of ctx
+func postJSON(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return upstreamClient().Do(req)
+}
+
+func (w webhookNotifier) send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := postJSON(ctx, w.url, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// errAllFetchesFailed is returned by runOnce when no direction could be
+// fetched, so a cron job or script can tell an outage from no arrivals
+var errAllFetchesFailed = errors.New("every direction failed to fetch")
+
+// runOnce fetches every configured direction a single time and prints the
+// arrivals, for use from cron, shell scripts, and status bars. The result
+// is printed even when every fetch failed, along with the error.
+func runOnce(ctx context.Context, w io.Writer, format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format %q (use text or json)", format)
+	}
+
+	// Keep stdout clean for the result
+	log.SetOutput(io.Discard)
+
+	refreshCacheContext(newTraceContext(ctx))
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	response := buildArrivalsResponse(clock.Now())
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(response); err != nil {
+			return err
+		}
+	} else {
+		writeArrivalsText(w, arrivalsTableFrom(response))
+	}
+
+	if allFailed(response) {
+		return errAllFetchesFailed
+	}
+	return nil
+}
+
+// allFailed reports whether every direction in resp has an error
+func allFailed(resp ArrivalsResponse) bool {
+	n := 0
+	for _, stop := range resp.Stops {
+		for _, dir := range stop.Directions {
+			if dir.Error == "" {
+				return false
+			}
+			n++
+		}
+	}
+	return n > 0
+}
+
+// formatDirectionText renders a direction as "3, 12, 25 min"
+func formatDirectionText(dir DirectionArrivals) string {
+	if dir.Error != "" {
+		return dir.Error
+	}
+	if len(dir.Arrivals) == 0 && dir.ServiceEnded {
+		return serviceEndedText(dir)
+	}
+	if len(dir.Arrivals) == 0 {
+		return tr("No arrivals")
+	}
+
+	mins := make([]string, len(dir.Arrivals))
+	for i, a := range dir.Arrivals {
+		switch a.Status {
+		case statusDue, statusDeparting, statusDeparted:
+			mins[i] = strings.ToLower(a.StatusText)
+		default:
+			mins[i] = strconv.Itoa(a.Minutes)
+		}
+	}
+	text := strings.Join(mins, ", ") + " min"
+	if dir.QualityWarning != "" {
+		text += " (" + dir.QualityWarning + ")"
+	}
+	return text
+}
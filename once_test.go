@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunOnce(t *testing.T) {
+	_, ft := withTestEnv(t, time.Date(2026, 3, 12, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-03-12T20:07:00Z"}}}
+	]}}}`)
+	logs := log.Writer()
+	t.Cleanup(func() { log.SetOutput(logs) })
+
+	var out bytes.Buffer
+	if err := runOnce(context.Background(), &out, "text"); err != nil {
+		t.Fatalf("runOnce: %v", err)
+	}
+	if !strings.Contains(out.String(), "Embarcadero") || !strings.Contains(out.String(), "  Ocean Beach: 6 min\n") {
+		t.Errorf("text output:\n%s", out.String())
+	}
+
+	// Every fetch failing is an error, with the failures still printed
+	ft.body = "unavailable"
+	out.Reset()
+	err := runOnce(context.Background(), &out, "json")
+	if !errors.Is(err, errAllFetchesFailed) {
+		t.Errorf("runOnce with every fetch failing = %v", err)
+	}
+	var resp ArrivalsResponse
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil || len(resp.Stops) != 1 || resp.Stops[0].Directions[0].Error == "" {
+		t.Errorf("json output (%v):\n%s", err, out.String())
+	}
+
+	if err := runOnce(context.Background(), &out, "xml"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OpsAlertConfig warns when an agency's feed is down, e.g. a revoked API
+// key or a 511 outage, before anyone relies on a frozen board
+type OpsAlertConfig struct {
+	// Minutes every fetch for an agency must have failed before alerting;
+	// off when 0
+	DownAfter int `yaml:"down_after,omitempty"`
+}
+
+func (o OpsAlertConfig) enabled() bool {
+	return o.DownAfter > 0
+}
+
+func validateOpsAlertConfig(o *OpsAlertConfig) error {
+	if o.DownAfter < 0 {
+		return fmt.Errorf("notifications.ops_alert.down_after cannot be negative")
+	}
+	return nil
+}
+
+// agencyOutage tracks an agency whose fetches are all failing
+type agencyOutage struct {
+	since     time.Time
+	lastError string
+	alerted   bool
+}
+
+var upstreamOutages = struct {
+	sync.Mutex
+	down map[string]*agencyOutage
+}{down: make(map[string]*agencyOutage)}
+
+// agencyName is the operator code a stop is fetched from
+func agencyName(stop Stop) string {
+	if stop.Agency == "" {
+		return "SF"
+	}
+	return stop.Agency
+}
+
+// checkUpstream records which agencies answered during a refresh and
+// sends an ops alert for any that have been down for down_after minutes,
+// then another when it recovers. An agency is down when every one of its
+// directions failed.
+func checkUpstream(ctx context.Context, resp ArrivalsResponse, stops []Stop, now time.Time) {
+	answered := make(map[string]bool)
+	lastError := make(map[string]string)
+	for i, stop := range stops {
+		if i >= len(resp.Stops) {
+			break
+		}
+		agency := agencyName(stop)
+		if _, seen := answered[agency]; !seen {
+			answered[agency] = false
+		}
+		for _, dir := range resp.Stops[i].Directions {
+			if dir.Error == "" {
+				answered[agency] = true
+			} else if dir.FetchError != "" {
+				lastError[agency] = dir.FetchError
+			}
+		}
+	}
+
+	ops := currentConfig().Notifications.OpsAlert
+	var send []Notification
+
+	upstreamOutages.Lock()
+	for agency, ok := range answered {
+		outage := upstreamOutages.down[agency]
+		switch {
+		case ok && outage != nil:
+			delete(upstreamOutages.down, agency)
+			if outage.alerted {
+				infof("Upstream: %s recovered after %v", agency, now.Sub(outage.since).Round(time.Minute))
+				send = append(send, Notification{
+					Kind:    "ops",
+					Title:   fmt.Sprintf("%s arrivals are back", agency),
+					Message: fmt.Sprintf("Fetches for %s are succeeding again after %v.", agency, now.Sub(outage.since).Round(time.Minute)),
+					Time:    localTime(now).Format(time.RFC3339),
+				})
+			}
+		case !ok:
+			if outage == nil {
+				outage = &agencyOutage{since: now}
+				upstreamOutages.down[agency] = outage
+			}
+			outage.lastError = lastError[agency]
+			if ops.enabled() && !outage.alerted && now.Sub(outage.since) >= time.Duration(ops.DownAfter)*time.Minute {
+				outage.alerted = true
+				errorf("Upstream: every fetch for %s has failed since %s", agency, localTime(outage.since).Format("15:04"))
+				send = append(send, Notification{
+					Kind:    "ops",
+					Title:   fmt.Sprintf("%s arrivals unavailable", agency),
+					Message: fmt.Sprintf("Every fetch for %s has failed since %s: %s", agency, localTime(outage.since).Format("15:04"), outage.lastError),
+					Time:    localTime(now).Format(time.RFC3339),
+				})
+			}
+		}
+	}
+	upstreamOutages.Unlock()
+
+	for _, n := range send {
+		notify(ctx, n)
+	}
+}
+
+// agenciesDown lists the agencies that have been down past the ops
+// alert's threshold, for /health
+func agenciesDown() []string {
+	upstreamOutages.Lock()
+	defer upstreamOutages.Unlock()
+
+	var down []string
+	for agency, outage := range upstreamOutages.down {
+		if outage.alerted {
+			down = append(down, agency)
+		}
+	}
+	sort.Strings(down)
+	return down
+}
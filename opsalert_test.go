@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpsAlert(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	rt := &recordTransport{}
+	upstreamTransport = rt
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+notifications:
+  webhooks:
+    - url: https://hooks.example.com/ops
+  ops_alert: {down_after: 10}
+stops:
+  - {name: A, line: N, directions: [{label: B, stop_id: "1"}, {label: C, stop_id: "2"}]}
+  - {name: D, line: Caltrain, agency: Caltrain, directions: [{label: E, stop_id: "3"}]}
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	health := func() string {
+		rec := httptest.NewRecorder()
+		handleHealth(rec, httptest.NewRequest("GET", "/health", nil))
+		var h HealthResponse
+		json.NewDecoder(rec.Body).Decode(&h)
+		return fmt.Sprint(h.Status, h.AgenciesDown)
+	}
+	// refresh reports whether each direction's fetch failed
+	refresh := func(now time.Time, failed ...bool) {
+		resp := ArrivalsResponse{Stops: []StopArrivals{
+			{Directions: make([]DirectionArrivals, 2)},
+			{Directions: make([]DirectionArrivals, 1)},
+		}}
+		k := 0
+		for _, stop := range resp.Stops {
+			for j := range stop.Directions {
+				if failed[k] {
+					stop.Directions[j] = DirectionArrivals{Error: "Unable to fetch", FetchError: "stop x: HTTP 401"}
+				}
+				k++
+			}
+		}
+		checkUpstream(context.Background(), resp, cfg.Stops, now)
+	}
+
+	start := time.Date(2026, 1, 30, 8, 0, 0, 0, cfg.location)
+	refresh(start, false, false, false)
+	// One direction of an agency answering keeps it up
+	refresh(start.Add(time.Minute), true, false, true)
+	refresh(start.Add(10*time.Minute), true, false, true)
+	if len(rt.bodies) != 0 || health() != "ok[]" {
+		t.Fatalf("alerted early: %v", rt.bodies)
+	}
+	refresh(start.Add(12*time.Minute), true, false, true)
+	if len(rt.bodies) != 1 || !strings.Contains(rt.bodies[0], "CT arrivals unavailable") || !strings.Contains(rt.bodies[0], "HTTP 401") {
+		t.Fatalf("alerts = %v", rt.bodies)
+	}
+	if got := health(); got != "degraded[CT]" {
+		t.Errorf("health = %s", got)
+	}
+	refresh(start.Add(13*time.Minute), true, true, true)
+	refresh(start.Add(22*time.Minute), true, true, true)
+	if len(rt.bodies) != 1 {
+		t.Fatalf("SF alerted early: %v", rt.bodies)
+	}
+	refresh(start.Add(23*time.Minute), true, true, true)
+	if len(rt.bodies) != 2 || !strings.Contains(rt.bodies[1], "SF arrivals unavailable") {
+		t.Fatalf("alerts = %v", rt.bodies)
+	}
+	refresh(start.Add(24*time.Minute), true, true, true)
+	if len(rt.bodies) != 2 {
+		t.Errorf("alert repeated: %d sent", len(rt.bodies))
+	}
+
+	refresh(start.Add(30*time.Minute), false, true, false)
+	if len(rt.bodies) != 4 || !strings.Contains(rt.bodies[2]+rt.bodies[3], "arrivals are back") {
+		t.Fatalf("recovery alerts = %v", rt.bodies)
+	}
+	if got := health(); got != "ok[]" {
+		t.Errorf("health after recovery = %s", got)
+	}
+}
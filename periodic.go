@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// periodicRefreshTimeout bounds one round of a periodic refresher
+const periodicRefreshTimeout = 15 * time.Second
+
+// startPeriodicRefresher calls refresh on the interval the live config
+// gives until ctx ends, so a feed can be turned on, off, or retimed
+// without a restart. An interval of 0 means the feed is off; the config
+// is looked at again a minute later. A shared cache's followers don't
+// refresh: they take the leader's copy from the shared file.
+func startPeriodicRefresher(ctx context.Context, interval func(*Config) time.Duration, refresh func(ctx context.Context)) {
+	go func() {
+		for {
+			every := interval(currentConfig())
+			if every <= 0 {
+				if !sleepContext(ctx, time.Minute) {
+					return
+				}
+				continue
+			}
+			// Another instance fetches for this one
+			if !leading() {
+				if !sleepContext(ctx, clusterTick) {
+					return
+				}
+				continue
+			}
+
+			rctx, cancel := context.WithTimeout(ctx, periodicRefreshTimeout)
+			refresh(rctx)
+			cancel()
+
+			if !sleepContext(ctx, every) {
+				return
+			}
+		}
+	}()
+}
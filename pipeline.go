@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"muni-tracker/internal/expr"
+)
+
+// PipelineConfig orders the stages each fetch passes through on its way
+// into the cache, and holds the household's own rules for the rules stage
+type PipelineConfig struct {
+	// Stage names, run in order (default filter, normalize_destinations,
+	// dedupe, rules). A stage left out is skipped.
+	Stages []string      `yaml:"stages,omitempty"`
+	Rules  []ArrivalRule `yaml:"rules,omitempty"`
+}
+
+// ArrivalRule acts on the arrivals its condition holds for
+type ArrivalRule struct {
+	// Named in logs; defaults to the condition
+	Name string `yaml:"name,omitempty"`
+	// Condition over the arrival, see ruleVars
+	When string `yaml:"when"`
+	// Leave the arrival out
+	Drop bool `yaml:"drop,omitempty"`
+	// Show the arrival with this destination instead
+	Destination string `yaml:"destination,omitempty"`
+	// Warn on the direction, when its own quality check finds nothing wrong
+	Warning string `yaml:"warning,omitempty"`
+
+	when *expr.Condition
+}
+
+// defaultStages reproduce what the board did before stages were
+// configurable
+var defaultStages = []string{"filter", "normalize_destinations", "dedupe", "rules"}
+
+// ruleVars are what a rule's condition can test
+var ruleVars = map[string]expr.Kind{
+	"destination": expr.String,
+	"line_type":   expr.String,
+	"realtime":    expr.Bool,
+	// Whole minutes from the fetch to the arrival
+	"minutes": expr.Number,
+	"stop":    expr.String,
+	"line":    expr.String,
+	"agency":  expr.String,
+	"stop_id": expr.String,
+	"label":   expr.String,
+	// The agency's local hour when fetched, 0-23
+	"hour": expr.Number,
+	// Whether the service day runs the weekday schedule
+	"weekday": expr.Bool,
+}
+
+func validatePipelineConfig(p *PipelineConfig) error {
+	if p.Stages == nil {
+		p.Stages = defaultStages
+	}
+	seen := make(map[string]bool)
+	for _, name := range p.Stages {
+		if _, ok := stages[name]; !ok {
+			return fmt.Errorf("pipeline: unknown stage %q; available: %v", name, stageNames())
+		}
+		if seen[name] {
+			return fmt.Errorf("pipeline: stage %q is listed twice", name)
+		}
+		seen[name] = true
+	}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.When == "" {
+			return fmt.Errorf("pipeline.rules[%d]: when is required", i)
+		}
+		if !rule.Drop && rule.Destination == "" && rule.Warning == "" {
+			return fmt.Errorf("pipeline.rules[%d]: give drop, destination, or warning", i)
+		}
+		if rule.Name == "" {
+			rule.Name = rule.When
+		}
+		when, err := expr.Compile(rule.When, ruleVars)
+		if err != nil {
+			return fmt.Errorf("pipeline.rules[%d]: %w", i, err)
+		}
+		rule.when = when
+	}
+	return nil
+}
+
+// pipelineBatch is one direction's fetch on its way into the cache
+type pipelineBatch struct {
+	Stop      Stop
+	Direction Direction
+	FetchedAt time.Time
+	// Sorted by arrival time; stages keep them so
+	Arrivals []Arrival
+	// Warning for the direction, see ArrivalRule.Warning
+	Warning string
+}
+
+// Stage transforms a fetch before it is cached
+type Stage func(b *pipelineBatch)
+
+// stages holds what pipeline.stages can name
+var stages = map[string]Stage{}
+
+// registerStage makes a stage available to pipeline.stages. Like
+// providers, a stage is added by a file in this package that calls it
+// from init.
+func registerStage(name string, stage Stage) {
+	if _, dup := stages[name]; dup {
+		panic("stage " + name + " registered twice")
+	}
+	stages[name] = stage
+}
+
+func init() {
+	registerStage("filter", filterStage)
+	registerStage("normalize_destinations", normalizeStage)
+	registerStage("dedupe", dedupeStage)
+	registerStage("rules", rulesStage)
+}
+
+// stageNames lists the registered stages, for error messages
+func stageNames() []string {
+	names := make([]string, 0, len(stages))
+	for name := range stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runPipeline passes a fetch through the configured stages
+func runPipeline(b *pipelineBatch) {
+	for _, name := range currentConfig().Pipeline.Stages {
+		stages[name](b)
+	}
+}
+
+// filterStage drops arrivals the board can't show: those without a
+// readable time, and those gone by the time they were fetched
+func filterStage(b *pipelineBatch) {
+	grace := departedGrace()
+	kept := b.Arrivals[:0]
+	for _, a := range b.Arrivals {
+		t, err := time.Parse(time.RFC3339, a.ArrivalTime)
+		if err != nil || t.Sub(b.FetchedAt) < -grace {
+			continue
+		}
+		kept = append(kept, a)
+	}
+	b.Arrivals = kept
+}
+
+// normalizeStage replaces destinations with the names riders know
+func normalizeStage(b *pipelineBatch) {
+	for i := range b.Arrivals {
+		b.Arrivals[i].Destination = normalizeDestination(b.Arrivals[i].Destination)
+	}
+}
+
+// dedupeStage drops arrivals within a minute of the one before, which
+// upstream reports twice when a vehicle is both scheduled and tracked
+func dedupeStage(b *pipelineBatch) {
+	kept := make([]Arrival, 0, len(b.Arrivals))
+	var prev time.Time
+	for i, a := range b.Arrivals {
+		t, err := time.Parse(time.RFC3339, a.ArrivalTime)
+		if i == 0 || err != nil || t.Sub(prev) >= time.Minute {
+			kept = append(kept, a)
+		}
+		prev = t
+	}
+	b.Arrivals = kept
+}
+
+// rulesStage applies pipeline.rules to each arrival, in order. A dropped
+// arrival isn't seen by later rules.
+func rulesStage(b *pipelineBatch) {
+	rules := currentConfig().Pipeline.Rules
+	if len(rules) == 0 {
+		return
+	}
+	env := map[string]any{
+		"stop":    b.Stop.Name,
+		"line":    b.Stop.Line,
+		"agency":  b.Stop.Agency,
+		"stop_id": b.Direction.StopID,
+		"label":   b.Direction.Label,
+		"hour":    localTime(b.FetchedAt).Hour(),
+		"weekday": serviceDayType(b.FetchedAt) == serviceWeekday,
+	}
+	kept := b.Arrivals[:0]
+	for _, a := range b.Arrivals {
+		minutes := 0
+		if t, err := time.Parse(time.RFC3339, a.ArrivalTime); err == nil {
+			minutes = int(t.Sub(b.FetchedAt).Minutes())
+		}
+		drop := false
+		for _, rule := range rules {
+			env["destination"], env["line_type"], env["realtime"], env["minutes"] = a.Destination, a.LineType, a.Realtime, minutes
+			if !rule.when.Eval(env) {
+				continue
+			}
+			if rule.Warning != "" && b.Warning == "" {
+				b.Warning = rule.Warning
+			}
+			if rule.Destination != "" {
+				a.Destination = rule.Destination
+			}
+			if rule.Drop {
+				debugf("Rule %q dropped the %s arrival to %s", rule.Name, a.ArrivalTime, a.Destination)
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			kept = append(kept, a)
+		}
+	}
+	b.Arrivals = kept
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	now := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	withTestEnv(t, now, `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"OCEAN BEACH","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:03:00Z"}}},
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"OCEAN BEACH","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:03:30Z"}}},
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"SUNSET TUNNEL","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:12:00Z"}}},
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"OCEAN BEACH","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:45:00Z"}}},
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"OCEAN BEACH","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T19:50:00Z"}}}
+	]}}}`)
+
+	fetch := func(yaml string) DirectionArrivals {
+		t.Helper()
+		cfg, err := parseConfig([]byte(`
+api_key: test
+timezone: UTC
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: "16994"
+` + yaml))
+		if err != nil {
+			t.Fatalf("parseConfig: %v", err)
+		}
+		activeConfig.Store(cfg)
+		return fetchDirection(context.Background(), cfg.Stops[0], cfg.Stops[0].Directions[0])
+	}
+	describe := func(d DirectionArrivals) string {
+		var out []string
+		for _, a := range d.Arrivals {
+			out = append(out, a.ArrivalTime[11:16]+" "+a.Destination)
+		}
+		return strings.Join(out, ", ")
+	}
+
+	// The default stages drop the departed arrival and the duplicate, and
+	// name destinations as riders know them
+	got := fetch("")
+	if want := "20:03 Ocean Beach, 20:12 Sunset Tunnel, 20:45 Ocean Beach"; describe(got) != want {
+		t.Errorf("default stages = %s, want %s", describe(got), want)
+	}
+
+	rules := `
+pipeline:
+  rules:
+    - name: ghost trains
+      when: '!realtime && minutes > 30'
+      drop: true
+    - when: 'destination == "Sunset Tunnel"'
+      destination: Carl & Cole
+    - when: 'hour >= 20 && stop_id == "16994" && weekday'
+      warning: Buses replace trains after 9pm
+`
+	got = fetch(rules)
+	if want := "20:03 Ocean Beach, 20:12 Carl & Cole"; describe(got) != want {
+		t.Errorf("with rules = %s, want %s", describe(got), want)
+	}
+	if got.RuleWarning != "Buses replace trains after 9pm" {
+		t.Errorf("rule warning = %q", got.RuleWarning)
+	}
+
+	// The board shows a rule's warning when its own check finds nothing
+	view := buildArrivalsView(ArrivalsResponse{Stops: []StopArrivals{{Name: "Embarcadero", Directions: []DirectionArrivals{got}}}},
+		currentConfig().Stops, now, allArrivals)
+	if d := view.Stops[0].Directions[0]; d.QualityLevel != "warning" || d.QualityWarning != "Buses replace trains after 9pm" {
+		t.Errorf("quality = %s %q, want the rule's warning", d.QualityLevel, d.QualityWarning)
+	}
+
+	// Stages run in the configured order: rules see raw names before
+	// normalize_destinations, and nothing is deduped
+	got = fetch(rules + "  stages: [filter, rules, normalize_destinations]\n")
+	if want := "20:03 Ocean Beach, 20:03 Ocean Beach, 20:12 Sunset Tunnel"; describe(got) != want {
+		t.Errorf("reordered stages = %s, want %s", describe(got), want)
+	}
+
+	for yaml, want := range map[string]string{
+		"pipeline: {stages: [dedupe, sort]}":                        `unknown stage "sort"`,
+		"pipeline: {stages: [dedupe, dedupe]}":                      "listed twice",
+		"pipeline: {rules: [{drop: true}]}":                         "when is required",
+		"pipeline: {rules: [{when: realtime}]}":                     "give drop, destination, or warning",
+		"pipeline: {rules: [{when: 'route == \"N\"', drop: true}]}": "unknown name route",
+	} {
+		if _, err := parseConfig([]byte("api_key: test\nstops: [{name: A, line: B, directions: [{label: C, stop_id: \"1\"}]}]\n" + yaml)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", yaml, err, want)
+		}
+	}
+}
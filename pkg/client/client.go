@@ -0,0 +1,249 @@
+// Package client is a Go client for the Muni Quick Tracker HTTP API, for
+// companion tools such as CLI notifiers and LED drivers.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Arrival is one upcoming vehicle
+type Arrival struct {
+	ArrivalTime string `json:"arrival_time"`
+	// ArrivalTime formatted for display per the server's time_format
+	DisplayTime string `json:"display_time,omitempty"`
+	Minutes     int    `json:"minutes"`
+	Seconds     int    `json:"seconds"`
+	// Status is "upcoming", "due" (under a minute), "departing" (left in
+	// the last 30 seconds) or "departed"
+	Status string `json:"status,omitempty"`
+	// Status in the server's configured language
+	StatusText  string `json:"status_text,omitempty"`
+	Destination string `json:"destination"`
+	LineType    string `json:"line_type,omitempty"`
+	// Realtime is false for predictions taken from the schedule
+	Realtime bool `json:"realtime"`
+	// ShortTurn marks a trip ending before the rider's destination
+	ShortTurn bool `json:"short_turn,omitempty"`
+	// Accessibility of the vehicle, nil when unknown
+	Wheelchair *bool `json:"wheelchair_accessible,omitempty"`
+	Bikes      *bool `json:"bikes_allowed,omitempty"`
+}
+
+// Time parses ArrivalTime
+func (a Arrival) Time() (time.Time, error) {
+	return time.Parse(time.RFC3339, a.ArrivalTime)
+}
+
+type DirectionArrivals struct {
+	Label          string    `json:"label"`
+	StopID         string    `json:"stop_id"`
+	Arrivals       []Arrival `json:"arrivals"`
+	Error          string    `json:"error,omitempty"`
+	QualityWarning string    `json:"quality_warning,omitempty"`
+	QualityLevel   string    `json:"quality_level,omitempty"`
+	ServiceEnded   bool      `json:"service_ended,omitempty"`
+	ServiceResumes string    `json:"service_resumes,omitempty"`
+	// Typical minutes between vehicles, 0 if unknown
+	ApproxHeadwayMinutes int `json:"approx_headway_minutes,omitempty"`
+	// Upcoming arrivals the server holds, before limit and offset
+	Available int `json:"available"`
+	// Whether the stop has step-free boarding, nil when unknown
+	WheelchairBoarding *bool `json:"wheelchair_boarding,omitempty"`
+}
+
+type StopArrivals struct {
+	Name       string              `json:"name"`
+	Line       string              `json:"line"`
+	Directions []DirectionArrivals `json:"directions"`
+	// Elevator and escalator outages at the stop's BART station
+	Advisories []string `json:"advisories,omitempty"`
+}
+
+type ArrivalsResponse struct {
+	Stops []StopArrivals `json:"stops"`
+	// RFC3339, empty until the server's first refresh
+	LastUpdated        string          `json:"last_updated"`
+	LastUpdatedDisplay string          `json:"last_updated_display"`
+	Weather            *Weather        `json:"weather,omitempty"`
+	Bikeshare          []StationStatus `json:"bikeshare,omitempty"`
+}
+
+// StationStatus is bikeshare availability at one configured station
+type StationStatus struct {
+	Name            string `json:"name"`
+	StationID       string `json:"station_id"`
+	BikesAvailable  int    `json:"bikes_available"`
+	EbikesAvailable int    `json:"ebikes_available"`
+	DocksAvailable  int    `json:"docks_available"`
+	Renting         bool   `json:"renting"`
+	LastReported    string `json:"last_reported,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// Weather is the current conditions, when the server has weather configured
+type Weather struct {
+	Temperature     float64 `json:"temperature"`
+	TemperatureUnit string  `json:"temperature_unit"`
+	PrecipitationMM float64 `json:"precipitation_mm"`
+	Description     string  `json:"description"`
+	UpdatedAt       string  `json:"updated_at"`
+}
+
+type Direction struct {
+	Label  string `json:"label"`
+	StopID string `json:"stop_id"`
+	// Extra stop codes whose arrivals are merged into this direction
+	StopIDs []string `json:"stop_ids,omitempty"`
+}
+
+type Stop struct {
+	Name       string      `json:"name"`
+	Line       string      `json:"line"`
+	Agency     string      `json:"agency"`
+	Directions []Direction `json:"directions"`
+}
+
+type Config struct {
+	Stops           []Stop `json:"stops"`
+	RefreshInterval int    `json:"refresh_interval"`
+	Timezone        string `json:"timezone"`
+}
+
+// Client talks to a tracker server
+type Client struct {
+	BaseURL    string
+	APIKey     string // sent as X-API-Key when the server requires client keys
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL, e.g. "http://pi.local:8080"
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Error is returned for non-2xx responses
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("tracker: HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// GetArrivals returns the current arrivals board
+func (c *Client) GetArrivals(ctx context.Context) (*ArrivalsResponse, error) {
+	var out ArrivalsResponse
+	if err := c.get(ctx, "/api/arrivals", &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetConfig returns the configured stops and the suggested refresh interval
+func (c *Client) GetConfig(ctx context.Context) (*Config, error) {
+	var out Config
+	if err := c.get(ctx, "/api/config", &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Update is delivered by StreamArrivals
+type Update struct {
+	Arrivals *ArrivalsResponse
+	Err      error
+}
+
+// StreamArrivals polls the server at its advertised refresh interval and
+// sends an update whenever the arrivals change, or when a poll fails. The
+// channel is closed when ctx is cancelled.
+func (c *Client) StreamArrivals(ctx context.Context) (<-chan Update, error) {
+	cfg, err := c.GetConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(cfg.RefreshInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	updates := make(chan Update)
+	go func() {
+		defer close(updates)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last []StopArrivals
+		for {
+			arrivals, err := c.GetArrivals(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+
+			var update *Update
+			if err != nil {
+				update = &Update{Err: err}
+			} else if !reflect.DeepEqual(arrivals.Stops, last) {
+				last = arrivals.Stops
+				update = &Update{Arrivals: arrivals}
+			}
+
+			if update != nil {
+				select {
+				case updates <- *update:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return updates, nil
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "kiosk" {
+			http.Error(w, "missing or invalid API key", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/config":
+			w.Write([]byte(`{"stops":[{"name":"Embarcadero","line":"N Judah","agency":"SF","directions":[{"label":"Ocean Beach","stop_id":"16994"}]}],"refresh_interval":1}`))
+		case "/api/arrivals":
+			w.Write([]byte(`{"stops":[{"name":"Embarcadero","line":"N Judah","directions":[{"label":"Ocean Beach","stop_id":"16994","arrivals":[{"arrival_time":"2026-01-30T20:05:00Z","minutes":4,"destination":"Ocean Beach"}]}]}],"last_updated":"2026-01-30T20:01:00Z","last_updated_display":"8:01:00 PM"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestGetArrivals(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	c := New(srv.URL + "/")
+	c.APIKey = "kiosk"
+
+	got, err := c.GetArrivals(context.Background())
+	if err != nil {
+		t.Fatalf("GetArrivals: %v", err)
+	}
+	arrivals := got.Stops[0].Directions[0].Arrivals
+	if len(arrivals) != 1 || arrivals[0].Minutes != 4 {
+		t.Fatalf("unexpected arrivals %+v", arrivals)
+	}
+	if _, err := arrivals[0].Time(); err != nil {
+		t.Errorf("Time(): %v", err)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	_, err := New(srv.URL).GetArrivals(context.Background())
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 *Error, got %v", err)
+	}
+}
+
+func TestStreamArrivals(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.APIKey = "kiosk"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updates, err := c.StreamArrivals(ctx)
+	if err != nil {
+		t.Fatalf("StreamArrivals: %v", err)
+	}
+
+	first := <-updates
+	if first.Err != nil || first.Arrivals == nil {
+		t.Fatalf("unexpected first update %+v", first)
+	}
+
+	cancel()
+	for range updates {
+	}
+}
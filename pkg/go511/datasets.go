@@ -0,0 +1,90 @@
+package go511
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// MaxDatafeedSize caps a GTFS datafeed download. Regional operators'
+// zipped feeds are tens of megabytes.
+const MaxDatafeedSize = 256 << 20
+
+// DatafeedURL builds the request URL for an operator's GTFS datafeed
+func (c *Client) DatafeedURL(operatorID string) string {
+	q := url.Values{}
+	q.Set("api_key", c.APIKey)
+	q.Set("operator_id", operatorID)
+	return c.BaseURL + "/datafeeds?" + q.Encode()
+}
+
+// Datafeed downloads an operator's GTFS feed as a zip archive
+func (c *Client) Datafeed(ctx context.Context, operatorID string) ([]byte, error) {
+	return c.get(ctx, c.DatafeedURL(operatorID), MaxDatafeedSize)
+}
+
+// Line is one entry in an operator's lines dataset
+type Line struct {
+	ID            string `json:"Id"`
+	Name          string `json:"Name"`
+	PublicCode    string `json:"PublicCode"`
+	SiriLineRef   string `json:"SiriLineRef"`
+	TransportMode string `json:"TransportMode"`
+	Monitored     bool   `json:"Monitored"`
+	OperatorRef   string `json:"OperatorRef"`
+}
+
+// LinesURL builds the request URL for an operator's lines
+func (c *Client) LinesURL(operatorID string) string {
+	q := url.Values{}
+	q.Set("api_key", c.APIKey)
+	q.Set("operator_id", operatorID)
+	q.Set("format", FormatJSON)
+	return c.BaseURL + "/lines?" + q.Encode()
+}
+
+// Lines lists the lines an operator runs
+func (c *Client) Lines(ctx context.Context, operatorID string) ([]Line, error) {
+	body, err := c.get(ctx, c.LinesURL(operatorID), MaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+	var lines []Line
+	if err := json.Unmarshal(body, &lines); err != nil {
+		return nil, fmt.Errorf("failed to parse lines: %w", err)
+	}
+	return lines, nil
+}
+
+// Operator is one transit agency in the 511 region
+type Operator struct {
+	ID          string `json:"Id"`
+	Name        string `json:"Name"`
+	ShortName   string `json:"ShortName"`
+	TimeZone    string `json:"TimeZone"`
+	WebSite     string `json:"WebSite"`
+	PrimaryMode string `json:"PrimaryMode"`
+	Monitored   bool   `json:"Monitored"`
+}
+
+// OperatorsURL builds the request URL for the operators list
+func (c *Client) OperatorsURL() string {
+	q := url.Values{}
+	q.Set("api_key", c.APIKey)
+	q.Set("format", FormatJSON)
+	return c.BaseURL + "/operators?" + q.Encode()
+}
+
+// Operators lists the agencies 511 covers
+func (c *Client) Operators(ctx context.Context) ([]Operator, error) {
+	body, err := c.get(ctx, c.OperatorsURL(), MaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+	var ops []Operator
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, fmt.Errorf("failed to parse operators: %w", err)
+	}
+	return ops, nil
+}
@@ -0,0 +1,53 @@
+package go511
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lines" || r.URL.Query().Get("operator_id") != "SF" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte("\xEF\xBB\xBF" + `[
+			{"Id":"N","Name":"JUDAH","PublicCode":"N","SiriLineRef":"N","TransportMode":"metro","Monitored":true,"OperatorRef":"SF"},
+			{"Id":"F","Name":"MARKET & WHARVES","PublicCode":"F","TransportMode":"tram","Monitored":true,"OperatorRef":"SF"}
+		]`))
+	}))
+	defer srv.Close()
+
+	c := NewClient("k")
+	c.BaseURL = srv.URL
+
+	lines, err := c.Lines(context.Background(), "SF")
+	if err != nil {
+		t.Fatalf("Lines: %v", err)
+	}
+	if len(lines) != 2 || lines[0].ID != "N" || lines[1].Name != "MARKET & WHARVES" || !lines[1].Monitored {
+		t.Errorf("got %+v", lines)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/operators" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		w.Write([]byte(`[{"Id":"SF","Name":"San Francisco Municipal Transportation Agency","ShortName":"SFMTA","TimeZone":"America/Los_Angeles","PrimaryMode":"bus","Monitored":true}]`))
+	}))
+	defer srv.Close()
+
+	c := NewClient("k")
+	c.BaseURL = srv.URL
+
+	ops, err := c.Operators(context.Background())
+	if err != nil {
+		t.Fatalf("Operators: %v", err)
+	}
+	if len(ops) != 1 || ops[0].ID != "SF" || ops[0].ShortName != "SFMTA" {
+		t.Errorf("got %+v", ops)
+	}
+}
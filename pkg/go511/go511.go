@@ -0,0 +1,209 @@
+// Package go511 is a small client for the 511.org SF Bay Area transit API.
+//
+// It covers the SIRI StopMonitoring endpoint: building requests, stripping
+// the UTF-8 byte order mark 511 prepends to its responses, and decoding
+// either the JSON or XML representation into typed structs. It can also
+// download an operator's GTFS datafeed.
+package go511
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultBaseURL is the 511.org transit API root
+const DefaultBaseURL = "https://api.511.org/transit"
+
+// Response formats supported by the API
+const (
+	FormatJSON = "json"
+	FormatXML  = "xml"
+)
+
+// utf8BOM is prepended to 511.org responses
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Client calls the 511.org API. The zero value is not usable; create one
+// with NewClient.
+type Client struct {
+	APIKey     string
+	BaseURL    string
+	Format     string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client using JSON responses and http.DefaultClient
+func NewClient(apiKey string) *Client {
+	return &Client{
+		APIKey:     apiKey,
+		BaseURL:    DefaultBaseURL,
+		Format:     FormatJSON,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// HTTPError is returned when the API responds with a non-200 status
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// StopMonitoringURL builds the request URL for a stop
+func (c *Client) StopMonitoringURL(agency, stopCode string) string {
+	q := url.Values{}
+	q.Set("api_key", c.APIKey)
+	q.Set("agency", agency)
+	q.Set("stopCode", stopCode)
+	q.Set("format", c.format())
+	return c.BaseURL + "/StopMonitoring?" + q.Encode()
+}
+
+func (c *Client) format() string {
+	if c.Format == "" {
+		return FormatJSON
+	}
+	return c.Format
+}
+
+// StopMonitoringRaw fetches the raw StopMonitoring body for a stop, with
+// the byte order mark removed
+func (c *Client) StopMonitoringRaw(ctx context.Context, agency, stopCode string) ([]byte, error) {
+	return c.get(ctx, c.StopMonitoringURL(agency, stopCode), MaxBodySize)
+}
+
+// get fetches a URL, reading at most limit bytes of the body
+func (c *Client) get(ctx context.Context, rawURL string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// Drop the URL from the error so the API key doesn't end up in logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Body: string(body[:min(len(body), 100)])}
+	}
+
+	return StripBOM(body), nil
+}
+
+// StopMonitoring fetches and decodes predictions for a stop
+func (c *Client) StopMonitoring(ctx context.Context, agency, stopCode string) (*StopMonitoringResponse, error) {
+	body, err := c.StopMonitoringRaw(ctx, agency, stopCode)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStopMonitoring(body)
+}
+
+// StripBOM removes a leading UTF-8 byte order mark
+func StripBOM(body []byte) []byte {
+	return bytes.TrimPrefix(body, utf8BOM)
+}
+
+// StopMonitoringResponse is the SIRI StopMonitoring document
+type StopMonitoringResponse struct {
+	ServiceDelivery ServiceDelivery `json:"ServiceDelivery"`
+}
+
+type ServiceDelivery struct {
+	ResponseTimestamp      string                 `json:"ResponseTimestamp"`
+	ProducerRef            string                 `json:"ProducerRef"`
+	StopMonitoringDelivery StopMonitoringDelivery `json:"StopMonitoringDelivery"`
+}
+
+type StopMonitoringDelivery struct {
+	ResponseTimestamp  string               `json:"ResponseTimestamp"`
+	MonitoredStopVisit []MonitoredStopVisit `json:"MonitoredStopVisit"`
+}
+
+type MonitoredStopVisit struct {
+	RecordedAtTime          string                  `json:"RecordedAtTime"`
+	MonitoringRef           string                  `json:"MonitoringRef"`
+	MonitoredVehicleJourney MonitoredVehicleJourney `json:"MonitoredVehicleJourney"`
+}
+
+type MonitoredVehicleJourney struct {
+	LineRef           string        `json:"LineRef"`
+	DirectionRef      string        `json:"DirectionRef"`
+	PublishedLineName string        `json:"PublishedLineName"`
+	OperatorRef       string        `json:"OperatorRef"`
+	DestinationRef    string        `json:"DestinationRef"`
+	DestinationName   string        `json:"DestinationName"`
+	Monitored         bool          `json:"Monitored"`
+	VehicleRef        string        `json:"VehicleRef"`
+	MonitoredCall     MonitoredCall `json:"MonitoredCall"`
+
+	FramedVehicleJourneyRef FramedVehicleJourneyRef `json:"FramedVehicleJourneyRef"`
+	// Vehicle features such as "lowFloor" or "bicycleRack", when published
+	VehicleFeatureRef []string `json:"VehicleFeatureRef"`
+}
+
+// FramedVehicleJourneyRef identifies one trip on one service day
+type FramedVehicleJourneyRef struct {
+	DataFrameRef           string `json:"DataFrameRef" xml:"DataFrameRef"`
+	DatedVehicleJourneyRef string `json:"DatedVehicleJourneyRef" xml:"DatedVehicleJourneyRef"`
+}
+
+type MonitoredCall struct {
+	StopPointRef          string `json:"StopPointRef" xml:"StopPointRef"`
+	StopPointName         string `json:"StopPointName" xml:"StopPointName"`
+	AimedArrivalTime      string `json:"AimedArrivalTime" xml:"AimedArrivalTime"`
+	ExpectedArrivalTime   string `json:"ExpectedArrivalTime" xml:"ExpectedArrivalTime"`
+	AimedDepartureTime    string `json:"AimedDepartureTime" xml:"AimedDepartureTime"`
+	ExpectedDepartureTime string `json:"ExpectedDepartureTime" xml:"ExpectedDepartureTime"`
+}
+
+// Visits returns the monitored stop visits in the response
+func (r *StopMonitoringResponse) Visits() []MonitoredStopVisit {
+	return r.ServiceDelivery.StopMonitoringDelivery.MonitoredStopVisit
+}
+
+// ExpectedTime returns the expected arrival time, falling back to the
+// expected departure time. ok is false if neither is present and valid.
+func (c MonitoredCall) ExpectedTime() (t time.Time, ok bool) {
+	s := c.ExpectedArrivalTime
+	if s == "" {
+		s = c.ExpectedDepartureTime
+	}
+	if s == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,186 @@
+package go511
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleJSON = "\xEF\xBB\xBF" + `{
+  "ServiceDelivery": {
+    "ResponseTimestamp": "2026-01-30T20:00:00Z",
+    "StopMonitoringDelivery": {
+      "MonitoredStopVisit": [
+        {
+          "MonitoredVehicleJourney": {
+            "LineRef": "N",
+            "DirectionRef": "IB",
+            "DestinationName": "Caltrain/Ball Park",
+            "MonitoredCall": {"ExpectedArrivalTime": "2026-01-30T20:05:00Z"}
+          }
+        },
+        {
+          "MonitoredVehicleJourney": {
+            "LineRef": "N",
+            "DestinationName": "Caltrain/Ball Park",
+            "MonitoredCall": {"ExpectedDepartureTime": "2026-01-30T20:12:00Z"}
+          }
+        }
+      ]
+    }
+  }
+}`
+
+const sampleXML = "\xEF\xBB\xBF" + `<?xml version="1.0" encoding="utf-8"?>
+<Siri>
+  <ServiceDelivery>
+    <ResponseTimestamp>2026-01-30T20:00:00Z</ResponseTimestamp>
+    <StopMonitoringDelivery>
+      <MonitoredStopVisit>
+        <MonitoredVehicleJourney>
+          <LineRef>F</LineRef>
+          <DestinationName>Castro</DestinationName>
+          <MonitoredCall>
+            <ExpectedArrivalTime>2026-01-30T20:07:00Z</ExpectedArrivalTime>
+          </MonitoredCall>
+        </MonitoredVehicleJourney>
+      </MonitoredStopVisit>
+    </StopMonitoringDelivery>
+  </ServiceDelivery>
+</Siri>`
+
+func TestStopMonitoringURL(t *testing.T) {
+	c := NewClient("key&1")
+	got := c.StopMonitoringURL("SF", "15731")
+	want := DefaultBaseURL + "/StopMonitoring?agency=SF&api_key=key%261&format=json&stopCode=15731"
+	if got != want {
+		t.Errorf("StopMonitoringURL() = %q, want %q", got, want)
+	}
+}
+
+func TestParseStopMonitoringJSON(t *testing.T) {
+	resp, err := ParseStopMonitoring([]byte(sampleJSON))
+	if err != nil {
+		t.Fatalf("ParseStopMonitoring: %v", err)
+	}
+
+	visits := resp.Visits()
+	if len(visits) != 2 {
+		t.Fatalf("got %d visits, want 2", len(visits))
+	}
+	if got := visits[0].MonitoredVehicleJourney.DirectionRef; got != "IB" {
+		t.Errorf("DirectionRef = %q, want IB", got)
+	}
+	if resp.ServiceDelivery.ResponseTimestamp != "2026-01-30T20:00:00Z" {
+		t.Errorf("ResponseTimestamp = %q", resp.ServiceDelivery.ResponseTimestamp)
+	}
+
+	// Second visit only has a departure time
+	got, ok := visits[1].MonitoredVehicleJourney.MonitoredCall.ExpectedTime()
+	if !ok || !got.Equal(time.Date(2026, 1, 30, 20, 12, 0, 0, time.UTC)) {
+		t.Errorf("ExpectedTime() = %v, %v", got, ok)
+	}
+}
+
+func TestParseStopMonitoringXML(t *testing.T) {
+	resp, err := ParseStopMonitoring([]byte(sampleXML))
+	if err != nil {
+		t.Fatalf("ParseStopMonitoring: %v", err)
+	}
+
+	visits := resp.Visits()
+	if len(visits) != 1 {
+		t.Fatalf("got %d visits, want 1", len(visits))
+	}
+	j := visits[0].MonitoredVehicleJourney
+	if j.LineRef != "F" || j.DestinationName != "Castro" {
+		t.Errorf("got line %q destination %q", j.LineRef, j.DestinationName)
+	}
+	if j.MonitoredCall.ExpectedArrivalTime != "2026-01-30T20:07:00Z" {
+		t.Errorf("ExpectedArrivalTime = %q", j.MonitoredCall.ExpectedArrivalTime)
+	}
+}
+
+func TestParseStopMonitoringInvalid(t *testing.T) {
+	if _, err := ParseStopMonitoring([]byte(`{"ServiceDelivery": [`)); err == nil {
+		t.Error("expected error for truncated body")
+	}
+}
+
+func TestExpectedTimeMissing(t *testing.T) {
+	if _, ok := (MonitoredCall{}).ExpectedTime(); ok {
+		t.Error("expected ok=false with no times")
+	}
+	if _, ok := (MonitoredCall{ExpectedArrivalTime: "soon"}).ExpectedTime(); ok {
+		t.Error("expected ok=false for unparseable time")
+	}
+}
+
+func TestStopMonitoring(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/StopMonitoring" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("api_key") != "k" || q.Get("agency") != "CT" || q.Get("stopCode") != "70012" {
+			t.Errorf("unexpected query %q", r.URL.RawQuery)
+		}
+		w.Write([]byte(sampleJSON))
+	}))
+	defer srv.Close()
+
+	c := NewClient("k")
+	c.BaseURL = srv.URL
+
+	resp, err := c.StopMonitoring(context.Background(), "CT", "70012")
+	if err != nil {
+		t.Fatalf("StopMonitoring: %v", err)
+	}
+	if len(resp.Visits()) != 2 {
+		t.Errorf("got %d visits, want 2", len(resp.Visits()))
+	}
+
+	raw, err := c.StopMonitoringRaw(context.Background(), "CT", "70012")
+	if err != nil {
+		t.Fatalf("StopMonitoringRaw: %v", err)
+	}
+	if strings.HasPrefix(string(raw), "\xEF\xBB\xBF") {
+		t.Error("BOM was not stripped")
+	}
+}
+
+func TestStopMonitoringHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := NewClient("k")
+	c.BaseURL = srv.URL
+
+	_, err := c.StopMonitoring(context.Background(), "SF", "1")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected *HTTPError, got %v", err)
+	}
+	if httpErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("StatusCode = %d", httpErr.StatusCode)
+	}
+}
+
+func TestStopMonitoringErrorHidesKey(t *testing.T) {
+	c := NewClient("secret-key")
+	c.BaseURL = "http://127.0.0.1:0"
+
+	_, err := c.StopMonitoringRaw(context.Background(), "SF", "1")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if strings.Contains(err.Error(), "secret-key") {
+		t.Errorf("error leaks API key: %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package go511
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits applied to upstream payloads. A StopMonitoring response for a
+// single stop is a few kilobytes; anything near these is not a real answer.
+const (
+	MaxBodySize = 4 << 20
+	MaxVisits   = 500
+)
+
+// ErrBodyTooLarge is returned for payloads over MaxBodySize
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// ParseStopMonitoring decodes a StopMonitoring body, detecting whether it
+// is JSON or XML. Decoding is lenient: nulls, numbers where strings are
+// expected, single objects where arrays are expected, and SIRI's
+// multilingual name arrays are all accepted. Only structurally invalid
+// documents are rejected.
+func ParseStopMonitoring(body []byte) (*StopMonitoringResponse, error) {
+	if len(body) > MaxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+
+	body = bytes.TrimSpace(StripBOM(body))
+	if len(body) == 0 {
+		return nil, errors.New("failed to parse response: empty body")
+	}
+
+	if body[0] == '<' {
+		return parseXML(body)
+	}
+	return parseJSON(body)
+}
+
+// JSON
+
+func parseJSON(body []byte) (*StopMonitoringResponse, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var root interface{}
+	if err := dec.Decode(&root); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	obj, ok := root.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("failed to parse response: expected a JSON object")
+	}
+
+	// Some producers wrap the document in a "Siri" object
+	if siri, ok := obj["Siri"].(map[string]interface{}); ok {
+		obj = siri
+	}
+
+	resp := &StopMonitoringResponse{}
+	sd := asObject(obj["ServiceDelivery"])
+	resp.ServiceDelivery.ResponseTimestamp = asString(sd["ResponseTimestamp"])
+	resp.ServiceDelivery.ProducerRef = asString(sd["ProducerRef"])
+
+	// SIRI allows several deliveries; merge their visits
+	smd := &resp.ServiceDelivery.StopMonitoringDelivery
+	for _, d := range asList(sd["StopMonitoringDelivery"]) {
+		delivery := asObject(d)
+		if smd.ResponseTimestamp == "" {
+			smd.ResponseTimestamp = asString(delivery["ResponseTimestamp"])
+		}
+		for _, v := range asList(delivery["MonitoredStopVisit"]) {
+			if len(smd.MonitoredStopVisit) >= MaxVisits {
+				break
+			}
+			if visit, ok := v.(map[string]interface{}); ok {
+				smd.MonitoredStopVisit = append(smd.MonitoredStopVisit, visitFromJSON(visit))
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+func visitFromJSON(v map[string]interface{}) MonitoredStopVisit {
+	j := asObject(v["MonitoredVehicleJourney"])
+	call := asObject(j["MonitoredCall"])
+	framed := asObject(j["FramedVehicleJourneyRef"])
+
+	var features []string
+	for _, f := range asList(j["VehicleFeatureRef"]) {
+		if s := asString(f); s != "" {
+			features = append(features, s)
+		}
+	}
+	if s, ok := j["VehicleFeatureRef"].(string); ok && s != "" {
+		features = append(features, s)
+	}
+
+	return MonitoredStopVisit{
+		RecordedAtTime: asString(v["RecordedAtTime"]),
+		MonitoringRef:  asString(v["MonitoringRef"]),
+		MonitoredVehicleJourney: MonitoredVehicleJourney{
+			LineRef:           asString(j["LineRef"]),
+			DirectionRef:      asString(j["DirectionRef"]),
+			PublishedLineName: asString(j["PublishedLineName"]),
+			OperatorRef:       asString(j["OperatorRef"]),
+			DestinationRef:    asString(j["DestinationRef"]),
+			DestinationName:   asString(j["DestinationName"]),
+			Monitored:         asBool(j["Monitored"]),
+			VehicleRef:        asString(j["VehicleRef"]),
+			MonitoredCall: MonitoredCall{
+				StopPointRef:          asString(call["StopPointRef"]),
+				StopPointName:         asString(call["StopPointName"]),
+				AimedArrivalTime:      asString(call["AimedArrivalTime"]),
+				ExpectedArrivalTime:   asString(call["ExpectedArrivalTime"]),
+				AimedDepartureTime:    asString(call["AimedDepartureTime"]),
+				ExpectedDepartureTime: asString(call["ExpectedDepartureTime"]),
+			},
+			FramedVehicleJourneyRef: FramedVehicleJourneyRef{
+				DataFrameRef:           asString(framed["DataFrameRef"]),
+				DatedVehicleJourneyRef: asString(framed["DatedVehicleJourneyRef"]),
+			},
+			VehicleFeatureRef: features,
+		},
+	}
+}
+
+// asObject returns v as an object, or an empty one. A single-element
+// array holding an object is unwrapped.
+func asObject(v interface{}) map[string]interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return t
+	case []interface{}:
+		if len(t) > 0 {
+			return asObject(t[0])
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// asList returns v as a list; a lone object becomes a one-element list
+func asList(v interface{}) []interface{} {
+	switch t := v.(type) {
+	case []interface{}:
+		return t
+	case map[string]interface{}:
+		return []interface{}{t}
+	}
+	return nil
+}
+
+// asString converts scalars to strings. SIRI text fields may also appear
+// as [{"value": "...", "lang": "en"}] or {"value": "..."}.
+func asString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	case map[string]interface{}:
+		return asString(t["value"])
+	case []interface{}:
+		if len(t) > 0 {
+			return asString(t[0])
+		}
+	}
+	return ""
+}
+
+func asBool(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		b, _ := strconv.ParseBool(strings.TrimSpace(t))
+		return b
+	}
+	return false
+}
+
+// XML. Everything is decoded as text first so a malformed value in one
+// field can't fail the whole document.
+
+type xmlSiri struct {
+	ServiceDelivery struct {
+		ResponseTimestamp      string `xml:"ResponseTimestamp"`
+		ProducerRef            string `xml:"ProducerRef"`
+		StopMonitoringDelivery []struct {
+			ResponseTimestamp  string         `xml:"ResponseTimestamp"`
+			MonitoredStopVisit []xmlStopVisit `xml:"MonitoredStopVisit"`
+		} `xml:"StopMonitoringDelivery"`
+	} `xml:"ServiceDelivery"`
+}
+
+type xmlStopVisit struct {
+	RecordedAtTime          string `xml:"RecordedAtTime"`
+	MonitoringRef           string `xml:"MonitoringRef"`
+	MonitoredVehicleJourney struct {
+		LineRef           string        `xml:"LineRef"`
+		DirectionRef      string        `xml:"DirectionRef"`
+		PublishedLineName string        `xml:"PublishedLineName"`
+		OperatorRef       string        `xml:"OperatorRef"`
+		DestinationRef    string        `xml:"DestinationRef"`
+		DestinationName   string        `xml:"DestinationName"`
+		Monitored         string        `xml:"Monitored"`
+		VehicleRef        string        `xml:"VehicleRef"`
+		MonitoredCall     MonitoredCall `xml:"MonitoredCall"`
+
+		FramedVehicleJourneyRef FramedVehicleJourneyRef `xml:"FramedVehicleJourneyRef"`
+		VehicleFeatureRef       []string                `xml:"VehicleFeatureRef"`
+	} `xml:"MonitoredVehicleJourney"`
+}
+
+func parseXML(body []byte) (*StopMonitoringResponse, error) {
+	var doc xmlSiri
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	resp := &StopMonitoringResponse{}
+	resp.ServiceDelivery.ResponseTimestamp = doc.ServiceDelivery.ResponseTimestamp
+	resp.ServiceDelivery.ProducerRef = doc.ServiceDelivery.ProducerRef
+
+	smd := &resp.ServiceDelivery.StopMonitoringDelivery
+	for _, d := range doc.ServiceDelivery.StopMonitoringDelivery {
+		if smd.ResponseTimestamp == "" {
+			smd.ResponseTimestamp = d.ResponseTimestamp
+		}
+		for _, v := range d.MonitoredStopVisit {
+			if len(smd.MonitoredStopVisit) >= MaxVisits {
+				break
+			}
+			j := v.MonitoredVehicleJourney
+			monitored, _ := strconv.ParseBool(strings.TrimSpace(j.Monitored))
+			smd.MonitoredStopVisit = append(smd.MonitoredStopVisit, MonitoredStopVisit{
+				RecordedAtTime: v.RecordedAtTime,
+				MonitoringRef:  v.MonitoringRef,
+				MonitoredVehicleJourney: MonitoredVehicleJourney{
+					LineRef:           j.LineRef,
+					DirectionRef:      j.DirectionRef,
+					PublishedLineName: j.PublishedLineName,
+					OperatorRef:       j.OperatorRef,
+					DestinationRef:    j.DestinationRef,
+					DestinationName:   j.DestinationName,
+					Monitored:         monitored,
+					VehicleRef:        j.VehicleRef,
+					MonitoredCall:     j.MonitoredCall,
+
+					FramedVehicleJourneyRef: j.FramedVehicleJourneyRef,
+					VehicleFeatureRef:       j.VehicleFeatureRef,
+				},
+			})
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,144 @@
+package go511
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseStopMonitoringLenient(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		visits      int
+		destination string
+	}{
+		{
+			name:   "null delivery",
+			body:   `{"ServiceDelivery":{"StopMonitoringDelivery":null}}`,
+			visits: 0,
+		},
+		{
+			name:        "single visit object instead of array",
+			body:        `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":{"MonitoredVehicleJourney":{"DestinationName":"Castro"}}}}}`,
+			visits:      1,
+			destination: "Castro",
+		},
+		{
+			name:        "multiple deliveries",
+			body:        `{"ServiceDelivery":{"StopMonitoringDelivery":[{"MonitoredStopVisit":[{}]},{"MonitoredStopVisit":[{"MonitoredVehicleJourney":{"DestinationName":"x"}}]}]}}`,
+			visits:      2,
+			destination: "",
+		},
+		{
+			name:        "numeric and multilingual fields",
+			body:        `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[{"MonitoredVehicleJourney":{"LineRef":14,"DestinationName":[{"value":"Daly City","lang":"en"}],"Monitored":"true"}}]}}}`,
+			visits:      1,
+			destination: "Daly City",
+		},
+		{
+			name:   "wrong types everywhere",
+			body:   `{"ServiceDelivery":"nope"}`,
+			visits: 0,
+		},
+		{
+			name:        "Siri wrapper",
+			body:        `{"Siri":{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[{"MonitoredVehicleJourney":{"DestinationName":"Ocean Beach"}}]}}}}`,
+			visits:      1,
+			destination: "Ocean Beach",
+		},
+		{
+			name:   "XML with bad boolean",
+			body:   `<Siri><ServiceDelivery><StopMonitoringDelivery><MonitoredStopVisit><MonitoredVehicleJourney><Monitored>maybe</Monitored></MonitoredVehicleJourney></MonitoredStopVisit></StopMonitoringDelivery></ServiceDelivery></Siri>`,
+			visits: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ParseStopMonitoring([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("ParseStopMonitoring: %v", err)
+			}
+			visits := resp.Visits()
+			if len(visits) != tt.visits {
+				t.Fatalf("got %d visits, want %d", len(visits), tt.visits)
+			}
+			if tt.destination != "" && visits[len(visits)-1].MonitoredVehicleJourney.DestinationName != tt.destination {
+				t.Errorf("destination = %q, want %q", visits[len(visits)-1].MonitoredVehicleJourney.DestinationName, tt.destination)
+			}
+		})
+	}
+}
+
+func TestParseStopMonitoringRejects(t *testing.T) {
+	for _, body := range []string{"", "   ", "[]", `"text"`, `{"ServiceDelivery":`, "<Siri><ServiceDelivery>"} {
+		if _, err := ParseStopMonitoring([]byte(body)); err == nil {
+			t.Errorf("expected error for %q", body)
+		}
+	}
+}
+
+func TestParseStopMonitoringLimits(t *testing.T) {
+	huge := bytes.Repeat([]byte(" "), MaxBodySize+1)
+	if _, err := ParseStopMonitoring(huge); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[`)
+	for i := 0; i < MaxVisits+10; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`{}`)
+	}
+	b.WriteString(`]}}}`)
+
+	resp, err := ParseStopMonitoring(b.Bytes())
+	if err != nil {
+		t.Fatalf("ParseStopMonitoring: %v", err)
+	}
+	if len(resp.Visits()) != MaxVisits {
+		t.Errorf("got %d visits, want cap of %d", len(resp.Visits()), MaxVisits)
+	}
+}
+
+func FuzzParseStopMonitoring(f *testing.F) {
+	f.Add([]byte(sampleJSON))
+	f.Add([]byte(sampleXML))
+	f.Add([]byte(`{"ServiceDelivery":{"StopMonitoringDelivery":[{"MonitoredStopVisit":{"MonitoredVehicleJourney":null}}]}}`))
+	f.Add([]byte(`{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[{"MonitoredVehicleJourney":{"DestinationName":[[[]]],"MonitoredCall":7}}]}}}`))
+	f.Add([]byte("\xEF\xBB\xBF<Siri/>"))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		resp, err := ParseStopMonitoring(body)
+		if err != nil {
+			return
+		}
+		if len(resp.Visits()) > MaxVisits {
+			t.Fatalf("visits exceed cap: %d", len(resp.Visits()))
+		}
+		for _, v := range resp.Visits() {
+			v.MonitoredVehicleJourney.MonitoredCall.ExpectedTime()
+		}
+	})
+}
+
+func TestParseVehicleFeatures(t *testing.T) {
+	bodies := []string{
+		`{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[{"MonitoredVehicleJourney":{"VehicleFeatureRef":["lowFloor","bicycleRack"]}}]}}}`,
+		`{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[{"MonitoredVehicleJourney":{"VehicleFeatureRef":"lowFloor"}}]}}}`,
+		`<Siri><ServiceDelivery><StopMonitoringDelivery><MonitoredStopVisit><MonitoredVehicleJourney><VehicleFeatureRef>lowFloor</VehicleFeatureRef><VehicleFeatureRef>bicycleRack</VehicleFeatureRef></MonitoredVehicleJourney></MonitoredStopVisit></StopMonitoringDelivery></ServiceDelivery></Siri>`,
+	}
+	for _, body := range bodies {
+		resp, err := ParseStopMonitoring([]byte(body))
+		if err != nil {
+			t.Fatalf("ParseStopMonitoring: %v", err)
+		}
+		features := resp.Visits()[0].MonitoredVehicleJourney.VehicleFeatureRef
+		if len(features) == 0 || features[0] != "lowFloor" {
+			t.Errorf("features = %v, want lowFloor first", features)
+		}
+	}
+}
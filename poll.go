@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Long polls wait 30 seconds unless ?timeout= asks otherwise, and never
+// more than a minute, which proxies commonly allow an idle response
+const (
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 60 * time.Second
+)
+
+// longPollRoutes hold requests open on purpose, so they aren't slow
+var longPollRoutes = map[string]bool{"/api/arrivals/poll": true, "/api/events": true}
+
+// PollResponse answers a long poll. Arrivals is left out when the poll
+// timed out without a change.
+type PollResponse struct {
+	Cursor   string            `json:"cursor"`
+	Changed  bool              `json:"changed"`
+	Arrivals *ArrivalsResponse `json:"arrivals,omitempty"`
+}
+
+// store replaces the cached arrivals, moving the revision on and waking
+// pollers when anything a client would see has changed. Each direction
+// keeps the revision it last changed at, for delta updates. What changed
+// is published on the event bus once the lock is released.
+func (c *ArrivalsCache) store(data ArrivalsResponse, fetched time.Time) {
+	c.mu.Lock()
+	prev, from := c.storeLocked(data, fetched)
+	data = c.data
+	c.mu.Unlock()
+	events.cacheStored(prev, data, from)
+}
+
+// update stores change's copy of the cached arrivals, holding the lock
+// throughout so concurrent updates aren't lost, and returns it. The fetch
+// time is left alone.
+func (c *ArrivalsCache) update(change func(ArrivalsResponse) ArrivalsResponse) ArrivalsResponse {
+	c.mu.Lock()
+	prev, from := c.storeLocked(change(c.data), c.lastFetched)
+	data := c.data
+	c.mu.Unlock()
+	events.cacheStored(prev, data, from)
+	return data
+}
+
+// adopt takes on cached arrivals from elsewhere, like a snapshot or
+// another instance, revisions and all, so cursors and delta revisions
+// carry over. It returns the replaced arrivals.
+func (c *ArrivalsCache) adopt(data ArrivalsResponse, fetched time.Time, revision, layoutRevision uint64) ArrivalsResponse {
+	c.mu.Lock()
+	c.init()
+	prev, from := c.data, c.revision
+	c.data, c.lastFetched, c.layoutRevision = data, fetched, layoutRevision
+	if revision != c.revision {
+		c.revision = revision
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+	c.mu.Unlock()
+	events.cacheStored(prev, data, from)
+	return prev
+}
+
+// storeLocked returns the replaced snapshot and the revision it was at
+func (c *ArrivalsCache) storeLocked(data ArrivalsResponse, fetched time.Time) (ArrivalsResponse, uint64) {
+	c.init()
+	prev, from := c.data, c.revision
+	next := c.revision + 1
+	changed := layoutChanged(c.data, data)
+	if changed {
+		c.layoutRevision = next
+	}
+	for i := range data.Stops {
+		for j := range data.Stops[i].Directions {
+			dir := &data.Stops[i].Directions[j]
+			dir.Revision = next
+			if !changed && !directionChanged(c.data.Stops[i].Directions[j], *dir) {
+				dir.Revision = c.data.Stops[i].Directions[j].Revision
+				continue
+			}
+			changed = true
+		}
+	}
+	c.data = data
+	c.lastFetched = fetched
+	if changed {
+		c.revision = next
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+	return prev, from
+}
+
+// watch returns the current revision and a channel closed when it moves on
+func (c *ArrivalsCache) watch() (uint64, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.init()
+	return c.revision, c.changed
+}
+
+// init seeds the revision from the clock, so revisions keep increasing
+// across restarts and a client's old cursor never matches a new one
+func (c *ArrivalsCache) init() {
+	if c.changed == nil {
+		c.revision = uint64(clock.Now().Unix())
+		c.changed = make(chan struct{})
+	}
+}
+
+// layoutChanged reports whether two cached snapshots hold different stops
+// or directions, as after a config change
+func layoutChanged(a, b ArrivalsResponse) bool {
+	if len(a.Stops) != len(b.Stops) {
+		return true
+	}
+	for i := range a.Stops {
+		if a.Stops[i].Name != b.Stops[i].Name || a.Stops[i].Line != b.Stops[i].Line ||
+			len(a.Stops[i].Directions) != len(b.Stops[i].Directions) {
+			return true
+		}
+		for j := range a.Stops[i].Directions {
+			if a.Stops[i].Directions[j].StopID != b.Stops[i].Directions[j].StopID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// directionChanged compares two fetches of a direction. Minutes and
+// seconds are left out: they count down from the fetch time, while the
+// arrival times they come from are compared.
+func directionChanged(a, b DirectionArrivals) bool {
+	return !reflect.DeepEqual(comparableDirection(a), comparableDirection(b))
+}
+
+func comparableDirection(d DirectionArrivals) DirectionArrivals {
+	d.FetchedAt, d.UpdatedAt, d.FetchError, d.Revision = time.Time{}, time.Time{}, "", 0
+	arrivals := make([]Arrival, len(d.Arrivals))
+	for i, a := range d.Arrivals {
+		a.Minutes, a.Seconds = 0, 0
+		arrivals[i] = a
+	}
+	d.Arrivals = arrivals
+	return d
+}
+
+// parsePollTimeout reads ?timeout= in seconds
+func parsePollTimeout(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("timeout")
+	if v == "" {
+		return defaultPollTimeout, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || time.Duration(n)*time.Second > maxPollTimeout {
+		return 0, fmt.Errorf("timeout must be 1-%d seconds", int(maxPollTimeout.Seconds()))
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+// handlePoll serves /api/arrivals/poll?cursor=. A request without a
+// cursor, or with one from an older revision, is answered at once. One
+// with the current cursor is held until the cache changes or the timeout
+// passes, so clients that can't keep a stream open still hear about new
+// arrivals within moments of the fetch.
+func handlePoll(w http.ResponseWriter, r *http.Request) {
+	page, err := parseArrivalsPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	timeout, err := parsePollTimeout(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	favorites, err := requestFavorites(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	revision, changed := cache.watch()
+	if r.URL.Query().Get("cursor") == strconv.FormatUint(revision, 10) {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	resp := PollResponse{Cursor: r.URL.Query().Get("cursor")}
+	if latest, _ := cache.watch(); resp.Cursor != strconv.FormatUint(latest, 10) {
+		now := clock.Now()
+		arrivals := buildArrivalsPage(now, page)
+		if favorites != nil {
+			arrivals = filterFavorites(arrivals, favorites)
+		}
+		setDataAge(w, arrivals, now)
+		resp = PollResponse{Cursor: strconv.FormatUint(latest, 10), Changed: true, Arrivals: &arrivals}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
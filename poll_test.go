@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLongPoll(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 3, 8, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-03-08T20:05:00Z"}}}
+	]}}}`)
+	refreshCache()
+
+	poll := func(query string) PollResponse {
+		rec := httptest.NewRecorder()
+		handlePoll(rec, httptest.NewRequest("GET", "/api/arrivals/poll"+query, nil))
+		var resp PollResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("poll%s: %d %v %s", query, rec.Code, err, rec.Body)
+		}
+		return resp
+	}
+
+	first := poll("")
+	if !first.Changed || first.Cursor == "" || first.Arrivals == nil || len(first.Arrivals.Stops) != 1 {
+		t.Fatalf("first poll = %+v", first)
+	}
+
+	// A refresh that fetches the same arrivals isn't a change
+	revision, _ := cache.watch()
+	refreshCache()
+	if again, _ := cache.watch(); again != revision {
+		t.Errorf("unchanged refresh moved revision %d to %d", revision, again)
+	}
+	start := time.Now()
+	if resp := poll("?timeout=1&cursor=" + first.Cursor); resp.Changed || resp.Arrivals != nil || resp.Cursor != first.Cursor || time.Since(start) < time.Second {
+		t.Errorf("timed out poll = %+v after %v", resp, time.Since(start))
+	}
+
+	done := make(chan PollResponse)
+	go func() { done <- poll("?cursor=" + first.Cursor) }()
+	cache.mu.RLock()
+	data := cache.data
+	cache.mu.RUnlock()
+	moved := data
+	moved.Stops = []StopArrivals{data.Stops[0]}
+	moved.Stops[0].Directions = []DirectionArrivals{data.Stops[0].Directions[0]}
+	moved.Stops[0].Directions[0].Arrivals = arrivalsAt(time.Date(2026, 3, 8, 20, 7, 0, 0, time.UTC))
+	cache.store(moved, clock.Now())
+
+	select {
+	case resp := <-done:
+		if !resp.Changed || resp.Cursor == first.Cursor || resp.Arrivals.Stops[0].Directions[0].Arrivals[0].Minutes != 6 {
+			t.Errorf("woken poll = %+v", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll was not woken by the change")
+	}
+
+	for _, q := range []string{"?timeout=0", "?timeout=61", "?limit=x"} {
+		rec := httptest.NewRecorder()
+		handlePoll(rec, httptest.NewRequest("GET", "/api/arrivals/poll"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d", q, rec.Code)
+		}
+	}
+}
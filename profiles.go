@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProfilesConfig enables server-side favorites, so each device or user can
+// keep its own selection of stops under a private token
+type ProfilesConfig struct {
+	// JSON file the profiles are stored in; profiles are off when empty
+	File string `yaml:"file,omitempty"`
+	// Most profiles the server will hold (default 100)
+	MaxProfiles int `yaml:"max_profiles,omitempty"`
+	// New profiles one client IP may create per hour (default 10). Admins
+	// and clients with a client key aren't limited.
+	CreatesPerHour int `yaml:"creates_per_hour,omitempty"`
+}
+
+func (p ProfilesConfig) enabled() bool {
+	return p.File != ""
+}
+
+func validateProfilesConfig(p *ProfilesConfig) error {
+	if p.MaxProfiles < 0 {
+		return fmt.Errorf("profiles.max_profiles cannot be negative")
+	}
+	if p.MaxProfiles == 0 {
+		p.MaxProfiles = 100
+	}
+	if p.CreatesPerHour < 0 {
+		return fmt.Errorf("profiles.creates_per_hour cannot be negative")
+	}
+	if p.CreatesPerHour == 0 {
+		p.CreatesPerHour = 10
+	}
+	return nil
+}
+
+// maxFavoriteSets bounds the named sets in one profile
+const maxFavoriteSets = 20
+
+// maxProfileSize bounds profile documents accepted over the API
+const maxProfileSize = 64 << 10
+
+// profileToken is what clients may use as a profile token. Tokens are
+// chosen by the client and act as the only credential, so they must be
+// long enough not to be guessed.
+var profileToken = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+// FavoriteSet is a named selection of configured stop codes
+type FavoriteSet struct {
+	Name    string   `json:"name"`
+	StopIDs []string `json:"stop_ids"`
+}
+
+// Profile is one device's or user's saved favorites
+type Profile struct {
+	Favorites []FavoriteSet `json:"favorites"`
+	// Set used when a request doesn't name one (default: the first)
+	Active    string    `json:"active,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// favoriteSet returns the named set, or the active set when name is empty
+func (p Profile) favoriteSet(name string) (FavoriteSet, bool) {
+	if name == "" {
+		name = p.Active
+	}
+	for _, set := range p.Favorites {
+		if name == "" || set.Name == name {
+			return set, true
+		}
+	}
+	return FavoriteSet{}, false
+}
+
+// validateProfile checks a profile against the configured stops
+func validateProfile(p *Profile, cfg *Config) error {
+	if len(p.Favorites) > maxFavoriteSets {
+		return fmt.Errorf("at most %d favorite sets are allowed", maxFavoriteSets)
+	}
+
+	known := make(map[string]bool)
+	for _, stop := range allStops(cfg) {
+		for _, dir := range stop.Directions {
+			known[dir.StopID] = true
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, set := range p.Favorites {
+		if strings.TrimSpace(set.Name) == "" {
+			return fmt.Errorf("favorites[%d] needs a name", i)
+		}
+		if names[set.Name] {
+			return fmt.Errorf("favorite set %q is used twice", set.Name)
+		}
+		names[set.Name] = true
+		for _, id := range set.StopIDs {
+			if !known[id] {
+				return fmt.Errorf("favorite set %q: stop %q is not configured", set.Name, id)
+			}
+		}
+	}
+	if p.Active != "" && !names[p.Active] {
+		return fmt.Errorf("active set %q does not exist", p.Active)
+	}
+	return nil
+}
+
+// profiles maps tokens to saved profiles
+var profiles = &jsonStore[Profile]{}
+
+// profileCreations limits how fast each client IP creates profiles, so
+// one client can't fill max_profiles for everyone
+var profileCreations = &ipLimiter{buckets: make(map[string]*tokenBucket)}
+
+// errCreateLimited is returned for a new profile over creates_per_hour
+var errCreateLimited = errors.New("too many new profiles from this address; try again later")
+
+// profileCreateWait returns how long until r may create a profile, 0 if
+// it may now
+func profileCreateWait(r *http.Request, cfg *Config) time.Duration {
+	if _, ok := clientKeyName(r); ok || adminAuthorized(r) {
+		return 0
+	}
+	n := cfg.Profiles.CreatesPerHour
+	_, wait := profileCreations.allow(peerHost(r), float64(n)/3600, n, clock.Now())
+	return wait
+}
+
+// handleProfile reads (GET), saves (PUT) or removes (DELETE) the profile
+// at /api/profiles/{token}
+func handleProfile(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	if !cfg.Profiles.enabled() {
+		http.Error(w, "profiles are not enabled", http.StatusNotFound)
+		return
+	}
+
+	token := strings.TrimPrefix(apiPath(r.URL.Path), "/api/profiles/")
+	if !profileToken.MatchString(token) {
+		http.Error(w, "profile token must be 16-128 letters, digits, '-' or '_'", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		p, ok, err := profiles.get(cfg.Profiles.File, token)
+		if err != nil {
+			errorf("Profiles: %v", err)
+			http.Error(w, "profiles unavailable", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "profile not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+
+	case http.MethodPut:
+		var p Profile
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProfileSize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&p); err != nil {
+			http.Error(w, "invalid profile: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateProfile(&p, cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if p.Favorites == nil {
+			p.Favorites = []FavoriteSet{}
+		}
+		p.UpdatedAt = clock.Now().UTC()
+
+		var wait time.Duration
+		_, err := profiles.update(cfg.Profiles.File, token, cfg.Profiles.MaxProfiles, func(_ Profile, exists bool) (Profile, error) {
+			if !exists {
+				if wait = profileCreateWait(r, cfg); wait > 0 {
+					return Profile{}, errCreateLimited
+				}
+			}
+			return p, nil
+		})
+		if err != nil {
+			if errors.Is(err, errStoreFull) {
+				http.Error(w, "profile limit reached", http.StatusInsufficientStorage)
+				return
+			}
+			if errors.Is(err, errCreateLimited) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			errorf("Profiles: %v", err)
+			http.Error(w, "saving profile failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+
+	case http.MethodDelete:
+		ok, err := profiles.delete(cfg.Profiles.File, token)
+		if err != nil {
+			errorf("Profiles: %v", err)
+			http.Error(w, "deleting profile failed", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "profile not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// requestFavorites returns the stop codes selected by the request's
+// ?profile= token and optional ?set= name, or nil when none is given
+func requestFavorites(r *http.Request) (map[string]bool, error) {
+	token := r.URL.Query().Get("profile")
+	if token == "" {
+		return nil, nil
+	}
+	cfg := currentConfig()
+	if !cfg.Profiles.enabled() {
+		return nil, fmt.Errorf("profiles are not enabled")
+	}
+
+	p, ok, err := profiles.get(cfg.Profiles.File, token)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("profile not found")
+	}
+	set, ok := p.favoriteSet(r.URL.Query().Get("set"))
+	if !ok {
+		return nil, fmt.Errorf("favorite set not found")
+	}
+
+	ids := make(map[string]bool, len(set.StopIDs))
+	for _, id := range set.StopIDs {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// favoriteStops keeps the directions whose stop code is a favorite,
+// dropping stops left with none
+func favoriteStops(stops []Stop, ids map[string]bool) []Stop {
+	out := []Stop{}
+	for _, stop := range stops {
+		var dirs []Direction
+		for _, dir := range stop.Directions {
+			if ids[dir.StopID] {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) > 0 {
+			stop.Directions = dirs
+			out = append(out, stop)
+		}
+	}
+	return out
+}
+
+// configStops is the /api/config stop list, narrowed to the request's
+// favorites when it names a profile
+func configStops(r *http.Request, stops []Stop) ([]Stop, error) {
+	favorites, err := requestFavorites(r)
+	if err != nil {
+		return nil, err
+	}
+	stops = labelledStops(stops)
+	if favorites != nil {
+		stops = favoriteStops(stops, favorites)
+	}
+	return stops, nil
+}
+
+// filterFavorites is favoriteStops for an arrivals response
+func filterFavorites(resp ArrivalsResponse, ids map[string]bool) ArrivalsResponse {
+	stops := []StopArrivals{}
+	for _, stop := range resp.Stops {
+		var dirs []DirectionArrivals
+		for _, dir := range stop.Directions {
+			if ids[dir.StopID] {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) > 0 {
+			stop.Directions = dirs
+			stops = append(stops, stop)
+		}
+	}
+	resp.Stops = stops
+	return resp
+}
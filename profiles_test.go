@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfiles(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+profiles:
+  file: ` + filepath.Join(t.TempDir(), "profiles.json") + `
+  creates_per_hour: 2
+client_keys: [{name: kiosk, key: kiosk-key}]
+stops:
+  - name: Embarcadero
+    directions: [{label: Ocean Beach, stop_id: "16994"}]
+  - name: 16th & Mission
+    directions: [{label: Marina, stop_id: "13300"}]
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+	if cfg.Profiles.MaxProfiles != 100 {
+		t.Errorf("max_profiles = %d, want 100", cfg.Profiles.MaxProfiles)
+	}
+
+	const token = "kitchen-display-0001"
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleProfile(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do("GET", "/api/profiles/short", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("short token: HTTP %d", rec.Code)
+	}
+	if rec := do("PUT", "/api/profiles/"+token, `{"favorites":[{"name":"Home","stop_ids":["99999"]}]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown stop: HTTP %d", rec.Code)
+	}
+	profileCreations = &ipLimiter{buckets: make(map[string]*tokenBucket)}
+	if rec := do("PUT", "/api/profiles/"+token, `{"favorites":[{"name":"Work","stop_ids":["16994"]},{"name":"Home","stop_ids":["13300"]}],"active":"Home"}`); rec.Code != http.StatusOK {
+		t.Fatalf("put: HTTP %d: %s", rec.Code, rec.Body)
+	}
+
+	// One address can only create so many profiles an hour, but may keep
+	// saving its own, and clients with a key aren't limited
+	if rec := do("PUT", "/api/profiles/kitchen-display-0002", `{}`); rec.Code != http.StatusOK {
+		t.Errorf("second profile: HTTP %d", rec.Code)
+	}
+	if rec := do("PUT", "/api/profiles/kitchen-display-0003", `{}`); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1800" {
+		t.Errorf("third profile: HTTP %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do("PUT", "/api/profiles/kitchen-display-0002", `{}`); rec.Code != http.StatusOK {
+		t.Errorf("saving an existing profile: HTTP %d", rec.Code)
+	}
+	req := httptest.NewRequest("PUT", "/api/profiles/kitchen-display-0003", strings.NewReader(`{}`))
+	req.Header.Set("X-API-Key", "kiosk-key")
+	rec := httptest.NewRecorder()
+	handleProfile(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("profile created with a client key: HTTP %d", rec.Code)
+	}
+
+	// A fresh store reads the profile back from disk
+	profiles = &jsonStore[Profile]{}
+	rec = do("GET", "/api/profiles/"+token, "")
+	var p Profile
+	json.NewDecoder(rec.Body).Decode(&p)
+	if rec.Code != http.StatusOK || len(p.Favorites) != 2 || p.Active != "Home" {
+		t.Fatalf("get: HTTP %d, %+v", rec.Code, p)
+	}
+
+	cache.mu.Lock()
+	cache.data = ArrivalsResponse{Stops: []StopArrivals{
+		{Name: "Embarcadero", Directions: []DirectionArrivals{{StopID: "16994"}}},
+		{Name: "16th & Mission", Directions: []DirectionArrivals{{StopID: "13300"}}},
+	}}
+	cache.mu.Unlock()
+
+	for set, want := range map[string]string{"": "16th & Mission", "Work": "Embarcadero"} {
+		rec := httptest.NewRecorder()
+		handleArrivals(rec, httptest.NewRequest("GET", "/api/arrivals?profile="+token+"&set="+set, nil))
+		var resp ArrivalsResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if len(resp.Stops) != 1 || resp.Stops[0].Name != want {
+			t.Errorf("set %q: stops = %+v, want only %s", set, resp.Stops, want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handleConfig(rec, httptest.NewRequest("GET", "/api/config?profile="+token, nil))
+	var config ConfigResponse
+	json.NewDecoder(rec.Body).Decode(&config)
+	if len(config.Stops) != 1 || config.Stops[0].Name != "16th & Mission" {
+		t.Errorf("config stops = %+v", config.Stops)
+	}
+
+	if rec := do("DELETE", "/api/profiles/"+token, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: HTTP %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handleArrivals(rec, httptest.NewRequest("GET", "/api/arrivals?profile="+token, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("deleted profile: HTTP %d", rec.Code)
+	}
+}
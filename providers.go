@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Provider fetches a stop's upcoming arrivals from a transit backend.
+// Arrivals need ArrivalTime (RFC3339) and Destination; minutes and status
+// are worked out from the arrival time when the cache is read.
+type Provider interface {
+	Arrivals(ctx context.Context, agency, stopID string) ([]Arrival, error)
+}
+
+// ProviderFunc lets an ordinary function be a Provider
+type ProviderFunc func(ctx context.Context, agency, stopID string) ([]Arrival, error)
+
+func (f ProviderFunc) Arrivals(ctx context.Context, agency, stopID string) ([]Arrival, error) {
+	return f(ctx, agency, stopID)
+}
+
+// ProviderSpec is how a backend is plugged in: a provider, and a check of
+// the config it runs with
+type ProviderSpec struct {
+	Provider Provider
+	// Validate checks and fills in defaults for the provider's settings.
+	// It may be nil.
+	Validate func(*Config) error
+}
+
+// providers holds the backends config.provider can name
+var providers = map[string]ProviderSpec{}
+
+// registerProvider makes a backend available as config.provider. A city
+// adds one by dropping a file into this package that calls it from
+// init, or without recompiling through the exec provider.
+func registerProvider(name string, spec ProviderSpec) {
+	if _, dup := providers[name]; dup {
+		panic("provider " + name + " registered twice")
+	}
+	if spec.Provider == nil {
+		panic("provider " + name + " has no Provider")
+	}
+	providers[name] = spec
+}
+
+func init() {
+	registerProvider("511", ProviderSpec{
+		Provider: ProviderFunc(fetchStopArrivals),
+		Validate: func(config *Config) error {
+			if config.APIKey == "" {
+				return fmt.Errorf("api_key is required in config")
+			}
+			return nil
+		},
+	})
+	registerProvider("replay", ProviderSpec{
+		Provider: ProviderFunc(func(_ context.Context, agency, stopID string) ([]Arrival, error) {
+			return replayStopArrivals(agency, stopID)
+		}),
+	})
+	registerProvider("simulator", ProviderSpec{
+		Provider: ProviderFunc(func(_ context.Context, agency, stopID string) ([]Arrival, error) {
+			return simulateStopArrivals(agency, stopID, clock.Now())
+		}),
+		Validate: validateSimulatorConfig,
+	})
+}
+
+// providerNames lists the registered providers, for error messages
+func providerNames() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateProvider defaults config.provider to 511 and runs its checks
+func validateProvider(config *Config) error {
+	if config.Provider == "" {
+		config.Provider = "511"
+	}
+	spec, ok := providers[config.Provider]
+	if !ok {
+		return fmt.Errorf("unknown provider %q; available: %v", config.Provider, providerNames())
+	}
+	if spec.Validate == nil {
+		return nil
+	}
+	return spec.Validate(config)
+}
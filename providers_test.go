@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProviders(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 3, 11, 20, 0, 0, 0, time.UTC), "")
+
+	registerProvider("test-fixed", ProviderSpec{
+		Provider: ProviderFunc(func(_ context.Context, agency, stopID string) ([]Arrival, error) {
+			return []Arrival{{ArrivalTime: "2026-03-11T20:06:00Z", Destination: "Stop " + stopID}}, nil
+		}),
+	})
+	t.Cleanup(func() { delete(providers, "test-fixed") })
+
+	load := func(yaml string) *Config {
+		t.Helper()
+		cfg, err := parseConfig([]byte(yaml + `
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: "16994"
+`))
+		if err != nil {
+			t.Fatalf("parseConfig: %v", err)
+		}
+		activeConfig.Store(cfg)
+		return cfg
+	}
+	firstDirection := func() DirectionArrivals {
+		refreshCache()
+		return buildArrivalsResponse(clock.Now()).Stops[0].Directions[0]
+	}
+
+	load("provider: test-fixed")
+	if dir := firstDirection(); len(dir.Arrivals) != 1 || dir.Arrivals[0].Destination != "Stop 16994" {
+		t.Errorf("registered provider = %+v", dir)
+	}
+
+	cfg := load(`
+provider: exec
+exec:
+  command: ["sh", "-c", "grep -q '\"stop_id\":\"16994\"' && echo '{\"arrivals\":[{\"arrival_time\":\"2026-03-11T20:09:00Z\",\"destination\":\"Ocean Beach\",\"line\":\"N\",\"realtime\":true}]}'"]
+`)
+	if cfg.Exec.Timeout != 10 {
+		t.Errorf("exec.timeout default = %d", cfg.Exec.Timeout)
+	}
+	if dir := firstDirection(); len(dir.Arrivals) != 1 || dir.Arrivals[0].Destination != "Ocean Beach" || !dir.Arrivals[0].Realtime || dir.Arrivals[0].Minutes != 9 {
+		t.Errorf("exec provider = %+v", dir)
+	}
+
+	for script, want := range map[string]string{
+		`echo 'no such stop' >&2; exit 3`: "exit status 3: no such stop",
+		`echo '{"error":"feed is down"}'`: "feed is down",
+		`echo 'not json'`:                 "invalid response",
+	} {
+		cfg := *currentConfig()
+		cfg.Exec.Command = []string{"sh", "-c", "cat >/dev/null; " + script}
+		activeConfig.Store(&cfg)
+		if _, err := fetchArrivals(context.Background(), "SF", "16994"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", script, err, want)
+		}
+	}
+
+	for yaml, want := range map[string]string{
+		"provider: portland": `unknown provider "portland"`,
+		"provider: exec":     "exec.command is required",
+	} {
+		if _, err := parseConfig([]byte(yaml + "\nstops: [{name: A, directions: [{label: B, stop_id: \"1\"}]}]")); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", yaml, err, want)
+		}
+	}
+
+	// Only config.yaml may choose the program
+	running := load("provider: \"511\"\napi_key: test")
+	for _, edit := range []func(c *Config){
+		func(c *Config) { c.Provider = "exec"; c.Exec.Command = []string{"sh"} },
+		func(c *Config) { c.Exec.Command = []string{"sh"} },
+	} {
+		cfg := *running
+		edit(&cfg)
+		if err := checkExecUnchanged(&cfg, running); err != errExecConfig {
+			t.Errorf("exec edit %+v: err = %v", cfg.Exec, err)
+		}
+	}
+	current := load("provider: exec\nexec: {command: [true]}")
+	unchanged, away := *current, *current
+	away.Provider = "511"
+	if checkExecUnchanged(&unchanged, current) != nil || checkExecUnchanged(&away, current) != nil {
+		t.Error("an unchanged exec config, or a switch away from it, was refused")
+	}
+	local := []byte("api_key: test\nstops: [{name: A, directions: [{label: B, stop_id: \"1\"}]}]\n")
+	for _, remote := range []string{"provider: exec\n", "exec: {command: [sh]}\n"} {
+		if _, err := mergeRemoteConfig(local, []byte(remote)); err == nil {
+			t.Errorf("remote config %q was accepted", remote)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func parseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			if ip := net.ParseIP(e); ip != nil && ip.To4() != nil {
+				e += "/32"
+			} else {
+				e += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted_proxies entry %q: %w", e, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range currentConfig().proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerHost is the direct peer's address, without the port
+func peerHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+// trustedPeer reports whether the direct peer is a trusted proxy.
+// Connections over a unix socket always come from a local proxy.
+func trustedPeer(r *http.Request) bool {
+	host := peerHost(r)
+	peer := net.ParseIP(host)
+	if peer == nil {
+		return host == "" || host == "@"
+	}
+	return isTrustedProxy(peer)
+}
+
+// clientIP resolves the real client address. Forwarding headers are only
+// honored when the direct peer is a trusted proxy.
+func clientIP(r *http.Request) string {
+	host := peerHost(r)
+	if !trustedPeer(r) {
+		return host
+	}
+
+	// Walk X-Forwarded-For right to left, skipping our own proxies
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		parts := strings.Split(xff, ",")
+		for i := len(parts) - 1; i >= 0; i-- {
+			candidate := strings.TrimSpace(parts[i])
+			ip := net.ParseIP(candidate)
+			if ip == nil {
+				break
+			}
+			if i == 0 || !isTrustedProxy(ip) {
+				return candidate
+			}
+		}
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+
+	return host
+}
+
+// realIPMiddleware rewrites RemoteAddr to the resolved client IP so
+// logging and per-client features see the real address
+func realIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = net.JoinHostPort(clientIP(r), "0")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder captures the response status and size for access
+// logging and metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection, so streamed
+// responses can flush through the middleware
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// tokenRoutes are the routes whose next path segment is a credential
+var tokenRoutes = []string{"/api/profiles/", "/api/rules/"}
+
+// loggedPath is the request's path with any token in it redacted, so
+// logs don't hand out access to profiles and saved rules
+func loggedPath(r *http.Request) string {
+	path := r.URL.Path
+	api := apiPath(path)
+	for _, route := range tokenRoutes {
+		rest, ok := strings.CutPrefix(api, route)
+		if !ok || rest == "" {
+			continue
+		}
+		_, tail, more := strings.Cut(rest, "/")
+		out := path[:len(path)-len(api)] + route + redacted
+		if more {
+			out += "/" + tail
+		}
+		return out
+	}
+	return path
+}
+
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !currentConfig().AccessLog {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		infof("%s %s %s %d %v trace=%s", host, r.Method, loggedPath(r), rec.status, time.Since(start).Round(time.Millisecond), traceID(r.Context()))
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 3, 2, 20, 0, 0, 0, time.UTC), "")
+	cfg, err := parseConfig([]byte("api_key: test\naccess_log: true\n" + testStop))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	// Tokens in paths are credentials, and stay out of logs
+	for path, want := range map[string]string{
+		"/api/arrivals":                         "/api/arrivals",
+		"/api/profiles/":                        "/api/profiles/",
+		"/api/profiles/kitchen-display-0001":    "/api/profiles/REDACTED",
+		"/api/rules/household-member-1":         "/api/rules/REDACTED",
+		"/api/rules/household-member-1/commute": "/api/rules/REDACTED/commute",
+	} {
+		if got := loggedPath(httptest.NewRequest("GET", path, nil)); got != want {
+			t.Errorf("loggedPath(%s) = %s, want %s", path, got, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	handler := accessLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/profiles/kitchen-display-0001?set=Home", nil))
+	if out := buf.String(); strings.Contains(out, "kitchen-display") || !strings.Contains(out, "GET /api/profiles/REDACTED 200") {
+		t.Errorf("access log:\n%s", out)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 3, 5, 20, 0, 0, 0, time.UTC), "")
+
+	for _, bad := range []string{"10.0.0.300", "proxy.local", "10.0.0.0/33"} {
+		if _, err := parseConfig([]byte("api_key: test\ntrusted_proxies: [" + bad + "]\n" + testStop)); err == nil || !strings.Contains(err.Error(), "invalid trusted_proxies entry") {
+			t.Errorf("trusted_proxies %s: err = %v", bad, err)
+		}
+	}
+
+	cfg, err := parseConfig([]byte("api_key: test\ntrusted_proxies: [10.0.0.1, 192.168.0.0/16, \"::1\"]\n" + testStop))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		realIP string
+		want   string
+	}{
+		{"direct", "203.0.113.9:5000", "", "", "203.0.113.9"},
+		{"untrusted peer's forwarding ignored", "203.0.113.9:5000", "1.2.3.4", "5.6.7.8", "203.0.113.9"},
+		{"neighbour of a trusted address", "10.0.0.2:5000", "1.2.3.4", "", "10.0.0.2"},
+		{"trusted proxy", "10.0.0.1:5000", "1.2.3.4", "", "1.2.3.4"},
+		{"trusted range", "192.168.4.4:5000", "1.2.3.4", "", "1.2.3.4"},
+		{"our proxies skipped", "10.0.0.1:5000", "1.2.3.4, 192.168.1.5", "", "1.2.3.4"},
+		{"spoofed entry left of the client", "10.0.0.1:5000", "6.6.6.6, 1.2.3.4", "", "1.2.3.4"},
+		{"only proxies", "10.0.0.1:5000", "192.168.1.4, 192.168.1.5", "", "192.168.1.4"},
+		{"garbage in the chain", "10.0.0.1:5000", "1.2.3.4, junk", "", "10.0.0.1"},
+		{"garbage falls back to X-Real-IP", "10.0.0.1:5000", "junk", "9.9.9.9", "9.9.9.9"},
+		{"X-Real-IP", "10.0.0.1:5000", "", "9.9.9.9", "9.9.9.9"},
+		{"invalid X-Real-IP", "10.0.0.1:5000", "", "nine", "10.0.0.1"},
+		{"IPv6 proxy", "[::1]:5000", "2001:db8::7", "", "2001:db8::7"},
+		{"untrusted IPv6", "[2001:db8::1]:5000", "1.2.3.4", "", "2001:db8::1"},
+		{"unix socket", "@", "1.2.3.4", "", "1.2.3.4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := clientIP(req); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// upstreamQuota tracks 511.org requests over a sliding one-hour window so
+// on-demand fetches can be refused before they exhaust the hourly budget.
+type upstreamQuota struct {
+	mu    sync.Mutex
+	calls []time.Time
+}
+
+var quota = &upstreamQuota{}
+
+func (q *upstreamQuota) prune(now time.Time) {
+	cutoff := now.Add(-time.Hour)
+	i := 0
+	for i < len(q.calls) && !q.calls[i].After(cutoff) {
+		i++
+	}
+	q.calls = q.calls[i:]
+}
+
+func (q *upstreamQuota) record(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(now)
+	q.calls = append(q.calls, now)
+}
+
+// remaining returns how many upstream requests are left in the current window
+func (q *upstreamQuota) remaining(now time.Time) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune(now)
+	return max(currentConfig().UpstreamHourlyLimit-len(q.calls), 0)
+}
+
+// waitFor returns how long until n requests fit within the budget
+func (q *upstreamQuota) waitFor(n int, now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.wait(n, now)
+}
+
+// take records a request if it fits within the budget, checking and
+// recording under one lock so concurrent callers can't both squeeze in.
+// When it doesn't fit, nothing is recorded and take returns how long
+// until it would.
+func (q *upstreamQuota) take(now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if wait := q.wait(1, now); wait > 0 {
+		return wait
+	}
+	q.calls = append(q.calls, now)
+	return 0
+}
+
+// wait is waitFor with q.mu held
+func (q *upstreamQuota) wait(n int, now time.Time) time.Duration {
+	q.prune(now)
+
+	excess := len(q.calls) + n - currentConfig().UpstreamHourlyLimit
+	if excess <= 0 {
+		return 0
+	}
+	if excess > len(q.calls) {
+		return time.Hour
+	}
+	return q.calls[excess-1].Add(time.Hour).Sub(now)
+}
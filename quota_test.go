@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQuotaCountsStopIDs(t *testing.T) {
+	fc, ft := withTestEnv(t, time.Date(2026, 3, 12, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`)
+	cfg, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: Church & Duboce
+    line: N Judah
+    directions:
+      - label: Outbound
+        stop_ids: ["17072", "14448"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeConfig.Store(cfg)
+	refreshCache()
+	if n := totalRequests(); n != 2 {
+		t.Errorf("totalRequests = %d, want 2", n)
+	}
+
+	// One request left isn't enough for a direction merging two stops
+	fc.Sleep(5 * time.Minute)
+	limited := *currentConfig()
+	quota.remaining(clock.Now())
+	limited.UpstreamHourlyLimit = len(quota.calls) + 1
+	activeConfig.Store(&limited)
+	before := ft.requests
+	if n := len(sched.plan(clock.Now())); n != 0 {
+		t.Errorf("scheduler planned %d batches over quota", n)
+	}
+	rec := httptest.NewRecorder()
+	handleAdminRefresh(rec, httptest.NewRequest("POST", "/api/admin/refresh", nil))
+	if rec.Code != http.StatusTooManyRequests || ft.requests != before {
+		t.Errorf("admin refresh over quota: status %d, %d requests", rec.Code, ft.requests-before)
+	}
+
+	// With the cache empty, a refresh scoped to one stop fetches them all,
+	// so it's checked against a full refresh's requests
+	two, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: A
+    directions: [{label: B, stop_id: "1"}]
+  - name: C
+    directions: [{label: D, stop_id: "2"}]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	quota.remaining(clock.Now())
+	two.UpstreamHourlyLimit = len(quota.calls) + 1
+	activeConfig.Store(two)
+	cache.mu.Lock()
+	cache.data = ArrivalsResponse{}
+	cache.mu.Unlock()
+	rec = httptest.NewRecorder()
+	handleAdminRefresh(rec, httptest.NewRequest("POST", "/api/admin/refresh?stop=A", nil))
+	if rec.Code != http.StatusTooManyRequests || ft.requests != before {
+		t.Errorf("scoped refresh of an empty cache over quota: status %d, %d requests", rec.Code, ft.requests-before)
+	}
+	two.UpstreamHourlyLimit++
+	rec = httptest.NewRecorder()
+	handleAdminRefresh(rec, httptest.NewRequest("POST", "/api/admin/refresh?stop=A", nil))
+	if rec.Code != http.StatusOK || ft.requests != before+2 {
+		t.Errorf("scoped refresh of an empty cache: status %d, %d requests", rec.Code, ft.requests-before)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inbound rate limiting per client IP on /api/*
+type RateLimitConfig struct {
+	Rate  float64 `yaml:"rate,omitempty"`  // requests per second, 0 disables
+	Burst int     `yaml:"burst,omitempty"` // bucket size
+}
+
+func validateRateLimitConfig(rl *RateLimitConfig) error {
+	if rl.Rate < 0 || rl.Burst < 0 {
+		return fmt.Errorf("rate_limit.rate and burst cannot be negative")
+	}
+	if rl.Rate > 0 && rl.Burst == 0 {
+		rl.Burst = int(math.Ceil(rl.Rate)) * 5
+	}
+	return nil
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type ipLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+var limiter = &ipLimiter{buckets: make(map[string]*tokenBucket)}
+
+// allow takes a token for ip, returning how long to wait if none is available
+func (l *ipLimiter) allow(ip string, rate float64, burst int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(rate, burst, now)
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[ip] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have refilled completely so the map stays small
+func (l *ipLimiter) sweep(rate float64, burst int, now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	full := time.Duration(float64(burst) / rate * float64(time.Second))
+	for ip, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl := currentConfig().RateLimit
+		if rl.Rate <= 0 || !strings.HasPrefix(apiPath(r.URL.Path), "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		ok, wait := limiter.allow(ip, rl.Rate, rl.Burst, clock.Now())
+		if !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Date(2026, 3, 5, 20, 0, 0, 0, time.UTC), "")
+
+	for _, bad := range []string{"{rate: -1}", "{rate: 1, burst: -1}"} {
+		if _, err := parseConfig([]byte("api_key: test\nrate_limit: " + bad + "\n" + testStop)); err == nil || !strings.Contains(err.Error(), "cannot be negative") {
+			t.Errorf("rate_limit %s: err = %v", bad, err)
+		}
+	}
+	cfg, err := parseConfig([]byte("api_key: test\nrate_limit: {rate: 1.5}\n" + testStop))
+	if err != nil || cfg.RateLimit.Burst != 10 {
+		t.Errorf("default burst = %d, %v; want 10", cfg.RateLimit.Burst, err)
+	}
+
+	// Requests at offsets from a start time against rate 2/s, burst 3
+	start := time.Date(2026, 3, 5, 20, 0, 0, 0, time.UTC)
+	type request struct {
+		ip    string
+		at    time.Duration
+		ok    bool
+		retry time.Duration
+	}
+	tests := []struct {
+		name     string
+		requests []request
+	}{
+		{"burst", []request{
+			{"a", 0, true, 0}, {"a", 0, true, 0}, {"a", 0, true, 0},
+			{"a", 0, false, 500 * time.Millisecond},
+		}},
+		{"refill", []request{
+			{"a", 0, true, 0}, {"a", 0, true, 0}, {"a", 0, true, 0},
+			{"a", 250 * time.Millisecond, false, 250 * time.Millisecond},
+			{"a", 500 * time.Millisecond, true, 0},
+			{"a", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		}},
+		{"refill caps at burst", []request{
+			{"a", 0, true, 0},
+			{"a", time.Hour, true, 0}, {"a", time.Hour, true, 0}, {"a", time.Hour, true, 0},
+			{"a", time.Hour, false, 500 * time.Millisecond},
+		}},
+		{"rejected requests cost nothing", []request{
+			{"a", 0, true, 0}, {"a", 0, true, 0}, {"a", 0, true, 0},
+			{"a", 0, false, 500 * time.Millisecond}, {"a", 0, false, 500 * time.Millisecond},
+			{"a", 500 * time.Millisecond, true, 0},
+		}},
+		{"clients are separate", []request{
+			{"a", 0, true, 0}, {"a", 0, true, 0}, {"a", 0, true, 0},
+			{"b", 0, true, 0},
+			{"a", 0, false, 500 * time.Millisecond},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &ipLimiter{buckets: make(map[string]*tokenBucket)}
+			for i, req := range tt.requests {
+				ok, retry := l.allow(req.ip, 2, 3, start.Add(req.at))
+				if ok != req.ok || retry != req.retry {
+					t.Errorf("request %d from %s at +%v = %v, retry %v; want %v, %v", i, req.ip, req.at, ok, retry, req.ok, req.retry)
+				}
+			}
+		})
+	}
+
+	// Only API routes are limited
+	cfg.RateLimit = RateLimitConfig{Rate: 0.001, Burst: 1}
+	activeConfig.Store(cfg)
+	old := limiter
+	limiter = &ipLimiter{buckets: make(map[string]*tokenBucket)}
+	t.Cleanup(func() { limiter = old })
+	handler := rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, step := range []struct {
+		path string
+		code int
+	}{
+		{"/api/arrivals", http.StatusOK},
+		{"/api/arrivals", http.StatusTooManyRequests},
+		{"/api/stops", http.StatusTooManyRequests},
+		{"/", http.StatusOK},
+		{"/static/app.js", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", step.path, nil))
+		if rec.Code != step.code {
+			t.Errorf("GET %s = %d, want %d", step.path, rec.Code, step.code)
+		}
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("GET %s: no Retry-After", step.path)
+		}
+	}
+
+	// The bucket refills on the injected clock
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/arrivals", nil))
+	if got := rec.Header().Get("Retry-After"); got != "1000" {
+		t.Errorf("Retry-After = %s, want 1000", got)
+	}
+	fc.Sleep(1000 * time.Second)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/arrivals", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /api/arrivals after the refill = %d", rec.Code)
+	}
+}
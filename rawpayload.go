@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"muni-tracker/pkg/go511"
+)
+
+// maxRawPayload caps how much of each upstream response is kept for
+// /api/debug/raw
+const maxRawPayload = 256 << 10
+
+// rawPayload is the last response 511 sent for a stop
+type rawPayload struct {
+	agency    string
+	fetchedAt time.Time
+	// HTTP status, 0 when no response arrived
+	status    int
+	err       string
+	body      []byte
+	truncated bool
+}
+
+// rawPayloads holds the last upstream response per stop code
+var rawPayloads = struct {
+	sync.Mutex
+	byStop map[string]rawPayload
+}{byStop: make(map[string]rawPayload)}
+
+// keepRawPayload records a StopMonitoring result for the debug endpoint
+func keepRawPayload(agency, stopID string, body []byte, err error) {
+	p := rawPayload{agency: agency, fetchedAt: clock.Now(), status: http.StatusOK}
+	if err != nil {
+		p.err = err.Error()
+		p.status = 0
+		var httpErr *go511.HTTPError
+		if errors.As(err, &httpErr) {
+			p.status = httpErr.StatusCode
+			body = []byte(httpErr.Body)
+		}
+	}
+	if len(body) > maxRawPayload {
+		body, p.truncated = body[:maxRawPayload], true
+	}
+	// Copy so the kept body doesn't pin the parser's buffer
+	p.body = append([]byte(nil), body...)
+
+	rawPayloads.Lock()
+	rawPayloads.byStop[stopID] = p
+	rawPayloads.Unlock()
+}
+
+// handleDebugRaw serves the last raw 511 response for a stop at
+// /api/debug/raw/{stopID}, exactly as received. The fetch time, agency,
+// status and any error are in X-Upstream-* headers.
+func handleDebugRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stopID := strings.TrimPrefix(apiPath(r.URL.Path), "/api/debug/raw/")
+
+	rawPayloads.Lock()
+	p, ok := rawPayloads.byStop[stopID]
+	rawPayloads.Unlock()
+	if !ok {
+		http.Error(w, "no response recorded for stop "+strconv.Quote(stopID), http.StatusNotFound)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	if p.status != http.StatusOK {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Upstream-Agency", p.agency)
+	h.Set("X-Upstream-Fetched-At", localTime(p.fetchedAt).Format(time.RFC3339))
+	h.Set("X-Upstream-Status", strconv.Itoa(p.status))
+	if p.err != "" {
+		h.Set("X-Upstream-Error", p.err)
+	}
+	if p.truncated {
+		h.Set("X-Upstream-Truncated", "true")
+	}
+	w.Write(p.body)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugRaw(t *testing.T) {
+	_, ft := withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":`)
+	cfg, err := parseConfig([]byte(`
+api_key: test
+admin: {token: debug-token-123}
+stops:
+  - name: Embarcadero
+    directions: [{label: Ocean Beach, stop_id: "16994"}]
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	handler := requireAdmin(handleDebugRaw)
+	get := func(path string, auth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if auth {
+			req.Header.Set("Authorization", "Bearer debug-token-123")
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := get("/api/debug/raw/99999", true); rec.Code != http.StatusNotFound {
+		t.Errorf("stop never fetched: HTTP %d", rec.Code)
+	}
+
+	// Even a body that fails to parse is kept verbatim
+	if _, err := fetchStopArrivals(context.Background(), "SF", "16994"); err == nil {
+		t.Fatal("expected a parse error")
+	}
+	if rec := get("/api/debug/raw/16994", false); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without auth: HTTP %d", rec.Code)
+	}
+	rec := get("/api/debug/raw/16994", true)
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"ServiceDelivery":` || rec.Header().Get("X-Upstream-Status") != "200" {
+		t.Errorf("raw = %d %q %v", rec.Code, rec.Body, rec.Header())
+	}
+
+	// Oversized bodies are cut off
+	ft.body = `"` + strings.Repeat("x", maxRawPayload) + `"`
+	fetchStopArrivals(context.Background(), "SF", "16994")
+	rec = get("/api/debug/raw/16994", true)
+	if rec.Body.Len() != maxRawPayload || rec.Header().Get("X-Upstream-Truncated") != "true" {
+		t.Errorf("truncated raw = %d bytes %v", rec.Body.Len(), rec.Header())
+	}
+
+	upstreamTransport = &recordTransport{status: http.StatusForbidden}
+	fetchStopArrivals(context.Background(), "SF", "16994")
+	rec = get("/api/debug/raw/16994", true)
+	if rec.Header().Get("X-Upstream-Status") != "403" || rec.Header().Get("X-Upstream-Error") == "" {
+		t.Errorf("error raw = %v", rec.Header())
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RemoteConfig pulls shared settings from a central URL, so a fleet of
+// kiosks can be managed from one repository. The fetched document is
+// merged over the local file the same way include fragments are.
+type RemoteConfig struct {
+	// HTTPS URL of a YAML document, e.g. a raw file in a Git repository
+	URL string `yaml:"url,omitempty"`
+	// Optional URL of the document's SHA-256 in sha256sum format. Required,
+	// and itself HTTPS, when url is plain HTTP.
+	ChecksumURL string `yaml:"checksum_url,omitempty"`
+	// Sent as a bearer token, for private repositories
+	Token     string `yaml:"token,omitempty"`
+	TokenFile string `yaml:"token_file,omitempty"`
+	// Seconds between fetches (default 300)
+	RefreshInterval int `yaml:"refresh_interval,omitempty"`
+	// Last good document, used when the URL can't be reached at startup
+	// (default: config.remote.yaml next to config.yaml)
+	CacheFile string `yaml:"cache_file,omitempty"`
+}
+
+func (rc RemoteConfig) enabled() bool {
+	return rc.URL != ""
+}
+
+func validateRemoteConfig(rc *RemoteConfig) error {
+	if !rc.enabled() {
+		return nil
+	}
+	for _, raw := range []string{rc.URL, rc.ChecksumURL} {
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("remote_config: %q is not an http(s) URL", raw)
+		}
+	}
+	// The document can change the whole config, so it must come over
+	// HTTPS or be checked against a checksum that does
+	if !strings.HasPrefix(rc.URL, "https://") && !strings.HasPrefix(rc.ChecksumURL, "https://") {
+		return fmt.Errorf("remote_config: url must be https, or checksum_url must be an https URL")
+	}
+	if rc.RefreshInterval < 0 {
+		return fmt.Errorf("remote_config.refresh_interval cannot be negative")
+	}
+	if rc.RefreshInterval == 0 {
+		rc.RefreshInterval = 300
+	}
+	return nil
+}
+
+// errRemoteConfig refuses edits to a config that is managed remotely
+var errRemoteConfig = fmt.Errorf("config is managed by remote_config; change it at the source")
+
+// remoteState is the local document remote documents are merged over, and
+// the checksum of the remote document currently applied
+var remoteState struct {
+	sync.Mutex
+	local   []byte
+	applied string
+}
+
+// remoteCacheFile returns where the last good remote document is kept
+func remoteCacheFile(rc RemoteConfig) string {
+	if rc.CacheFile != "" {
+		return rc.CacheFile
+	}
+	return strings.TrimSuffix(configPath, ".yaml") + ".remote.yaml"
+}
+
+// fetchRemoteConfig downloads the remote document and checks it against
+// the published checksum, if any
+func fetchRemoteConfig(ctx context.Context, rc RemoteConfig) ([]byte, error) {
+	body, err := remoteGet(ctx, rc, rc.URL)
+	if err != nil {
+		return nil, err
+	}
+	if rc.ChecksumURL == "" {
+		return body, nil
+	}
+
+	sum, err := remoteGet(ctx, rc, rc.ChecksumURL)
+	if err != nil {
+		return nil, fmt.Errorf("checksum: %w", err)
+	}
+	fields := strings.Fields(string(sum))
+	got := sha256.Sum256(body)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], hex.EncodeToString(got[:])) {
+		return nil, fmt.Errorf("checksum mismatch for %s", rc.URL)
+	}
+	return body, nil
+}
+
+func remoteGet(ctx context.Context, rc RemoteConfig, target string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rc.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+rc.Token)
+	}
+	resp, err := upstreamClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d from %s", resp.StatusCode, target)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxConfigSize))
+}
+
+// mergeRemoteConfig builds the effective config from the local document
+// and a remote one. The remote document may not point elsewhere itself.
+func mergeRemoteConfig(local, remote []byte) (*Config, error) {
+	remote, _, err := migrateConfig(remote)
+	if err != nil {
+		return nil, err
+	}
+	remoteDoc := make(map[string]interface{})
+	if err := yaml.Unmarshal(remote, &remoteDoc); err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"remote_config", "include", "exec"} {
+		if _, ok := remoteDoc[key]; ok {
+			return nil, fmt.Errorf("%s is not allowed in a remote config", key)
+		}
+	}
+	if remoteDoc["provider"] == "exec" {
+		return nil, errExecConfig
+	}
+	delete(remoteDoc, "version")
+
+	doc := make(map[string]interface{})
+	if err := yaml.Unmarshal(local, &doc); err != nil {
+		return nil, err
+	}
+	mergeConfigMaps(doc, remoteDoc)
+
+	merged, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(merged)
+}
+
+// loadRemoteConfig applies the remote document over local at startup,
+// falling back to the cached copy when the URL is unreachable. The local
+// config stays in effect if neither works.
+func loadRemoteConfig(local []byte, cfg *Config) *Config {
+	rc := cfg.RemoteConfig
+	remoteState.Lock()
+	remoteState.local = local
+	remoteState.Unlock()
+
+	body, err := fetchRemoteConfig(serverCtx, rc)
+	source := rc.URL
+	if err != nil {
+		warnf("Remote config: %v; trying the cached copy", err)
+		if body, err = os.ReadFile(remoteCacheFile(rc)); err != nil {
+			warnf("Remote config: no cached copy, using the local config only")
+			return cfg
+		}
+		source = remoteCacheFile(rc)
+	}
+
+	merged, err := mergeRemoteConfig(local, body)
+	if err != nil {
+		warnf("Remote config from %s rejected, using the local config only: %v", source, err)
+		return cfg
+	}
+	rememberRemoteConfig(rc, body, source == rc.URL)
+	infof("Applied remote config from %s", source)
+	return merged
+}
+
+// rememberRemoteConfig records body as applied, caching fresh downloads
+func rememberRemoteConfig(rc RemoteConfig, body []byte, fresh bool) {
+	sum := sha256.Sum256(body)
+	remoteState.Lock()
+	remoteState.applied = hex.EncodeToString(sum[:])
+	remoteState.Unlock()
+
+	if fresh {
+		if err := writeFileAtomic(remoteCacheFile(rc), body); err != nil {
+			warnf("Remote config: caching failed: %v", err)
+		}
+	}
+}
+
+// refreshRemoteConfig refetches the remote document and hot-applies it
+// when it changed. A document that fails to fetch, verify, or validate is
+// logged and the running config is kept.
+func refreshRemoteConfig(ctx context.Context) {
+	rc := currentConfig().RemoteConfig
+	body, err := fetchRemoteConfig(ctx, rc)
+	if err != nil {
+		warnf("Remote config: %v; keeping the current config", err)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	remoteState.Lock()
+	local, unchanged := remoteState.local, remoteState.applied == hex.EncodeToString(sum[:])
+	remoteState.Unlock()
+	if unchanged {
+		debugf("Remote config unchanged")
+		return
+	}
+
+	cfg, err := mergeRemoteConfig(local, body)
+	if err != nil {
+		warnf("Remote config rejected, keeping the current config: %v", err)
+		return
+	}
+	applyOverrides(cfg)
+	if applyConfig(cfg) {
+		warnf("Remote config changed settings that need a restart to apply")
+	}
+	rememberRemoteConfig(rc, body, true)
+	infof("Applied updated remote config from %s (%d stops)", rc.URL, len(cfg.Stops))
+}
+
+// startRemoteConfigRefresher refetches the remote config on its interval
+// until ctx ends
+func startRemoteConfigRefresher(ctx context.Context) {
+	go func() {
+		for {
+			rc := currentConfig().RemoteConfig
+			if !rc.enabled() {
+				return
+			}
+			if !sleepContext(ctx, time.Duration(rc.RefreshInterval)*time.Second) {
+				return
+			}
+			refreshRemoteConfig(ctx)
+		}
+	}()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteConfig(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+
+	oldPath := configPath
+	configPath = filepath.Join(t.TempDir(), "config.yaml")
+	t.Cleanup(func() { configPath = oldPath })
+
+	local := []byte(`api_key: test
+remote_config:
+  url: https://example.com/fleet/kitchen.yaml
+  checksum_url: https://example.com/fleet/kitchen.yaml.sha256
+stops:
+  - name: Embarcadero
+    directions: [{label: Ocean Beach, stop_id: "16994"}]
+`)
+	cfg, err := parseConfig(local)
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+
+	remote := "refresh_interval: 45\nstops:\n  - name: Church\n    directions: [{label: Inbound, stop_id: \"14448\"}]\n"
+	serve := func(body string) routeTransport {
+		sum := sha256.Sum256([]byte(body))
+		return routeTransport{
+			"https://example.com/fleet/kitchen.yaml":        body,
+			"https://example.com/fleet/kitchen.yaml.sha256": hex.EncodeToString(sum[:]) + "  kitchen.yaml\n",
+		}
+	}
+	upstreamTransport = serve(remote)
+
+	merged := loadRemoteConfig(local, cfg)
+	if merged.RefreshInterval != 45 || len(merged.Stops) != 2 {
+		t.Fatalf("merged config: refresh %d, %d stops", merged.RefreshInterval, len(merged.Stops))
+	}
+	activeConfig.Store(merged)
+	if err := configEditable(); err == nil {
+		t.Error("a remotely managed config should not be editable")
+	}
+
+	// A bad checksum and an invalid document both keep the running config
+	bad := serve(remote + "max_arrivals: 9\n")
+	bad["https://example.com/fleet/kitchen.yaml.sha256"] = strings.Repeat("0", 64)
+	upstreamTransport = bad
+	refreshRemoteConfig(context.Background())
+	upstreamTransport = serve("max_arrivals: -1\n")
+	refreshRemoteConfig(context.Background())
+	if currentConfig() != merged {
+		t.Error("a rejected remote config replaced the running one")
+	}
+
+	upstreamTransport = serve(remote + "max_arrivals: 9\n")
+	refreshRemoteConfig(context.Background())
+	if got := currentConfig(); got.MaxArrivals != 9 || len(got.Stops) != 2 {
+		t.Errorf("updated config: max_arrivals %d, %d stops", got.MaxArrivals, len(got.Stops))
+	}
+
+	// The last good document is used when the URL is unreachable
+	upstreamTransport = routeTransport{}
+	if merged := loadRemoteConfig(local, cfg); merged.MaxArrivals != 9 {
+		t.Errorf("cached fallback: max_arrivals %d", merged.MaxArrivals)
+	}
+
+	// Plain HTTP needs an HTTPS checksum to vouch for it
+	for rc, ok := range map[RemoteConfig]bool{
+		{URL: "http://example.com/a.yaml"}:                                              false,
+		{URL: "http://example.com/a.yaml", ChecksumURL: "http://example.com/a.sha256"}:  false,
+		{URL: "http://example.com/a.yaml", ChecksumURL: "https://example.com/a.sha256"}: true,
+		{URL: "https://example.com/a.yaml"}:                                             true,
+	} {
+		if err := validateRemoteConfig(&rc); (err == nil) != ok {
+			t.Errorf("%+v: err = %v", rc, err)
+		}
+	}
+}
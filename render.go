@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Response formats the arrivals endpoints can negotiate
+const (
+	formatJSON = "json"
+	formatText = "text"
+	formatCSV  = "csv"
+	formatHTML = "html"
+)
+
+// formatTypes maps media types to formats. text/* and */* are handled
+// by negotiateFormat.
+var formatTypes = map[string]string{
+	"application/json": formatJSON,
+	"text/plain":       formatText,
+	"text/csv":         formatCSV,
+	"text/html":        formatHTML,
+}
+
+// formatContentTypes is the Content-Type sent for each format
+var formatContentTypes = map[string]string{
+	formatJSON: "application/json",
+	formatText: "text/plain; charset=utf-8",
+	formatCSV:  "text/csv; charset=utf-8",
+	formatHTML: "text/html; charset=utf-8",
+}
+
+// negotiateFormat picks a response format from ?format= or the Accept
+// header, preferring JSON on ties. It fails when nothing acceptable is
+// offered.
+func negotiateFormat(r *http.Request) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		if _, ok := formatContentTypes[f]; !ok {
+			return "", fmt.Errorf("unknown format %q (use json, text, csv, or html)", f)
+		}
+		return f, nil
+	}
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return formatJSON, nil
+	}
+
+	best, bestQ := "", 0.0
+	consider := func(format string, q float64) {
+		if q > bestQ || (q == bestQ && format == formatJSON) {
+			best, bestQ = format, q
+		}
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		switch mediaType {
+		case "*/*", "application/*":
+			consider(formatJSON, q)
+		case "text/*":
+			consider(formatText, q)
+		default:
+			if format, ok := formatTypes[mediaType]; ok {
+				consider(format, q)
+			}
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("acceptable formats are application/json, text/plain, text/csv, and text/html")
+	}
+	return best, nil
+}
+
+// tableSection is one block of a rendered response: a direction of a
+// stop, or the merged departures of /api/next
+type tableSection struct {
+	Stop, Line, Direction string
+	// One-line summary, like "3, 12, 25 min" or an error
+	Text string
+	Rows []NextDeparture
+}
+
+// arrivalsTable is what the text, CSV, and HTML formats render from
+type arrivalsTable struct {
+	Title       string
+	LastUpdated string
+	Sections    []tableSection
+}
+
+// arrivalsTableFrom lays out an arrivals response, one section per
+// direction
+func arrivalsTableFrom(resp ArrivalsResponse) arrivalsTable {
+	t := arrivalsTable{Title: tr("Arrivals"), LastUpdated: resp.LastUpdatedDisplay}
+	for _, stop := range resp.Stops {
+		for _, dir := range stop.Directions {
+			s := tableSection{Stop: stop.Name, Line: stop.Line, Direction: dir.Label, Text: formatDirectionText(dir)}
+			for _, a := range dir.Arrivals {
+				s.Rows = append(s.Rows, NextDeparture{Stop: stop.Name, Line: stop.Line, Direction: dir.Label, Arrival: a})
+			}
+			t.Sections = append(t.Sections, s)
+		}
+	}
+	return t
+}
+
+// nextTableFrom lays out a /api/next response as a single section
+func nextTableFrom(resp NextResponse) arrivalsTable {
+	return arrivalsTable{
+		Title:       tr("Next departures"),
+		LastUpdated: resp.LastUpdatedDisplay,
+		Sections:    []tableSection{{Text: resp.Summary, Rows: resp.Departures}},
+	}
+}
+
+// departureWhen is a departure's time as shown in text and HTML
+func departureWhen(d NextDeparture) string {
+	switch d.Status {
+	case statusDue, statusDeparting, statusDeparted:
+		return d.StatusText
+	}
+	return strconv.Itoa(d.Minutes) + " min"
+}
+
+// writeArrivalsText renders a table as plain text. Direction sections
+// print one line each, grouped under their stop, as -once does.
+func writeArrivalsText(w io.Writer, t arrivalsTable) {
+	lastStop := ""
+	for _, s := range t.Sections {
+		if s.Stop == "" {
+			fmt.Fprintln(w, s.Text)
+			for _, d := range s.Rows {
+				fmt.Fprintf(w, "  %s  %s, %s\n", departureWhen(d), trf("%s to %s", d.Line, d.Direction), d.Stop)
+			}
+			continue
+		}
+		if s.Stop+"\x00"+s.Line != lastStop {
+			fmt.Fprintf(w, "%s (%s)\n", s.Stop, s.Line)
+			lastStop = s.Stop + "\x00" + s.Line
+		}
+		fmt.Fprintf(w, "  %s: %s\n", s.Direction, s.Text)
+	}
+}
+
+// csvColumns heads the CSV format, one row per arrival
+var csvColumns = []string{"stop", "line", "direction", "destination", "minutes", "seconds", "status", "arrival_time", "display_time", "realtime"}
+
+// writeArrivalsCSV writes the given columns of a table as CSV
+func writeArrivalsCSV(w io.Writer, t arrivalsTable, cols []int) error {
+	cw := csv.NewWriter(w)
+	pick := func(row []string) []string {
+		out := make([]string, len(cols))
+		for i, c := range cols {
+			out[i] = row[c]
+		}
+		return out
+	}
+	cw.Write(pick(csvColumns))
+	for _, s := range t.Sections {
+		for _, d := range s.Rows {
+			cw.Write(pick([]string{
+				d.Stop, d.Line, d.Direction, d.Destination,
+				strconv.Itoa(d.Minutes), strconv.Itoa(d.Seconds), d.Status,
+				d.ArrivalTime, d.DisplayTime, strconv.FormatBool(d.Realtime),
+			}))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var arrivalsHTML = template.Must(template.New("arrivals").Funcs(template.FuncMap{"when": departureWhen}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Sections}}<section>
+{{if .Stop}}<h2>{{.Stop}}{{if .Line}} ({{.Line}}){{end}}: {{.Direction}}</h2>{{end}}
+<p>{{.Text}}</p>
+{{if .Rows}}<table>
+<tr><th>When</th><th>Line</th><th>Direction</th><th>Stop</th><th>Destination</th><th>Time</th></tr>
+{{range .Rows}}<tr><td>{{when .}}</td><td>{{.Line}}</td><td>{{.Direction}}</td><td>{{.Stop}}</td><td>{{.Destination}}</td><td>{{.DisplayTime}}</td></tr>
+{{end}}</table>{{end}}
+</section>
+{{end}}<p><small>{{.LastUpdated}}</small></p>
+</body></html>
+`))
+
+// writeNegotiated writes v as JSON or table in the request's format,
+// trimmed to ?fields= for JSON and CSV. JSON is wrapped with meta when
+// that isn't nil. The response varies by Accept, so caches are told so.
+func writeNegotiated(w http.ResponseWriter, r *http.Request, v interface{}, meta *ResponseMeta, table func() arrivalsTable) {
+	format, err := negotiateFormat(r)
+	w.Header().Add("Vary", "Accept")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	fields, err := parseFields(r, v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cols := selectColumns(fields)
+	if format == formatCSV && len(cols) == 0 {
+		http.Error(w, "none of the fields are CSV columns: "+strings.Join(csvColumns, ", "), http.StatusBadRequest)
+		return
+	}
+	if fields != nil && format == formatJSON {
+		if v, err = fieldsJSON(v, fields); err != nil {
+			errorf("Selecting fields: %v", err)
+			http.Error(w, "selecting fields failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", formatContentTypes[format])
+
+	switch format {
+	case formatText:
+		writeArrivalsText(w, table())
+	case formatCSV:
+		writeArrivalsCSV(w, table(), cols)
+	case formatHTML:
+		arrivalsHTML.Execute(w, table())
+	default:
+		if meta != nil {
+			v = metaEnvelope{Data: v, Meta: *meta}
+		}
+		json.NewEncoder(w).Encode(v)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentNegotiation(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                             formatJSON,
+		"*/*":                          formatJSON,
+		"text/csv":                     formatCSV,
+		"text/plain, application/json": formatJSON,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": formatHTML,
+		"text/*":                         formatText,
+		"application/json;q=0, text/csv": formatCSV,
+		"image/png":                      "",
+	} {
+		req := httptest.NewRequest("GET", "/api/arrivals", nil)
+		req.Header.Set("Accept", accept)
+		if got, _ := negotiateFormat(req); got != want {
+			t.Errorf("Accept %q: %q, want %q", accept, got, want)
+		}
+	}
+
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:05:00Z"}}},
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach, \"Judah\"","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:14:00Z"}}}
+	]}}}`)
+	refreshCache()
+
+	get := func(handler http.HandlerFunc, path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := get(handleArrivals, "/api/arrivals", "text/plain")
+	if rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" || rec.Body.String() != "Embarcadero (N Judah)\n  Ocean Beach: 4, 13 min\n" {
+		t.Errorf("text = %q %q", rec.Header().Get("Content-Type"), rec.Body)
+	}
+
+	rec = get(handleArrivals, "/api/arrivals?format=csv", "application/json")
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(csvColumns, ",") {
+		t.Fatalf("csv = %v %v", rows, err)
+	}
+	if rows[2][3] != `Ocean Beach, "Judah"` || rows[1][4] != "4" || rows[1][9] != "true" {
+		t.Errorf("csv rows = %v", rows[1:])
+	}
+
+	rec = get(handleNext, "/api/next", "text/html")
+	if body := rec.Body.String(); !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(body, "<td>4 min</td><td>N Judah</td>") || !strings.Contains(body, "Ocean Beach, &#34;Judah&#34;") {
+		t.Errorf("html = %s", body)
+	}
+	if rec := get(handleNext, "/api/next", "text/plain"); !strings.HasPrefix(rec.Body.String(), "N Judah to Ocean Beach from Embarcadero, in 4 minutes.\n  4 min  N Judah to Ocean Beach, Embarcadero\n") {
+		t.Errorf("next text = %q", rec.Body)
+	}
+
+	if rec := get(handleArrivals, "/api/arrivals", "image/png"); rec.Code != http.StatusNotAcceptable || rec.Header().Get("Vary") != "Accept" {
+		t.Errorf("image/png = %d %v", rec.Code, rec.Header())
+	}
+	if rec := get(handleArrivals, "/api/arrivals?format=xml", ""); rec.Code != http.StatusNotAcceptable {
+		t.Errorf("?format=xml = %d", rec.Code)
+	}
+}
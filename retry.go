@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"muni-tracker/pkg/go511"
+)
+
+// RetryConfig sets how upstream GETs are retried after a transient
+// failure, so one 502 from 511 doesn't blank a direction until its next
+// scheduled fetch
+type RetryConfig struct {
+	// Tries per request, counting the first; 1 turns retries off (default 3)
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// Wait before the first retry in milliseconds, doubling after each
+	// (default 500)
+	BackoffMS int `yaml:"backoff_ms,omitempty"`
+	// Longest wait between tries in milliseconds (default 5000)
+	MaxBackoffMS int `yaml:"max_backoff_ms,omitempty"`
+	// Response statuses worth retrying (default 502, 503, 504). Requests
+	// that fail without a response are always retried.
+	Statuses []int `yaml:"statuses,omitempty"`
+}
+
+func validateRetryConfig(r *RetryConfig) error {
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("retry.max_attempts cannot be negative")
+	}
+	if r.BackoffMS < 0 || r.MaxBackoffMS < 0 {
+		return fmt.Errorf("retry.backoff_ms and retry.max_backoff_ms cannot be negative")
+	}
+	for _, code := range r.Statuses {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("retry.statuses: %d is not an error status", code)
+		}
+	}
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = 3
+	}
+	if r.BackoffMS == 0 {
+		r.BackoffMS = 500
+	}
+	if r.MaxBackoffMS == 0 {
+		r.MaxBackoffMS = 5000
+	}
+	if r.Statuses == nil {
+		r.Statuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	}
+	return nil
+}
+
+// backoff is the wait before retry number n, counting from 1
+func (r RetryConfig) backoff(n int) time.Duration {
+	wait := time.Duration(r.BackoffMS) * time.Millisecond << min(n-1, 16)
+	return min(wait, time.Duration(r.MaxBackoffMS)*time.Millisecond)
+}
+
+// retryable reports whether a try that ended with resp and err is worth
+// repeating, and why, for the metrics
+func (r RetryConfig) retryable(ctx context.Context, resp *http.Response, err error) (string, bool) {
+	if err != nil {
+		// The caller gave up; trying again won't help
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return "", false
+		}
+		return "error", true
+	}
+	if slices.Contains(r.Statuses, resp.StatusCode) {
+		return strconv.Itoa(resp.StatusCode), true
+	}
+	return "", false
+}
+
+// retryAfter is the wait a response asks for in whole seconds, or zero
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// quotaHost is the host whose requests count against upstream_hourly_limit
+var quotaHost = func() string {
+	u, _ := url.Parse(go511.DefaultBaseURL)
+	return u.Host
+}()
+
+// upstreamRetries counts retried upstream requests by host and by the
+// status, or error, that prompted them
+var upstreamRetries = newCounterVec("host", "reason")
+
+// retryTransport repeats idempotent upstream requests that failed in a
+// way that's likely to pass, per the config's retry policy. Retries to
+// 511 count against the hourly quota and stop when it runs out.
+type retryTransport struct {
+	next http.RoundTripper
+}
+
+func (t retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	policy := currentConfig().Retry
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Body != nil && r.Body != http.NoBody {
+		return t.next.RoundTrip(r)
+	}
+
+	ctx := r.Context()
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(r)
+		reason, retry := policy.retryable(ctx, resp, err)
+		if !retry || attempt >= policy.MaxAttempts {
+			return resp, err
+		}
+		if r.URL.Host == quotaHost && quota.remaining(clock.Now()) <= 0 {
+			return resp, err
+		}
+
+		wait := policy.backoff(attempt)
+		if after := retryAfter(resp); after > wait {
+			// Asked to wait longer than we would: take the answer as final
+			if after > time.Duration(policy.MaxBackoffMS)*time.Millisecond {
+				return resp, err
+			}
+			wait = after
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		debugf("Retrying %s %s after %s in %v (attempt %d of %d) trace=%s",
+			r.Method, r.URL.Host, reason, wait, attempt+1, policy.MaxAttempts, traceID(ctx))
+		upstreamRetries.inc(r.URL.Host, reason)
+		if !sleepContext(ctx, wait) {
+			return nil, ctx.Err()
+		}
+		if r.URL.Host == quotaHost {
+			quota.record(clock.Now())
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"muni-tracker/pkg/go511"
+)
+
+func TestUpstreamRetry(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Date(2026, 3, 14, 20, 0, 0, 0, time.UTC), "")
+	cfg := *currentConfig()
+	cfg.UpstreamHourlyLimit = 1000
+	activeConfig.Store(&cfg)
+	body := `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-03-14T20:30:00Z"}}}
+	]}}}`
+
+	if r := cfg.Retry; r.MaxAttempts != 3 || r.backoff(1) != 500*time.Millisecond || r.backoff(2) != time.Second || r.backoff(10) != 5*time.Second {
+		t.Errorf("default policy = %+v", r)
+	}
+
+	// A transient 502 is retried, and the retry is paid for from the quota
+	seq := &sequenceTransport{statuses: []int{http.StatusBadGateway}, body: body}
+	upstreamTransport = seq
+	left := quota.remaining(fc.now)
+	start := fc.now
+	arrivals, err := fetchStopArrivals(context.Background(), "SF", "16994")
+	if err != nil || len(arrivals) != 1 {
+		t.Fatalf("fetch after one 502 = %v, %v", arrivals, err)
+	}
+	if seq.requests != 2 {
+		t.Errorf("requests = %d, want 2", seq.requests)
+	}
+	if spent := left - quota.remaining(fc.now); spent != 2 {
+		t.Errorf("quota spent = %d, want 2", spent)
+	}
+	if waited := fc.now.Sub(start); waited != 500*time.Millisecond {
+		t.Errorf("backoff = %v, want 500ms", waited)
+	}
+
+	// A lasting outage gives up after max_attempts
+	seq = &sequenceTransport{statuses: []int{503, 503, 503, 503}, body: body}
+	upstreamTransport = seq
+	var httpErr *go511.HTTPError
+	if _, err := fetchStopArrivals(context.Background(), "SF", "16994"); !errors.As(err, &httpErr) || httpErr.StatusCode != 503 {
+		t.Errorf("fetch during outage err = %v", err)
+	}
+	if seq.requests != 3 {
+		t.Errorf("requests = %d, want 3", seq.requests)
+	}
+
+	// Statuses that won't change aren't retried, nor is a Retry-After
+	// longer than the policy allows
+	for _, tc := range []struct {
+		status int
+		header http.Header
+	}{
+		{http.StatusNotFound, nil},
+		{http.StatusTooManyRequests, nil},
+		{http.StatusServiceUnavailable, http.Header{"Retry-After": {"60"}}},
+	} {
+		seq = &sequenceTransport{statuses: []int{tc.status}, headers: []http.Header{tc.header}, body: body}
+		upstreamTransport = seq
+		fetchStopArrivals(context.Background(), "SF", "16994")
+		if seq.requests != 1 {
+			t.Errorf("status %d %v: requests = %d, want 1", tc.status, tc.header, seq.requests)
+		}
+	}
+
+	// Nor are POSTs, which may not be safe to repeat
+	seq = &sequenceTransport{statuses: []int{http.StatusBadGateway}}
+	upstreamTransport = seq
+	if resp, err := postJSON(context.Background(), "https://hooks.example.com/x", []byte(`{}`)); err != nil || resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("POST = %v, %v", resp, err)
+	}
+	if seq.requests != 1 {
+		t.Errorf("POST requests = %d, want 1", seq.requests)
+	}
+
+	// Retries to 511 stop when the quota is spent
+	quota.remaining(fc.now)
+	quota.mu.Lock()
+	cfg.UpstreamHourlyLimit = len(quota.calls) + 1
+	quota.mu.Unlock()
+	activeConfig.Store(&cfg)
+	seq = &sequenceTransport{statuses: []int{http.StatusBadGateway}, body: body}
+	upstreamTransport = seq
+	fetchStopArrivals(context.Background(), "SF", "16994")
+	if seq.requests != 1 {
+		t.Errorf("requests with no quota left = %d, want 1", seq.requests)
+	}
+
+	// max_attempts: 1 turns retries off
+	cfg.UpstreamHourlyLimit = 1000
+	cfg.Retry.MaxAttempts = 1
+	activeConfig.Store(&cfg)
+	seq = &sequenceTransport{statuses: []int{http.StatusBadGateway}, body: body}
+	upstreamTransport = seq
+	fetchStopArrivals(context.Background(), "SF", "16994")
+	if seq.requests != 1 {
+		t.Errorf("requests with retries off = %d, want 1", seq.requests)
+	}
+
+	if _, err := parseConfig([]byte("api_key: test\nretry: {statuses: [200]}\n" + testStop)); err == nil {
+		t.Error("retry.statuses accepted 200")
+	}
+}
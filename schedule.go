@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// serviceDayRollover is when one service day ends and the next begins.
+// Departures before it belong to the previous day's late-night service.
+const serviceDayRollover = 3 * time.Hour
+
+// ServiceTimes holds a clock time (HH:MM) per service day type. Holidays
+// use the Sunday time.
+type ServiceTimes struct {
+	Weekday  string `yaml:"weekday,omitempty"`
+	Saturday string `yaml:"saturday,omitempty"`
+	Sunday   string `yaml:"sunday,omitempty"`
+}
+
+func (s ServiceTimes) forDay(dayType string) string {
+	switch dayType {
+	case serviceSaturday:
+		return s.Saturday
+	case serviceSunday:
+		return s.Sunday
+	}
+	return s.Weekday
+}
+
+func (s ServiceTimes) validate(field string) error {
+	for _, v := range []string{s.Weekday, s.Saturday, s.Sunday} {
+		if v == "" {
+			continue
+		}
+		if _, err := parseClock(v); err != nil {
+			return fmt.Errorf("invalid %s time %q (want HH:MM)", field, v)
+		}
+	}
+	return nil
+}
+
+// parseClock parses HH:MM into an offset from the start of the service
+// day. Times before the rollover count as after midnight.
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, err
+	}
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if d < serviceDayRollover {
+		d += 24 * time.Hour
+	}
+	return d, nil
+}
+
+// serviceTime resolves a configured clock time on the service day that
+// starts at midnight of day
+func serviceTime(times ServiceTimes, day time.Time) (time.Time, bool) {
+	v := times.forDay(serviceDayType(day))
+	if v == "" {
+		return time.Time{}, false
+	}
+	d, err := parseClock(v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	return midnight.Add(d), true
+}
+
+// serviceDay is the service day containing t, as local midnight of its
+// calendar date
+func serviceDay(t time.Time) time.Time {
+	day := localTime(t).Add(-serviceDayRollover)
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+}
+
+// maxServiceGap bounds the search for the next day with service
+const maxServiceGap = 14
+
+// serviceStatus reports whether a direction is outside its scheduled
+// service hours at now, or on a day the agency's GTFS calendar runs
+// nothing at its stop, and when service next starts
+func serviceStatus(agency string, dir Direction, now time.Time) (ended bool, resumes time.Time) {
+	now = localTime(now)
+	day := serviceDay(now)
+
+	if !serviceRunsOn(agency, dir, day) {
+		return true, nextServiceStart(agency, dir, day)
+	}
+
+	if first, ok := serviceTime(dir.FirstDeparture, day); ok && now.Before(first) {
+		return true, first
+	}
+
+	if last, ok := serviceTime(dir.LastDeparture, day); ok && now.After(last) {
+		return true, nextServiceStart(agency, dir, day)
+	}
+
+	return false, time.Time{}
+}
+
+// nextServiceStart is the first departure on the next service day after
+// day that has service, or zero when that isn't known
+func nextServiceStart(agency string, dir Direction, day time.Time) time.Time {
+	for n := 1; n <= maxServiceGap; n++ {
+		next := day.AddDate(0, 0, n)
+		if serviceRunsOn(agency, dir, next) {
+			first, _ := serviceTime(dir.FirstDeparture, next)
+			return first
+		}
+	}
+	return time.Time{}
+}
+
+// configuredDirection finds the config for cached direction j of stop i
+// in stops. The cache can briefly lag a config reload, so the stop code
+// must match.
+func configuredDirection(stops []Stop, i, j int, stopID string) (Direction, bool) {
+	if i >= len(stops) || j >= len(stops[i].Directions) {
+		return Direction{}, false
+	}
+	dir := stops[i].Directions[j]
+	return dir, dir.StopID == stopID
+}
+
+// serviceEndedText describes a direction that is outside service hours
+func serviceEndedText(dir DirectionArrivals) string {
+	resumes, err := time.Parse(time.RFC3339, dir.ServiceResumes)
+	if err != nil {
+		return tr("Service has ended")
+	}
+	return trf("Service resumes %s", displayTime(resumes))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServiceStatus(t *testing.T) {
+	loc, _ := time.LoadLocation("America/Los_Angeles")
+	withTestEnv(t, time.Now(), "")
+
+	dir := Direction{
+		FirstDeparture: ServiceTimes{Weekday: "05:12", Saturday: "06:05"},
+		LastDeparture:  ServiceTimes{Weekday: "00:48", Saturday: "00:48"},
+	}
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		ended   bool
+		resumes time.Time
+	}{
+		{"midday", time.Date(2026, 1, 29, 12, 0, 0, 0, loc), false, time.Time{}},
+		{"late-night service", time.Date(2026, 1, 30, 0, 30, 0, 0, loc), false, time.Time{}},
+		{"after last departure", time.Date(2026, 1, 30, 0, 50, 0, 0, loc), true, time.Date(2026, 1, 30, 5, 12, 0, 0, loc)},
+		{"before first departure", time.Date(2026, 1, 30, 4, 0, 0, 0, loc), true, time.Date(2026, 1, 30, 5, 12, 0, 0, loc)},
+		{"friday night into saturday", time.Date(2026, 1, 31, 1, 0, 0, 0, loc), true, time.Date(2026, 1, 31, 6, 5, 0, 0, loc)},
+		{"in UTC", time.Date(2026, 1, 30, 8, 50, 0, 0, time.UTC), true, time.Date(2026, 1, 30, 5, 12, 0, 0, loc)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ended, resumes := serviceStatus("SF", dir, tt.now)
+			if ended != tt.ended || !resumes.Equal(tt.resumes) {
+				t.Errorf("serviceStatus() = %v, %v; want %v, %v", ended, resumes, tt.ended, tt.resumes)
+			}
+		})
+	}
+}
+
+func TestServiceEnded(t *testing.T) {
+	now := time.Date(2026, 1, 30, 8, 50, 0, 0, time.UTC) // 12:50 AM Friday in San Francisco
+	_, ft := withTestEnv(t, now, `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T08:55:00Z"}}}
+	]}}}`)
+	cfg, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: "16994"
+        first_departure: {weekday: "05:12"}
+        last_departure: {weekday: "00:48"}
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	// A late vehicle past the last departure time is still shown
+	refreshCache()
+	dir := buildArrivalsResponse(now).Stops[0].Directions[0]
+	if dir.ServiceEnded || len(dir.Arrivals) != 1 {
+		t.Errorf("with an arrival to come: service_ended = %v, %d arrivals", dir.ServiceEnded, len(dir.Arrivals))
+	}
+
+	ft.body = `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`
+	refreshCache()
+	dir = buildArrivalsResponse(now).Stops[0].Directions[0]
+	if !dir.ServiceEnded || dir.ServiceResumes != "2026-01-30T05:12:00-08:00" || dir.QualityWarning != "" {
+		t.Errorf("with no arrivals: service_ended = %v, service_resumes = %q, warning = %q", dir.ServiceEnded, dir.ServiceResumes, dir.QualityWarning)
+	}
+}
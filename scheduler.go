@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// schedulerTick is how often the scheduler looks for due directions
+const schedulerTick = time.Second
+
+// retryBase is how soon a failed direction is first retried. Each further
+// failure doubles the wait, up to the refresh interval.
+const retryBase = 30 * time.Second
+
+// scheduledDirection is one main-board direction's place in its agency's
+// queue
+type scheduledDirection struct {
+	ref    directionRef
+	stopID string
+	// When it was last fetched, and how many fetches in a row failed
+	lastRun  time.Time
+	failures int
+}
+
+// due is when the direction should next be fetched: an interval after the
+// last fetch, or sooner while it is failing
+func (d *scheduledDirection) due(interval time.Duration) time.Time {
+	if d.failures == 0 {
+		return d.lastRun.Add(interval)
+	}
+	return d.lastRun.Add(min(retryBase<<min(d.failures-1, 16), interval))
+}
+
+// agencyQueue holds one agency's directions. Agencies are fetched on their
+// own, so a slow or failing feed doesn't hold up the others.
+type agencyQueue struct {
+	agency     string
+	directions []*scheduledDirection
+	running    bool
+	lastBatch  time.Time
+	// The upstream quota ran short; nothing is fetched before this
+	throttledUntil time.Time
+}
+
+// schedBatch is the due directions of one queue, fetched together
+type schedBatch struct {
+	queue      *agencyQueue
+	directions []*scheduledDirection
+}
+
+// scheduler keeps the main board's directions fresh, each on its own due
+// time. It replaced a single ticker that refetched everything at once.
+type scheduler struct {
+	mu      sync.Mutex
+	queues  map[string]*agencyQueue
+	started bool
+}
+
+var sched = &scheduler{queues: make(map[string]*agencyQueue)}
+
+// sync matches the queues to the configured directions, keeping the state
+// of those that are unchanged. Directions new to the scheduler count as
+// fetched when the cache last fetched them.
+func (s *scheduler) sync(cfg *Config, data ArrivalsResponse) {
+	known := make(map[directionRef]*scheduledDirection)
+	for _, q := range s.queues {
+		for _, d := range q.directions {
+			known[d.ref] = d
+		}
+	}
+
+	queues := make(map[string]*agencyQueue)
+	for i, stop := range cfg.Stops {
+		agency := stop.Agency
+		if agency == "" {
+			agency = "SF"
+		}
+		q := queues[agency]
+		if q == nil {
+			q = s.queues[agency]
+			if q == nil {
+				q = &agencyQueue{agency: agency}
+			}
+			q.directions = nil
+			queues[agency] = q
+		}
+		for j, dir := range stop.Directions {
+			ref := directionRef{i, j}
+			d := known[ref]
+			if d == nil || d.stopID != dir.StopID {
+				d = &scheduledDirection{ref: ref, stopID: dir.StopID}
+				if cached, ok := previousDirection(data, i, j, dir.StopID); ok {
+					d.lastRun = cached.FetchedAt
+				}
+			}
+			q.directions = append(q.directions, d)
+		}
+	}
+	// A removed agency's batch finishes, but isn't planned again
+	s.queues = queues
+}
+
+// plan picks out each idle queue's due directions. A queue whose batch
+// would overrun the hourly quota is held back until it fits.
+func (s *scheduler) plan(now time.Time) []schedBatch {
+	cfg := currentConfig()
+	cache.mu.RLock()
+	data := cache.data
+	cache.mu.RUnlock()
+	interval := cacheRefreshInterval(cfg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sync(cfg, data)
+
+	var batches []schedBatch
+	// Requests the batches planned so far will make
+	planned := 0
+	for _, agency := range s.agencies() {
+		q := s.queues[agency]
+		if q.running || now.Before(q.throttledUntil) {
+			continue
+		}
+		var due []*scheduledDirection
+		requests := 0
+		for _, d := range q.directions {
+			if now.Before(d.due(interval)) {
+				continue
+			}
+			dir := cfg.Stops[d.ref.stop].Directions[d.ref.dir]
+			// Nothing runs at the stop today; look again next interval
+			if !serviceRunsOn(agency, dir, serviceDay(now)) {
+				d.lastRun = now
+				continue
+			}
+			due = append(due, d)
+			requests += upstreamRequests(dir)
+		}
+		if len(due) == 0 {
+			continue
+		}
+		if wait := quota.waitFor(planned+requests, now); wait > 0 {
+			warnf("Scheduler: holding %d %s directions for %v to stay within upstream_hourly_limit", len(due), agency, wait.Round(time.Second))
+			q.throttledUntil = now.Add(wait)
+			continue
+		}
+		q.running = true
+		planned += requests
+		batches = append(batches, schedBatch{queue: q, directions: due})
+	}
+	return batches
+}
+
+// agencies lists the queues in a stable order
+func (s *scheduler) agencies() []string {
+	names := make([]string, 0, len(s.queues))
+	for agency := range s.queues {
+		names = append(names, agency)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// run fetches a batch and merges it into the cache. Batches of different
+// agencies run side by side; a full refresh waits for them.
+func (s *scheduler) run(ctx context.Context, b schedBatch) {
+	// Load the feed whose calendar plan checks, so it's ready next time.
+	// A download can be slow, so it doesn't hold up a full refresh.
+	if currentConfig().GTFS.Calendar {
+		if _, err := agencyIndex(b.queue.agency); err != nil {
+			debugf("No service calendar for %s: %v", b.queue.agency, err)
+		}
+	}
+
+	refreshMu.RLock()
+	defer refreshMu.RUnlock()
+
+	cfg := currentConfig()
+	infof("Refreshing %d %s directions... trace=%s", len(b.directions), b.queue.agency, traceID(ctx))
+	fetched := make(map[directionRef]DirectionArrivals, len(b.directions))
+	for n, d := range b.directions {
+		if d.ref.stop >= len(cfg.Stops) || d.ref.dir >= len(cfg.Stops[d.ref.stop].Directions) ||
+			cfg.Stops[d.ref.stop].Directions[d.ref.dir].StopID != d.stopID {
+			continue // the config changed under the batch
+		}
+		if n > 0 && cfg.Provider == "511" {
+			sleepContext(ctx, upstreamDelay)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		markRefreshProgress()
+		stop := cfg.Stops[d.ref.stop]
+		result := fetchDirection(ctx, stop, stop.Directions[d.ref.dir])
+		// A fetch cut off by shutdown says nothing about the feed
+		if ctx.Err() != nil {
+			break
+		}
+		fetched[d.ref] = result
+	}
+
+	now := clock.Now()
+	data := cache.update(func(data ArrivalsResponse) ArrivalsResponse {
+		return mergeDirections(data, fetched, now)
+	})
+
+	s.mu.Lock()
+	for _, d := range b.directions {
+		result, ok := fetched[d.ref]
+		if !ok {
+			continue
+		}
+		d.lastRun = result.FetchedAt
+		if result.Error != "" {
+			d.failures++
+		} else {
+			d.failures = 0
+		}
+	}
+	b.queue.running = false
+	b.queue.lastBatch = now
+	s.mu.Unlock()
+
+	checkUpstream(ctx, data, cfg.Stops, now)
+	markRefreshDone()
+}
+
+// mergeDirections swaps freshly fetched directions into a copy of data
+func mergeDirections(data ArrivalsResponse, fetched map[directionRef]DirectionArrivals, now time.Time) ArrivalsResponse {
+	stops := make([]StopArrivals, len(data.Stops))
+	copy(stops, data.Stops)
+	for ref, result := range fetched {
+		if ref.stop >= len(stops) || ref.dir >= len(stops[ref.stop].Directions) {
+			continue
+		}
+		dirs := make([]DirectionArrivals, len(stops[ref.stop].Directions))
+		copy(dirs, stops[ref.stop].Directions)
+		keepUpdatedAt(&result, dirs[ref.dir], dirs[ref.dir].StopID == result.StopID)
+		dirs[ref.dir] = result
+		stops[ref.stop].Directions = dirs
+	}
+	data.Stops = stops
+	data.LastUpdated = localTime(now).Format(time.RFC3339)
+	return data
+}
+
+// cacheMatchesConfig reports whether the cache holds the configured stops,
+// which it doesn't before the first refresh or after the stops change
+func cacheMatchesConfig() bool {
+	cfg := currentConfig()
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	return !layoutChanged(cache.data, configLayout(cfg))
+}
+
+// configLayout is an empty cache snapshot shaped like the configured stops
+func configLayout(cfg *Config) ArrivalsResponse {
+	var layout ArrivalsResponse
+	for _, stop := range cfg.Stops {
+		s := StopArrivals{Name: stop.Name, Line: stop.Line}
+		for _, dir := range stop.Directions {
+			s.Directions = append(s.Directions, DirectionArrivals{StopID: dir.StopID})
+		}
+		layout.Stops = append(layout.Stops, s)
+	}
+	return layout
+}
+
+// loop runs the scheduler until ctx ends. When the configured stops no
+// longer match the cache, everything is fetched at once. Batches share
+// ctx, so shutdown cancels their requests too. An instance following a
+// shared cache's leader fetches nothing.
+func (s *scheduler) loop(ctx context.Context) {
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.started = false
+		s.mu.Unlock()
+	}()
+
+	for sleepContext(ctx, schedulerTick) {
+		s.tick(ctx)
+	}
+}
+
+// tick is one pass of the loop. Each pass counts as the refresher being
+// alive, since a pass may fetch nothing: nothing is due, the quota holds
+// queues back, the calendar shows no service, or another instance fetches.
+func (s *scheduler) tick(ctx context.Context) {
+	markSchedulerTick()
+	// Another instance fetches for this one
+	if !leading() {
+		return
+	}
+	if !cacheMatchesConfig() {
+		refreshCacheContext(newTraceContext(ctx))
+		return
+	}
+	for _, b := range s.plan(clock.Now()) {
+		go s.run(newTraceContext(ctx), b)
+	}
+}
+
+// nextDue is when the scheduler next means to fetch anything, or zero
+// when it isn't running
+func (s *scheduler) nextDue() time.Time {
+	interval := cacheRefreshInterval(currentConfig())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started {
+		return time.Time{}
+	}
+	var next time.Time
+	for _, q := range s.queues {
+		for _, d := range q.directions {
+			due := d.due(interval)
+			if due.Before(q.throttledUntil) {
+				due = q.throttledUntil
+			}
+			if next.IsZero() || due.Before(next) {
+				next = due
+			}
+		}
+	}
+	return next
+}
+
+// QueueStatus describes one agency's queue, for /api/status
+type QueueStatus struct {
+	Agency     string `json:"agency"`
+	Directions int    `json:"directions"`
+	// Directions waiting to retry a failed fetch
+	Retrying       int        `json:"retrying"`
+	Running        bool       `json:"running"`
+	LastBatch      *time.Time `json:"last_batch"`
+	NextFetch      *time.Time `json:"next_fetch"`
+	ThrottledUntil *time.Time `json:"throttled_until,omitempty"`
+}
+
+// queueStatuses reports every queue, in agency order
+func (s *scheduler) queueStatuses(now time.Time) []QueueStatus {
+	interval := cacheRefreshInterval(currentConfig())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := []QueueStatus{}
+	for _, agency := range s.agencies() {
+		q := s.queues[agency]
+		status := QueueStatus{
+			Agency:     agency,
+			Directions: len(q.directions),
+			Running:    q.running,
+			LastBatch:  optionalTime(q.lastBatch),
+		}
+		if q.throttledUntil.After(now) {
+			status.ThrottledUntil = optionalTime(q.throttledUntil)
+		}
+		var next time.Time
+		for _, d := range q.directions {
+			if d.failures > 0 {
+				status.Retrying++
+			}
+			if due := d.due(interval); next.IsZero() || due.Before(next) {
+				next = due
+			}
+		}
+		status.NextFetch = optionalTime(next)
+		out = append(out, status)
+	}
+	return out
+}
+
+// queueSamples gives each queue's directions, retrying directions, and
+// seconds until its next fetch, for /metrics
+func queueSamples(queues []QueueStatus, now time.Time) (directions, retrying, nextFetch []metricSample) {
+	for _, q := range queues {
+		labels := []string{"agency", q.Agency}
+		directions = append(directions, metricSample{labels: labels, value: float64(q.Directions)})
+		retrying = append(retrying, metricSample{labels: labels, value: float64(q.Retrying)})
+		if q.NextFetch != nil {
+			nextFetch = append(nextFetch, metricSample{labels: labels, value: max(q.NextFetch.Sub(now).Seconds(), 0)})
+		}
+	}
+	return directions, retrying, nextFetch
+}
+
+// directionDue is when a main-board direction is next due, or zero when
+// the scheduler doesn't know it
+func (s *scheduler) directionDue(ref directionRef, stopID string) time.Time {
+	interval := cacheRefreshInterval(currentConfig())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, q := range s.queues {
+		for _, d := range q.directions {
+			if d.ref == ref && d.stopID == stopID {
+				return d.due(interval)
+			}
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	fc, ft := withTestEnv(t, time.Date(2026, 3, 12, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-03-12T20:30:00Z"}}}
+	]}}}`)
+	cfg, err := parseConfig([]byte(`
+api_key: test
+cache_refresh_interval: 300
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: "16994"
+  - name: Uptown
+    line: "51A"
+    agency: AC
+    directions:
+      - label: Berkeley
+        stop_id: "50000"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeConfig.Store(cfg)
+	refreshCache()
+
+	runAll := func() int {
+		batches := sched.plan(clock.Now())
+		for _, b := range batches {
+			sched.run(context.Background(), b)
+		}
+		return len(batches)
+	}
+
+	if n := runAll(); n != 0 {
+		t.Errorf("batches right after a refresh = %d", n)
+	}
+
+	// Each agency gets its own batch once its directions are due
+	fc.Sleep(5 * time.Minute)
+	ft.body = "unavailable"
+	before := ft.requests
+	if n := runAll(); n != 2 || ft.requests != before+2 {
+		t.Fatalf("due batches = %d, %d requests", n, ft.requests-before)
+	}
+	queues := sched.queueStatuses(clock.Now())
+	if len(queues) != 2 || queues[0].Agency != "AC" || queues[1].Agency != "SF" || queues[0].Retrying != 1 {
+		t.Fatalf("queues = %+v", queues)
+	}
+	// Failed data is kept, and the failure retried well before the interval
+	if got := buildArrivalsResponse(clock.Now()).Stops[0].Directions[0]; got.Error == "" {
+		t.Errorf("failed direction = %+v", got)
+	}
+	if next := queues[1].NextFetch; next == nil || next.Sub(clock.Now()) > retryBase {
+		t.Errorf("retry at %v, now %v", next, clock.Now())
+	}
+
+	fc.Sleep(retryBase)
+	ft.body = `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`
+	if n := runAll(); n != 2 {
+		t.Errorf("retry batches = %d", n)
+	}
+	if s := currentStatus(); len(s.Scheduler.Queues) != 2 || s.Scheduler.Queues[1].Retrying != 0 || s.Directions[0].NextFetch == nil {
+		t.Errorf("status = %+v", s.Scheduler)
+	}
+
+	// Batches wait when they would overrun the hourly quota
+	fc.Sleep(5 * time.Minute)
+	limited := *currentConfig()
+	quota.remaining(clock.Now())
+	limited.UpstreamHourlyLimit = len(quota.calls) + 1
+	activeConfig.Store(&limited)
+	before = ft.requests
+	if n := runAll(); n != 1 || ft.requests != before+1 {
+		t.Errorf("batches under quota = %d, %d requests", n, ft.requests-before)
+	}
+	if queues := sched.queueStatuses(clock.Now()); queues[1].ThrottledUntil == nil {
+		t.Errorf("throttled queues = %+v", queues)
+	}
+}
+
+func TestSchedulerLiveness(t *testing.T) {
+	fc, ft := withTestEnv(t, time.Date(2026, 3, 12, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`)
+	withRefresher(t, cacheRefreshInterval(currentConfig()))
+	const timeout = 30 * time.Second
+	refreshCache()
+	base := currentConfig()
+
+	// A queue held back by the quota fetches nothing for a long while
+	held := *base
+	quota.remaining(clock.Now())
+	held.UpstreamHourlyLimit = len(quota.calls)
+	activeConfig.Store(&held)
+	fc.Sleep(10 * time.Minute)
+	if refresherAlive(timeout) {
+		t.Fatal("alive long after the last refresh, without passes")
+	}
+	before := ft.requests
+	sched.tick(context.Background())
+	if ft.requests != before || !refresherAlive(timeout) {
+		t.Errorf("with the quota spent: %d requests, alive = %v", ft.requests-before, refresherAlive(timeout))
+	}
+
+	// A follower never plans fetches, though its directions are due
+	resetUpstreamState()
+	follower := *base
+	follower.SharedCache.Path = t.TempDir()
+	activeConfig.Store(&follower)
+	fc.Sleep(10 * time.Minute)
+	sched.tick(context.Background())
+	if q := sched.queueStatuses(clock.Now()); len(q) != 0 || !refresherAlive(timeout) {
+		t.Errorf("following: queues %+v, alive = %v", q, refresherAlive(timeout))
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sdNotify sends a state string to systemd when running under
+// Type=notify. It is a no-op when NOTIFY_SOCKET is not set.
+func sdNotify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+
+	// Abstract namespace sockets are announced with a leading '@'
+	if socketPath[0] == '@' {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Refresher liveness, used to decide whether to pet the watchdog
+type refresherHealth struct {
+	mu           sync.Mutex
+	busy         bool
+	lastProgress time.Time
+	lastComplete time.Time
+	// The scheduler's last pass, which may not have fetched anything
+	lastTick time.Time
+	interval time.Duration
+}
+
+var refresher = &refresherHealth{}
+
+// markRefreshProgress is called by the refresher as it works through stops
+func markRefreshProgress() {
+	refresher.mu.Lock()
+	refresher.busy = true
+	refresher.lastProgress = clock.Now()
+	refresher.mu.Unlock()
+}
+
+// markRefreshDone is called when a refresh cycle completes
+func markRefreshDone() {
+	now := clock.Now()
+	refresher.mu.Lock()
+	refresher.busy = false
+	refresher.lastProgress = now
+	refresher.lastComplete = now
+	refresher.mu.Unlock()
+
+	sdNotify("STATUS=Last refresh " + displayClock(now))
+}
+
+// markSchedulerTick is called on each pass of the scheduler loop
+func markSchedulerTick() {
+	refresher.mu.Lock()
+	refresher.lastTick = clock.Now()
+	refresher.mu.Unlock()
+}
+
+// refresherAlive reports whether the refresher is making progress. A single
+// fetch must not stall longer than timeout, and the scheduler must keep
+// passing or refreshes completing.
+func refresherAlive(timeout time.Duration) bool {
+	refresher.mu.Lock()
+	defer refresher.mu.Unlock()
+
+	now := clock.Now()
+	if refresher.busy {
+		return now.Sub(refresher.lastProgress) < timeout
+	}
+	last := refresher.lastComplete
+	if refresher.lastTick.After(last) {
+		last = refresher.lastTick
+	}
+	return now.Sub(last) < refresher.interval+timeout
+}
+
+// watchdogInterval returns the systemd watchdog timeout, or 0 if disabled
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// startWatchdog sends WATCHDOG=1 heartbeats at half the configured timeout
+// for as long as the cache refresher is healthy. If the refresher wedges,
+// heartbeats stop and systemd restarts the service.
+func startWatchdog() {
+	timeout := watchdogInterval()
+	if timeout == 0 {
+		return
+	}
+
+	infof("systemd watchdog enabled (%v)", timeout)
+
+	go func() {
+		ticker := time.NewTicker(timeout / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			petWatchdog(timeout)
+		}
+	}()
+}
+
+// petWatchdog sends one heartbeat if the refresher is healthy
+func petWatchdog(timeout time.Duration) bool {
+	if !refresherAlive(timeout) {
+		errorf("Cache refresher appears stuck, withholding watchdog heartbeat")
+		return false
+	}
+	sdNotify("WATCHDOG=1")
+	return true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// notifySocket listens where sdNotify sends, as systemd would
+func notifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// notified returns the next state sent to the socket, or "" if none comes
+func notified(conn *net.UnixConn) string {
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+// withRefresher gives a test its own refresher state
+func withRefresher(t *testing.T, interval time.Duration) {
+	t.Helper()
+	old := refresher
+	refresher = &refresherHealth{interval: interval}
+	t.Cleanup(func() { refresher = old })
+}
+
+func TestWatchdog(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+	withRefresher(t, 4*time.Minute)
+	conn := notifySocket(t)
+	const timeout = 30 * time.Second
+
+	markRefreshDone()
+	if got := notified(conn); !strings.HasPrefix(got, "STATUS=Last refresh ") {
+		t.Errorf("after a refresh, notified %q", got)
+	}
+	if !petWatchdog(timeout) || notified(conn) != "WATCHDOG=1" {
+		t.Error("no heartbeat right after a refresh")
+	}
+
+	// A fetch may run up to the timeout
+	markRefreshProgress()
+	fc.Sleep(timeout - time.Second)
+	if !petWatchdog(timeout) || notified(conn) != "WATCHDOG=1" {
+		t.Error("no heartbeat during a fetch")
+	}
+	fc.Sleep(time.Second)
+	if petWatchdog(timeout) || notified(conn) != "" {
+		t.Error("heartbeat while a fetch is stuck")
+	}
+
+	// Between refreshes, the next one may come up to the timeout late
+	markRefreshDone()
+	notified(conn)
+	fc.Sleep(4*time.Minute + timeout - time.Second)
+	if !refresherAlive(timeout) {
+		t.Error("not alive before the next refresh is overdue")
+	}
+	fc.Sleep(time.Second)
+	if petWatchdog(timeout) || notified(conn) != "" {
+		t.Error("heartbeat with the refresher overdue")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"30000000", "", 30 * time.Second},
+		{"30000000", strconv.Itoa(os.Getpid()), 30 * time.Second},
+		{"30000000", "1", 0},
+		{"-1", "", 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := watchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: interval = %v, want %v", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+
+	// Without a socket, notifications are dropped
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("sdNotify without NOTIFY_SOCKET: %v", err)
+	}
+}
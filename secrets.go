@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dockerSecretsDir is where Docker and Compose mount secrets
+var dockerSecretsDir = "/run/secrets"
+
+// secretField is a credential that may be given inline or as a file
+type secretField struct {
+	name  string
+	value *string
+	file  *string
+	// Environment variable naming the file, per the Docker image
+	// convention of <VAR>_FILE
+	env string
+	// Secret name looked for in dockerSecretsDir when nothing else is set
+	dockerSecret string
+}
+
+func secretFields(cfg *Config) []secretField {
+	return []secretField{
+		{"api_key", &cfg.APIKey, &cfg.APIKeyFile, "API_KEY_FILE", "511_api_key"},
+		{"admin.token", &cfg.Admin.Token, &cfg.Admin.TokenFile, "ADMIN_TOKEN_FILE", ""},
+		{"admin.password", &cfg.Admin.Password, &cfg.Admin.PasswordFile, "ADMIN_PASSWORD_FILE", ""},
+		{"bart.api_key", &cfg.BART.APIKey, &cfg.BART.APIKeyFile, "BART_API_KEY_FILE", ""},
+		{"transitland.api_key", &cfg.Transitland.APIKey, &cfg.Transitland.APIKeyFile, "TRANSITLAND_API_KEY_FILE", ""},
+		{"remote_config.token", &cfg.RemoteConfig.Token, &cfg.RemoteConfig.TokenFile, "", ""},
+		{"notifications.email.password", &cfg.Notifications.Email.Password, &cfg.Notifications.Email.PasswordFile, "SMTP_PASSWORD_FILE", ""},
+	}
+}
+
+// resolveSecretFiles reads credentials kept in files, such as a mode 0400
+// file or a mounted Docker or Kubernetes secret, so they needn't appear in
+// config.yaml. Surrounding whitespace, like the trailing newline most
+// secret files have, is dropped.
+func resolveSecretFiles(cfg *Config) error {
+	for _, s := range secretFields(cfg) {
+		if *s.file == "" && *s.value == "" && s.env != "" {
+			*s.file = os.Getenv(s.env)
+		}
+		if *s.file == "" && *s.value == "" && s.dockerSecret != "" {
+			path := filepath.Join(dockerSecretsDir, s.dockerSecret)
+			if _, err := os.Stat(path); err == nil {
+				*s.file = path
+			}
+		}
+		if *s.file == "" {
+			continue
+		}
+		if *s.value != "" {
+			return fmt.Errorf("set %s or %s_file, not both", s.name, s.name)
+		}
+
+		data, err := os.ReadFile(*s.file)
+		if err != nil {
+			return fmt.Errorf("%s_file: %w", s.name, err)
+		}
+		*s.value = strings.TrimSpace(string(data))
+		if *s.value == "" {
+			return fmt.Errorf("%s_file: %s is empty", s.name, *s.file)
+		}
+	}
+	return nil
+}
+
+// withoutFileSecrets returns a copy of cfg without the values read from
+// secret files, so saving or exporting it keeps only the file references
+func withoutFileSecrets(cfg *Config) *Config {
+	out := *cfg
+	for _, s := range secretFields(&out) {
+		if *s.file != "" {
+			*s.value = ""
+		}
+	}
+	return &out
+}
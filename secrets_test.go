@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "511.key")
+	os.WriteFile(keyFile, []byte("file-key\n"), 0400)
+	os.WriteFile(filepath.Join(dir, "511_api_key"), []byte("docker-key\n"), 0400)
+	os.WriteFile(filepath.Join(dir, "admin.pass"), []byte("hunter2"), 0400)
+
+	oldDir := dockerSecretsDir
+	dockerSecretsDir = dir
+	t.Cleanup(func() { dockerSecretsDir = oldDir })
+
+	stops := "stops: [{name: Embarcadero, directions: [{label: Out, stop_id: \"16994\"}]}]\n"
+	cfg, err := parseConfig([]byte("api_key_file: " + keyFile + "\nadmin: {username: admin, password_file: " + filepath.Join(dir, "admin.pass") + "}\n" + stops))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	if cfg.APIKey != "file-key" || cfg.Admin.Password != "hunter2" {
+		t.Errorf("api_key = %q, admin.password = %q", cfg.APIKey, cfg.Admin.Password)
+	}
+
+	// Saving keeps the references, never the secrets
+	out, _ := marshalConfig(cfg)
+	if strings.Contains(string(out), "file-key") || strings.Contains(string(out), "hunter2") || !strings.Contains(string(out), "api_key_file: ") {
+		t.Errorf("marshalled config leaks secrets:\n%s", out)
+	}
+	if cfg.APIKey != "file-key" {
+		t.Error("marshalConfig modified the running config")
+	}
+
+	// Docker secrets are picked up when nothing else is configured
+	cfg, err = parseConfig([]byte(stops))
+	if err != nil || cfg.APIKey != "docker-key" {
+		t.Errorf("docker secret: api_key = %q, %v", cfg.APIKey, err)
+	}
+
+	t.Setenv("API_KEY_FILE", keyFile)
+	if cfg, err := parseConfig([]byte(stops)); err != nil || cfg.APIKey != "file-key" {
+		t.Errorf("API_KEY_FILE: %v", err)
+	}
+
+	if _, err := parseConfig([]byte("api_key: inline\napi_key_file: " + keyFile + "\n" + stops)); err == nil {
+		t.Error("api_key and api_key_file together should be rejected")
+	}
+}
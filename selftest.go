@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"muni-tracker/pkg/go511"
+)
+
+// Self-test outcomes per configured direction
+const (
+	selfTestPass  = "PASS"
+	selfTestEmpty = "EMPTY"
+	selfTestFail  = "FAIL"
+)
+
+type selfTestResult struct {
+	Agency string
+	Stop   string
+	Label  string
+	StopID string
+	Status string
+	Detail string
+}
+
+// runSelfTest checks each configured stop code against 511.org. The first
+// request per agency doubles as the API key check: if it is rejected, the
+// remaining stops for that agency are skipped instead of burning quota.
+func runSelfTest(ctx context.Context) []selfTestResult {
+	config := currentConfig()
+
+	client := go511.NewClient(config.APIKey)
+	client.HTTPClient = upstreamClient()
+
+	badKey := make(map[string]bool)
+	var results []selfTestResult
+	requests := 0
+
+	for _, stop := range config.Stops {
+		agency := stop.Agency
+		if agency == "" {
+			agency = "SF"
+		}
+
+		for _, dir := range stop.Directions {
+			for _, stopID := range dir.allStopIDs() {
+				result := selfTestResult{
+					Agency: agency,
+					Stop:   stop.Name,
+					Label:  dir.Label,
+					StopID: stopID,
+				}
+
+				if badKey[agency] {
+					result.Status = selfTestFail
+					result.Detail = "skipped, API key rejected"
+					results = append(results, result)
+					continue
+				}
+
+				if requests > 0 {
+					clock.Sleep(upstreamDelay)
+				}
+				requests++
+				quota.record(clock.Now())
+
+				resp, err := client.StopMonitoring(ctx, agency, stopID)
+				var httpErr *go511.HTTPError
+				switch {
+				case errors.As(err, &httpErr) && (httpErr.StatusCode == http.StatusUnauthorized || httpErr.StatusCode == http.StatusForbidden):
+					badKey[agency] = true
+					result.Status = selfTestFail
+					result.Detail = fmt.Sprintf("API key rejected (HTTP %d)", httpErr.StatusCode)
+				case err != nil:
+					result.Status = selfTestFail
+					result.Detail = err.Error()
+				case len(resp.Visits()) == 0:
+					result.Status = selfTestEmpty
+					result.Detail = "no upcoming vehicles; check the stop code if service should be running"
+				default:
+					result.Status = selfTestPass
+					result.Detail = fmt.Sprintf("%d upcoming vehicles", len(resp.Visits()))
+				}
+
+				results = append(results, result)
+			}
+		}
+	}
+
+	return results
+}
+
+// selfTestFailed reports whether any check failed outright
+func selfTestFailed(results []selfTestResult) bool {
+	for _, r := range results {
+		if r.Status == selfTestFail {
+			return true
+		}
+	}
+	return false
+}
+
+func printSelfTest(w io.Writer, results []selfTestResult) {
+	for _, r := range results {
+		fmt.Fprintf(w, "%-5s  %s  %s / %s (stop %s): %s\n", r.Status, r.Agency, r.Stop, r.Label, r.StopID, r.Detail)
+	}
+}
+
+// logSelfTest reports startup self-test results through the logger
+func logSelfTest(results []selfTestResult) {
+	for _, r := range results {
+		msg := fmt.Sprintf("Self-test %s: %s / %s (stop %s, agency %s): %s", r.Status, r.Stop, r.Label, r.StopID, r.Agency, r.Detail)
+		switch r.Status {
+		case selfTestPass:
+			infof("%s", msg)
+		case selfTestEmpty:
+			warnf("%s", msg)
+		default:
+			errorf("%s", msg)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stopCodeTransport answers StopMonitoring by stop code, with a status
+// and body, and records the codes asked for
+type stopCodeTransport struct {
+	answers map[string]string
+	asked   []string
+}
+
+func (t *stopCodeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	code := r.URL.Query().Get("stopCode")
+	t.asked = append(t.asked, code)
+	status, body, _ := strings.Cut(t.answers[code], " ")
+	n, _ := strconv.Atoi(status)
+	return &http.Response{
+		StatusCode: n,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+		Request:    r,
+	}, nil
+}
+
+func TestSelfTest(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+	const (
+		visit = `200 {"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[{"MonitoredVehicleJourney":{"LineRef":"N","MonitoredCall":{"ExpectedArrivalTime":"2026-01-30T20:05:00Z"}}}]}}}`
+		empty = `200 {"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`
+	)
+
+	tests := []struct {
+		name    string
+		stops   string
+		answers map[string]string
+		want    []string
+		asked   string
+	}{
+		{"pass", `[{name: A, directions: [{label: B, stop_id: "1"}]}]`,
+			map[string]string{"1": visit}, []string{"PASS"}, "1"},
+		{"no vehicles", `[{name: A, directions: [{label: B, stop_id: "1"}]}]`,
+			map[string]string{"1": empty}, []string{"EMPTY"}, "1"},
+		{"unknown stop code", `[{name: A, directions: [{label: B, stop_id: "1"}]}]`,
+			map[string]string{"1": "404 no such stop"}, []string{"FAIL"}, "1"},
+		{"unreadable response", `[{name: A, directions: [{label: B, stop_id: "1"}]}]`,
+			map[string]string{"1": "200 <html>"}, []string{"FAIL"}, "1"},
+		{"each merged stop", `[{name: A, directions: [{label: B, stop_ids: ["1", "2"]}]}]`,
+			map[string]string{"1": visit, "2": "404 gone"}, []string{"PASS", "FAIL"}, "1 2"},
+		{"rejected key skips the agency", `[{name: A, directions: [{label: B, stop_id: "1"}, {label: C, stop_id: "2"}]}, {name: D, agency: AC, directions: [{label: E, stop_id: "3"}]}]`,
+			map[string]string{"1": "401 bad key", "2": visit, "3": visit}, []string{"FAIL", "FAIL", "PASS"}, "1 3"},
+		{"forbidden key", `[{name: A, directions: [{label: B, stop_id: "1"}, {label: C, stop_id: "2"}]}]`,
+			map[string]string{"1": "403 forbidden", "2": visit}, []string{"FAIL", "FAIL"}, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseConfig([]byte("api_key: test\nstops: " + tt.stops + "\n"))
+			if err != nil {
+				t.Fatalf("parseConfig: %v", err)
+			}
+			activeConfig.Store(cfg)
+			st := &stopCodeTransport{answers: tt.answers}
+			upstreamTransport = st
+
+			results := runSelfTest(context.Background())
+			var got []string
+			for _, r := range results {
+				got = append(got, r.Status)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("results = %+v, want %v", results, tt.want)
+			}
+			if asked := strings.Join(st.asked, " "); asked != tt.asked {
+				t.Errorf("asked for %s, want %s", asked, tt.asked)
+			}
+			if selfTestFailed(results) != slices.Contains(tt.want, selfTestFail) {
+				t.Errorf("selfTestFailed = %v", selfTestFailed(results))
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// geoJSONFeature is a LineString feature for one direction of a line
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Properties map[string]string      `json:"properties"`
+	Geometry   map[string]interface{} `json:"geometry"`
+}
+
+// handleShapes serves /api/shapes/{line} as a GeoJSON FeatureCollection
+// with one LineString per direction
+func handleShapes(w http.ResponseWriter, r *http.Request) {
+	line := strings.TrimPrefix(r.URL.Path, "/api/shapes/")
+	if line == "" || strings.Contains(line, "/") {
+		http.Error(w, "line is required: /api/shapes/{line}", http.StatusBadRequest)
+		return
+	}
+
+	agency := r.URL.Query().Get("agency")
+	if agency == "" {
+		agency = "SF"
+	}
+
+	idx, err := agencyIndex(agency)
+	if errors.Is(err, errUnknownAgency) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		warnf("Shapes for %s unavailable: %v", agency, err)
+		http.Error(w, "shape data unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	shapes, ok := idx.Shapes[strings.ToUpper(line)]
+	if !ok {
+		http.Error(w, "no shape for line "+line, http.StatusNotFound)
+		return
+	}
+	setCacheControl(w, r, datasetMaxAge)
+
+	features := make([]geoJSONFeature, len(shapes))
+	for i, s := range shapes {
+		features[i] = geoJSONFeature{
+			Type:       "Feature",
+			Properties: map[string]string{"line": line, "direction": s.Direction, "headsign": s.Headsign},
+			Geometry:   map[string]interface{}{"type": "LineString", "coordinates": s.Points},
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseGTFSShapes(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	idx, err := parseGTFS("SF", gtfsZip(t, testGTFS))
+	if err != nil {
+		t.Fatalf("parseGTFS: %v", err)
+	}
+
+	// The most used shape wins and points are in sequence order
+	n := idx.Shapes["N"]
+	if len(n) != 1 || n[0].Direction != "1" || n[0].Headsign != "Ocean Beach" {
+		t.Fatalf("N shapes = %+v", n)
+	}
+	if want := [][2]float64{{-122.40, 37.80}, {-122.39, 37.79}}; len(n[0].Points) != 2 || n[0].Points[0] != want[0] || n[0].Points[1] != want[1] {
+		t.Errorf("N points = %v, want %v", n[0].Points, want)
+	}
+
+	// Feeds without shapes.txt still load
+	files := map[string]string{}
+	for k, v := range testGTFS {
+		if k != "shapes.txt" {
+			files[k] = v
+		}
+	}
+	if idx, err := parseGTFS("SF", gtfsZip(t, files)); err != nil || len(idx.Shapes) != 0 {
+		t.Errorf("without shapes.txt: %v, %d shapes", err, len(idx.Shapes))
+	}
+}
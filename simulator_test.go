@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSimulator(t *testing.T) {
+	now := time.Date(2026, 3, 12, 20, 0, 0, 0, time.UTC)
+	withTestEnv(t, now, "")
+	cfg, err := parseConfig([]byte(`
+provider: simulator
+simulator: {headway: 8, jitter: 3}
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: "16994"
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	arrivals, err := simulateStopArrivals("SF", "16994", now)
+	if err != nil {
+		t.Fatalf("simulateStopArrivals: %v", err)
+	}
+	// 90 minutes of 8-minute headways
+	if len(arrivals) < 10 || len(arrivals) > 12 {
+		t.Fatalf("%d arrivals", len(arrivals))
+	}
+	prev := now
+	for i, a := range arrivals {
+		at, err := time.Parse(time.RFC3339, a.ArrivalTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gap := at.Sub(prev); at.Before(now) || (i > 0 && (gap < 2*time.Minute || gap > 14*time.Minute)) {
+			t.Errorf("arrival %d at %v, %v after the last", i, at, gap)
+		}
+		if a.Destination != "Ocean Beach" || a.LineType != "N Judah" || !a.Realtime {
+			t.Errorf("arrival %d = %+v", i, a)
+		}
+		prev = at
+	}
+	// The same clock simulates the same board
+	if again, _ := simulateStopArrivals("SF", "16994", now); !reflect.DeepEqual(again, arrivals) {
+		t.Error("arrivals changed between identical fetches")
+	}
+
+	// Statuses follow the board's clock as the first vehicle comes and goes
+	refreshCache()
+	first, _ := time.Parse(time.RFC3339, arrivals[0].ArrivalTime)
+	for _, tt := range []struct {
+		before time.Duration
+		want   string
+	}{
+		{5 * time.Minute, statusUpcoming},
+		{30 * time.Second, statusDue},
+		{-10 * time.Second, statusDeparting},
+	} {
+		got := buildArrivalsResponse(first.Add(-tt.before)).Stops[0].Directions[0].Arrivals
+		if len(got) == 0 || got[0].Status != tt.want {
+			t.Errorf("%v before the first arrival: %+v, want %s", tt.before, got, tt.want)
+		}
+	}
+
+	// Failures and gaps are drawn per fetch time and slot
+	failing := *cfg
+	failing.Simulator.ErrorChance = 1
+	activeConfig.Store(&failing)
+	if _, err := simulateStopArrivals("SF", "16994", now); err == nil {
+		t.Error("no failure with error_chance 1")
+	}
+	gappy := *cfg
+	gappy.Simulator.GapChance = 1
+	activeConfig.Store(&gappy)
+	gapped, _ := simulateStopArrivals("SF", "16994", now)
+	if len(gapped) >= len(arrivals)/2 {
+		t.Errorf("%d arrivals with gap_chance 1, %d without", len(gapped), len(arrivals))
+	}
+}
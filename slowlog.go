@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// SlowLogConfig sets how slow an upstream fetch or a client request must
+// be to log a warning, so intermittent slowness shows up without tracing
+type SlowLogConfig struct {
+	// Upstream fetch threshold in milliseconds; 0 disables
+	FetchMS int `yaml:"fetch_ms,omitempty"`
+	// Client request threshold in milliseconds; 0 disables
+	RequestMS int `yaml:"request_ms,omitempty"`
+}
+
+func validateSlowLogConfig(s *SlowLogConfig) error {
+	if s.FetchMS < 0 {
+		return fmt.Errorf("slow_log.fetch_ms cannot be negative")
+	}
+	if s.RequestMS < 0 {
+		return fmt.Errorf("slow_log.request_ms cannot be negative")
+	}
+	return nil
+}
+
+// slowThreshold converts a millisecond setting, with 0 meaning never
+func slowThreshold(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// noteSlowFetch warns about an upstream fetch over slow_log.fetch_ms
+func noteSlowFetch(ctx context.Context, agency, stopID string, took time.Duration) {
+	threshold := slowThreshold(currentConfig().SlowLog.FetchMS)
+	if threshold == 0 || took < threshold {
+		return
+	}
+	warnf("Slow fetch: agency=%s stop=%s took=%v threshold=%v trace=%s",
+		agency, stopID, took.Round(time.Millisecond), threshold, traceID(ctx))
+}
+
+// slowRequestMiddleware warns about requests over slow_log.request_ms
+func slowRequestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		threshold := slowThreshold(currentConfig().SlowLog.RequestMS)
+		if threshold == 0 || longPollRoutes[routePattern(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		took := time.Since(start)
+		if took < threshold {
+			return
+		}
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		client, _ := clientKeyName(r)
+		warnf("Slow request: method=%s endpoint=%s path=%s status=%d bytes=%d client=%s ip=%s took=%v threshold=%v trace=%s",
+			r.Method, routePattern(r), loggedPath(r), rec.status, rec.bytes, client, host,
+			took.Round(time.Millisecond), threshold, traceID(r.Context()))
+	})
+}
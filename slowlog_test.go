@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowTransport delays upstream responses by real time
+type slowTransport struct {
+	delay time.Duration
+	next  http.RoundTripper
+}
+
+func (t slowTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	time.Sleep(t.delay)
+	return t.next.RoundTrip(r)
+}
+
+func TestSlowLog(t *testing.T) {
+	_, ft := withTestEnv(t, time.Date(2026, 3, 2, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`)
+	if _, err := parseConfig([]byte("api_key: test\nslow_log: {fetch_ms: -1}\nstops: []\n")); err == nil {
+		t.Error("negative fetch_ms accepted")
+	}
+	cfg, err := parseConfig([]byte(`
+api_key: test
+slow_log: {fetch_ms: 20, request_ms: 20}
+stops:
+  - name: Embarcadero
+    directions: [{label: Ocean Beach, stop_id: "16994"}]
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	fetchStopArrivals(context.Background(), "SF", "16994")
+	upstreamTransport = slowTransport{40 * time.Millisecond, ft}
+	fetchStopArrivals(newTraceContext(context.Background()), "SF", "16994")
+	if got := strings.Count(buf.String(), "Slow fetch: agency=SF stop=16994 "); got != 1 {
+		t.Errorf("%d slow fetch warnings in:\n%s", got, buf.String())
+	}
+
+	buf.Reset()
+	handler := slowRequestMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("slow") != "" {
+			time.Sleep(40 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/next", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/next?slow=1", nil))
+	out := buf.String()
+	if strings.Count(out, "Slow request:") != 1 || !strings.Contains(out, "path=/api/next status=200 bytes=2 ") {
+		t.Errorf("slow request log:\n%s", out)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// snapshotVersion changes when the snapshot format does; a snapshot of
+// another version is ignored
+const snapshotVersion = 1
+
+// snapshotFile is what the server saves on shutdown and reloads at start:
+// enough to carry on as if it had never stopped
+type snapshotFile struct {
+	Version int             `json:"version"`
+	SavedAt time.Time       `json:"saved_at"`
+	Cache   snapshotCache   `json:"cache"`
+	Queues  []snapshotQueue `json:"queues"`
+	// Upstream requests made in the last hour
+	Quota []time.Time `json:"quota"`
+	// What the dashboards and side feeds last fetched
+	Feeds snapshotFeeds `json:"feeds"`
+}
+
+// snapshotFeeds is everything fetched from upstream besides the main
+// board, so a shared cache's followers can serve it without fetching
+type snapshotFeeds struct {
+	Dashboards map[string]snapshotCache   `json:"dashboards,omitempty"`
+	Weather    *Weather                   `json:"weather,omitempty"`
+	Bikeshare  []StationStatus            `json:"bikeshare,omitempty"`
+	BART       map[string][]string        `json:"bart,omitempty"`
+	Datasets   map[string]snapshotDataset `json:"datasets,omitempty"`
+}
+
+type snapshotDataset struct {
+	Value     json.RawMessage `json:"value"`
+	FetchedAt time.Time       `json:"fetched_at"`
+}
+
+type snapshotCache struct {
+	Data           ArrivalsResponse `json:"data"`
+	LastFetched    time.Time        `json:"last_fetched"`
+	Revision       uint64           `json:"revision"`
+	LayoutRevision uint64           `json:"layout_revision"`
+	// Per-direction bookkeeping that Data's JSON leaves out
+	Directions []snapshotDirection `json:"directions"`
+	// When a dashboard refreshes next, which Data's JSON also leaves out
+	NextRefresh time.Time `json:"next_refresh,omitempty"`
+}
+
+type snapshotDirection struct {
+	Stop        int       `json:"stop"`
+	Dir         int       `json:"dir"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	FetchError  string    `json:"fetch_error,omitempty"`
+	Revision    uint64    `json:"revision"`
+	RuleWarning string    `json:"rule_warning,omitempty"`
+}
+
+type snapshotQueue struct {
+	Agency         string              `json:"agency"`
+	LastBatch      time.Time           `json:"last_batch"`
+	ThrottledUntil time.Time           `json:"throttled_until"`
+	Directions     []snapshotScheduled `json:"directions"`
+}
+
+type snapshotScheduled struct {
+	Stop     int       `json:"stop"`
+	Dir      int       `json:"dir"`
+	StopID   string    `json:"stop_id"`
+	LastRun  time.Time `json:"last_run"`
+	Failures int       `json:"failures"`
+}
+
+// saveSnapshot writes the cache, scheduler, and quota to path
+func saveSnapshot(path string) error {
+	data, err := json.Marshal(takeSnapshot())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// takeSnapshot captures the cache, scheduler, and quota. Refreshes in
+// progress are waited for, so the snapshot is of finished work.
+func takeSnapshot() snapshotFile {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	snap := snapshotFile{Version: snapshotVersion, SavedAt: clock.Now()}
+
+	cache.mu.RLock()
+	snap.Cache = snapshotCache{
+		Data:           cache.data,
+		LastFetched:    cache.lastFetched,
+		Revision:       cache.revision,
+		LayoutRevision: cache.layoutRevision,
+		Directions:     snapshotDirections(cache.data),
+	}
+	cache.mu.RUnlock()
+
+	sched.mu.Lock()
+	for _, agency := range sched.agencies() {
+		q := sched.queues[agency]
+		sq := snapshotQueue{Agency: agency, LastBatch: q.lastBatch, ThrottledUntil: q.throttledUntil}
+		for _, d := range q.directions {
+			sq.Directions = append(sq.Directions, snapshotScheduled{
+				Stop: d.ref.stop, Dir: d.ref.dir, StopID: d.stopID,
+				LastRun: d.lastRun, Failures: d.failures,
+			})
+		}
+		snap.Queues = append(snap.Queues, sq)
+	}
+	sched.mu.Unlock()
+
+	quota.mu.Lock()
+	quota.prune(snap.SavedAt)
+	snap.Quota = append([]time.Time(nil), quota.calls...)
+	quota.mu.Unlock()
+
+	snap.Feeds = takeFeeds()
+	return snap
+}
+
+// snapshotDirections captures the bookkeeping of data's directions that
+// their JSON leaves out
+func snapshotDirections(data ArrivalsResponse) []snapshotDirection {
+	var out []snapshotDirection
+	for i, stop := range data.Stops {
+		for j, dir := range stop.Directions {
+			out = append(out, snapshotDirection{
+				Stop: i, Dir: j,
+				FetchedAt: dir.FetchedAt, UpdatedAt: dir.UpdatedAt,
+				FetchError: dir.FetchError, Revision: dir.Revision,
+				RuleWarning: dir.RuleWarning,
+			})
+		}
+	}
+	return out
+}
+
+// restoreDirections puts captured bookkeeping back on data's directions
+func restoreDirections(data ArrivalsResponse, dirs []snapshotDirection) {
+	for _, d := range dirs {
+		if d.Stop >= len(data.Stops) || d.Dir >= len(data.Stops[d.Stop].Directions) {
+			continue
+		}
+		dir := &data.Stops[d.Stop].Directions[d.Dir]
+		dir.FetchedAt, dir.UpdatedAt, dir.FetchError, dir.Revision = d.FetchedAt, d.UpdatedAt, d.FetchError, d.Revision
+		dir.RuleWarning = d.RuleWarning
+	}
+}
+
+// takeFeeds captures the dashboards and side feeds
+func takeFeeds() snapshotFeeds {
+	var feeds snapshotFeeds
+
+	dashboardCache.RLock()
+	if len(dashboardCache.byPath) > 0 {
+		feeds.Dashboards = make(map[string]snapshotCache, len(dashboardCache.byPath))
+	}
+	for path, data := range dashboardCache.byPath {
+		feeds.Dashboards[path] = snapshotCache{Data: data, Directions: snapshotDirections(data), NextRefresh: data.NextRefresh}
+	}
+	dashboardCache.RUnlock()
+
+	weatherCache.RLock()
+	feeds.Weather = weatherCache.data
+	weatherCache.RUnlock()
+	bikeshareCache.RLock()
+	feeds.Bikeshare = bikeshareCache.data
+	bikeshareCache.RUnlock()
+	bartAdvisories.RLock()
+	feeds.BART = bartAdvisories.byStation
+	bartAdvisories.RUnlock()
+
+	datasets.Lock()
+	for key, entry := range datasets.entries {
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			continue
+		}
+		if feeds.Datasets == nil {
+			feeds.Datasets = make(map[string]snapshotDataset)
+		}
+		feeds.Datasets[key] = snapshotDataset{Value: value, FetchedAt: entry.fetchedAt}
+	}
+	datasets.Unlock()
+	return feeds
+}
+
+// applyFeeds takes on captured dashboards and side feeds. Feeds the
+// snapshot doesn't have keep what this instance holds.
+func applyFeeds(feeds snapshotFeeds) {
+	dashboardCache.Lock()
+	for path, board := range feeds.Dashboards {
+		data := board.Data
+		restoreDirections(data, board.Directions)
+		data.NextRefresh = board.NextRefresh
+		dashboardCache.byPath[path] = data
+	}
+	dashboardCache.Unlock()
+
+	if feeds.Weather != nil {
+		weatherCache.Lock()
+		weatherCache.data = feeds.Weather
+		weatherCache.Unlock()
+	}
+	if feeds.Bikeshare != nil {
+		bikeshareCache.Lock()
+		bikeshareCache.data = feeds.Bikeshare
+		bikeshareCache.Unlock()
+	}
+	if feeds.BART != nil {
+		bartAdvisories.Lock()
+		bartAdvisories.byStation = feeds.BART
+		bartAdvisories.Unlock()
+	}
+
+	datasets.Lock()
+	for key, d := range feeds.Datasets {
+		// Decoded into its type on first use, see datasetValue
+		datasets.entries[key] = datasetEntry{value: d.Value, fetchedAt: d.FetchedAt}
+	}
+	datasets.Unlock()
+}
+
+// restoreSnapshot loads a snapshot saved by an earlier run and removes
+// it, so a crash later on doesn't bring back state this run has moved
+// past. The quota is always restored. The cache and scheduler are only
+// restored when the snapshot holds the configured stops; restoreSnapshot
+// reports whether they were.
+func restoreSnapshot(path string) (bool, error) {
+	snap, err := readSnapshot(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := os.Remove(path); err != nil {
+		warnf("Snapshot: could not remove %s after reading it: %v", path, err)
+	}
+
+	if !applySnapshot(snap) {
+		infof("Snapshot from %s restored the upstream quota; the stops have changed since, so the cache starts cold", localTime(snap.SavedAt).Format(time.RFC3339))
+		return false, nil
+	}
+	infof("Warm start from the snapshot saved %s (%d directions)", localTime(snap.SavedAt).Format(time.RFC3339), len(snap.Cache.Directions))
+	return true, nil
+}
+
+// readSnapshot reads and checks a snapshot file
+func readSnapshot(path string) (snapshotFile, error) {
+	var snap snapshotFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snap, err
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if snap.Version != snapshotVersion {
+		return snap, fmt.Errorf("%s is snapshot version %d, want %d", path, snap.Version, snapshotVersion)
+	}
+	return snap, nil
+}
+
+// applySnapshot takes on a snapshot's quota and feeds and, when it holds
+// the configured stops, its cache and scheduler, reporting whether it did
+func applySnapshot(snap snapshotFile) bool {
+	quota.mu.Lock()
+	quota.calls = snap.Quota
+	quota.prune(clock.Now())
+	quota.mu.Unlock()
+	applyFeeds(snap.Feeds)
+
+	cfg := currentConfig()
+	restored := snap.Cache.Data
+	if len(restored.Stops) == 0 || layoutChanged(restored, configLayout(cfg)) {
+		return false
+	}
+	restoreDirections(restored, snap.Cache.Directions)
+
+	prev := cache.adopt(restored, snap.Cache.LastFetched, snap.Cache.Revision, snap.Cache.LayoutRevision)
+	sched.restore(snap.Queues)
+
+	// Health and the watchdog follow the fetches the snapshot brought in
+	for i, stop := range cfg.Stops {
+		for j, dir := range stop.Directions {
+			result := restored.Stops[i].Directions[j]
+			if last, ok := previousDirection(prev, i, j, dir.StopID); !ok || !last.FetchedAt.Equal(result.FetchedAt) {
+				recordFetch(stop, dir, result)
+			}
+		}
+	}
+	markRefreshDone()
+	return true
+}
+
+// restore takes up the queues of a snapshot. Directions it doesn't
+// mention are picked up from the cache by the next sync.
+func (s *scheduler) restore(queues []snapshotQueue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sq := range queues {
+		q := &agencyQueue{agency: sq.Agency, lastBatch: sq.LastBatch, throttledUntil: sq.ThrottledUntil}
+		for _, d := range sq.Directions {
+			q.directions = append(q.directions, &scheduledDirection{
+				ref:      directionRef{d.Stop, d.Dir},
+				stopID:   d.StopID,
+				lastRun:  d.LastRun,
+				failures: d.Failures,
+			})
+		}
+		s.queues[sq.Agency] = q
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Date(2026, 3, 16, 20, 0, 0, 0, time.UTC), `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[
+		{"MonitoredVehicleJourney":{"LineRef":"N","DestinationName":"Ocean Beach","Monitored":true,"MonitoredCall":{"ExpectedArrivalTime":"2026-03-16T20:30:00Z"}}}
+	]}}}`)
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	refreshCache()
+	sched.plan(fc.now)
+	sched.mu.Lock()
+	sched.queues["SF"].directions[0].failures = 2
+	lastRun := sched.queues["SF"].directions[0].lastRun
+	sched.mu.Unlock()
+	fc.Sleep(time.Minute)
+
+	_, revision := cache.revisions()
+	left := quota.remaining(fc.now)
+	want := buildArrivalsPage(fc.now, allArrivals)
+	if err := saveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart loses everything in memory...
+	cache.mu.Lock()
+	cache.data, cache.revision, cache.changed = ArrivalsResponse{}, 0, nil
+	cache.mu.Unlock()
+	sched.mu.Lock()
+	sched.queues = make(map[string]*agencyQueue)
+	sched.mu.Unlock()
+	quota.mu.Lock()
+	quota.calls = nil
+	quota.mu.Unlock()
+
+	// ...and the snapshot brings it back
+	warm, err := restoreSnapshot(path)
+	if err != nil || !warm {
+		t.Fatalf("restoreSnapshot = %v, %v", warm, err)
+	}
+	if _, got := cache.revisions(); got != revision {
+		t.Errorf("revision = %d, want %d", got, revision)
+	}
+	if got := buildArrivalsPage(fc.now, allArrivals); !reflectEqualJSON(t, got, want) {
+		t.Errorf("arrivals after warm start = %+v, want %+v", got, want)
+	}
+	if got := quota.remaining(fc.now); got != left {
+		t.Errorf("quota remaining = %d, want %d", got, left)
+	}
+	if due := sched.directionDue(directionRef{0, 0}, "16994"); !due.Equal(lastRun.Add(2 * retryBase)) {
+		t.Errorf("next fetch = %v, want the second retry", due)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("snapshot left behind after loading: %v", err)
+	}
+
+	// The restored cache spares the start-up fetch
+	if !cacheMatchesConfig() {
+		t.Error("restored cache doesn't match the config; start-up would refetch it")
+	}
+
+	// Once the stops change, only the quota carries over
+	if err := saveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	cfg := *currentConfig()
+	cfg.Stops = []Stop{{Name: "Church", Line: "J Church", Directions: []Direction{{Label: "Downtown", StopID: "13326"}}}}
+	activeConfig.Store(&cfg)
+	quota.mu.Lock()
+	quota.calls = nil
+	quota.mu.Unlock()
+	if warm, err := restoreSnapshot(path); err != nil || warm {
+		t.Errorf("restoreSnapshot with new stops = %v, %v", warm, err)
+	}
+	if got := quota.remaining(fc.now); got != left {
+		t.Errorf("quota remaining = %d, want %d", got, left)
+	}
+
+	// A missing snapshot is a cold start, not an error
+	if warm, err := restoreSnapshot(path); err != nil || warm {
+		t.Errorf("restoreSnapshot without a file = %v, %v", warm, err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// staticFS returns the web UI assets. The embedded copy is used unless
+// static_dir points at a directory on disk, which is handy during
+// frontend development.
+func staticFS() fs.FS {
+	return staticDirFS(currentConfig().StaticDir)
+}
+
+// staticDirFS serves dir, or the embedded assets when dir is empty
+func staticDirFS(dir string) fs.FS {
+	if dir != "" {
+		infof("Serving static files from %s", dir)
+		return os.DirFS(dir)
+	}
+
+	sub, err := fs.Sub(embeddedStatic, "static")
+	if err != nil {
+		log.Fatalf("Embedded static files missing: %v", err)
+	}
+	return sub
+}
+
+// compressibleExts are the asset types worth compressing; images other
+// than SVG are compressed already
+var compressibleExts = map[string]bool{
+	".html": true, ".css": true, ".js": true, ".mjs": true, ".json": true,
+	".svg": true, ".txt": true, ".xml": true, ".map": true, ".webmanifest": true,
+}
+
+// staticEncodings are the precompressed variants looked for, best first
+var staticEncodings = []struct{ name, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// staticServer serves static files, preferring a precompressed app.js.br
+// or app.js.gz next to app.js when the client accepts it
+type staticServer struct {
+	fsys  fs.FS
+	files http.Handler
+	// gzip variants made at startup with static_compress, by file name
+	gzipped map[string][]byte
+}
+
+// staticHandler serves fsys. With compress, every compressible file
+// without a .gz variant is gzipped in memory up front.
+func staticHandler(fsys fs.FS, compress bool) http.Handler {
+	s := &staticServer{fsys: fsys, files: http.FileServer(http.FS(fsys))}
+	if compress {
+		s.gzipped = gzipStatic(fsys)
+	}
+	return s
+}
+
+// gzipStatic compresses the compressible files in fsys, skipping those
+// with a .gz on disk already and those that don't shrink
+func gzipStatic(fsys fs.FS) map[string][]byte {
+	out := make(map[string][]byte)
+	var before, after int
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !compressibleExts[path.Ext(name)] {
+			return nil
+		}
+		if _, err := fs.Stat(fsys, name+".gz"); err == nil {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			warnf("Compressing %s: %v", name, err)
+			return nil
+		}
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		zw.Write(data)
+		zw.Close()
+		if buf.Len() < len(data) {
+			out[name] = buf.Bytes()
+			before, after = before+len(data), after+buf.Len()
+		}
+		return nil
+	})
+	if len(out) > 0 {
+		infof("Compressed %d static files, %d KB to %d KB", len(out), before>>10, after>>10)
+	}
+	return out
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows enc
+func acceptsEncoding(header, enc string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), enc) && strings.TrimSpace(name) != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// variant returns a compressed copy of name for the encoding, if any
+func (s *staticServer) variant(name, enc, ext string) (io.ReadSeeker, bool) {
+	if f, err := s.fsys.Open(name + ext); err == nil {
+		if info, err := f.Stat(); err == nil && !info.IsDir() {
+			if rs, ok := f.(io.ReadSeeker); ok {
+				return rs, true
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			return bytes.NewReader(data), err == nil
+		}
+		f.Close()
+	}
+	if data, ok := s.gzipped[name]; ok && enc == "gzip" {
+		return bytes.NewReader(data), true
+	}
+	return nil, false
+}
+
+func (s *staticServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	// The file server redirects /index.html to / and lists directories
+	if !compressibleExts[path.Ext(name)] || strings.HasSuffix(r.URL.Path, "/index.html") {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil || info.IsDir() {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	accept := r.Header.Get("Accept-Encoding")
+	for _, enc := range staticEncodings {
+		if !acceptsEncoding(accept, enc.name) {
+			continue
+		}
+		body, ok := s.variant(name, enc.name, enc.ext)
+		if !ok {
+			continue
+		}
+		if c, ok := body.(io.Closer); ok {
+			defer c.Close()
+		}
+		w.Header().Set("Content-Encoding", enc.name)
+		// Content-Type comes from name, not the .br or .gz file
+		http.ServeContent(w, r, name, info.ModTime(), body)
+		return
+	}
+	s.files.ServeHTTP(w, r)
+}
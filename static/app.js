@@ -4,19 +4,163 @@ let refreshInterval = null;
 let isLoading = false;
 let arrivalsData = null;
 let displayMode = 'minutes'; // 'minutes' or 'time'
+let userRules = null; // this profile's notification rules by stop code
 
 // DOM Elements
 const stopsGrid = document.getElementById('stopsGrid');
 const lastUpdatedEl = document.getElementById('lastUpdated');
+const weatherEl = document.getElementById('weather');
 const toggleBtn = document.getElementById('toggleBtn');
 const toggleText = document.getElementById('toggleText');
 const refreshBtn = document.getElementById('refreshBtn');
+const pushBtn = document.getElementById('pushBtn');
 const errorBanner = document.getElementById('errorBanner');
 const errorText = document.getElementById('errorText');
 
+// Client API key, passed through from the page URL (?key=...)
+const pageParams = new URLSearchParams(window.location.search);
+const apiKey = pageParams.get('key');
+
+// Saved favorites to show, from the page URL (?profile=...&set=...)
+const favoritesQuery = new URLSearchParams();
+for (const name of ['profile', 'set']) {
+    if (pageParams.get(name)) favoritesQuery.set(name, pageParams.get(name));
+}
+
+// Fetch an API endpoint, attaching the client key if one was given
+function apiFetch(path) {
+    if (favoritesQuery.toString()) path += '?' + favoritesQuery;
+    if (!apiKey) return fetch(path);
+    return fetch(path, { headers: { 'X-API-Key': apiKey } });
+}
+
+// Send a JSON body to an API endpoint, attaching the client key
+function apiSend(path, method, body) {
+    const headers = { 'Content-Type': 'application/json' };
+    if (apiKey) headers['X-API-Key'] = apiKey;
+    return fetch(path, { method, headers, body: JSON.stringify(body) });
+}
+
+// Register this display when the page URL names it (?device=...) and
+// return the settings the server keeps for it
+async function registerDevice() {
+    const name = pageParams.get('device');
+    if (!name) return null;
+
+    const path = `api/devices/${encodeURIComponent(name)}`;
+    let response = await apiSend(path, 'POST', {
+        screen: `${window.screen.width}x${window.screen.height}`,
+    });
+    // Registering takes a client key; without one, a display registered
+    // by the admin can still pick up its settings
+    if (response.status === 401) response = await apiFetch(path);
+    if (!response.ok) return null;
+    return response.json();
+}
+
+// Apply a registered device's settings. Returns false when the page is
+// moving to the device's dashboard instead.
+function applyDeviceSettings(device) {
+    const here = window.location.pathname.replace(/\/+$/, '');
+    const want = device.dashboard || '';
+    if (want !== here) {
+        window.location.replace(`${want}/${window.location.search}`);
+        return false;
+    }
+    if (device.theme) document.documentElement.dataset.theme = device.theme;
+    if (device.display_mode) localStorage.setItem('displayMode', device.display_mode);
+    return true;
+}
+
+// Show the notifications button when the server has Web Push enabled and
+// the browser supports it
+async function setupPush() {
+    if (!('serviceWorker' in navigator) || !('PushManager' in window)) return;
+    const response = await apiFetch('api/push/key');
+    if (!response.ok) return;
+    const { public_key: publicKey } = await response.json();
+
+    const registration = await navigator.serviceWorker.register('sw.js');
+    const showState = (subscribed) => {
+        pushBtn.classList.toggle('active', subscribed);
+        pushBtn.title = subscribed ? 'Notifications on (tap to turn off)' : 'Turn on notifications';
+    };
+    showState(Boolean(await registration.pushManager.getSubscription()));
+    pushBtn.hidden = false;
+
+    pushBtn.addEventListener('click', async () => {
+        const existing = await registration.pushManager.getSubscription();
+        if (existing) {
+            await apiSend('api/push/subscriptions', 'DELETE', { endpoint: existing.endpoint });
+            await existing.unsubscribe();
+            showState(false);
+            return;
+        }
+        if (await Notification.requestPermission() !== 'granted') return;
+        const key = Uint8Array.from(atob(publicKey.replace(/-/g, '+').replace(/_/g, '/')), (c) => c.charCodeAt(0));
+        const subscription = await registration.pushManager.subscribe({
+            userVisibleOnly: true,
+            applicationServerKey: key,
+        });
+        const profile = pageParams.get('profile');
+        const path = profile ? `api/push/subscriptions?profile=${encodeURIComponent(profile)}` : 'api/push/subscriptions';
+        const saved = await apiSend(path, 'POST', subscription.toJSON());
+        showState(saved.ok);
+        if (!saved.ok) await subscription.unsubscribe();
+    });
+}
+
+// Load the profile's notification rules, so each direction shows a bell
+// for setting its own "tell me when it's N-M minutes away" alert
+async function setupUserRules() {
+    const profile = pageParams.get('profile');
+    if (!profile) return;
+    const base = `api/rules/${encodeURIComponent(profile)}`;
+    const response = await apiFetch(base);
+    if (!response.ok) return;
+    const { rules } = await response.json();
+    userRules = Object.fromEntries(rules.map((rule) => [rule.stop_id, rule]));
+    renderArrivals();
+
+    stopsGrid.addEventListener('click', async (event) => {
+        const btn = event.target.closest('.rule-btn');
+        if (!btn) return;
+        const stopId = btn.dataset.stopId;
+        const existing = userRules[stopId];
+        const current = existing ? `${existing.min}-${existing.max}` : '';
+        const answer = prompt('Notify me when the next arrival is this many minutes away (e.g. 8-12). Leave empty to turn off.', current);
+        if (answer === null) return;
+
+        if (existing) {
+            await apiSend(`${base}/${existing.id}`, 'DELETE');
+            delete userRules[stopId];
+        }
+        const match = answer.trim().match(/^(\d+)\s*-\s*(\d+)$/);
+        if (match) {
+            const saved = await apiSend(base, 'POST', { stop_id: stopId, min: Number(match[1]), max: Number(match[2]) });
+            if (saved.ok) {
+                const { rules: all } = await saved.json();
+                userRules[stopId] = all[all.length - 1];
+            }
+        }
+        renderArrivals();
+    });
+}
+
+// Bell for a direction's notification rule, when the profile has rules
+function renderRuleButton(dir) {
+    if (!userRules) return '';
+    const rule = userRules[dir.stop_id];
+    const title = rule ? `Notifying at ${rule.min}-${rule.max} min` : 'Set a notification';
+    return `<button class="rule-btn ${rule ? 'active' : ''}" data-stop-id="${dir.stop_id}" title="${title}">&#128276;</button>`;
+}
+
 // Initialize
 async function init() {
     try {
+        const device = await registerDevice().catch(() => null);
+        if (device && !applyDeviceSettings(device)) return;
+
         // Load display mode from localStorage
         const savedMode = localStorage.getItem('displayMode');
         if (savedMode === 'time') {
@@ -26,7 +170,7 @@ async function init() {
         }
 
         // Load config first
-        const response = await fetch('/api/config');
+        const response = await apiFetch('api/config');
         config = await response.json();
 
         // Render initial skeleton
@@ -41,6 +185,9 @@ async function init() {
         // Set up visibility handling
         setupVisibilityHandler();
 
+        setupPush().catch((error) => console.error('Push setup error:', error));
+        setupUserRules().catch((error) => console.error('Rules setup error:', error));
+
     } catch (error) {
         console.error('Init error:', error);
         showError('Failed to load configuration');
@@ -94,7 +241,7 @@ async function fetchArrivals() {
     refreshBtn.classList.add('loading');
 
     try {
-        const response = await fetch('/api/arrivals');
+        const response = await apiFetch('api/arrivals');
 
         if (!response.ok) {
             throw new Error(`HTTP ${response.status}`);
@@ -117,6 +264,7 @@ async function fetchArrivals() {
 // Format current time in user's local timezone
 function formatLocalTime() {
     return new Date().toLocaleTimeString('en-US', {
+        timeZone: config?.timezone,
         hour: 'numeric',
         minute: '2-digit',
         second: '2-digit',
@@ -128,9 +276,10 @@ function formatLocalTime() {
 function formatArrivalTime(arrivalTime) {
     const date = new Date(arrivalTime);
     return date.toLocaleTimeString('en-US', {
+        timeZone: config?.timezone,
         hour: 'numeric',
         minute: '2-digit',
-        hour12: true
+        hour12: config?.time_format !== '24h'
     });
 }
 
@@ -150,11 +299,25 @@ function renderQualityWarning(qualityWarning, qualityLevel) {
     `;
 }
 
+// Render current weather next to the update time
+function renderWeather(weather) {
+    if (!weather) {
+        weatherEl.hidden = true;
+        return;
+    }
+    let text = `${Math.round(weather.temperature)}${weather.temperature_unit}`;
+    if (weather.description) text += ` ${weather.description}`;
+    if (weather.precipitation_mm > 0) text += ` · ${weather.precipitation_mm} mm`;
+    weatherEl.textContent = text;
+    weatherEl.hidden = false;
+}
+
 // Render arrivals data
 function renderArrivals() {
     if (!arrivalsData) return;
 
     lastUpdatedEl.textContent = formatLocalTime();
+    renderWeather(arrivalsData.weather);
 
     stopsGrid.innerHTML = arrivalsData.stops.map((stop, index) => {
         const isTThird = index === 0 && stop.line.toLowerCase().includes('t third');
@@ -175,9 +338,10 @@ function renderArrivals() {
                     <span class="line-name">${stop.line}</span>
                 </div>
             </div>
+            ${(stop.advisories || []).map(a => `<div class="advisory">${a}</div>`).join('')}
             ${stop.directions.map(dir => `
                 <div class="direction">
-                    <div class="direction-label">${dir.label}</div>
+                    <div class="direction-label">${dir.label}${dir.approx_headway_minutes ? `<span class="headway">every ~${dir.approx_headway_minutes} min</span>` : ''}${dir.wheelchair_boarding === false ? `<span class="access-note">No step-free boarding</span>` : ''}${renderRuleButton(dir)}</div>
                     <div class="arrivals">
                         ${renderDirectionArrivals(dir)}
                     </div>
@@ -185,10 +349,40 @@ function renderArrivals() {
             `).join('')}
             ${dripImg}
         </div>
-    `}).join('');
+    `}).join('') + renderBikeshare(arrivalsData.bikeshare);
 
 }
 
+// Render configured bikeshare stations as one extra card
+function renderBikeshare(stations) {
+    if (!stations || stations.length === 0) return '';
+
+    return `
+        <div class="stop-card bikeshare-card">
+            <div class="stop-header">
+                <div class="line-badge default">B</div>
+                <div class="stop-info">
+                    <h2>Bay Wheels</h2>
+                </div>
+            </div>
+            ${stations.map(station => `
+                <div class="direction">
+                    <div class="direction-label">${station.name}</div>
+                    <div class="arrivals">
+                        ${station.error
+                            ? `<span class="error-message">${station.error}</span>`
+                            : !station.renting
+                                ? `<span class="no-arrivals">Not renting</span>`
+                                : `<span class="bike-count">${station.bikes_available} bikes</span>
+                                   <span class="bike-count">${station.ebikes_available} e-bikes</span>
+                                   <span class="bike-count">${station.docks_available} docks</span>`}
+                    </div>
+                </div>
+            `).join('')}
+        </div>
+    `;
+}
+
 // Get short train type label
 function getTrainTypeLabel(lineType) {
     if (!lineType) return '';
@@ -222,29 +416,43 @@ function renderDirectionArrivals(direction) {
         : '';
 
     if (!direction.arrivals || direction.arrivals.length === 0) {
+        if (direction.service_ended) {
+            const resumes = direction.service_resumes
+                ? `Service resumes ${formatArrivalTime(direction.service_resumes)}`
+                : 'Service has ended';
+            return `<span class="no-arrivals">${resumes}</span>`;
+        }
         return qualityWarning || `<span class="no-arrivals">No upcoming vehicles</span>`;
     }
 
     const arrivalPills = direction.arrivals.map(arrival => {
-        const isNow = arrival.minutes <= 0;
+        const isDeparting = arrival.status === 'departing';
+        const isDeparted = arrival.status === 'departed';
+        const isNow = arrival.status ? arrival.status !== 'upcoming' : arrival.minutes <= 0;
         const isImminent = arrival.minutes <= 5 && arrival.minutes > 0;
         const trainType = getTrainTypeLabel(arrival.line_type);
         const trainClass = getTrainTypeClass(arrival.line_type);
+        const scheduledClass = arrival.realtime === false ? 'scheduled' : '';
+        const shortTurnClass = arrival.short_turn ? 'short-turn' : '';
+        const title = arrival.short_turn
+            ? `Short turn: only goes to ${arrival.destination}`
+            : scheduledClass ? 'Scheduled time, no live vehicle data' : '';
 
         let displayValue, displayLabel;
         if (displayMode === 'time') {
-            displayValue = formatArrivalTime(arrival.arrival_time);
+            displayValue = arrival.display_time || formatArrivalTime(arrival.arrival_time);
             displayLabel = '';
         } else {
-            displayValue = isNow ? 'Now' : arrival.minutes;
+            displayValue = isNow ? (arrival.status_text || (isDeparted ? 'Departed' : isDeparting ? 'Departing' : 'Due')) : arrival.minutes;
             displayLabel = isNow ? '' : '<span class="minutes-label">min</span>';
         }
 
         return `
-            <div class="arrival-pill ${isNow ? 'now' : ''} ${isImminent ? 'imminent' : ''} ${trainClass}">
+            <div class="arrival-pill ${isNow ? 'now' : ''} ${isDeparted ? 'departed' : ''} ${isImminent ? 'imminent' : ''} ${trainClass} ${scheduledClass} ${shortTurnClass}"${title ? ` title="${title}"` : ''}>
                 ${trainType ? `<span class="train-type">${trainType}</span>` : ''}
                 <span class="minutes">${displayValue}</span>
                 ${displayLabel}
+                ${renderAccessIcons(arrival)}
             </div>
         `;
     }).join('');
@@ -252,6 +460,16 @@ function renderDirectionArrivals(direction) {
     return qualityWarning + arrivalPills;
 }
 
+// Small icons for vehicle accessibility; unknown flags show nothing
+function renderAccessIcons(arrival) {
+    let icons = '';
+    if (arrival.wheelchair_accessible === true) icons += '<span class="access-icon" title="Wheelchair accessible">♿</span>';
+    if (arrival.wheelchair_accessible === false) icons += '<span class="access-icon no" title="Not wheelchair accessible">♿</span>';
+    if (arrival.bikes_allowed === true) icons += '<span class="access-icon" title="Bikes allowed">🚲</span>';
+    if (arrival.bikes_allowed === false) icons += '<span class="access-icon no" title="No bikes">🚲</span>';
+    return icons;
+}
+
 // Get line badge CSS class
 function getLineBadgeClass(line) {
     const l = line.toLowerCase();
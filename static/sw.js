@@ -0,0 +1,29 @@
+// Service worker for Web Push notifications from the tracker
+
+self.addEventListener('push', (event) => {
+    let message = { title: 'Muni Tracker', body: '' };
+    try {
+        message = event.data.json();
+    } catch (e) {
+        if (event.data) message.body = event.data.text();
+    }
+    event.waitUntil(self.registration.showNotification(message.title, {
+        body: message.body,
+        tag: message.tag,
+        renotify: Boolean(message.tag),
+        icon: 'muni-logo.svg',
+        data: { url: message.url || './' },
+    }));
+});
+
+// Focus an open tracker tab, or open one, when a notification is tapped
+self.addEventListener('notificationclick', (event) => {
+    event.notification.close();
+    event.waitUntil((async () => {
+        const windows = await clients.matchAll({ type: 'window', includeUncontrolled: true });
+        for (const w of windows) {
+            if ('focus' in w) return w.focus();
+        }
+        return clients.openWindow(event.notification.data.url);
+    })());
+});
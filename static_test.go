@@ -0,0 +1,66 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStaticCompression(t *testing.T) {
+	js := strings.Repeat("console.log('muni');\n", 200)
+	fsys := fstest.MapFS{
+		"index.html":   {Data: []byte("<html>" + strings.Repeat("<p>muni</p>", 100) + "</html>")},
+		"app.js":       {Data: []byte(js)},
+		"app.js.br":    {Data: []byte("brotli bytes")},
+		"style.css":    {Data: []byte("body{}")},
+		"drip1.png":    {Data: []byte("png")},
+		"drip1.png.gz": {Data: []byte("not used")},
+	}
+	get := func(h http.Handler, path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	plain := staticHandler(fsys, false)
+	rec := get(plain, "/app.js", "gzip, br")
+	if rec.Header().Get("Content-Encoding") != "br" || rec.Body.String() != "brotli bytes" ||
+		!strings.HasPrefix(rec.Header().Get("Content-Type"), "text/javascript") || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("br: %v %q", rec.Header(), rec.Body)
+	}
+	if rec := get(plain, "/app.js", "gzip, br;q=0"); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != js {
+		t.Errorf("br refused: %v", rec.Header())
+	}
+	if rec := get(plain, "/drip1.png", "gzip"); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "png" {
+		t.Errorf("png: %v", rec.Header())
+	}
+
+	// static_compress gzips the rest at startup
+	compressed := staticHandler(fsys, true)
+	rec = get(compressed, "/", "gzip")
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil || rec.Header().Get("Content-Encoding") != "gzip" || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("gzip index: %v %v", err, rec.Header())
+	}
+	if body, _ := io.ReadAll(zr); !strings.HasPrefix(string(body), "<html><p>muni") {
+		t.Errorf("gunzipped index = %q", body)
+	}
+	// Too small to shrink
+	if rec := get(compressed, "/style.css", "gzip"); rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("style.css: %v", rec.Header())
+	}
+	if rec := get(compressed, "/index.html", "gzip"); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("/index.html = %d", rec.Code)
+	}
+	if rec := get(compressed, "/missing.js", "gzip"); rec.Code != http.StatusNotFound {
+		t.Errorf("/missing.js = %d", rec.Code)
+	}
+}
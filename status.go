@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// DirectionStatus is one direction's fetch state, for /api/status
+type DirectionStatus struct {
+	// Dashboard path, empty for the main board
+	Dashboard           string     `json:"dashboard,omitempty"`
+	Stop                string     `json:"stop"`
+	Direction           string     `json:"direction"`
+	StopID              string     `json:"stop_id"`
+	LastFetch           *time.Time `json:"last_fetch"`
+	LastSuccess         *time.Time `json:"last_success"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Arrivals            int        `json:"arrivals"`
+	// When the scheduler will next fetch it; main board only
+	NextFetch *time.Time `json:"next_fetch,omitempty"`
+}
+
+// SchedulerStatus describes the main board's background refresher
+type SchedulerStatus struct {
+	Provider        string `json:"provider"`
+	IntervalSeconds int    `json:"interval_seconds"`
+	// A refresh is in progress
+	Refreshing   bool       `json:"refreshing"`
+	LastProgress *time.Time `json:"last_progress"`
+	LastRefresh  *time.Time `json:"last_refresh"`
+	NextRefresh  *time.Time `json:"next_refresh"`
+	// One queue per agency on the main board
+	Queues []QueueStatus `json:"queues"`
+}
+
+// StatusResponse is everything worth checking when the board looks wrong
+type StatusResponse struct {
+	Time           time.Time       `json:"time"`
+	Health         string          `json:"health"`
+	Scheduler      SchedulerStatus `json:"scheduler"`
+	QuotaLimit     int             `json:"quota_limit"`
+	QuotaRemaining int             `json:"quota_remaining"`
+	// Set when instances share a cache
+	Cluster    *ClusterStatus    `json:"cluster,omitempty"`
+	Directions []DirectionStatus `json:"directions"`
+}
+
+// directionStatuses lists a cached board's directions
+func directionStatuses(dashboard string, data ArrivalsResponse) []DirectionStatus {
+	directionHealth.Lock()
+	defer directionHealth.Unlock()
+
+	var out []DirectionStatus
+	for i, stop := range data.Stops {
+		for j, dir := range stop.Directions {
+			status := DirectionStatus{
+				Dashboard:   dashboard,
+				Stop:        stop.Name,
+				Direction:   dir.Label,
+				StopID:      dir.StopID,
+				LastFetch:   optionalTime(dir.FetchedAt),
+				LastSuccess: optionalTime(dir.UpdatedAt),
+				LastError:   dir.FetchError,
+				Arrivals:    len(dir.Arrivals),
+			}
+			if h := directionHealth.byKey[stop.Name+"\x00"+dir.StopID]; h != nil {
+				status.ConsecutiveFailures = h.ConsecutiveFailures
+			}
+			if dashboard == "" {
+				status.NextFetch = optionalTime(sched.directionDue(directionRef{i, j}, dir.StopID))
+			}
+			out = append(out, status)
+		}
+	}
+	return out
+}
+
+func currentStatus() StatusResponse {
+	cfg := currentConfig()
+	now := clock.Now()
+
+	refresher.mu.Lock()
+	scheduler := SchedulerStatus{
+		Provider:        cfg.Provider,
+		IntervalSeconds: int(refresher.interval.Seconds()),
+		Refreshing:      refresher.busy,
+		LastProgress:    optionalTime(refresher.lastProgress),
+		LastRefresh:     optionalTime(refresher.lastComplete),
+	}
+	refresher.mu.Unlock()
+	scheduler.NextRefresh = optionalTime(nextRefresh())
+	scheduler.Queues = sched.queueStatuses(now)
+
+	cache.mu.RLock()
+	data := cache.data
+	cache.mu.RUnlock()
+	directions := directionStatuses("", data)
+
+	dashboardCache.Lock()
+	paths := make([]string, 0, len(dashboardCache.byPath))
+	for path := range dashboardCache.byPath {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	boards := make([]ArrivalsResponse, len(paths))
+	for i, path := range paths {
+		boards[i] = dashboardCache.byPath[path]
+	}
+	dashboardCache.Unlock()
+	for i, path := range paths {
+		directions = append(directions, directionStatuses(path, boards[i])...)
+	}
+	if directions == nil {
+		directions = []DirectionStatus{}
+	}
+
+	return StatusResponse{
+		Time:           now,
+		Health:         currentHealth().Status,
+		Scheduler:      scheduler,
+		QuotaLimit:     cfg.UpstreamHourlyLimit,
+		QuotaRemaining: quota.remaining(now),
+		Cluster:        clusterStatus(),
+		Directions:     directions,
+	}
+}
+
+// handleStatus serves /api/status
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentStatus())
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	start := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	fc, ft := withTestEnv(t, start, `{"ServiceDelivery":{"StopMonitoringDelivery":{"MonitoredStopVisit":[]}}}`)
+	cfg, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: Status Street
+    line: N Judah
+    directions:
+      - {label: Inbound, stop_id: "70001"}
+      - {label: Outbound, stop_id: "70002"}
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	check := func() StatusResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handleStatus(rec, httptest.NewRequest("GET", "/api/status", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d %s", rec.Code, rec.Body)
+		}
+		var s StatusResponse
+		json.NewDecoder(rec.Body).Decode(&s)
+		return s
+	}
+
+	refreshCache()
+	s := check()
+	if s.Scheduler.Provider != "511" || s.QuotaLimit != 60 || s.QuotaRemaining != quota.remaining(s.Time) || len(s.Directions) != 2 {
+		t.Fatalf("status = %+v", s)
+	}
+	in := s.Directions[0]
+	if in.Stop != "Status Street" || in.StopID != "70001" || in.LastFetch == nil || in.LastSuccess == nil || in.LastError != "" {
+		t.Errorf("inbound = %+v", in)
+	}
+
+	// A failed fetch keeps the last success and shows the error
+	fc.now = start.Add(time.Minute)
+	ft.body = "down"
+	refreshCache()
+	out := check().Directions[1]
+	if out.LastError == "" || out.ConsecutiveFailures != 1 || out.LastSuccess == nil || !out.LastSuccess.Equal(start.Add(upstreamDelay)) {
+		t.Errorf("outbound = %+v", out)
+	}
+
+	rec := httptest.NewRecorder()
+	handleStatus(rec, httptest.NewRequest("POST", "/api/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST = %d", rec.Code)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// checkStopCodes cross-checks every configured agency and stop code
+// against 511's operator list and the agency's GTFS stops, returning a
+// warning for each one that doesn't exist. A typo otherwise shows up only
+// as a direction that never has arrivals.
+func checkStopCodes(cfg *Config) []string {
+	// Onestop IDs aren't in any 511 or GTFS stop list
+	if cfg.Provider == "transitland" {
+		return nil
+	}
+	var warnings []string
+
+	// The operator list is only available from 511; skip the agency check
+	// when it can't be had
+	var agencies []AgencyInfo
+	if cfg.Provider == "511" {
+		var err error
+		if agencies, err = agencyList(); err != nil {
+			debugf("Stop check: agency list unavailable: %v", err)
+		}
+	}
+
+	checked := make(map[string]bool)
+	for _, stop := range allStops(cfg) {
+		agency := stop.Agency
+		if agency == "" {
+			agency = "SF"
+		}
+
+		if len(agencies) > 0 && !knownAgency(agencies, agency) {
+			if !checked["agency:"+agency] {
+				warnings = append(warnings, fmt.Sprintf("agency %s (stop %q) is not a 511 operator%s", agency, stop.Name, suggestAgency(agencies, agency)))
+			}
+			checked["agency:"+agency] = true
+			continue
+		}
+
+		idx, err := agencyIndex(agency)
+		if err != nil {
+			if !checked["index:"+agency] {
+				debugf("Stop check: no stop list for %s: %v", agency, err)
+			}
+			checked["index:"+agency] = true
+			continue
+		}
+
+		for _, dir := range stop.Directions {
+			for _, code := range dir.allStopIDs() {
+				if checked[agency+":"+code] {
+					continue
+				}
+				checked[agency+":"+code] = true
+
+				if idx.findStop(code) != nil || idx.findStopID(code) != nil {
+					continue
+				}
+				msg := fmt.Sprintf("stop %s not found for agency %s", code, agency)
+				if s := closestStop(idx, code); s != nil {
+					msg += fmt.Sprintf("; did you mean %s %s?", s.Code, s.Name)
+				}
+				warnings = append(warnings, msg)
+			}
+		}
+	}
+	return warnings
+}
+
+// logStopCodeCheck runs checkStopCodes and logs what it finds
+func logStopCodeCheck(cfg *Config) {
+	warnings := checkStopCodes(cfg)
+	for _, w := range warnings {
+		warnf("Config: %s", w)
+	}
+	if len(warnings) == 0 {
+		debugf("Stop check: every configured stop code was found")
+	}
+}
+
+func knownAgency(agencies []AgencyInfo, code string) bool {
+	for _, a := range agencies {
+		if a.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestAgency names the operator whose code or name best matches code
+func suggestAgency(agencies []AgencyInfo, code string) string {
+	best, bestDist := AgencyInfo{}, 3
+	for _, a := range agencies {
+		if strings.EqualFold(a.Code, code) || strings.EqualFold(a.ShortName, code) {
+			return fmt.Sprintf("; did you mean %s (%s)?", a.Code, a.Name)
+		}
+		if d := editDistance(strings.ToUpper(code), a.Code); d < bestDist {
+			best, bestDist = a, d
+		}
+	}
+	if best.Code == "" {
+		return ""
+	}
+	return fmt.Sprintf("; did you mean %s (%s)?", best.Code, best.Name)
+}
+
+// closestStop returns the stop whose code is within two edits of code,
+// preferring fewer edits and then the numerically nearest code
+func closestStop(idx *gtfsIndex, code string) *gtfsStop {
+	var best *gtfsStop
+	bestDist, bestGap := 3, 0
+	n, _ := strconv.Atoi(code)
+	for i := range idx.Stops {
+		s := &idx.Stops[i]
+		if s.Code == "" {
+			continue
+		}
+		d := editDistance(code, s.Code)
+		m, _ := strconv.Atoi(s.Code)
+		gap := max(n-m, m-n)
+		if d < bestDist || (d == bestDist && best != nil && gap < bestGap) {
+			best, bestDist, bestGap = s, d, gap
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
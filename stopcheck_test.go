@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckStopCodes(t *testing.T) {
+	now := time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC)
+	withTestEnv(t, now, "")
+
+	gtfsIndexes.Lock()
+	gtfsIndexes.byAgency["SF"] = &gtfsIndex{
+		Agency: "SF",
+		Stops: []gtfsStop{
+			{ID: "4446", Code: "14446", Name: "Church & Duboce"},
+			{ID: "4449", Code: "14449", Name: "Church & 14th"},
+			{ID: "6994", Code: "16994", Name: "Embarcadero"},
+		},
+		LoadedAt: now,
+	}
+	gtfsIndexes.Unlock()
+	datasets.Lock()
+	datasets.entries["agencies"] = datasetEntry{
+		value:     []AgencyInfo{{Code: "SF", Name: "San Francisco Muni"}, {Code: "BA", Name: "BART"}},
+		fetchedAt: now,
+	}
+	datasets.Unlock()
+	t.Cleanup(func() {
+		gtfsIndexes.Lock()
+		delete(gtfsIndexes.byAgency, "SF")
+		gtfsIndexes.Unlock()
+		datasets.Lock()
+		delete(datasets.entries, "agencies")
+		datasets.Unlock()
+	})
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: Church
+    directions:
+      - {label: Inbound, stop_id: "14448"}
+      - {label: Outbound, stop_id: "6994"}
+  - name: Embarcadero
+    agency: XF
+    directions: [{label: Out, stop_id: "16994"}]
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+
+	got := checkStopCodes(cfg)
+	want := []string{
+		"stop 14448 not found for agency SF; did you mean 14449 Church & 14th?",
+		`agency XF (stop "Embarcadero") is not a 511 operator; did you mean SF (San Francisco Muni)?`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("warnings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NearbyStop is a stop near the requested point
+type NearbyStop struct {
+	StopCode  string   `json:"stop_code"`
+	Name      string   `json:"name"`
+	Lat       float64  `json:"lat"`
+	Lon       float64  `json:"lon"`
+	DistanceM int      `json:"distance_m"`
+	Lines     []string `json:"lines"`
+}
+
+// Nearby search bounds, in meters
+const (
+	defaultNearbyRadius = 400
+	maxNearbyRadius     = 2000
+	maxNearbyStops      = 25
+)
+
+// distanceMeters is the great-circle distance between two points
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// nearbyStops returns stops within radius meters, closest first
+func nearbyStops(idx *gtfsIndex, lat, lon, radius float64) []NearbyStop {
+	out := make([]NearbyStop, 0)
+	for _, s := range idx.Stops {
+		d := distanceMeters(lat, lon, s.Lat, s.Lon)
+		if d > radius {
+			continue
+		}
+		out = append(out, NearbyStop{
+			StopCode:  s.Code,
+			Name:      s.Name,
+			Lat:       s.Lat,
+			Lon:       s.Lon,
+			DistanceM: int(math.Round(d)),
+			Lines:     s.Lines,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DistanceM < out[j].DistanceM })
+	if len(out) > maxNearbyStops {
+		out = out[:maxNearbyStops]
+	}
+	return out
+}
+
+// StopMatch is an autocomplete suggestion
+type StopMatch struct {
+	StopCode  string   `json:"stop_code"`
+	Name      string   `json:"name"`
+	Lines     []string `json:"lines"`
+	Headsigns []string `json:"headsigns"`
+}
+
+const (
+	defaultAutocompleteLimit = 10
+	maxAutocompleteLimit     = 25
+)
+
+// matchScore ranks how well a stop matches the query; 0 means no match.
+// Name prefixes beat word prefixes beat substrings, and every query word
+// must appear. A stop code prefix ranks with name prefixes.
+func matchScore(s gtfsStop, query string) int {
+	name := strings.ToLower(s.Name)
+	if strings.HasPrefix(s.Code, query) {
+		return 3
+	}
+	for _, word := range strings.Fields(query) {
+		if !strings.Contains(name, word) {
+			return 0
+		}
+	}
+	switch {
+	case strings.HasPrefix(name, query):
+		return 3
+	case strings.Contains(" "+name, " "+strings.Fields(query)[0]):
+		return 2
+	}
+	return 1
+}
+
+// autocompleteStops returns the best matches for a partial stop name
+func autocompleteStops(idx *gtfsIndex, query string, limit int) []StopMatch {
+	query = strings.ToLower(strings.Join(strings.Fields(query), " "))
+	if query == "" {
+		return []StopMatch{}
+	}
+
+	type scored struct {
+		stop  gtfsStop
+		score int
+	}
+	var matches []scored
+	for _, s := range idx.Stops {
+		if score := matchScore(s, query); score > 0 {
+			matches = append(matches, scored{s, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].stop.Name < matches[j].stop.Name
+	})
+
+	out := make([]StopMatch, 0, min(len(matches), limit))
+	for _, m := range matches[:min(len(matches), limit)] {
+		out = append(out, StopMatch{
+			StopCode:  m.stop.Code,
+			Name:      m.stop.Name,
+			Lines:     m.stop.Lines,
+			Headsigns: m.stop.Headsigns,
+		})
+	}
+	return out
+}
+
+func handleAutocompleteStops(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit := defaultAutocompleteLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxAutocompleteLimit)
+	}
+
+	agency := q.Get("agency")
+	if agency == "" {
+		agency = "SF"
+	}
+
+	idx, err := agencyIndex(agency)
+	if errors.Is(err, errUnknownAgency) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		warnf("Stop index for %s unavailable: %v", agency, err)
+		http.Error(w, "stop data unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	setCacheControl(w, r, datasetMaxAge)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agency": agency,
+		"stops":  autocompleteStops(idx, q.Get("q"), limit),
+	})
+}
+
+func handleNearbyStops(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lat, err1 := strconv.ParseFloat(q.Get("lat"), 64)
+	lon, err2 := strconv.ParseFloat(q.Get("lon"), 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		http.Error(w, "lat and lon are required", http.StatusBadRequest)
+		return
+	}
+
+	radius := float64(defaultNearbyRadius)
+	if v := q.Get("radius"); v != "" {
+		radius, err1 = strconv.ParseFloat(v, 64)
+		if err1 != nil || radius <= 0 {
+			http.Error(w, "invalid radius", http.StatusBadRequest)
+			return
+		}
+		radius = math.Min(radius, maxNearbyRadius)
+	}
+
+	agency := q.Get("agency")
+	if agency == "" {
+		agency = "SF"
+	}
+
+	idx, err := agencyIndex(agency)
+	if errors.Is(err, errUnknownAgency) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		warnf("Stop index for %s unavailable: %v", agency, err)
+		http.Error(w, "stop data unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	setCacheControl(w, r, datasetMaxAge)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agency": agency,
+		"stops":  nearbyStops(idx, lat, lon, radius),
+	})
+}
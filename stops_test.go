@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNearbyStops(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	idx, err := parseGTFS("SF", gtfsZip(t, testGTFS))
+	if err != nil {
+		t.Fatalf("parseGTFS: %v", err)
+	}
+
+	got := nearbyStops(idx, 37.7850, -122.4078, 2000)
+	if len(got) != 2 {
+		t.Fatalf("got %d stops, want 2: %+v", len(got), got)
+	}
+	if got[0].StopCode != "15731" || got[0].DistanceM > 100 || strings.Join(got[0].Lines, ",") != "F" {
+		t.Errorf("closest stop = %+v", got[0])
+	}
+	if strings.Join(got[1].Lines, ",") != "F,N" {
+		t.Errorf("Embarcadero lines = %v, want F,N", got[1].Lines)
+	}
+
+	if got := nearbyStops(idx, 37.7850, -122.4078, 200); len(got) != 1 {
+		t.Errorf("within 200m got %d stops, want 1", len(got))
+	}
+}
+
+func TestAutocompleteStops(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	idx, err := parseGTFS("SF", gtfsZip(t, testGTFS))
+	if err != nil {
+		t.Fatalf("parseGTFS: %v", err)
+	}
+
+	got := autocompleteStops(idx, "  EMBARC ", 10)
+	if len(got) != 1 || got[0].StopCode != "16994" {
+		t.Fatalf("got %+v", got)
+	}
+	if strings.Join(got[0].Headsigns, ",") != "Fisherman's Wharf,Ocean Beach" {
+		t.Errorf("headsigns = %v", got[0].Headsigns)
+	}
+
+	// Equal word-prefix matches sort by name; every query word must match
+	got = autocompleteStops(idx, "st", 10)
+	if len(got) != 2 || got[0].Name != "Embarcadero Station" {
+		t.Errorf("ranking for %q = %+v", "st", got)
+	}
+	if got := autocompleteStops(idx, "powell judah", 10); len(got) != 0 {
+		t.Errorf("unmatched word still matched: %+v", got)
+	}
+	if got := autocompleteStops(idx, "157", 10); len(got) != 1 || got[0].StopCode != "15731" {
+		t.Errorf("stop code match = %+v", got)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+	"sync"
+)
+
+var errStoreFull = errors.New("store is full")
+
+// jsonStore keeps small keyed records in memory, backed by a JSON file
+// that is rewritten on every change. The file is named on each call so a
+// config edit that moves it takes effect without a restart.
+type jsonStore[T any] struct {
+	mu    sync.Mutex
+	path  string
+	items map[string]T
+}
+
+// open loads the file the first time it is used, or again when the
+// configured file changes. Callers hold s.mu.
+func (s *jsonStore[T]) open(path string) error {
+	if s.items != nil && s.path == path {
+		return nil
+	}
+
+	loaded := make(map[string]T)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	s.path, s.items = path, loaded
+	return nil
+}
+
+func (s *jsonStore[T]) save() error {
+	data, err := json.MarshalIndent(s.items, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+func (s *jsonStore[T]) get(path, key string) (T, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var zero T
+	if err := s.open(path); err != nil {
+		return zero, false, err
+	}
+	v, ok := s.items[key]
+	return v, ok, nil
+}
+
+// list returns every record, ordered by key
+func (s *jsonStore[T]) list(path string) ([]T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(path); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(s.items))
+	for k := range s.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]T, len(keys))
+	for i, k := range keys {
+		out[i] = s.items[k]
+	}
+	return out, nil
+}
+
+// update replaces the record at key with fn's result and saves the file.
+// New keys are refused with errStoreFull once limit records exist (0 for
+// no limit). Nothing changes if fn or the save fails.
+func (s *jsonStore[T]) update(path, key string, limit int, fn func(old T, exists bool) (T, error)) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var zero T
+	if err := s.open(path); err != nil {
+		return zero, err
+	}
+	old, exists := s.items[key]
+	if !exists && limit > 0 && len(s.items) >= limit {
+		return zero, errStoreFull
+	}
+
+	v, err := fn(old, exists)
+	if err != nil {
+		return zero, err
+	}
+	s.items[key] = v
+	if err := s.save(); err != nil {
+		if exists {
+			s.items[key] = old
+		} else {
+			delete(s.items, key)
+		}
+		return zero, err
+	}
+	return v, nil
+}
+
+// edit lets fn change any of the records in place, saving the file once
+// if fn reports a change. Nothing changes if the save fails.
+func (s *jsonStore[T]) edit(path string, fn func(items map[string]T) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(path); err != nil {
+		return err
+	}
+	before := maps.Clone(s.items)
+	if !fn(s.items) {
+		return nil
+	}
+	if err := s.save(); err != nil {
+		s.items = before
+		return err
+	}
+	return nil
+}
+
+// delete removes key, reporting whether it existed
+func (s *jsonStore[T]) delete(path, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(path); err != nil {
+		return false, err
+	}
+	old, ok := s.items[key]
+	if !ok {
+		return false, nil
+	}
+
+	delete(s.items, key)
+	if err := s.save(); err != nil {
+		s.items[key] = old
+		return false, err
+	}
+	return true, nil
+}
+
+// all returns a copy of every record by key
+func (s *jsonStore[T]) all(path string) (map[string]T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.open(path); err != nil {
+		return nil, err
+	}
+	out := make(map[string]T, len(s.items))
+	for k, v := range s.items {
+		out[k] = v
+	}
+	return out, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// MessageTemplate reshapes a notification's title and message with Go
+// templates, e.g. "🚋 N in {{.Minutes}}m → {{.Destination}}". Templates
+// see the Notification's fields, including the matched Arrival and the
+// direction's quality fields. An empty template keeps the built-in text.
+type MessageTemplate struct {
+	Title   string `yaml:"title,omitempty"`
+	Message string `yaml:"message,omitempty"`
+
+	title, message *template.Template
+}
+
+// sampleNotification is rendered when templates are loaded, so a
+// misspelled field fails at startup rather than on the first alert
+var sampleNotification = Notification{
+	Kind:    "threshold",
+	Minutes: new(int),
+}
+
+func (m *MessageTemplate) compile(where string) error {
+	var err error
+	if m.title, err = parseMessageTemplate(where+".title", m.Title); err != nil {
+		return err
+	}
+	m.message, err = parseMessageTemplate(where+".message", m.Message)
+	return err
+}
+
+func parseMessageTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := t.Execute(new(strings.Builder), sampleNotification); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return t, nil
+}
+
+// or fills in the parts of m left empty from fallback
+func (m MessageTemplate) or(fallback MessageTemplate) MessageTemplate {
+	if m.title == nil {
+		m.Title, m.title = fallback.Title, fallback.title
+	}
+	if m.message == nil {
+		m.Message, m.message = fallback.Message, fallback.message
+	}
+	return m
+}
+
+// apply renders n's title and message, keeping the built-in text when a
+// template fails, such as on a field a kind of notification doesn't set
+func (m MessageTemplate) apply(n Notification) Notification {
+	render := func(t *template.Template, text *string) {
+		if t == nil {
+			return
+		}
+		var out strings.Builder
+		if err := t.Execute(&out, n); err != nil {
+			warnf("Notification template %s: %v", t.Name(), err)
+			return
+		}
+		*text = out.String()
+	}
+	title, message := n.Title, n.Message
+	render(m.title, &title)
+	render(m.message, &message)
+	n.Title, n.Message = title, message
+	return n
+}
+
+// templatedNotifier applies a channel's templates before sending
+type templatedNotifier struct {
+	notifier
+	tmpl MessageTemplate
+}
+
+func (t templatedNotifier) send(ctx context.Context, n Notification) error {
+	return t.notifier.send(ctx, t.tmpl.apply(n))
+}
+
+// compileTemplates loads the shared template and each channel's own
+func compileTemplates(n *NotificationsConfig) error {
+	if err := n.Template.compile("notifications.template"); err != nil {
+		return err
+	}
+	for i := range n.Webhooks {
+		if err := n.Webhooks[i].Template.compile(fmt.Sprintf("notifications.webhooks[%d].template", i)); err != nil {
+			return err
+		}
+	}
+	for i := range n.Discord {
+		if err := n.Discord[i].Template.compile(fmt.Sprintf("notifications.discord[%d].template", i)); err != nil {
+			return err
+		}
+	}
+	if err := n.Apprise.Template.compile("notifications.apprise.template"); err != nil {
+		return err
+	}
+	if err := n.WebPush.Template.compile("notifications.web_push.template"); err != nil {
+		return err
+	}
+	return n.Email.Template.compile("notifications.email.template")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationTemplates(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Now(), "")
+	rt := &recordTransport{}
+	upstreamTransport = rt
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+notifications:
+  template:
+    title: "🚋 {{.Line}} in {{.Minutes}}m → {{.Destination}}"
+  webhooks:
+    - url: https://hooks.example.com/plain
+    - url: https://hooks.example.com/own
+      template:
+        message: "{{.Arrival.DisplayTime}} ({{.QualityLevel}}){{if .Arrival.Realtime}} live{{end}}"
+stops:
+  - name: A
+    line: N
+    directions:
+      - {label: B, stop_id: "1", notify_when: {max: 12}}
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	for _, bad := range []string{`{{.Minutes`, `{{.Minuets}}`} {
+		_, err := parseConfig([]byte("api_key: test\nnotifications:\n  webhooks: [{url: https://x.example.com, template: {title: \"" + bad + "\"}}]\n" + testStop))
+		if err == nil || !strings.Contains(err.Error(), "notifications.webhooks[0].template.title") {
+			t.Errorf("template %q: err = %v", bad, err)
+		}
+	}
+
+	now := time.Now()
+	fc.now = now
+	resp := ArrivalsResponse{Stops: []StopArrivals{{Name: "A", Line: "N", Directions: []DirectionArrivals{
+		{Label: "B", StopID: "1", Arrivals: []Arrival{{ArrivalTime: now.Add(10*time.Minute + 30*time.Second).Format(time.RFC3339), Destination: "Ocean Beach"}}, FetchedAt: now},
+	}}}}
+	cache.store(resp, now)
+	if len(rt.bodies) != 2 {
+		t.Fatalf("sent %d notifications", len(rt.bodies))
+	}
+	var plain, own Notification
+	json.Unmarshal([]byte(rt.bodies[0]), &plain)
+	json.Unmarshal([]byte(rt.bodies[1]), &own)
+	if want := "🚋 N in 10m → Ocean Beach"; plain.Title != want || own.Title != want {
+		t.Errorf("titles = %q, %q", plain.Title, own.Title)
+	}
+	if !strings.HasPrefix(plain.Message, "N to Ocean Beach arrives at") {
+		t.Errorf("default message = %q", plain.Message)
+	}
+	if want := displayTime(now.Add(10*time.Minute+30*time.Second)) + " (" + plain.QualityLevel + ")"; own.Message != want || plain.QualityLevel == "" {
+		t.Errorf("own message = %q, want %q", own.Message, want)
+	}
+
+	// A template that can't render for some notification keeps the text
+	cfg.Notifications.Template = MessageTemplate{}
+	cfg.Notifications.Webhooks = cfg.Notifications.Webhooks[:1]
+	cfg.Notifications.Webhooks[0].Template = MessageTemplate{Title: "{{if .Stop}}{{index .Stop 99}}{{end}}"}
+	if err := compileTemplates(&cfg.Notifications); err != nil {
+		t.Fatal(err)
+	}
+	notify(context.Background(), Notification{Kind: "alarm", Title: "Leave now", Stop: "A"})
+	if !strings.Contains(rt.bodies[len(rt.bodies)-1], `"title":"Leave now"`) {
+		t.Errorf("fallback = %s", rt.bodies[len(rt.bodies)-1])
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NotifyRule sends a notification when a direction's next vehicle is
+// between min and max minutes away, e.g. "tell me when the N is 8-12
+// minutes out on weekday mornings"
+type NotifyRule struct {
+	// Minutes until arrival, inclusive
+	Min int `yaml:"min,omitempty" json:"min"`
+	Max int `yaml:"max" json:"max"`
+	// weekday, saturday, sunday, or day names like Mon-Fri (default:
+	// every day)
+	Days []string `yaml:"days,omitempty" json:"days,omitempty"`
+	// Local time range such as 07:30-09:30 (default: all day)
+	Hours string `yaml:"hours,omitempty" json:"hours,omitempty"`
+	// Minutes after a notification before the rule may send another
+	// (default 15), so a prediction flapping in and out of range doesn't
+	// send a burst
+	Cooldown int `yaml:"cooldown,omitempty" json:"cooldown"`
+	// Rules sharing a key share the cool-down, e.g. both platforms of a
+	// station (default: one key per direction)
+	DedupeKey string `yaml:"dedupe_key,omitempty" json:"dedupe_key,omitempty"`
+
+	from, to time.Duration
+}
+
+func (r NotifyRule) enabled() bool {
+	return r.Max > 0
+}
+
+func (r *NotifyRule) validate() error {
+	if !r.enabled() {
+		return nil
+	}
+	if r.Min < 0 || r.Min > r.Max {
+		return fmt.Errorf("notify_when: min must be between 0 and max")
+	}
+	if err := validateDays(r.Days); err != nil {
+		return fmt.Errorf("notify_when: %w", err)
+	}
+	if r.Cooldown < 0 {
+		return fmt.Errorf("notify_when: cooldown cannot be negative")
+	}
+	if r.Cooldown == 0 {
+		r.Cooldown = 15
+	}
+	if r.Hours != "" {
+		first, last, ok := strings.Cut(r.Hours, "-")
+		from, err1 := time.Parse("15:04", strings.TrimSpace(first))
+		to, err2 := time.Parse("15:04", strings.TrimSpace(last))
+		if !ok || err1 != nil || err2 != nil {
+			return fmt.Errorf("notify_when: invalid hours %q (want HH:MM-HH:MM)", r.Hours)
+		}
+		r.from = time.Duration(from.Hour())*time.Hour + time.Duration(from.Minute())*time.Minute
+		r.to = time.Duration(to.Hour())*time.Hour + time.Duration(to.Minute())*time.Minute
+	}
+	return nil
+}
+
+// activeAt reports whether the rule applies at t. An hours range ending
+// before it starts runs past midnight.
+func (r NotifyRule) activeAt(t time.Time) bool {
+	if !onDays(r.Days, t) {
+		return false
+	}
+	if r.Hours == "" {
+		return true
+	}
+	local := localTime(t)
+	of := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	if r.from <= r.to {
+		return of >= r.from && of <= r.to
+	}
+	return of >= r.from || of <= r.to
+}
+
+// match returns the first arrival inside the rule's minutes range
+func (r NotifyRule) match(arrivals []Arrival) (Arrival, bool) {
+	for _, a := range arrivals {
+		if a.Status == statusDeparted {
+			continue
+		}
+		if a.Minutes >= r.Min && a.Minutes <= r.Max {
+			return a, true
+		}
+	}
+	return Arrival{}, false
+}
+
+// ruleState records which directions' rules matched on the last
+// evaluation. A rule notifies when it starts matching, not on every
+// refresh it stays matched, and then not again until its cool-down ends.
+var ruleState = struct {
+	sync.Mutex
+	matched map[string]bool
+}{matched: make(map[string]bool)}
+
+func init() {
+	events.handle(evaluateNotifyRules)
+}
+
+// evaluateNotifyRules checks a freshly fetched direction's notify_when
+// rule, and the rules users saved through the API for its stop, against
+// its arrivals. Only the instance that fetched notifies, so a shared
+// cache's followers don't repeat the leader's notifications.
+func evaluateNotifyRules(e Event) {
+	if e.Kind != eventDirectionFetched || !leading() {
+		return
+	}
+	stops := currentConfig().Stops
+	dir, ok := configuredDirection(stops, e.ref.stop, e.ref.dir, e.Direction.StopID)
+	if !ok {
+		return
+	}
+	stop := stops[e.ref.stop]
+	ctx := newTraceContext(serverCtx)
+	if dir.NotifyWhen.enabled() {
+		key := stop.Name + "\x00" + dir.StopID
+		checkNotifyRule(ctx, dir.NotifyWhen, key, "", stop, e.Direction, e.Time)
+	}
+	for token, saved := range savedUserRules() {
+		for _, rule := range saved.Rules {
+			if rule.StopID == dir.StopID {
+				checkNotifyRule(ctx, rule.NotifyRule, "user\x00"+token+"\x00"+rule.ID, token, stop, e.Direction, e.Time)
+			}
+		}
+	}
+}
+
+// checkNotifyRule notifies when rule starts matching served's arrivals.
+// key identifies the rule between refreshes; user, when set, is the
+// profile token whose devices alone are notified.
+func checkNotifyRule(ctx context.Context, rule NotifyRule, key, user string, stop Stop, served DirectionArrivals, now time.Time) {
+	var arrival Arrival
+	matched := false
+	if rule.activeAt(now) {
+		arrival, matched = rule.match(served.Arrivals)
+	}
+
+	ruleState.Lock()
+	was := ruleState.matched[key]
+	ruleState.matched[key] = matched
+	ruleState.Unlock()
+	if !matched || was {
+		return
+	}
+
+	dedupe := key
+	if rule.DedupeKey != "" {
+		dedupe = user + "\x00" + rule.DedupeKey
+	}
+	notify(ctx, Notification{
+		Kind:  "threshold",
+		Title: fmt.Sprintf("%s %s: %d min", stop.Name, served.Label, arrival.Minutes),
+		Message: fmt.Sprintf("%s to %s arrives at %s",
+			stop.Line, arrival.Destination, arrival.DisplayTime),
+		Time:           localTime(now).Format(time.RFC3339),
+		Stop:           stop.Name,
+		Direction:      served.Label,
+		Line:           stop.Line,
+		Destination:    arrival.Destination,
+		Minutes:        &arrival.Minutes,
+		QualityLevel:   served.QualityLevel,
+		QualityWarning: served.QualityWarning,
+		Arrival:        arrival,
+		key:            "notify_when\x00" + dedupe,
+		cooldown:       time.Duration(rule.Cooldown) * time.Minute,
+		user:           user,
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotifyRules(t *testing.T) {
+	fc, ft := withTestEnv(t, time.Now(), "")
+
+	cfg, err := parseConfig([]byte(`
+api_key: test
+notifications:
+  webhooks:
+    - url: https://hooks.example.com/muni
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: "16994"
+        notify_when: {min: 8, max: 12, days: [Mon-Fri], hours: 07:30-09:30, cooldown: 10}
+      - label: Other platform
+        stop_id: "16995"
+        notify_when: {max: 5, dedupe_key: embarcadero}
+      - label: Same platform
+        stop_id: "16996"
+        notify_when: {max: 5, dedupe_key: embarcadero}
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	// A Friday
+	at := func(hour, min int) time.Time {
+		return time.Date(2026, 1, 30, hour, min, 0, 0, cfg.location)
+	}
+	// evaluate stores a refresh with arrivals minutes away on the first
+	// direction, and others on the platforms sharing a dedupe key
+	evaluate := func(now time.Time, minutes []int, others ...int) {
+		fc.now = now
+		direction := func(label, stopID string, minutes []int) DirectionArrivals {
+			times := make([]time.Time, len(minutes))
+			for i, m := range minutes {
+				times[i] = now.Add(time.Duration(m) * time.Minute)
+			}
+			return DirectionArrivals{Label: label, StopID: stopID, Arrivals: arrivalsAt(times...), FetchedAt: now}
+		}
+		resp := ArrivalsResponse{Stops: []StopArrivals{{
+			Name: "Embarcadero",
+			Line: "N Judah",
+			Directions: []DirectionArrivals{
+				direction("Ocean Beach", "16994", minutes),
+				direction("Other platform", "16995", others),
+				direction("Same platform", "16996", others),
+			},
+		}}}
+		cache.store(resp, now)
+	}
+	in := func(minutes ...int) []int { return minutes }
+
+	evaluate(at(8, 0), in(3, 15))
+	if ft.requests != 0 {
+		t.Fatal("notified with nothing in range")
+	}
+	evaluate(at(8, 4), in(11))
+	evaluate(at(8, 8), in(7, 9))
+	evaluate(at(8, 12), in(8))
+	if ft.requests != 1 {
+		t.Fatalf("notified %d times while the rule stayed matched, want 1", ft.requests)
+	}
+
+	// A prediction flapping in and out of range is held by the cool-down
+	evaluate(at(8, 13), in(7))
+	evaluate(at(8, 13), in(8))
+	if ft.requests != 1 {
+		t.Fatalf("notified %d times within the cool-down, want 1", ft.requests)
+	}
+	evaluate(at(8, 15), in(2, 20))
+	evaluate(at(8, 16), in(10))
+	if ft.requests != 2 {
+		t.Errorf("notified %d times, want a second notification after the cool-down", ft.requests)
+	}
+
+	// Outside the hours and on weekends nothing is sent
+	evaluate(at(8, 20), in(2))
+	evaluate(at(10, 0), in(10))
+	evaluate(at(8, 0).AddDate(0, 0, 1), in(10))
+	if ft.requests != 2 {
+		t.Errorf("notified %d times, want no more outside the rule's hours and days", ft.requests)
+	}
+
+	// Rules sharing a dedupe key send one notification between them
+	evaluate(at(12, 0), nil, 3)
+	if ft.requests != 3 {
+		t.Errorf("notified %d times, want one more for the shared dedupe key", ft.requests)
+	}
+
+	late := NotifyRule{Max: 5, Hours: "23:00-01:00"}
+	if err := late.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !late.activeAt(at(0, 30)) || late.activeAt(at(12, 0)) {
+		t.Error("hours past midnight not handled")
+	}
+
+	for _, bad := range []string{"{min: 5, max: 2}", "{max: 5, hours: 9-10}", "{max: 5, days: [Mon-Funday]}"} {
+		doc := "api_key: test\nstops: [{name: A, line: N, directions: [{label: B, stop_id: \"1\", notify_when: " + bad + "}]}]\n"
+		if _, err := parseConfig([]byte(doc)); err == nil {
+			t.Errorf("notify_when %s should be rejected", bad)
+		}
+	}
+}
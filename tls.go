@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS configuration. Either a static cert/key pair or autocert can be used.
+type AutocertConfig struct {
+	Domains  []string `yaml:"domains,omitempty"`
+	Email    string   `yaml:"email,omitempty"`
+	CacheDir string   `yaml:"cache_dir,omitempty"`
+}
+
+type TLSConfig struct {
+	CertFile string         `yaml:"cert_file,omitempty"`
+	KeyFile  string         `yaml:"key_file,omitempty"`
+	Autocert AutocertConfig `yaml:"autocert,omitempty"`
+	// Plain HTTP listener used for redirects and ACME HTTP-01 challenges.
+	// Set to -1 to disable it.
+	HTTPPort int `yaml:"http_port,omitempty"`
+}
+
+func (t TLSConfig) enabled() bool {
+	return t.CertFile != "" || len(t.Autocert.Domains) > 0
+}
+
+func validateTLSConfig(t *TLSConfig) error {
+	// Checked first: a lone key_file doesn't enable TLS, and would
+	// otherwise be ignored
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+	}
+
+	if !t.enabled() {
+		return nil
+	}
+
+	if t.CertFile != "" && len(t.Autocert.Domains) > 0 {
+		return fmt.Errorf("tls.cert_file and tls.autocert cannot both be configured")
+	}
+
+	if len(t.Autocert.Domains) > 0 && t.Autocert.CacheDir == "" {
+		t.Autocert.CacheDir = "autocert-cache"
+	}
+
+	if t.HTTPPort == 0 {
+		t.HTTPPort = 80
+	}
+
+	return nil
+}
+
+// listenRedirect opens the plain HTTP listener for serveTLS, or returns
+// nil when it is disabled or can't be opened
+func listenRedirect() net.Listener {
+	t := currentConfig().TLS
+	if !t.enabled() || t.HTTPPort <= 0 {
+		return nil
+	}
+	httpAddr := fmt.Sprintf(":%d", t.HTTPPort)
+	ln, err := handoff.listener("redirect", func() (net.Listener, error) {
+		return net.Listen("tcp", httpAddr)
+	})
+	if err != nil {
+		errorf("HTTP redirect listener failed: %v", err)
+		return nil
+	}
+	return ln
+}
+
+// serveTLS starts the HTTPS server and, given a redirectLn from
+// listenRedirect, a plain HTTP server on it that redirects to HTTPS and
+// answers ACME HTTP-01 challenges.
+func serveTLS(ctx context.Context, ln, redirectLn net.Listener, handler http.Handler) error {
+	t := currentConfig().TLS
+
+	server := &http.Server{Handler: handler}
+
+	var redirect http.Handler = http.HandlerFunc(redirectToHTTPS)
+
+	if len(t.Autocert.Domains) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(t.Autocert.Domains...),
+			Cache:      autocert.DirCache(t.Autocert.CacheDir),
+			Email:      t.Autocert.Email,
+		}
+		server.TLSConfig = m.TLSConfig()
+		redirect = m.HTTPHandler(redirect)
+		infof("Using Let's Encrypt certificates for %v", t.Autocert.Domains)
+	} else {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if err := configureHTTP2(server); err != nil {
+		return err
+	}
+
+	// The redirect server stops with the HTTPS one, so it doesn't keep
+	// its port after shutdown or a handoff
+	redirectCtx, stopRedirect := context.WithCancel(ctx)
+	redirectDone := make(chan struct{})
+	if redirectLn != nil {
+		redirectServer := &http.Server{Handler: redirect}
+		go func() {
+			defer close(redirectDone)
+			infof("HTTP redirect listener on %s", redirectLn.Addr())
+			if err := serveUntilDone(redirectCtx, redirectServer, redirectLn, redirectServer.Serve); err != nil {
+				errorf("HTTP redirect listener failed: %v", err)
+			}
+		}()
+	} else {
+		close(redirectDone)
+	}
+
+	infof("Server starting on %s", listenURL(ln, "https"))
+	err := serveUntilDone(ctx, server, ln, func(ln net.Listener) error {
+		return server.ServeTLS(ln, t.CertFile, t.KeyFile)
+	})
+	stopRedirect()
+	<-redirectDone
+	return err
+}
+
+// redirectToHTTPS sends plain HTTP requests to the HTTPS listener
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if port := currentConfig().Port; port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
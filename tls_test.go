@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTLSConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		tls  TLSConfig
+		err  string
+	}{
+		{"off", TLSConfig{}, ""},
+		{"cert and key", TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}, ""},
+		{"autocert", TLSConfig{Autocert: AutocertConfig{Domains: []string{"board.example.com"}}}, ""},
+		{"lone cert_file", TLSConfig{CertFile: "cert.pem"}, "set together"},
+		{"lone key_file", TLSConfig{KeyFile: "key.pem"}, "set together"},
+		{"lone key_file with autocert", TLSConfig{KeyFile: "key.pem", Autocert: AutocertConfig{Domains: []string{"board.example.com"}}}, "set together"},
+		{"files and autocert", TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", Autocert: AutocertConfig{Domains: []string{"board.example.com"}}}, "cannot both"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTLSConfig(&tt.tls)
+			if (err == nil) != (tt.err == "") || (err != nil && !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// writeTestCert writes a self-signed certificate for localhost and
+// returns the cert and key paths
+func writeTestCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestServeTLS(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+	certFile, keyFile := writeTestCert(t)
+	cfg := *currentConfig()
+	cfg.Port = 8443
+	cfg.TLS = TLSConfig{CertFile: certFile, KeyFile: keyFile}
+	activeConfig.Store(&cfg)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirectLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	served := make(chan error, 1)
+	go func() { served <- serveTLS(ctx, ln, redirectLn, http.NotFoundHandler()) }()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get("http://localhost:" + strconv.Itoa(redirectLn.Addr().(*net.TCPAddr).Port) + "/api/arrivals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); resp.StatusCode != http.StatusMovedPermanently || loc != "https://localhost:8443/api/arrivals" {
+		t.Errorf("redirect = %d to %q", resp.StatusCode, loc)
+	}
+
+	// Both servers stop with the context and free their ports
+	stop()
+	if err := <-served; err != nil {
+		t.Errorf("serveTLS = %v", err)
+	}
+	for _, l := range []net.Listener{ln, redirectLn} {
+		again, err := net.Listen("tcp", l.Addr().String())
+		if err != nil {
+			t.Errorf("%s still bound: %v", l.Addr(), err)
+			continue
+		}
+		again.Close()
+	}
+}
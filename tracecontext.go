@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// traceContext is a W3C trace context (https://www.w3.org/TR/trace-context/),
+// carried from incoming requests and refresh cycles to upstream calls and
+// logs so they can be correlated with an external tracing system
+type traceContext struct {
+	traceID string // 32 lowercase hex digits
+	spanID  string // 16 lowercase hex digits
+	flags   string // 2 hex digits; 01 is sampled
+	// Vendor-specific tracestate header, passed along untouched
+	state string
+}
+
+type traceKey struct{}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceparent reads a traceparent header. Later versions may add
+// fields after the flags, which are ignored.
+func parseTraceparent(h string) (traceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 {
+		return traceContext{}, false
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return traceContext{}, false
+	}
+	if len(traceID) != 32 || !isLowerHex(traceID) || traceID == strings.Repeat("0", 32) {
+		return traceContext{}, false
+	}
+	if len(spanID) != 16 || !isLowerHex(spanID) || spanID == strings.Repeat("0", 16) {
+		return traceContext{}, false
+	}
+	if len(flags) != 2 || !isLowerHex(flags) {
+		return traceContext{}, false
+	}
+	return traceContext{traceID: traceID, spanID: spanID, flags: flags}, true
+}
+
+// newTrace starts a trace for work no request asked for, like a scheduled
+// refresh
+func newTrace() traceContext {
+	return traceContext{traceID: randomHex(16), spanID: randomHex(8), flags: "00"}
+}
+
+// child is a new span in the same trace
+func (tc traceContext) child() traceContext {
+	tc.spanID = randomHex(8)
+	return tc
+}
+
+// String formats the context as a traceparent header
+func (tc traceContext) String() string {
+	return "00-" + tc.traceID + "-" + tc.spanID + "-" + tc.flags
+}
+
+func withTrace(ctx context.Context, tc traceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, tc)
+}
+
+// traceFrom returns the context's trace, or a zero traceContext
+func traceFrom(ctx context.Context) traceContext {
+	tc, _ := ctx.Value(traceKey{}).(traceContext)
+	return tc
+}
+
+// traceID returns the context's trace ID for log lines, or "-"
+func traceID(ctx context.Context) string {
+	if tc := traceFrom(ctx); tc.traceID != "" {
+		return tc.traceID
+	}
+	return "-"
+}
+
+// newTraceContext starts a fresh trace under parent, for background work
+// that no request asked for
+func newTraceContext(parent context.Context) context.Context {
+	return withTrace(parent, newTrace())
+}
+
+// traceMiddleware continues the caller's trace from its traceparent
+// header, or starts one, and makes it available to handlers
+func traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc, ok := parseTraceparent(r.Header.Get("traceparent"))
+		if ok {
+			tc = tc.child()
+			tc.state = r.Header.Get("tracestate")
+		} else {
+			tc = newTrace()
+		}
+		next.ServeHTTP(w, r.WithContext(withTrace(r.Context(), tc)))
+	})
+}
+
+// tracingTransport adds traceparent and tracestate headers to upstream
+// requests made with a traced context
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	tc := traceFrom(r.Context())
+	if tc.traceID == "" {
+		return t.next.RoundTrip(r)
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("traceparent", tc.child().String())
+	if tc.state != "" {
+		r.Header.Set("tracestate", tc.state)
+	}
+	return t.next.RoundTrip(r)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTraceContext(t *testing.T) {
+	for h, ok := range map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":       true,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra": true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra": false,
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01":       false,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01":       false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01":       false,
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":       false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7":          false,
+		"": false,
+	} {
+		if _, got := parseTraceparent(h); got != ok {
+			t.Errorf("parseTraceparent(%q) ok = %v", h, got)
+		}
+	}
+
+	withTestEnv(t, time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC), "")
+	cfg, err := parseConfig([]byte(`
+api_key: test
+admin: {token: trace-token-123}
+stops:
+  - name: Embarcadero
+    directions: [{label: Ocean Beach, stop_id: "16994"}]
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+	rt := &recordTransport{}
+	upstreamTransport = rt
+
+	// A forced refresh carries the caller's trace to 511
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest("POST", "/api/admin/refresh", nil)
+	req.Header.Set("Authorization", "Bearer trace-token-123")
+	req.Header.Set("traceparent", incoming)
+	req.Header.Set("tracestate", "vendor=abc")
+	rec := httptest.NewRecorder()
+	traceMiddleware(requireAdmin(handleAdminRefresh)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || len(rt.headers) != 1 {
+		t.Fatalf("refresh = %d with %d upstream requests", rec.Code, len(rt.headers))
+	}
+	out, ok := parseTraceparent(rt.headers[0].Get("traceparent"))
+	if !ok || out.traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || out.spanID == "00f067aa0ba902b7" || out.flags != "01" {
+		t.Errorf("upstream traceparent = %q", rt.headers[0].Get("traceparent"))
+	}
+	if got := rt.headers[0].Get("tracestate"); got != "vendor=abc" {
+		t.Errorf("upstream tracestate = %q", got)
+	}
+
+	// Scheduled refreshes start their own trace
+	refreshCache()
+	next, ok := parseTraceparent(rt.headers[1].Get("traceparent"))
+	if !ok || next.traceID == out.traceID {
+		t.Errorf("scheduled traceparent = %q", rt.headers[1].Get("traceparent"))
+	}
+}
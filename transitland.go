@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"muni-tracker/pkg/go511"
+)
+
+const transitlandURL = "https://transit.land/api/v2/rest"
+
+// TransitlandConfig is for the transitland provider, which fetches
+// departures from Transitland's REST API. Transitland aggregates
+// thousands of GTFS and GTFS Realtime feeds, so it covers cities no
+// other provider does. Stops are given by their Onestop IDs.
+type TransitlandConfig struct {
+	APIKey     string `yaml:"api_key,omitempty"`
+	APIKeyFile string `yaml:"api_key_file,omitempty"`
+	// Minutes ahead to ask for departures (default 90)
+	Window int `yaml:"window,omitempty"`
+	// API root, for a self-hosted Transitland server
+	BaseURL string `yaml:"base_url,omitempty"`
+}
+
+func validateTransitlandProvider(config *Config) error {
+	t := &config.Transitland
+	if t.APIKey == "" {
+		return fmt.Errorf("transitland.api_key is required for the transitland provider")
+	}
+	if t.Window < 0 {
+		return fmt.Errorf("transitland.window cannot be negative")
+	}
+	if t.Window == 0 {
+		t.Window = 90
+	}
+	if t.BaseURL == "" {
+		t.BaseURL = transitlandURL
+	}
+	t.BaseURL = strings.TrimSuffix(t.BaseURL, "/")
+	return nil
+}
+
+// maxTransitlandBody bounds one departures response
+const maxTransitlandBody = 4 << 20
+
+// transitlandDepartures is the part of a departures response the board
+// uses. Transitland answers for the stop asked for and, for a parent
+// station, its platforms.
+type transitlandDepartures struct {
+	Stops []struct {
+		Departures []transitlandStopTime `json:"departures"`
+	} `json:"stops"`
+}
+
+type transitlandStopTime struct {
+	// Scheduled, as HH:MM:SS on the service date in the stop's zone; past
+	// midnight it runs over 24:00:00
+	ArrivalTime   string           `json:"arrival_time"`
+	DepartureTime string           `json:"departure_time"`
+	ServiceDate   string           `json:"service_date"`
+	StopHeadsign  string           `json:"stop_headsign"`
+	Arrival       transitlandEvent `json:"arrival"`
+	Departure     transitlandEvent `json:"departure"`
+	Trip          struct {
+		TripID       string `json:"trip_id"`
+		TripHeadsign string `json:"trip_headsign"`
+		// GTFS: 0 unknown, 1 yes, 2 no
+		WheelchairAccessible int `json:"wheelchair_accessible"`
+		BikesAllowed         int `json:"bikes_allowed"`
+		Route                struct {
+			RouteShortName string `json:"route_short_name"`
+			RouteLongName  string `json:"route_long_name"`
+		} `json:"route"`
+	} `json:"trip"`
+}
+
+// transitlandEvent is an arrival or departure at the stop, with the
+// realtime estimate when a feed gives one
+type transitlandEvent struct {
+	StopTimezone string `json:"stop_timezone"`
+	ScheduledUTC string `json:"scheduled_utc"`
+	EstimatedUTC string `json:"estimated_utc"`
+	// HH:MM:SS like arrival_time, from feeds that give no UTC estimate
+	Estimated string `json:"estimated"`
+}
+
+// transitlandStopArrivals fetches a stop's departures. agency isn't
+// used: a Onestop ID names the stop worldwide.
+func transitlandStopArrivals(ctx context.Context, agency, stopID string) ([]Arrival, error) {
+	cfg := currentConfig().Transitland
+	q := url.Values{}
+	q.Set("next", strconv.Itoa(cfg.Window*60))
+	q.Set("limit", "50")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.BaseURL+"/stops/"+url.PathEscape(stopID)+"/departures?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// In a header rather than the query, so the key stays out of logs
+	req.Header.Set("apikey", cfg.APIKey)
+
+	start := time.Now()
+	body, err := transitlandGet(req)
+	took := time.Since(start)
+	debugf("Transitland departures stop=%s took %v trace=%s", stopID, took.Round(time.Millisecond), traceID(ctx))
+	recordUpstreamFetch(agency, stopID, took, err)
+	noteSlowFetch(ctx, agency, stopID, took)
+	keepRawPayload(agency, stopID, body, err)
+	if err != nil {
+		return nil, err
+	}
+
+	arrivals, err := parseTransitlandDepartures(body, localTime(clock.Now()).Location())
+	noteParseResult(agency, stopID, err)
+	if err != nil {
+		upstreamParseFailures.inc(agency)
+		return nil, err
+	}
+	return arrivals, nil
+}
+
+// transitlandGet makes the request, returning the body of a 200 and an
+// HTTPError, as 511's are, for anything else
+func transitlandGet(req *http.Request) ([]byte, error) {
+	resp, err := upstreamClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTransitlandBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return body, &go511.HTTPError{StatusCode: resp.StatusCode, Body: string(body[:min(len(body), 100)])}
+	}
+	return body, nil
+}
+
+// parseTransitlandDepartures turns a departures response into arrivals.
+// Times without a zone are read in loc when the response doesn't name
+// the stop's.
+func parseTransitlandDepartures(body []byte, loc *time.Location) ([]Arrival, error) {
+	var resp transitlandDepartures
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("transitland: invalid response: %v", err)
+	}
+
+	arrivals := make([]Arrival, 0)
+	for _, stop := range resp.Stops {
+		for _, st := range stop.Departures {
+			at, realtime, ok := st.when(loc)
+			if !ok {
+				continue
+			}
+			destination := st.StopHeadsign
+			if destination == "" {
+				destination = st.Trip.TripHeadsign
+			}
+			line := st.Trip.Route.RouteShortName
+			if line == "" {
+				line = st.Trip.Route.RouteLongName
+			}
+			arrivals = append(arrivals, Arrival{
+				ArrivalTime: at.Format(time.RFC3339),
+				Destination: destination,
+				LineType:    line,
+				Realtime:    realtime,
+				Wheelchair:  gtfsFlag(strconv.Itoa(st.Trip.WheelchairAccessible)),
+				Bikes:       gtfsFlag(strconv.Itoa(st.Trip.BikesAllowed)),
+				TripID:      st.Trip.TripID,
+			})
+		}
+	}
+	return arrivals, nil
+}
+
+// when is the stop time's best known arrival: the realtime estimate if
+// there is one, and otherwise the schedule. A trip's first stop has only
+// a departure.
+func (st transitlandStopTime) when(loc *time.Location) (at time.Time, realtime, ok bool) {
+	events := []transitlandEvent{st.Arrival, st.Departure}
+	for _, ev := range events {
+		if t, err := time.Parse(time.RFC3339, ev.EstimatedUTC); err == nil {
+			return t, true, true
+		}
+	}
+	for _, ev := range events {
+		if l, err := time.LoadLocation(ev.StopTimezone); ev.StopTimezone != "" && err == nil {
+			loc = l
+			break
+		}
+	}
+	for _, ev := range events {
+		if t, err := serviceDateTime(st.ServiceDate, ev.Estimated, loc); err == nil {
+			return t, true, true
+		}
+	}
+	for _, ev := range events {
+		if t, err := time.Parse(time.RFC3339, ev.ScheduledUTC); err == nil {
+			return t, false, true
+		}
+	}
+
+	scheduled := st.ArrivalTime
+	if scheduled == "" {
+		scheduled = st.DepartureTime
+	}
+	t, err := serviceDateTime(st.ServiceDate, scheduled, loc)
+	return t, false, err == nil
+}
+
+// serviceDateTime reads a GTFS time on a service date. GTFS times count
+// from noon minus twelve hours, so they run past 24:00 and stay right
+// across daylight saving changes.
+func serviceDateTime(date, hms string, loc *time.Location) (time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	parts := strings.Split(hms, ":")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("invalid time %q", hms)
+	}
+	var n [3]int
+	for i, p := range parts {
+		if n[i], err = strconv.Atoi(p); err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", hms)
+		}
+	}
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, loc)
+	return noon.Add(time.Duration(n[0]-12)*time.Hour + time.Duration(n[1])*time.Minute + time.Duration(n[2])*time.Second), nil
+}
+
+func init() {
+	registerProvider("transitland", ProviderSpec{
+		Provider: ProviderFunc(transitlandStopArrivals),
+		Validate: validateTransitlandProvider,
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransitland(t *testing.T) {
+	// 8pm in San Francisco
+	now := time.Date(2026, 3, 18, 3, 0, 0, 0, time.UTC)
+	withTestEnv(t, now, "")
+	cfg, err := parseConfig([]byte(`
+provider: transitland
+transitland:
+  api_key: tl-key
+stops:
+  - name: Embarcadero
+    line: N Judah
+    directions:
+      - label: Ocean Beach
+        stop_id: s-9q8znb12j1-embarcadero
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+	if cfg.Transitland.Window != 90 || cfg.Transitland.BaseURL != transitlandURL {
+		t.Errorf("transitland defaults = %+v", cfg.Transitland)
+	}
+	exported := redactConfig(cfg)
+	if exported.Transitland.APIKey != redacted {
+		t.Error("Transitland API key not redacted")
+	}
+	if err := restoreSecrets(&exported, cfg); err != nil || exported.Transitland.APIKey != "tl-key" {
+		t.Errorf("restored Transitland API key = %q, %v", exported.Transitland.APIKey, err)
+	}
+
+	upstreamTransport = routeTransport{
+		"https://transit.land/api/v2/rest/stops/s-9q8znb12j1-embarcadero/departures?limit=50&next=5400": `{"stops":[{"departures":[
+			{"service_date":"2026-03-17","arrival":{"estimated_utc":"2026-03-18T03:04:00Z"},
+			 "trip":{"trip_id":"t1","trip_headsign":"Ocean Beach","wheelchair_accessible":1,"bikes_allowed":2,"route":{"route_short_name":"N"}}},
+			{"service_date":"2026-03-17","stop_headsign":"Sunset Tunnel","arrival":{"scheduled_utc":"2026-03-18T03:12:00Z"},
+			 "trip":{"trip_headsign":"Ocean Beach","route":{"route_long_name":"Judah"}}},
+			{"service_date":"2026-03-17","arrival":null,"departure":{"estimated":"20:20:30","stop_timezone":"America/Los_Angeles"},
+			 "trip":{"trip_headsign":"Ocean Beach"}},
+			{"service_date":"2026-03-17","arrival_time":"24:30:00","trip":{"trip_headsign":"Owl"}},
+			{"service_date":"2026-03-17","trip":{"trip_headsign":"Nowhere"}}
+		]}]}`,
+	}
+	got, err := fetchArrivals(context.Background(), "", "s-9q8znb12j1-embarcadero")
+	if err != nil {
+		t.Fatalf("fetchArrivals: %v", err)
+	}
+	var desc []string
+	for _, a := range got {
+		at, _ := time.Parse(time.RFC3339, a.ArrivalTime)
+		desc = append(desc, fmt.Sprintf("%s %s %s %v", at.UTC().Format("15:04:05"), a.Destination, a.LineType, a.Realtime))
+	}
+	want := []string{
+		"03:04:00 Ocean Beach N true",
+		"03:12:00 Sunset Tunnel Judah false",
+		// Estimated on the stop's clock, PDT
+		"03:20:30 Ocean Beach  true",
+		// Scheduled past midnight of the service day
+		"07:30:00 Owl  false",
+	}
+	if !slices.Equal(desc, want) {
+		t.Errorf("arrivals =\n%s\nwant\n%s", strings.Join(desc, "\n"), strings.Join(want, "\n"))
+	}
+	if a := got[0]; a.TripID != "t1" || a.Wheelchair == nil || !*a.Wheelchair || a.Bikes == nil || *a.Bikes {
+		t.Errorf("trip details = %+v", a)
+	}
+
+	// The key goes in a header, never the URL, and errors carry the status
+	rt := &recordTransport{status: http.StatusUnauthorized}
+	upstreamTransport = rt
+	if _, err := fetchArrivals(context.Background(), "", "s-9q8znb12j1-embarcadero"); err == nil || !strings.Contains(err.Error(), "HTTP 401") {
+		t.Errorf("unauthorized: err = %v", err)
+	}
+	if len(rt.urls) != 1 || strings.Contains(rt.urls[0], "tl-key") || rt.headers[0].Get("apikey") != "tl-key" {
+		t.Errorf("request = %v %v", rt.urls, rt.headers)
+	}
+
+	if _, err := parseConfig([]byte("provider: transitland\nstops: [{name: A, directions: [{label: B, stop_id: s-1}]}]")); err == nil || !strings.Contains(err.Error(), "transitland.api_key is required") {
+		t.Errorf("without a key: err = %v", err)
+	}
+}
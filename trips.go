@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TripConfig is a journey made of legs on configured stops, e.g. the 22
+// to Duboce then the N inbound
+type TripConfig struct {
+	Name string `yaml:"name"`
+	// Minimum minutes to make each transfer (default 2)
+	TransferBuffer int       `yaml:"transfer_buffer,omitempty"`
+	Legs           []TripLeg `yaml:"legs"`
+}
+
+type TripLeg struct {
+	Stop      string `yaml:"stop"`
+	Direction string `yaml:"direction"`
+	// Stop code of the direction, needed when a stop has two directions
+	// with the same label; filled in from the config otherwise
+	StopID string `yaml:"stop_id,omitempty"`
+	// Minutes on board until the next leg's stop (or the destination)
+	RideMinutes int `yaml:"ride_minutes"`
+}
+
+func validateTrips(cfg *Config) error {
+	for i := range cfg.Trips {
+		trip := &cfg.Trips[i]
+		if trip.Name == "" {
+			return fmt.Errorf("trips[%d] needs a name", i)
+		}
+		if len(trip.Legs) == 0 {
+			return fmt.Errorf("trip %q needs at least one leg", trip.Name)
+		}
+		for j := range trip.Legs {
+			leg := &trip.Legs[j]
+			if err := resolveLeg(cfg, leg); err != nil {
+				return fmt.Errorf("trip %q: %w", trip.Name, err)
+			}
+			if leg.RideMinutes < 0 {
+				return fmt.Errorf("trip %q: ride_minutes cannot be negative", trip.Name)
+			}
+		}
+		if trip.TransferBuffer <= 0 {
+			trip.TransferBuffer = 2
+		}
+	}
+	return nil
+}
+
+// resolveLeg finds the one configured direction a leg names, by stop,
+// direction label, and stop code, and fills in whichever it left out
+func resolveLeg(cfg *Config, leg *TripLeg) error {
+	if leg.StopID == "" && (leg.Stop == "" || leg.Direction == "") {
+		return fmt.Errorf("a leg needs a stop and direction, or a stop_id")
+	}
+	var matches []Stop
+	var found Direction
+	for _, stop := range cfg.Stops {
+		if leg.Stop != "" && !strings.EqualFold(stop.Name, leg.Stop) {
+			continue
+		}
+		for _, dir := range stop.Directions {
+			if (leg.Direction == "" || strings.EqualFold(dir.Label, leg.Direction)) &&
+				(leg.StopID == "" || dir.StopID == leg.StopID) {
+				matches = append(matches, stop)
+				found = dir
+			}
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return fmt.Errorf("no configured stop %q with direction %q and stop_id %q", leg.Stop, leg.Direction, leg.StopID)
+	case len(matches) > 1:
+		return fmt.Errorf("stop %q direction %q matches %d configured directions; give stop_id", leg.Stop, leg.Direction, len(matches))
+	}
+	if leg.Stop == "" {
+		leg.Stop = matches[0].Name
+	}
+	if leg.Direction == "" {
+		leg.Direction = found.Label
+	}
+	leg.StopID = found.StopID
+	return nil
+}
+
+// TripOptions lists the connections that work for one configured trip
+type TripOptions struct {
+	Name    string      `json:"name"`
+	Options []Itinerary `json:"options"`
+}
+
+// Itinerary is one way to make the trip, leaving on a specific vehicle
+type Itinerary struct {
+	Legs    []ItineraryLeg `json:"legs"`
+	Arrives string         `json:"arrives"`
+	// Minutes from now until arrival at the destination
+	TotalMinutes int `json:"total_minutes"`
+}
+
+type ItineraryLeg struct {
+	Stop      string `json:"stop"`
+	Direction string `json:"direction"`
+	Departs   string `json:"departs"`
+	// Minutes spent waiting at this stop after the previous leg
+	WaitMinutes int `json:"wait_minutes"`
+}
+
+type TripsResponse struct {
+	Trips              []TripOptions `json:"trips"`
+	LastUpdated        string        `json:"last_updated"`
+	LastUpdatedDisplay string        `json:"last_updated_display"`
+}
+
+// directionDepartures finds upcoming departure times for a leg's
+// direction. Vehicles that have already left can't be caught.
+func directionDepartures(resp ArrivalsResponse, leg TripLeg) []time.Time {
+	for _, stop := range resp.Stops {
+		if !strings.EqualFold(stop.Name, leg.Stop) {
+			continue
+		}
+		for _, dir := range stop.Directions {
+			if dir.StopID != leg.StopID {
+				continue
+			}
+			times := make([]time.Time, 0, len(dir.Arrivals))
+			for _, a := range dir.Arrivals {
+				if a.Status == statusDeparted {
+					continue
+				}
+				if t, err := time.Parse(time.RFC3339, a.ArrivalTime); err == nil {
+					times = append(times, t)
+				}
+			}
+			return times
+		}
+	}
+	return nil
+}
+
+// planTrip chains each first-leg departure through the later legs, taking
+// the first vehicle that leaves after the ride plus the transfer buffer.
+// Departures whose connection isn't in the predictions are left out.
+func planTrip(trip TripConfig, resp ArrivalsResponse, now time.Time) TripOptions {
+	out := TripOptions{Name: trip.Name, Options: make([]Itinerary, 0)}
+	buffer := time.Duration(trip.TransferBuffer) * time.Minute
+
+	legTimes := make([][]time.Time, len(trip.Legs))
+	for i, leg := range trip.Legs {
+		legTimes[i] = directionDepartures(resp, leg)
+	}
+
+	for _, first := range legTimes[0] {
+		it := Itinerary{Legs: []ItineraryLeg{{
+			Stop:      trip.Legs[0].Stop,
+			Direction: trip.Legs[0].Direction,
+			Departs:   first.Format(time.RFC3339),
+		}}}
+		at := first.Add(time.Duration(trip.Legs[0].RideMinutes) * time.Minute)
+
+		ok := true
+		for i := 1; i < len(trip.Legs) && ok; i++ {
+			ok = false
+			for _, t := range legTimes[i] {
+				if t.Before(at.Add(buffer)) {
+					continue
+				}
+				it.Legs = append(it.Legs, ItineraryLeg{
+					Stop:        trip.Legs[i].Stop,
+					Direction:   trip.Legs[i].Direction,
+					Departs:     t.Format(time.RFC3339),
+					WaitMinutes: int(t.Sub(at).Minutes()),
+				})
+				at = t.Add(time.Duration(trip.Legs[i].RideMinutes) * time.Minute)
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		it.Arrives = at.Format(time.RFC3339)
+		it.TotalMinutes = int(at.Sub(now).Minutes())
+		out.Options = append(out.Options, it)
+	}
+	return out
+}
+
+func handleTrips(w http.ResponseWriter, r *http.Request) {
+	now := clock.Now()
+	// Every cached arrival, not just the few the board shows, so later
+	// legs can connect to vehicles further out
+	arrivals := buildArrivalsPage(now, allArrivals)
+
+	resp := TripsResponse{
+		Trips:              make([]TripOptions, 0),
+		LastUpdated:        arrivals.LastUpdated,
+		LastUpdatedDisplay: arrivals.LastUpdatedDisplay,
+	}
+	for _, trip := range currentConfig().Trips {
+		resp.Trips = append(resp.Trips, planTrip(trip, arrivals, now))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanTrip(t *testing.T) {
+	now := time.Date(2026, 1, 30, 8, 0, 0, 0, time.UTC)
+	at := func(min int) Arrival {
+		return Arrival{ArrivalTime: now.Add(time.Duration(min) * time.Minute).Format(time.RFC3339)}
+	}
+	departed := at(-2)
+	departed.Status = statusDeparted
+	resp := ArrivalsResponse{Stops: []StopArrivals{
+		{Name: "Fillmore", Directions: []DirectionArrivals{{Label: "Downtown", StopID: "1", Arrivals: []Arrival{departed, at(2), at(10), at(30)}}}},
+		// Two platforms share a label; the leg's stop code picks one
+		{Name: "Duboce", Directions: []DirectionArrivals{
+			{Label: "Inbound", StopID: "2", Arrivals: []Arrival{at(9), at(12), at(20)}},
+			{Label: "Inbound", StopID: "3", Arrivals: []Arrival{at(10)}},
+		}},
+	}}
+	cfg, err := parseConfig([]byte(`
+api_key: test
+stops:
+  - name: Fillmore
+    directions: [{label: Downtown, stop_id: "1"}]
+  - name: Duboce
+    directions: [{label: Inbound, stop_id: "2"}, {label: Inbound, stop_id: "3"}]
+trips:
+  - name: Work
+    legs:
+      - {stop: Fillmore, direction: Downtown, ride_minutes: 6}
+      - {stop: duboce, direction: inbound, stop_id: "2", ride_minutes: 10}
+`))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	trip := cfg.Trips[0]
+	if trip.Legs[0].StopID != "1" || trip.TransferBuffer != 2 {
+		t.Errorf("resolved trip = %+v", trip)
+	}
+	if _, err := parseConfig([]byte("api_key: test\nstops: [{name: Duboce, directions: [{label: Inbound, stop_id: \"2\"}, {label: Inbound, stop_id: \"3\"}]}]\n" +
+		"trips: [{name: Work, legs: [{stop: Duboce, direction: Inbound}]}]\n")); err == nil || !strings.Contains(err.Error(), "give stop_id") {
+		t.Errorf("ambiguous leg: %v", err)
+	}
+
+	got := planTrip(trip, resp, now)
+
+	// 2+6 reaches Duboce at 8, misses 9 with the buffer, takes 12.
+	// 10+6 reaches at 16 and takes 20. Nothing after 30+6 is predicted.
+	if len(got.Options) != 2 {
+		t.Fatalf("got %d options, want 2: %+v", len(got.Options), got.Options)
+	}
+	if o := got.Options[0]; o.Legs[1].Departs != at(12).ArrivalTime || o.Legs[1].WaitMinutes != 4 || o.TotalMinutes != 22 {
+		t.Errorf("first option = %+v", o)
+	}
+	if o := got.Options[1]; o.Legs[1].Departs != at(20).ArrivalTime || o.TotalMinutes != 30 {
+		t.Errorf("second option = %+v", o)
+	}
+
+	// /api/trips plans over every cached arrival, past the three the
+	// board shows: 30 and 40 connect to the 50
+	withTestEnv(t, now, "")
+	activeConfig.Store(cfg)
+	resp.Stops[0].Directions[0].Arrivals = append(resp.Stops[0].Directions[0].Arrivals, at(40))
+	resp.Stops[1].Directions[0].Arrivals = append(resp.Stops[1].Directions[0].Arrivals, at(50))
+	cache.mu.Lock()
+	cache.data = resp
+	cache.mu.Unlock()
+	rec := httptest.NewRecorder()
+	handleTrips(rec, httptest.NewRequest("GET", "/api/trips", nil))
+	var trips TripsResponse
+	json.NewDecoder(rec.Body).Decode(&trips)
+	if len(trips.Trips) != 1 || len(trips.Trips[0].Options) != 4 {
+		t.Errorf("trips = %+v", trips.Trips)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"muni-tracker/internal/quality"
+)
+
+// ANSI escape sequences used by the terminal dashboard
+const (
+	ansiReset      = "\033[0m"
+	ansiBold       = "\033[1m"
+	ansiDim        = "\033[2m"
+	ansiRed        = "\033[31m"
+	ansiGreen      = "\033[32m"
+	ansiYellow     = "\033[33m"
+	ansiCyan       = "\033[36m"
+	ansiClear      = "\033[H\033[2J"
+	ansiAltScreen  = "\033[?1049h"
+	ansiMainScreen = "\033[?1049l"
+	ansiHideCursor = "\033[?25l"
+	ansiShowCursor = "\033[?25h"
+)
+
+// runTUI renders the arrivals board in the terminal with live countdowns
+// until ctx ends, which Ctrl-C does. The cache refresher runs exactly as
+// in server mode.
+func runTUI(ctx context.Context, out io.Writer) {
+	// Log lines would corrupt the display
+	log.SetOutput(io.Discard)
+
+	go startCacheRefresher(ctx)
+
+	fmt.Fprint(out, ansiAltScreen+ansiHideCursor)
+	defer fmt.Fprint(out, ansiShowCursor+ansiMainScreen)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		now := clock.Now()
+		fmt.Fprint(out, ansiClear+renderBoard(buildArrivalsResponse(now), now))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renderBoard draws every stop and direction in response
+func renderBoard(response ArrivalsResponse, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%sMuni Quick Tracker%s  %s%s%s\n\n", ansiBold, ansiReset, ansiDim, displayClock(now), ansiReset)
+
+	if len(response.Stops) == 0 {
+		b.WriteString("Loading...\n")
+		return b.String()
+	}
+
+	for _, stop := range response.Stops {
+		fmt.Fprintf(&b, "%s%s%s %s(%s)%s\n", ansiBold, stop.Name, ansiReset, ansiCyan, stop.Line, ansiReset)
+		for _, dir := range stop.Directions {
+			fmt.Fprintf(&b, "  %-22s %s\n", dir.Label, renderDirectionTUI(dir, now))
+		}
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "%sCtrl-C to quit%s\n", ansiDim, ansiReset)
+	return b.String()
+}
+
+func renderDirectionTUI(dir DirectionArrivals, now time.Time) string {
+	if dir.Error != "" {
+		return ansiRed + dir.Error + ansiReset
+	}
+	if len(dir.Arrivals) == 0 && dir.ServiceEnded {
+		return ansiDim + serviceEndedText(dir) + ansiReset
+	}
+	if len(dir.Arrivals) == 0 {
+		return ansiDim + tr("No arrivals") + ansiReset
+	}
+
+	parts := make([]string, 0, len(dir.Arrivals))
+	for _, a := range dir.Arrivals {
+		parts = append(parts, formatCountdown(a, now))
+	}
+	line := strings.Join(parts, "  ")
+
+	if dir.ApproxHeadwayMinutes > 0 {
+		line += fmt.Sprintf("  %severy ~%d min%s", ansiDim, dir.ApproxHeadwayMinutes, ansiReset)
+	}
+
+	if dir.QualityWarning != "" {
+		color := ansiYellow
+		if dir.QualityLevel != quality.Warning {
+			color = ansiRed
+		}
+		line += "  " + color + "! " + dir.QualityWarning + ansiReset
+	}
+	return line
+}
+
+// formatCountdown shows m:ss until arrival, highlighting imminent ones
+func formatCountdown(a Arrival, now time.Time) string {
+	t, err := time.Parse(time.RFC3339, a.ArrivalTime)
+	if err != nil {
+		return fmt.Sprintf("%dm", a.Minutes)
+	}
+
+	secs := int(t.Sub(now).Seconds())
+	if secs < 0 {
+		return ansiDim + statusText(arrivalStatus(secs)) + ansiReset
+	}
+	text := fmt.Sprintf("%d:%02d", secs/60, secs%60)
+
+	if !a.Realtime {
+		return ansiDim + text + ansiReset
+	}
+	if secs < 120 {
+		return ansiBold + ansiGreen + text + ansiReset
+	}
+	return ansiGreen + text + ansiReset
+}
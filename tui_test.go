@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"muni-tracker/internal/quality"
+)
+
+func TestRenderBoard(t *testing.T) {
+	now := time.Date(2026, 3, 12, 20, 0, 0, 0, time.UTC)
+	withTestEnv(t, now, "")
+	at := func(d time.Duration) string { return now.Add(d).Format(time.RFC3339) }
+
+	resp := ArrivalsResponse{Stops: []StopArrivals{{
+		Name: "Embarcadero",
+		Line: "N Judah",
+		Directions: []DirectionArrivals{
+			{
+				Label: "Ocean Beach",
+				Arrivals: []Arrival{
+					{ArrivalTime: at(90 * time.Second), Realtime: true},
+					{ArrivalTime: at(5 * time.Minute), Realtime: true},
+					{ArrivalTime: at(12*time.Minute + 5*time.Second)},
+				},
+				ApproxHeadwayMinutes: 8,
+				QualityWarning:       "Limited schedule data",
+				QualityLevel:         quality.Warning,
+			},
+			{Label: "Caltrain", Error: "upstream unavailable"},
+			{Label: "Downtown", ServiceEnded: true, ServiceResumes: "2026-03-13T05:12:00-07:00"},
+			{Label: "Castro"},
+		},
+	}}}
+
+	want := ansiBold + "Muni Quick Tracker" + ansiReset + "  " + ansiDim + "1:00:00 PM" + ansiReset + "\n\n" +
+		ansiBold + "Embarcadero" + ansiReset + " " + ansiCyan + "(N Judah)" + ansiReset + "\n" +
+		"  Ocean Beach            " +
+		ansiBold + ansiGreen + "1:30" + ansiReset + "  " + ansiGreen + "5:00" + ansiReset + "  " + ansiDim + "12:05" + ansiReset +
+		"  " + ansiDim + "every ~8 min" + ansiReset + "  " + ansiYellow + "! Limited schedule data" + ansiReset + "\n" +
+		"  Caltrain               " + ansiRed + "upstream unavailable" + ansiReset + "\n" +
+		"  Downtown               " + ansiDim + "Service resumes 5:12 AM" + ansiReset + "\n" +
+		"  Castro                 " + ansiDim + "No arrivals" + ansiReset + "\n\n" +
+		ansiDim + "Ctrl-C to quit" + ansiReset + "\n"
+	if got := renderBoard(resp, now); got != want {
+		t.Errorf("renderBoard =\n%q\nwant\n%q", got, want)
+	}
+
+	if got, want := renderBoard(ArrivalsResponse{}, now), "Loading...\n"; got[len(got)-len(want):] != want {
+		t.Errorf("empty board = %q", got)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// umask does nothing: there is no file creation mask here
+func umask(mask int) int {
+	return 0
+}
@@ -0,0 +1,10 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// umask sets the process's file creation mask and returns the old one
+func umask(mask int) int {
+	return syscall.Umask(mask)
+}
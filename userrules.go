@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// UserRulesConfig lets household members keep their own notify_when
+// rules under their profile token, managed from the web UI. Their
+// notifications go only to the Web Push subscriptions made with that
+// token.
+type UserRulesConfig struct {
+	// JSON file the rules are stored in; user rules are off when empty
+	File string `yaml:"file,omitempty"`
+	// Most users the server will hold rules for (default 50)
+	MaxUsers int `yaml:"max_users,omitempty"`
+	// Most rules one user may keep (default 20)
+	MaxRules int `yaml:"max_rules,omitempty"`
+}
+
+func (u UserRulesConfig) enabled() bool {
+	return u.File != ""
+}
+
+func validateUserRulesConfig(n *NotificationsConfig) error {
+	u := &n.UserRules
+	if !u.enabled() {
+		return nil
+	}
+	if !n.WebPush.enabled() {
+		return fmt.Errorf("notifications.user_rules needs web_push, which delivers them")
+	}
+	if u.MaxUsers < 0 || u.MaxRules < 0 {
+		return fmt.Errorf("notifications.user_rules: max_users and max_rules cannot be negative")
+	}
+	if u.MaxUsers == 0 {
+		u.MaxUsers = 50
+	}
+	if u.MaxRules == 0 {
+		u.MaxRules = 20
+	}
+	return nil
+}
+
+// maxUserRuleSize bounds rule documents accepted over the API
+const maxUserRuleSize = 4 << 10
+
+// UserRule is a notify_when rule a user saved for one direction
+type UserRule struct {
+	ID string `json:"id"`
+	// Optional label shown in the web UI
+	Name string `json:"name,omitempty"`
+	// Stop code of the direction the rule watches
+	StopID string `json:"stop_id"`
+	NotifyRule
+}
+
+// UserRules is everything one profile token has saved
+type UserRules struct {
+	Rules     []UserRule `json:"rules"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// userRules maps profile tokens to their rules
+var userRules = &jsonStore[UserRules]{}
+
+// validateUserRule checks a rule against the configured stops. Only the
+// main board's directions are watched.
+func validateUserRule(rule *UserRule, cfg *Config) error {
+	if len(rule.Name) > 64 {
+		return fmt.Errorf("name is too long")
+	}
+	found := false
+	for _, stop := range cfg.Stops {
+		for _, dir := range stop.Directions {
+			found = found || dir.StopID == rule.StopID
+		}
+	}
+	if !found {
+		return fmt.Errorf("stop %q is not configured", rule.StopID)
+	}
+	if !rule.enabled() {
+		return fmt.Errorf("max must be at least 1")
+	}
+	return rule.validate()
+}
+
+// savedUserRules returns every user's rules, ready to evaluate, or nil
+// when user rules are off
+func savedUserRules() map[string]UserRules {
+	u := currentConfig().Notifications.UserRules
+	if !u.enabled() {
+		return nil
+	}
+	saved, err := userRules.all(u.File)
+	if err != nil {
+		errorf("User rules: %v", err)
+		return nil
+	}
+	for token, ur := range saved {
+		rules := make([]UserRule, 0, len(ur.Rules))
+		for _, rule := range ur.Rules {
+			// Parses hours, which aren't stored parsed
+			if err := rule.validate(); err == nil {
+				rules = append(rules, rule)
+			}
+		}
+		ur.Rules = rules
+		saved[token] = ur
+	}
+	return saved
+}
+
+func newRuleID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// readUserRule decodes a rule from a request body and validates it
+func readUserRule(w http.ResponseWriter, r *http.Request, cfg *Config) (UserRule, error) {
+	var rule UserRule
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUserRuleSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rule); err != nil {
+		return rule, fmt.Errorf("invalid rule: %w", err)
+	}
+	return rule, validateUserRule(&rule, cfg)
+}
+
+var (
+	errRuleNotFound = errors.New("rule not found")
+	errTooManyRules = errors.New("rule limit reached")
+)
+
+// handleUserRules lists (GET) or adds (POST) a user's rules at
+// /api/rules/{token}, and replaces (PUT) or removes (DELETE) one at
+// /api/rules/{token}/{id}
+func handleUserRules(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	u := cfg.Notifications.UserRules
+	if !u.enabled() {
+		http.Error(w, "user rules are not enabled", http.StatusNotFound)
+		return
+	}
+
+	token, id, _ := strings.Cut(strings.TrimPrefix(apiPath(r.URL.Path), "/api/rules/"), "/")
+	if !profileToken.MatchString(token) {
+		http.Error(w, "profile token must be 16-128 letters, digits, '-' or '_'", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		saved  UserRules
+		status = http.StatusOK
+		err    error
+	)
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		var ok bool
+		saved, ok, err = userRules.get(u.File, token)
+		if err == nil && !ok {
+			saved.Rules = []UserRule{}
+		}
+
+	case r.Method == http.MethodPost && id == "":
+		rule, rerr := readUserRule(w, r, cfg)
+		if rerr != nil {
+			http.Error(w, rerr.Error(), http.StatusBadRequest)
+			return
+		}
+		rule.ID = newRuleID()
+		saved, err = userRules.update(u.File, token, u.MaxUsers, func(old UserRules, _ bool) (UserRules, error) {
+			if len(old.Rules) >= u.MaxRules {
+				return old, errTooManyRules
+			}
+			old.Rules = append(append([]UserRule{}, old.Rules...), rule)
+			old.UpdatedAt = clock.Now().UTC()
+			return old, nil
+		})
+		status = http.StatusCreated
+
+	case r.Method == http.MethodPut && id != "":
+		rule, rerr := readUserRule(w, r, cfg)
+		if rerr != nil {
+			http.Error(w, rerr.Error(), http.StatusBadRequest)
+			return
+		}
+		rule.ID = id
+		saved, err = userRules.update(u.File, token, u.MaxUsers, func(old UserRules, _ bool) (UserRules, error) {
+			rules := append([]UserRule{}, old.Rules...)
+			for i := range rules {
+				if rules[i].ID == id {
+					rules[i] = rule
+					old.Rules, old.UpdatedAt = rules, clock.Now().UTC()
+					return old, nil
+				}
+			}
+			return old, errRuleNotFound
+		})
+
+	case r.Method == http.MethodDelete && id != "":
+		var remaining int
+		saved, err = userRules.update(u.File, token, u.MaxUsers, func(old UserRules, _ bool) (UserRules, error) {
+			rules := make([]UserRule, 0, len(old.Rules))
+			for _, rule := range old.Rules {
+				if rule.ID != id {
+					rules = append(rules, rule)
+				}
+			}
+			if len(rules) == len(old.Rules) {
+				return old, errRuleNotFound
+			}
+			remaining = len(rules)
+			old.Rules, old.UpdatedAt = rules, clock.Now().UTC()
+			return old, nil
+		})
+		if err == nil && remaining == 0 {
+			_, err = userRules.delete(u.File, token)
+		}
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+	default:
+		if id == "" {
+			w.Header().Set("Allow", "GET, POST")
+		} else {
+			w.Header().Set("Allow", "PUT, DELETE")
+		}
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case errors.Is(err, errRuleNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errTooManyRules):
+		http.Error(w, fmt.Sprintf("at most %d rules are allowed", u.MaxRules), http.StatusBadRequest)
+		return
+	case errors.Is(err, errStoreFull):
+		http.Error(w, "user limit reached", http.StatusInsufficientStorage)
+		return
+	case err != nil:
+		errorf("User rules: %v", err)
+		http.Error(w, "user rules unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(saved)
+}
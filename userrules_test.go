@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserRules(t *testing.T) {
+	fc, _ := withTestEnv(t, time.Now(), "")
+	rt := &recordTransport{status: http.StatusCreated}
+	upstreamTransport = rt
+
+	dir := t.TempDir()
+	cfg, err := parseConfig([]byte(`
+api_key: test
+notifications:
+  web_push:
+    subject: mailto:me@example.com
+    keys_file: ` + filepath.Join(dir, "vapid.json") + `
+    subscriptions_file: ` + filepath.Join(dir, "push.json") + `
+  user_rules:
+    file: ` + filepath.Join(dir, "rules.json") + `
+    max_rules: 2
+` + testStop))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	if _, err := parseConfig([]byte("notifications:\n  user_rules: {file: rules.json}\n" + testStop)); err == nil {
+		t.Error("user_rules without web_push accepted")
+	}
+
+	// One browser subscribed with the token, one without
+	const token = "household-member-1"
+	for i, path := range []string{"/api/push/subscriptions?profile=" + token, "/api/push/subscriptions"} {
+		uaKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+		sub := fmt.Sprintf(`{"endpoint":"https://push.example.net/%d","keys":{"p256dh":%q,"auth":%q}}`,
+			i, b64.EncodeToString(uaKey.PublicKey().Bytes()), b64.EncodeToString(make([]byte, 16)))
+		rec := httptest.NewRecorder()
+		handlePushSubscriptions(rec, httptest.NewRequest("POST", path, strings.NewReader(sub)))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("subscribe = %d", rec.Code)
+		}
+	}
+
+	call := func(method, path, body string) (int, UserRules) {
+		rec := httptest.NewRecorder()
+		handleUserRules(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var saved UserRules
+		json.NewDecoder(rec.Body).Decode(&saved)
+		return rec.Code, saved
+	}
+	base := "/api/rules/" + token
+
+	if code, saved := call("GET", base, ""); code != http.StatusOK || len(saved.Rules) != 0 {
+		t.Fatalf("GET empty = %d %+v", code, saved)
+	}
+	code, saved := call("POST", base, `{"name":"Commute","stop_id":"1","min":8,"max":12}`)
+	if code != http.StatusCreated || len(saved.Rules) != 1 || saved.Rules[0].ID == "" || saved.Rules[0].Cooldown != 15 {
+		t.Fatalf("POST = %d %+v", code, saved)
+	}
+	id := saved.Rules[0].ID
+	for _, bad := range []string{`{"stop_id":"999","max":5}`, `{"stop_id":"1","min":9,"max":5}`, `{"stop_id":"1"}`, `{"stop_id":"1","max":5,"extra":1}`} {
+		if code, _ := call("POST", base, bad); code != http.StatusBadRequest {
+			t.Errorf("POST %s = %d, want 400", bad, code)
+		}
+	}
+	if code, _ := call("POST", "/api/rules/short", `{"stop_id":"1","max":5}`); code != http.StatusBadRequest {
+		t.Errorf("short token = %d", code)
+	}
+	call("POST", base, `{"stop_id":"1","max":2}`)
+	if code, _ := call("POST", base, `{"stop_id":"1","max":3}`); code != http.StatusBadRequest {
+		t.Errorf("rule over max_rules = %d", code)
+	}
+
+	// Only the member's own browser is notified
+	now := time.Now()
+	fc.now = now
+	resp := ArrivalsResponse{Stops: []StopArrivals{{Name: "A", Line: "N", Directions: []DirectionArrivals{
+		{Label: "B", StopID: "1", Arrivals: arrivalsAt(now.Add(10 * time.Minute)), FetchedAt: now},
+	}}}}
+	cache.store(resp, now)
+	if len(rt.urls) != 1 || rt.urls[0] != "https://push.example.net/0" {
+		t.Fatalf("pushed to %v", rt.urls)
+	}
+
+	if code, saved := call("PUT", base+"/"+id, `{"stop_id":"1","min":1,"max":4}`); code != http.StatusOK || saved.Rules[0].Max != 4 || saved.Rules[0].ID != id {
+		t.Errorf("PUT = %d %+v", code, saved)
+	}
+	if code, _ := call("PUT", base+"/missing", `{"stop_id":"1","max":4}`); code != http.StatusNotFound {
+		t.Errorf("PUT missing = %d", code)
+	}
+	if code, _ := call("DELETE", base+"/"+id, ""); code != http.StatusNoContent {
+		t.Errorf("DELETE = %d", code)
+	}
+	if code, _ := call("PATCH", base, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("PATCH = %d", code)
+	}
+	if _, saved := call("GET", base, ""); len(saved.Rules) != 1 {
+		t.Errorf("rules after delete = %+v", saved.Rules)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const openMeteoURL = "https://api.open-meteo.com/v1/forecast"
+
+// WeatherConfig enables current conditions from Open-Meteo (no key needed)
+type WeatherConfig struct {
+	// Pointers, so a location on the equator or the prime meridian isn't
+	// taken for no location
+	Latitude  *float64 `yaml:"latitude,omitempty"`
+	Longitude *float64 `yaml:"longitude,omitempty"`
+	// fahrenheit (default) or celsius
+	Units string `yaml:"units,omitempty"`
+	// Minutes between weather fetches (default 15)
+	RefreshInterval int `yaml:"refresh_interval,omitempty"`
+}
+
+func (w WeatherConfig) enabled() bool {
+	return w.Latitude != nil || w.Longitude != nil
+}
+
+func validateWeatherConfig(w *WeatherConfig) error {
+	if !w.enabled() {
+		return nil
+	}
+	if w.Latitude == nil || w.Longitude == nil {
+		return fmt.Errorf("weather needs both latitude and longitude")
+	}
+	if *w.Latitude < -90 || *w.Latitude > 90 || *w.Longitude < -180 || *w.Longitude > 180 {
+		return fmt.Errorf("weather.latitude and weather.longitude are out of range")
+	}
+	switch w.Units {
+	case "":
+		w.Units = "fahrenheit"
+	case "fahrenheit", "celsius":
+	default:
+		return fmt.Errorf("weather.units must be fahrenheit or celsius")
+	}
+	if w.RefreshInterval <= 0 {
+		w.RefreshInterval = 15
+	}
+	return nil
+}
+
+// Weather is the current conditions at the configured location
+type Weather struct {
+	Temperature     float64 `json:"temperature"`
+	TemperatureUnit string  `json:"temperature_unit"`
+	PrecipitationMM float64 `json:"precipitation_mm"`
+	Description     string  `json:"description"`
+	UpdatedAt       string  `json:"updated_at"`
+}
+
+var weatherCache struct {
+	sync.RWMutex
+	data *Weather
+}
+
+// currentWeather returns the last fetched conditions, or nil if weather is
+// disabled or has not been fetched yet
+func currentWeather() *Weather {
+	if !currentConfig().Weather.enabled() {
+		return nil
+	}
+	weatherCache.RLock()
+	defer weatherCache.RUnlock()
+	return weatherCache.data
+}
+
+// wmoDescriptions names the WMO weather codes Open-Meteo reports
+var wmoDescriptions = map[int]string{
+	0: "Clear", 1: "Mostly clear", 2: "Partly cloudy", 3: "Overcast",
+	45: "Fog", 48: "Fog",
+	51: "Light drizzle", 53: "Drizzle", 55: "Heavy drizzle",
+	61: "Light rain", 63: "Rain", 65: "Heavy rain",
+	71: "Light snow", 73: "Snow", 75: "Heavy snow",
+	80: "Showers", 81: "Showers", 82: "Heavy showers",
+	95: "Thunderstorm", 96: "Thunderstorm", 99: "Thunderstorm",
+}
+
+func fetchWeather(ctx context.Context, w WeatherConfig) (*Weather, error) {
+	q := url.Values{}
+	q.Set("latitude", strconv.FormatFloat(*w.Latitude, 'f', -1, 64))
+	q.Set("longitude", strconv.FormatFloat(*w.Longitude, 'f', -1, 64))
+	q.Set("current", "temperature_2m,precipitation,weather_code")
+	q.Set("temperature_unit", w.Units)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, openMeteoURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := upstreamClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("open-meteo returned HTTP %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Current struct {
+			Temperature   float64 `json:"temperature_2m"`
+			Precipitation float64 `json:"precipitation"`
+			WeatherCode   int     `json:"weather_code"`
+		} `json:"current"`
+		CurrentUnits struct {
+			Temperature string `json:"temperature_2m"`
+		} `json:"current_units"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to parse weather: %w", err)
+	}
+
+	return &Weather{
+		Temperature:     body.Current.Temperature,
+		TemperatureUnit: body.CurrentUnits.Temperature,
+		PrecipitationMM: body.Current.Precipitation,
+		Description:     wmoDescriptions[body.Current.WeatherCode],
+		UpdatedAt:       clock.Now().Format(time.RFC3339),
+	}, nil
+}
+
+// startWeatherRefresher polls Open-Meteo on its own interval. It follows
+// config reloads, so weather can be enabled without a restart.
+func startWeatherRefresher(ctx context.Context) {
+	startPeriodicRefresher(ctx, func(cfg *Config) time.Duration {
+		if !cfg.Weather.enabled() {
+			return 0
+		}
+		return time.Duration(cfg.Weather.RefreshInterval) * time.Minute
+	}, refreshWeather)
+}
+
+// refreshWeather fetches the current conditions into the cache
+func refreshWeather(ctx context.Context) {
+	w := currentConfig().Weather
+	if !w.enabled() {
+		return
+	}
+	weather, err := fetchWeather(ctx, w)
+	if err != nil {
+		warnf("Error fetching weather: %v", err)
+		return
+	}
+	weatherCache.Lock()
+	weatherCache.data = weather
+	weatherCache.Unlock()
+	debugf("Weather: %.0f%s, %s", weather.Temperature, weather.TemperatureUnit, weather.Description)
+}
+
+func handleWeather(w http.ResponseWriter, r *http.Request) {
+	if !currentConfig().Weather.enabled() {
+		http.Error(w, "weather is not configured", http.StatusNotFound)
+		return
+	}
+	weather := currentWeather()
+	if weather == nil {
+		http.Error(w, "weather not available yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(weather)
+}
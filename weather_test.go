@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeather(t *testing.T) {
+	withTestEnv(t, time.Date(2026, 1, 30, 20, 0, 0, 0, time.UTC), "")
+	stops := "\nstops: [{name: A, directions: [{label: B, stop_id: \"1\"}]}]"
+
+	// Null Island is a place, not a missing location
+	cfg, err := parseConfig([]byte("api_key: test\nweather: {latitude: 0, longitude: 0, units: celsius}" + stops))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	if !cfg.Weather.enabled() || cfg.Weather.RefreshInterval != 15 {
+		t.Fatalf("weather = %+v", cfg.Weather)
+	}
+	for yaml, want := range map[string]string{
+		"weather: {latitude: 37.7}":                           "both latitude and longitude",
+		"weather: {latitude: 91, longitude: 0}":               "out of range",
+		"weather: {latitude: 0, longitude: 0, units: kelvin}": "fahrenheit or celsius",
+	} {
+		if _, err := parseConfig([]byte("api_key: test\n" + yaml + stops)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want %q", yaml, err, want)
+		}
+	}
+	activeConfig.Store(cfg)
+	t.Cleanup(func() {
+		weatherCache.Lock()
+		weatherCache.data = nil
+		weatherCache.Unlock()
+	})
+
+	upstreamTransport = routeTransport{
+		"https://api.open-meteo.com/v1/forecast?current=temperature_2m%2Cprecipitation%2Cweather_code&latitude=0&longitude=0&temperature_unit=celsius": `{
+			"current": {"temperature_2m": 27.4, "precipitation": 0.2, "weather_code": 61},
+			"current_units": {"temperature_2m": "°C"}}`,
+	}
+	refreshWeather(context.Background())
+	rec := httptest.NewRecorder()
+	handleWeather(rec, httptest.NewRequest("GET", "/api/weather", nil))
+	var got Weather
+	json.NewDecoder(rec.Body).Decode(&got)
+	if rec.Code != http.StatusOK || got.Temperature != 27.4 || got.TemperatureUnit != "°C" || got.Description != "Light rain" {
+		t.Errorf("weather: status %d, %+v", rec.Code, got)
+	}
+
+	// A failed fetch keeps the last conditions
+	upstreamTransport = routeTransport{}
+	refreshWeather(context.Background())
+	if w := currentWeather(); w == nil || w.Temperature != 27.4 {
+		t.Errorf("after a failed fetch: %+v", w)
+	}
+
+	cfg.Weather = WeatherConfig{}
+	if currentWeather() != nil {
+		t.Error("weather served after it was turned off")
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// WebPushConfig delivers notifications straight to browsers that
+// subscribed from the web UI, with no third-party service in between
+type WebPushConfig struct {
+	// Contact for push services, a mailto: or https: URL. Web Push is off
+	// when empty.
+	Subject string `yaml:"subject,omitempty"`
+	// VAPID key pair, generated on first use (default vapid.json)
+	KeysFile string `yaml:"keys_file,omitempty"`
+	// Browser subscriptions (default push_subscriptions.json)
+	SubscriptionsFile string `yaml:"subscriptions_file,omitempty"`
+	// Most subscriptions the server will hold (default 50)
+	MaxSubscriptions int `yaml:"max_subscriptions,omitempty"`
+	// Seconds a push service keeps an undelivered notification for an
+	// offline device (default 300; alerts are stale soon after)
+	TTL      int             `yaml:"ttl,omitempty"`
+	Template MessageTemplate `yaml:"template,omitempty"`
+}
+
+func (wp WebPushConfig) enabled() bool {
+	return wp.Subject != ""
+}
+
+func validateWebPushConfig(wp *WebPushConfig) error {
+	if !wp.enabled() {
+		return nil
+	}
+	if !strings.HasPrefix(wp.Subject, "mailto:") && !strings.HasPrefix(wp.Subject, "https://") {
+		return fmt.Errorf("notifications.web_push.subject must be a mailto: or https: URL")
+	}
+	if wp.MaxSubscriptions < 0 || wp.TTL < 0 {
+		return fmt.Errorf("notifications.web_push: max_subscriptions and ttl cannot be negative")
+	}
+	if wp.KeysFile == "" {
+		wp.KeysFile = "vapid.json"
+	}
+	if wp.SubscriptionsFile == "" {
+		wp.SubscriptionsFile = "push_subscriptions.json"
+	}
+	if wp.MaxSubscriptions == 0 {
+		wp.MaxSubscriptions = 50
+	}
+	if wp.TTL == 0 {
+		wp.TTL = 300
+	}
+	return nil
+}
+
+var b64 = base64.RawURLEncoding
+
+// vapidKeys is the server's VAPID key pair, which push services use to
+// check that pushes come from the server browsers subscribed to
+type vapidKeys struct {
+	private *ecdsa.PrivateKey
+	// Uncompressed P-256 point, as browsers expect applicationServerKey
+	public []byte
+}
+
+// vapidKeyFile is the keys file format, base64url like other Web Push
+// tools use
+type vapidKeyFile struct {
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+}
+
+var vapidCache struct {
+	sync.Mutex
+	path string
+	keys *vapidKeys
+}
+
+// loadVAPIDKeys reads the key pair from path, generating and saving one
+// the first time. Changing keys invalidates every subscription.
+func loadVAPIDKeys(path string) (*vapidKeys, error) {
+	vapidCache.Lock()
+	defer vapidCache.Unlock()
+	if vapidCache.keys != nil && vapidCache.path == path {
+		return vapidCache.keys, nil
+	}
+
+	var d []byte
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		var f vapidKeyFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if d, err = b64.DecodeString(f.PrivateKey); err != nil || len(d) != 32 {
+			return nil, fmt.Errorf("reading %s: invalid private_key", path)
+		}
+	case os.IsNotExist(err):
+		key, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		d = key.Bytes()
+		data, _ := json.MarshalIndent(vapidKeyFile{
+			PrivateKey: b64.EncodeToString(d),
+			PublicKey:  b64.EncodeToString(key.PublicKey().Bytes()),
+		}, "", "  ")
+		if err := writeFileAtomic(path, data); err != nil {
+			return nil, err
+		}
+		infof("Generated VAPID keys in %s", path)
+	default:
+		return nil, err
+	}
+
+	keys, err := vapidKeysFromPrivate(d)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	vapidCache.path, vapidCache.keys = path, keys
+	return keys, nil
+}
+
+func vapidKeysFromPrivate(d []byte) (*vapidKeys, error) {
+	ek, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, err
+	}
+	pub := ek.PublicKey().Bytes()
+	priv := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	priv.Curve = elliptic.P256()
+	priv.X = new(big.Int).SetBytes(pub[1:33])
+	priv.Y = new(big.Int).SetBytes(pub[33:])
+	return &vapidKeys{private: priv, public: pub}, nil
+}
+
+// vapidAuthorization builds the RFC 8292 Authorization header for a push
+// to endpoint: a signed JWT naming the push service's origin
+func vapidAuthorization(keys *vapidKeys, endpoint, subject string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(12 * time.Hour).Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + b64.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, keys.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return "vapid t=" + unsigned + "." + b64.EncodeToString(sig) + ", k=" + b64.EncodeToString(keys.public), nil
+}
+
+// PushSubscription is a browser's PushSubscription.toJSON()
+type PushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+	// Profile token of the user who subscribed, for their own rules
+	Profile   string    `json:"profile,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s PushSubscription) validate() error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("endpoint must be an https URL")
+	}
+	pub, err := b64.DecodeString(strings.TrimRight(s.Keys.P256dh, "="))
+	if err != nil {
+		return fmt.Errorf("invalid keys.p256dh")
+	}
+	if _, err := ecdh.P256().NewPublicKey(pub); err != nil {
+		return fmt.Errorf("invalid keys.p256dh")
+	}
+	if auth, err := b64.DecodeString(strings.TrimRight(s.Keys.Auth, "=")); err != nil || len(auth) != 16 {
+		return fmt.Errorf("invalid keys.auth")
+	}
+	return nil
+}
+
+// subscriptionKey names a subscription in the store
+func subscriptionKey(endpoint string) string {
+	sum := sha256.Sum256([]byte(endpoint))
+	return hex.EncodeToString(sum[:16])
+}
+
+var pushSubscriptions = &jsonStore[PushSubscription]{}
+
+// maxSubscriptionSize bounds subscription documents accepted over the API
+const maxSubscriptionSize = 4 << 10
+
+// handlePushKey serves the public key browsers subscribe with
+func handlePushKey(w http.ResponseWriter, r *http.Request) {
+	wp := currentConfig().Notifications.WebPush
+	if !wp.enabled() {
+		http.Error(w, "web push is not enabled", http.StatusNotFound)
+		return
+	}
+	keys, err := loadVAPIDKeys(wp.KeysFile)
+	if err != nil {
+		errorf("Web Push: %v", err)
+		http.Error(w, "web push unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"public_key": b64.EncodeToString(keys.public)})
+}
+
+// handlePushSubscriptions saves (POST) or removes (DELETE) a browser's
+// subscription at /api/push/subscriptions
+func handlePushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	wp := currentConfig().Notifications.WebPush
+	if !wp.enabled() {
+		http.Error(w, "web push is not enabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var sub PushSubscription
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSubscriptionSize)).Decode(&sub); err != nil {
+		http.Error(w, "invalid subscription: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := subscriptionKey(sub.Endpoint)
+
+	if r.Method == http.MethodDelete {
+		ok, err := pushSubscriptions.delete(wp.SubscriptionsFile, key)
+		if err != nil {
+			errorf("Web Push: %v", err)
+			http.Error(w, "removing subscription failed", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "subscription not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := sub.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sub.Profile = r.URL.Query().Get("profile")
+	if sub.Profile != "" && !profileToken.MatchString(sub.Profile) {
+		http.Error(w, "profile token must be 16-128 letters, digits, '-' or '_'", http.StatusBadRequest)
+		return
+	}
+	sub.CreatedAt = clock.Now().UTC()
+	_, err := pushSubscriptions.update(wp.SubscriptionsFile, key, wp.MaxSubscriptions, func(PushSubscription, bool) (PushSubscription, error) {
+		return sub, nil
+	})
+	if err != nil {
+		if errors.Is(err, errStoreFull) {
+			http.Error(w, "subscription limit reached", http.StatusInsufficientStorage)
+			return
+		}
+		errorf("Web Push: %v", err)
+		http.Error(w, "saving subscription failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// pushRecordSize is the aes128gcm record size. Payloads fit one record.
+const pushRecordSize = 4096
+
+// pushContentKeys derives the content encryption key and nonce per
+// RFC 8291 from the ECDH secret, the subscription's auth secret, both
+// public keys, and the message salt
+func pushContentKeys(ecdhSecret, authSecret, uaPublic, asPublic, salt []byte) (cek, nonce []byte, err error) {
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ecdhSecret, authSecret, keyInfo), ikm); err != nil {
+		return nil, nil, err
+	}
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek = make([]byte, 16)
+	nonce = make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, nil, err
+	}
+	return cek, nonce, nil
+}
+
+// encryptPush encrypts payload for a subscription as a single aes128gcm
+// record, keyed with a fresh ephemeral key pair
+func encryptPush(sub PushSubscription, payload []byte) ([]byte, error) {
+	uaPublic, err := b64.DecodeString(strings.TrimRight(sub.Keys.P256dh, "="))
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := b64.DecodeString(strings.TrimRight(sub.Keys.Auth, "="))
+	if err != nil {
+		return nil, err
+	}
+	uaKey, err := ecdh.P256().NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := asKey.ECDH(uaKey)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asKey.PublicKey().Bytes()
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	cek, nonce, err := pushContentKeys(secret, authSecret, uaPublic, asPublic, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: salt, record size, and the ephemeral public key as key ID.
+	// The 0x02 after the payload marks the last record.
+	var out bytes.Buffer
+	out.Write(salt)
+	binary.Write(&out, binary.BigEndian, uint32(pushRecordSize))
+	out.WriteByte(byte(len(asPublic)))
+	out.Write(asPublic)
+	out.Write(gcm.Seal(nil, nonce, append(payload, 0x02), nil))
+	return out.Bytes(), nil
+}
+
+// pushMessage is what the service worker receives
+type pushMessage struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	// Notifications with the same tag replace each other on the device
+	Tag string `json:"tag"`
+	URL string `json:"url"`
+}
+
+type webPushNotifier struct {
+	cfg WebPushConfig
+	// Only push to this profile token's subscriptions, when set
+	user string
+}
+
+func (wp webPushNotifier) String() string {
+	return "Web Push"
+}
+
+// send pushes n to every subscribed browser. Subscriptions the push
+// service reports as gone are removed.
+func (wp webPushNotifier) send(ctx context.Context, n Notification) error {
+	all, err := pushSubscriptions.list(wp.cfg.SubscriptionsFile)
+	if err != nil {
+		return err
+	}
+	subs := all[:0:0]
+	for _, sub := range all {
+		if wp.user == "" || sub.Profile == wp.user {
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) == 0 {
+		return fmt.Errorf("no browsers subscribed")
+	}
+	keys, err := loadVAPIDKeys(wp.cfg.KeysFile)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(pushMessage{Title: n.Title, Body: n.Message, Tag: n.Kind, URL: "/"})
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, sub := range subs {
+		if err := wp.push(ctx, keys, sub, payload); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) == len(subs) {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	for _, f := range failed {
+		warnf("Web Push: %s", f)
+	}
+	return nil
+}
+
+func (wp webPushNotifier) push(ctx context.Context, keys *vapidKeys, sub PushSubscription, payload []byte) error {
+	body, err := encryptPush(sub, payload)
+	if err != nil {
+		return err
+	}
+	auth, err := vapidAuthorization(keys, sub.Endpoint, wp.cfg.Subject, clock.Now())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(wp.cfg.TTL))
+	req.Header.Set("Urgency", "high")
+
+	resp, err := upstreamClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	host := req.URL.Host
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		infof("Web Push: removing expired subscription at %s", host)
+		if _, err := pushSubscriptions.delete(wp.cfg.SubscriptionsFile, subscriptionKey(sub.Endpoint)); err != nil {
+			warnf("Web Push: %v", err)
+		}
+		return fmt.Errorf("subscription at %s expired", host)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("HTTP %d from %s", resp.StatusCode, host)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebPush(t *testing.T) {
+	withTestEnv(t, time.Now(), "")
+	rt := &recordTransport{status: http.StatusCreated}
+	upstreamTransport = rt
+
+	dir := t.TempDir()
+	cfg, err := parseConfig([]byte(`
+api_key: test
+notifications:
+  web_push:
+    subject: mailto:me@example.com
+    keys_file: ` + filepath.Join(dir, "vapid.json") + `
+    subscriptions_file: ` + filepath.Join(dir, "push.json") + `
+` + testStop))
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	activeConfig.Store(cfg)
+
+	rec := httptest.NewRecorder()
+	handlePushKey(rec, httptest.NewRequest("GET", "/api/push/key", nil))
+	var key struct {
+		PublicKey string `json:"public_key"`
+	}
+	json.NewDecoder(rec.Body).Decode(&key)
+	serverKey, err := b64.DecodeString(key.PublicKey)
+	if err != nil || len(serverKey) != 65 {
+		t.Fatalf("public key = %q", key.PublicKey)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vapid.json")); err != nil {
+		t.Fatalf("VAPID keys not saved: %v", err)
+	}
+
+	// Subscribe as a browser would
+	uaKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+	endpoint := "https://push.example.net/send/abc"
+	subscribe := func(method, body string) int {
+		rec := httptest.NewRecorder()
+		handlePushSubscriptions(rec, httptest.NewRequest(method, "/api/push/subscriptions", strings.NewReader(body)))
+		return rec.Code
+	}
+	sub := fmt.Sprintf(`{"endpoint":%q,"expirationTime":null,"keys":{"p256dh":%q,"auth":%q}}`,
+		endpoint, b64.EncodeToString(uaKey.PublicKey().Bytes()), b64.EncodeToString(authSecret))
+	if code := subscribe("POST", sub); code != http.StatusCreated {
+		t.Fatalf("subscribe = %d", code)
+	}
+	if code := subscribe("POST", `{"endpoint":"https://push.example.net/x","keys":{"p256dh":"AAAA","auth":"AAAA"}}`); code != http.StatusBadRequest {
+		t.Errorf("invalid subscription = %d, want 400", code)
+	}
+
+	if !notify(context.Background(), Notification{Kind: "alarm", Title: "Leave now: Work", Message: "N in 6 min"}) {
+		t.Fatal("push not delivered")
+	}
+	if len(rt.urls) != 1 || rt.urls[0] != endpoint {
+		t.Fatalf("pushed to %v", rt.urls)
+	}
+	h := rt.headers[0]
+	if h.Get("Content-Encoding") != "aes128gcm" || h.Get("TTL") != "300" {
+		t.Errorf("headers = %v", h)
+	}
+
+	// The VAPID JWT is signed with the advertised key for the push service
+	var token, k string
+	fmt.Sscanf(strings.ReplaceAll(h.Get("Authorization"), ",", ""), "vapid t=%s k=%s", &token, &k)
+	parts := strings.Split(token, ".")
+	if k != key.PublicKey || len(parts) != 3 {
+		t.Fatalf("Authorization = %q", h.Get("Authorization"))
+	}
+	claims, _ := b64.DecodeString(parts[1])
+	if !strings.Contains(string(claims), `"aud":"https://push.example.net"`) {
+		t.Errorf("claims = %s", claims)
+	}
+	sig, _ := b64.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(serverKey[1:33]), Y: new(big.Int).SetBytes(serverKey[33:])}
+	if len(sig) != 64 || !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Error("VAPID signature does not verify")
+	}
+
+	// Decrypt the payload as the browser would
+	body := []byte(rt.bodies[0])
+	salt, idLen := body[:16], int(body[20])
+	asPublic := body[21 : 21+idLen]
+	asKey, err := ecdh.P256().NewPublicKey(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := uaKey.ECDH(asKey)
+	cek, nonce, _ := pushContentKeys(secret, authSecret, uaKey.PublicKey().Bytes(), asPublic, salt)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if want := `{"title":"Leave now: Work","body":"N in 6 min","tag":"alarm","url":"/"}` + "\x02"; string(plain) != want {
+		t.Errorf("payload = %q", plain)
+	}
+
+	// Expired subscriptions are dropped
+	rt.status = http.StatusGone
+	notify(context.Background(), Notification{Kind: "alarm", Title: "again"})
+	if subs, _ := pushSubscriptions.list(cfg.Notifications.WebPush.SubscriptionsFile); len(subs) != 0 {
+		t.Errorf("%d subscriptions left after 410", len(subs))
+	}
+	if code := subscribe("DELETE", fmt.Sprintf(`{"endpoint":%q}`, endpoint)); code != http.StatusNotFound {
+		t.Errorf("delete of removed subscription = %d", code)
+	}
+}